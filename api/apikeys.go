@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// APIKeyPrefix starts every API key, so that they can be recognised, such as by secret scanners.
+const APIKeyPrefix = "tea_"
+
+// Headers an API key can be sent in, instead of a token.
+const (
+	APIKeyHeader        = "X-API-Key"
+	AuthorizationHeader = "Authorization"
+)
+
+// An APIKey lets a client, such as a script or a kitchen display, use the API as a user without logging in. Each key
+// is limited to some of the permissions of the user's role. Only a hash of the key is stored, and the first few
+// characters, so that the user can tell their keys apart.
+type APIKey struct {
+	ID          int          `json:"id"`
+	Name        string       `json:"name"`
+	Prefix      string       `json:"prefix"`
+	Permissions []Permission `json:"permissions"`
+	CreatedAt   time.Time    `json:"createdAt"`
+	LastUsedAt  *time.Time   `json:"lastUsedAt,omitempty"`
+	Username    string       `json:"-"`
+	Hash        string       `json:"-"`
+}
+
+// An APIKeyRequest creates an API key with a name and the permissions it is limited to. Keys can read by default.
+type APIKeyRequest struct {
+	Name        string       `json:"name"`
+	Permissions []Permission `json:"permissions"`
+}
+
+// A NewAPIKey is a key that has just been created. This is the only time the key itself is shown.
+type NewAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// newAPIKey creates a random API key for a user, giving the key along with the details that are stored.
+func newAPIKey(username string, request APIKeyRequest) (NewAPIKey, error) {
+	random, err := randomToken(20)
+	if err != nil {
+		return NewAPIKey{}, err
+	}
+	key := APIKeyPrefix + random
+	permissions := request.Permissions
+	if len(permissions) == 0 {
+		permissions = []Permission{PermissionRead}
+	}
+	return NewAPIKey{
+		APIKey: APIKey{
+			Name:        request.Name,
+			Prefix:      key[:len(APIKeyPrefix)+8],
+			Permissions: permissions,
+			CreatedAt:   time.Now().Truncate(time.Second).UTC(),
+			Username:    username,
+			Hash:        hashToken(key),
+		},
+		Key: key,
+	}, nil
+}
+
+// allows checks whether the key has all of the given permissions.
+func (key APIKey) allows(required []Permission) bool {
+	for _, permission := range required {
+		if !containsPermission(key.Permissions, permission) {
+			return false
+		}
+	}
+	return true
+}
+
+// validateAPIKeyRequest checks a key has a name, and that the role of the user creating it has all of its permissions.
+func validateAPIKeyRequest(request APIKeyRequest, role string) error {
+	if strings.TrimSpace(request.Name) == "" {
+		return newError(ErrValidation, "API key must have a name")
+	}
+	for _, permission := range request.Permissions {
+		if !containsPermission(rolePermissions[RoleAdmin], permission) {
+			return newError(ErrValidation, "Unknown permission %q", permission)
+		}
+		if !hasPermission(role, permission) {
+			return newError(ErrForbidden, "Your role doesn't have permission %q", permission)
+		}
+	}
+	return nil
+}
+
+// apiKeyFrom gives the API key sent with a request, either as a bearer token or in the X-API-Key header, or an empty
+// string if there isn't one.
+func apiKeyFrom(r *http.Request) string {
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		return key
+	}
+	if authorization := r.Header.Get(AuthorizationHeader); strings.HasPrefix(authorization, "Bearer ") {
+		return strings.TrimPrefix(authorization, "Bearer ")
+	}
+	return ""
+}
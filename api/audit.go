@@ -0,0 +1,27 @@
+package main
+
+import "time"
+
+// Events recorded in the audit log.
+const (
+	AuditUserLocked    = "login.user_locked"    // A username was locked out after too many failed logins
+	AuditAddressLocked = "login.address_locked" // An address was locked out after too many failed logins
+)
+
+// An AuditEntry records something that happened to a household's users, which its admins may want to know about.
+type AuditEntry struct {
+	ID        int       `json:"id"`
+	Event     string    `json:"event"`
+	Username  string    `json:"username"`
+	Address   string    `json:"address"`
+	Message   string    `json:"message"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// An AuditLog is a page of the audit log.
+type AuditLog struct {
+	Entries []AuditEntry `json:"entries"`
+	Total   int          `json:"total"`
+	Limit   int          `json:"limit"`
+	Offset  int          `json:"offset"`
+}
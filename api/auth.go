@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
@@ -10,24 +11,21 @@ import (
 	jwt "github.com/dgrijalva/jwt-go"
 )
 
-var signingKey []byte
-
-// SetSigningKey lets you set the signing key
-func SetSigningKey(key string) {
-	signingKey = []byte(key)
-}
-
-// GenerateJWT generates a JWT token
-func GenerateJWT(user string) (string, error) {
+// GenerateJWT generates a JWT access token, including the user's role and household so that they can be checked without
+// the store. The token's ID lets it be revoked before it expires.
+func (s *Server) GenerateJWT(user User, id string, expiresAt time.Time) (string, error) {
 	token := jwt.New(jwt.SigningMethodHS256)
 
 	claims := token.Claims.(jwt.MapClaims)
 
 	claims["authorized"] = true
-	claims["user"] = user
-	claims["exp"] = time.Now().AddDate(0, 0, 7).Unix() // 7 days expiry
+	claims["jti"] = id
+	claims["user"] = user.Username
+	claims["role"] = user.Role
+	claims["household"] = user.Household
+	claims["exp"] = expiresAt.Unix()
 
-	tokenString, err := token.SignedString(signingKey)
+	tokenString, err := token.SignedString(s.signingKey)
 
 	if err != nil {
 		return "", err
@@ -36,48 +34,187 @@ func GenerateJWT(user string) (string, error) {
 	return tokenString, nil
 }
 
-// GetJWTUser gets the user from a JWT token
-func GetJWTUser(tokenString string) (string, error) {
+// startSession gives a user a new access token, along with a refresh token that is stored so it can be used to get
+// the next access token. The user's role and household are read again whenever the session is refreshed.
+func (s *Server) startSession(user User) (Session, error) {
+	id, err := randomToken(16)
+	if err != nil {
+		return Session{}, err
+	}
+	accessExpiresAt := time.Now().Add(AccessTokenLifetime)
+	accessToken, err := s.GenerateJWT(user, id, accessExpiresAt)
+	if err != nil {
+		return Session{}, err
+	}
+
+	refreshToken, err := randomToken(32)
+	if err != nil {
+		return Session{}, err
+	}
+	stored := RefreshToken{
+		Hash:            hashToken(refreshToken),
+		Username:        user.Username,
+		AccessTokenID:   id,
+		AccessExpiresAt: accessExpiresAt,
+		ExpiresAt:       time.Now().Add(RefreshTokenLifetime),
+	}
+	if err := s.store.CreateRefreshToken(stored); err != nil {
+		return Session{}, err
+	}
+	return Session{Token: accessToken, RefreshToken: refreshToken}, nil
+}
+
+// parseJWT checks a token was signed by the server and hasn't expired, giving its claims.
+func (s *Server) parseJWT(tokenString string) (jwt.MapClaims, error) {
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, errors.New("Error parsing JWT")
 		}
-		return signingKey, nil
+		return s.signingKey, nil
 	})
 	if err != nil {
-		return "", nil
+		return nil, err
 	}
 
 	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok && token.Valid {
-		return "", errors.New("Couldn't extract claims from token")
+	if !ok || !token.Valid {
+		return nil, errors.New("Couldn't extract claims from token")
 	}
+	return claims, nil
+}
+
+// An authorization is who made a request, and how they proved it, which isAuthorized adds to the request's context.
+type authorization struct {
+	username  string
+	role      string
+	household int
+	tokenID   string    // The ID of the access token, if one was sent
+	expiresAt time.Time // When the access token expires
+	apiKey    *APIKey   // The API key, if one was sent instead of a token
+}
+
+// A contextKey is the key of a value that isAuthorized adds to the context of a request.
+type contextKey string
+
+// authorizationKey is the context key of the authorization of the user making a request.
+const authorizationKey contextKey = "authorization"
 
-	username := fmt.Sprintf("%v", claims["user"])
+// authorizationFor gives who made a request that isAuthorized let through.
+func authorizationFor(r *http.Request) authorization {
+	auth, _ := r.Context().Value(authorizationKey).(authorization)
+	return auth
+}
 
-	return username, nil
+// storeFor gives the store for the household of the user making a request.
+func (s *Server) storeFor(r *http.Request) Store {
+	return s.store.ForHousehold(authorizationFor(r).household)
 }
 
-func isAuthorized(endpoint func(http.ResponseWriter, *http.Request)) http.Handler {
+// isAuthorized only lets a request through to the endpoint if it has a valid token that hasn't been revoked, or an API
+// key, for a user whose role has all of the required permissions. An API key must also have all of the permissions.
+// Who made the request is added to the request's context.
+func (s *Server) isAuthorized(endpoint func(http.ResponseWriter, *http.Request), permissions ...Permission) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Header["Token"] != nil {
-			token, err := jwt.Parse(r.Header["Token"][0], func(token *jwt.Token) (interface{}, error) {
-				if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-					return nil, errors.New("Error parsing JWT")
-				}
-				return signingKey, nil
-			})
-
-			if err != nil {
-				respondWithError(w, http.StatusBadRequest, "Not Authorized")
-			}
-
-			if token.Valid {
-				endpoint(w, r)
-			}
+		var auth authorization
+		var err error
+		if key := apiKeyFrom(r); key != "" {
+			auth, err = s.authorizeAPIKey(key)
+		} else if r.Header["Token"] != nil {
+			auth, err = s.authorizeToken(r.Header["Token"][0])
 		} else {
 			log.Printf("Not authorized")
-			respondWithError(w, http.StatusBadRequest, "Not Authorized")
+			err = newError(ErrUnauthorized, "Not Authorized")
+		}
+		if err != nil {
+			respondWithError(w, err)
+			return
+		}
+
+		if !hasPermissions(auth.role, permissions) {
+			log.Printf("User %q with role %q doesn't have permissions %v\n", auth.username, auth.role, permissions)
+			respondWithError(w, newError(ErrForbidden, "You don't have permission to do this"))
+			return
+		}
+		if auth.apiKey != nil && !auth.apiKey.allows(permissions) {
+			log.Printf("API key %d of user %q doesn't have permissions %v\n", auth.apiKey.ID, auth.username, permissions)
+			respondWithError(w, newError(ErrForbidden, "This API key doesn't have permission to do this"))
+			return
 		}
+
+		endpoint(w, r.WithContext(context.WithValue(r.Context(), authorizationKey, auth)))
 	})
 }
+
+// authorizeToken checks an access token is valid and hasn't been revoked, giving who it was issued to.
+func (s *Server) authorizeToken(tokenString string) (authorization, error) {
+	claims, err := s.parseJWT(tokenString)
+	if err != nil {
+		return authorization{}, newError(ErrUnauthorized, "Not Authorized")
+	}
+
+	// Tokens generated before they could be revoked have to be replaced
+	id, ok := claims["jti"].(string)
+	if !ok {
+		return authorization{}, newError(ErrUnauthorized, "Token has no ID, please log in again")
+	}
+	revoked, err := s.store.IsTokenRevoked(id)
+	if err != nil {
+		log.Printf("Failed to check whether token %q was revoked: %v\n", id, err)
+		return authorization{}, err
+	}
+	if revoked {
+		return authorization{}, newError(ErrUnauthorized, "Token has been revoked, please log in again")
+	}
+
+	// Tokens generated before roles were added have to be replaced
+	role, ok := claims["role"].(string)
+	if !ok {
+		return authorization{}, newError(ErrUnauthorized, "Token has no role, please log in again")
+	}
+
+	// Numbers in the claims are decoded as floats
+	household, ok := claims["household"].(float64)
+	if !ok {
+		return authorization{}, newError(ErrUnauthorized, "Token has no household, please log in again")
+	}
+	expiresAt, _ := claims["exp"].(float64)
+
+	return authorization{
+		username:  fmt.Sprintf("%v", claims["user"]),
+		role:      role,
+		household: int(household),
+		tokenID:   id,
+		expiresAt: time.Unix(int64(expiresAt), 0),
+	}, nil
+}
+
+// authorizeAPIKey checks an API key exists, giving the user it belongs to. Their current role and household are used,
+// so a key can never do more than the user can.
+func (s *Server) authorizeAPIKey(key string) (authorization, error) {
+	apiKey, err := s.store.UseAPIKey(hashToken(key))
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			err = newError(ErrUnauthorized, "Invalid API key")
+		}
+		return authorization{}, err
+	}
+
+	user, err := s.store.GetUser(apiKey.Username)
+	if err != nil {
+		log.Printf("Failed to get user %q of API key %d: %v\n", apiKey.Username, apiKey.ID, err)
+		return authorization{}, err
+	}
+	return authorization{username: user.Username, role: user.Role, household: user.Household, apiKey: &apiKey}, nil
+}
+
+// sessionOnly refuses requests made with an API key, for endpoints that manage the user's account, so that a leaked
+// key can't be used to take it over.
+func sessionOnly(endpoint func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if authorizationFor(r).apiKey != nil {
+			respondWithError(w, newError(ErrForbidden, "API keys can't be used to do this, please log in"))
+			return
+		}
+		endpoint(w, r)
+	}
+}
@@ -0,0 +1,577 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Formats a collection can be exported and imported in.
+const (
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+)
+
+// Kinds of item in a collection. They are the first column of each row of a collection in CSV.
+const (
+	KindType      = "type"
+	KindOwner     = "owner"
+	KindTea       = "tea"
+	KindOwnership = "ownership"
+)
+
+// What happened to each item of an imported collection.
+const (
+	ImportCreated = "created"
+	ImportUpdated = "updated"
+	ImportSkipped = "skipped" // The item already exists as it is
+	ImportFailed  = "failed"
+)
+
+// A Collection is everything a household has: its tea types, owners and teas, along with which owners have each tea.
+// Collections are matched up with the household they are imported to by name, so the IDs in them are only used to find
+// tea types and owners without a name.
+type Collection struct {
+	Types  []TeaType       `json:"types"`
+	Owners []Owner         `json:"owners"`
+	Teas   []TeaWithOwners `json:"teas"`
+}
+
+// An ImportRow details what happened to an item of an imported collection. Ownerships are named after their tea.
+type ImportRow struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Owner  string `json:"owner,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// An ImportReport details what happened to each item of an imported collection, in the order they were imported.
+// A dry run doesn't change anything, but reports what would have happened.
+type ImportReport struct {
+	DryRun  bool        `json:"dryRun"`
+	Created int         `json:"created"`
+	Updated int         `json:"updated"`
+	Skipped int         `json:"skipped"`
+	Failed  int         `json:"failed"`
+	Rows    []ImportRow `json:"rows"`
+}
+
+// add adds a row to the report, with the given status.
+func (report *ImportReport) add(row ImportRow, status string) {
+	row.Status = status
+	switch status {
+	case ImportCreated:
+		report.Created++
+	case ImportUpdated:
+		report.Updated++
+	case ImportSkipped:
+		report.Skipped++
+	}
+	report.Rows = append(report.Rows, row)
+}
+
+// fail adds a row to the report that couldn't be imported, with the reason why.
+func (report *ImportReport) fail(row ImportRow, err error) {
+	row.Status = ImportFailed
+	row.Error = err.Error()
+	report.Failed++
+	report.Rows = append(report.Rows, row)
+}
+
+// exportCollection gets everything in a store's household.
+func exportCollection(store Store) (Collection, error) {
+	var collection Collection
+	var err error
+	if collection.Types, err = store.GetAllTeaTypes(ListOptions{}); err != nil {
+		return Collection{}, err
+	}
+	if collection.Owners, err = store.GetAllOwners(ListOptions{}); err != nil {
+		return Collection{}, err
+	}
+
+	// Teas are only summarised along with their owners, so their details are got separately
+	teas, err := store.GetAllTeas(ListOptions{})
+	if err != nil {
+		return Collection{}, err
+	}
+	teaOwners, err := store.GetAllTeaOwners(ListOptions{})
+	if err != nil {
+		return Collection{}, err
+	}
+	owners := make(map[int][]TeaOwner, len(teaOwners))
+	for _, tea := range teaOwners {
+		owners[tea.Tea.ID] = tea.Owners
+	}
+	collection.Teas = make([]TeaWithOwners, len(teas))
+	for i, tea := range teas {
+		tea.AverageRating = 0 // Ratings aren't part of a collection
+		collection.Teas[i] = TeaWithOwners{Tea: tea, Owners: owners[tea.ID]}
+		if collection.Teas[i].Owners == nil {
+			collection.Teas[i].Owners = make([]TeaOwner, 0)
+		}
+	}
+	return collection, nil
+}
+
+// An importKey identifies an item of a collection by its kind and name, along with the owner of an ownership.
+type importKey struct {
+	kind  string
+	name  string
+	owner string
+}
+
+// An importer adds a collection to a store. Tea types, owners and teas that don't exist are created, and tea types and
+// teas that are different are updated. Owners are added to teas they don't have yet.
+// Items that can't be imported, such as teas of an unknown type, are reported as failed without stopping the import.
+// Any error from the store stops it, so the store should be in a transaction that can be rolled back.
+type importer struct {
+	store      Store
+	dryRun     bool                    // Whether to check what would happen, without changing the store
+	checkOwner func(ownerID int) error // Whether teas can be added to an owner
+	report     ImportReport
+
+	types      map[string]TeaType // By name, including those that have been imported
+	owners     map[string]Owner
+	teas       map[string]Tea
+	ownerships map[importKey]bool // The ownerships that exist or have been imported
+	imported   map[importKey]bool // Every item that has been imported, to find repeated items
+}
+
+// importCollection adds a collection to a store, reporting what happened to each item.
+func importCollection(store Store, collection Collection, dryRun bool, checkOwner func(ownerID int) error) (ImportReport, error) {
+	im := &importer{store: store, dryRun: dryRun, checkOwner: checkOwner, report: ImportReport{DryRun: dryRun, Rows: make([]ImportRow, 0)}}
+	if err := im.load(); err != nil {
+		return ImportReport{}, err
+	}
+
+	for _, teaType := range collection.Types {
+		if err := im.importType(teaType); err != nil {
+			return ImportReport{}, err
+		}
+	}
+	for _, owner := range collection.Owners {
+		if err := im.importOwner(owner); err != nil {
+			return ImportReport{}, err
+		}
+	}
+	for _, tea := range collection.Teas {
+		if err := im.importTea(tea); err != nil {
+			return ImportReport{}, err
+		}
+	}
+	return im.report, nil
+}
+
+// load gets what the store already has, so that it can be matched up with the collection.
+func (im *importer) load() error {
+	existing, err := exportCollection(im.store)
+	if err != nil {
+		return err
+	}
+
+	im.types = make(map[string]TeaType, len(existing.Types))
+	for _, teaType := range existing.Types {
+		im.types[teaType.Name] = teaType
+	}
+	im.owners = make(map[string]Owner, len(existing.Owners))
+	for _, owner := range existing.Owners {
+		im.owners[owner.Name] = owner
+	}
+	im.teas = make(map[string]Tea, len(existing.Teas))
+	im.ownerships = make(map[importKey]bool)
+	for _, tea := range existing.Teas {
+		im.teas[tea.Tea.Name] = tea.Tea
+		for _, owner := range tea.Owners {
+			im.ownerships[importKey{KindOwnership, tea.Tea.Name, owner.Name}] = true
+		}
+	}
+	im.imported = make(map[importKey]bool)
+	return nil
+}
+
+// checkItem checks an item has a name, and hasn't already been imported.
+func (im *importer) checkItem(kind string, name string) error {
+	if strings.TrimSpace(name) == "" {
+		return newError(ErrValidation, "Name must not be empty")
+	}
+	return im.checkRepeated(importKey{kind: kind, name: name})
+}
+
+// checkRepeated checks an item hasn't already been imported.
+func (im *importer) checkRepeated(key importKey) error {
+	if im.imported[key] {
+		return newError(ErrValidation, "Appears more than once")
+	}
+	im.imported[key] = true
+	return nil
+}
+
+func (im *importer) importType(teaType TeaType) error {
+	row := ImportRow{Kind: KindType, Name: teaType.Name}
+	if err := im.checkItem(KindType, teaType.Name); err != nil {
+		im.report.fail(row, err)
+		return nil
+	}
+	if err := validateBrewingGuide(teaType.BrewingGuide); err != nil {
+		im.report.fail(row, err)
+		return nil
+	}
+
+	existing, ok := im.types[teaType.Name]
+	switch {
+	case !ok:
+		teaType.ID = 0
+		if !im.dryRun {
+			if err := im.store.CreateTeaType(&teaType); err != nil {
+				return err
+			}
+		}
+		im.report.add(row, ImportCreated)
+	case existing.BrewingGuide == teaType.BrewingGuide:
+		teaType = existing
+		im.report.add(row, ImportSkipped)
+	default:
+		teaType.ID = existing.ID
+		if !im.dryRun {
+			if err := im.store.UpdateTeaType(&teaType); err != nil {
+				return err
+			}
+		}
+		im.report.add(row, ImportUpdated)
+	}
+	im.types[teaType.Name] = teaType
+	return nil
+}
+
+func (im *importer) importOwner(owner Owner) error {
+	row := ImportRow{Kind: KindOwner, Name: owner.Name}
+	if err := im.checkItem(KindOwner, owner.Name); err != nil {
+		im.report.fail(row, err)
+		return nil
+	}
+
+	if _, ok := im.owners[owner.Name]; ok {
+		im.report.add(row, ImportSkipped)
+		return nil
+	}
+	owner.ID = 0
+	if !im.dryRun {
+		if err := im.store.CreateOwner(&owner); err != nil {
+			return err
+		}
+	}
+	im.owners[owner.Name] = owner
+	im.report.add(row, ImportCreated)
+	return nil
+}
+
+// importTea imports a tea, and then its owners. A tea without a type only has its owners imported, so must already
+// exist.
+func (im *importer) importTea(item TeaWithOwners) error {
+	tea := item.Tea
+	row := ImportRow{Kind: KindTea, Name: tea.Name}
+	existing, exists := im.teas[tea.Name]
+
+	if tea.TeaType.Name == "" && tea.TeaType.ID == 0 && exists {
+		tea = existing
+	} else {
+		err := im.checkItem(KindTea, tea.Name)
+		if err == nil {
+			err = validateBrewingGuide(tea.BrewingGuide)
+		}
+		if err == nil {
+			tea.TeaType, err = im.findType(tea.TeaType)
+		}
+		if err != nil {
+			im.report.fail(row, err)
+			for _, owner := range item.Owners {
+				im.report.fail(ImportRow{Kind: KindOwnership, Name: tea.Name, Owner: owner.Name}, newError(ErrValidation, "Tea wasn't imported"))
+			}
+			return nil
+		}
+
+		switch {
+		case !exists:
+			tea.ID = 0
+			if !im.dryRun {
+				if err := im.store.CreateTea(&tea); err != nil {
+					return err
+				}
+			}
+			im.report.add(row, ImportCreated)
+		case existing.TeaType.ID == tea.TeaType.ID && existing.BrewingGuide == tea.BrewingGuide && existing.Notes == tea.Notes:
+			tea = existing
+			im.report.add(row, ImportSkipped)
+		default:
+			tea.ID = existing.ID
+			if !im.dryRun {
+				if err := im.store.UpdateTea(&tea); err != nil {
+					return err
+				}
+			}
+			im.report.add(row, ImportUpdated)
+		}
+		im.teas[tea.Name] = tea
+	}
+
+	for _, owner := range item.Owners {
+		if err := im.importOwnership(tea, owner); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importOwnership adds an owner to a tea, if they don't have it already and the importer allows it. Their stock of a
+// tea they already have isn't changed.
+func (im *importer) importOwnership(tea Tea, teaOwner TeaOwner) error {
+	row := ImportRow{Kind: KindOwnership, Name: tea.Name, Owner: teaOwner.Name}
+	owner, err := im.findOwner(teaOwner.Owner)
+	if err == nil {
+		row.Owner = owner.Name
+		err = im.checkOwner(owner.ID)
+	}
+	if err == nil {
+		err = validateStock(teaOwner.Stock)
+	}
+	if err == nil {
+		err = im.checkRepeated(importKey{KindOwnership, tea.Name, owner.Name})
+	}
+	if err != nil {
+		im.report.fail(row, err)
+		return nil
+	}
+
+	key := importKey{KindOwnership, tea.Name, owner.Name}
+	if im.ownerships[key] {
+		im.report.add(row, ImportSkipped)
+		return nil
+	}
+	if !im.dryRun {
+		if _, err := im.store.CreateTeaOwner(tea.ID, &owner, teaOwner.Stock); err != nil {
+			return err
+		}
+	}
+	im.ownerships[key] = true
+	im.report.add(row, ImportCreated)
+	return nil
+}
+
+// findType finds a tea type that exists or has been imported, by its name, or by its ID if it has no name.
+func (im *importer) findType(teaType TeaType) (TeaType, error) {
+	if found, ok := im.types[teaType.Name]; ok {
+		return found, nil
+	}
+	if teaType.Name == "" && teaType.ID != 0 {
+		for _, found := range im.types {
+			if found.ID == teaType.ID {
+				return found, nil
+			}
+		}
+	}
+	if teaType.Name == "" {
+		return TeaType{}, newError(ErrValidation, "Tea must have a type")
+	}
+	return TeaType{}, newError(ErrValidation, "Unknown tea type: %s", teaType.Name)
+}
+
+// findOwner finds an owner that exists or has been imported, by their name, or by their ID if they have no name.
+func (im *importer) findOwner(owner Owner) (Owner, error) {
+	if found, ok := im.owners[owner.Name]; ok {
+		return found, nil
+	}
+	if owner.Name == "" && owner.ID != 0 {
+		for _, found := range im.owners {
+			if found.ID == owner.ID {
+				return found, nil
+			}
+		}
+	}
+	if owner.Name == "" {
+		return Owner{}, newError(ErrValidation, "Owner must have a name")
+	}
+	return Owner{}, newError(ErrValidation, "Unknown owner: %s", owner.Name)
+}
+
+// csvColumns are the columns of a collection in CSV. Each row is a tea type, owner, tea or ownership, given by its
+// kind, and only has the columns that apply to it. An ownership is named after its tea.
+var csvColumns = []string{"kind", "name", "type", "brewTemperature", "steepSeconds", "leafGrams", "caffeine", "notes", "owner", "quantity", "unit"}
+
+// writeCollectionCSV writes a collection in CSV, with a header. Each tea is followed by its owners.
+func writeCollectionCSV(w io.Writer, collection Collection) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvColumns); err != nil {
+		return err
+	}
+
+	for _, teaType := range collection.Types {
+		writer.Write(csvRow(map[string]string{"kind": KindType, "name": teaType.Name}, teaType.BrewingGuide))
+	}
+	for _, owner := range collection.Owners {
+		writer.Write(csvRow(map[string]string{"kind": KindOwner, "name": owner.Name}, BrewingGuide{}))
+	}
+	for _, tea := range collection.Teas {
+		writer.Write(csvRow(map[string]string{"kind": KindTea, "name": tea.Tea.Name, "type": tea.Tea.TeaType.Name, "notes": tea.Tea.Notes}, tea.Tea.BrewingGuide))
+		for _, owner := range tea.Owners {
+			quantity := ""
+			if owner.Quantity != nil {
+				quantity = strconv.FormatFloat(*owner.Quantity, 'f', -1, 64)
+			}
+			writer.Write(csvRow(map[string]string{"kind": KindOwnership, "name": tea.Tea.Name, "owner": owner.Name, "quantity": quantity, "unit": owner.Unit}, BrewingGuide{}))
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// csvRow gives a row of a collection in CSV, with the given columns and brewing guide. Details of the brewing guide that
+// aren't known are left empty.
+func csvRow(columns map[string]string, guide BrewingGuide) []string {
+	if guide.BrewTemperature != 0 {
+		columns["brewTemperature"] = strconv.Itoa(guide.BrewTemperature)
+	}
+	if guide.SteepSeconds != 0 {
+		columns["steepSeconds"] = strconv.Itoa(guide.SteepSeconds)
+	}
+	if guide.LeafGrams != 0 {
+		columns["leafGrams"] = strconv.FormatFloat(guide.LeafGrams, 'f', -1, 64)
+	}
+	columns["caffeine"] = guide.Caffeine
+
+	row := make([]string, len(csvColumns))
+	for i, column := range csvColumns {
+		row[i] = columns[column]
+	}
+	return row
+}
+
+// readCollectionCSV reads a collection in CSV, as written by writeCollectionCSV. The header decides the order of the
+// columns, and columns that aren't needed can be left out. Ownerships are added to the tea before them with the same
+// name, or to a tea without a type if there isn't one, so that owners can be added to teas that already exist.
+func readCollectionCSV(r io.Reader) (Collection, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return Collection{}, newError(ErrBadRequest, "Invalid CSV: %v", err)
+	}
+	indexes := make(map[string]int, len(header))
+	for i, column := range header {
+		column = strings.TrimSpace(column)
+		if !isCSVColumn(column) {
+			return Collection{}, newError(ErrValidation, "Unknown column in CSV: %s", column)
+		}
+		indexes[column] = i
+	}
+	if _, ok := indexes["kind"]; !ok {
+		return Collection{}, newError(ErrValidation, "CSV must have a kind column")
+	}
+	if _, ok := indexes["name"]; !ok {
+		return Collection{}, newError(ErrValidation, "CSV must have a name column")
+	}
+
+	collection := Collection{Types: make([]TeaType, 0), Owners: make([]Owner, 0), Teas: make([]TeaWithOwners, 0)}
+	teas := make(map[string]int) // Index in the collection of each tea, by name
+	for number := 2; ; number++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return Collection{}, newError(ErrBadRequest, "Invalid CSV: %v", err)
+		}
+		row := csvRecord{indexes: indexes, record: record, number: number}
+
+		switch kind := row.get("kind"); kind {
+		case KindType:
+			teaType := TeaType{Name: row.get("name")}
+			if teaType.BrewingGuide, err = row.brewingGuide(); err != nil {
+				return Collection{}, err
+			}
+			collection.Types = append(collection.Types, teaType)
+		case KindOwner:
+			collection.Owners = append(collection.Owners, Owner{Name: row.get("name")})
+		case KindTea:
+			tea := Tea{Name: row.get("name"), TeaType: TeaType{Name: row.get("type")}, Notes: row.get("notes")}
+			if tea.BrewingGuide, err = row.brewingGuide(); err != nil {
+				return Collection{}, err
+			}
+			teas[tea.Name] = len(collection.Teas)
+			collection.Teas = append(collection.Teas, TeaWithOwners{Tea: tea, Owners: make([]TeaOwner, 0)})
+		case KindOwnership:
+			owner := TeaOwner{Owner: Owner{Name: row.get("owner")}, Stock: Stock{Unit: row.get("unit")}}
+			if quantity := row.get("quantity"); quantity != "" {
+				value, err := strconv.ParseFloat(quantity, 64)
+				if err != nil {
+					return Collection{}, row.invalid("quantity")
+				}
+				owner.Quantity = &value
+			}
+			i, ok := teas[row.get("name")]
+			if !ok {
+				i = len(collection.Teas)
+				teas[row.get("name")] = i
+				collection.Teas = append(collection.Teas, TeaWithOwners{Tea: Tea{Name: row.get("name")}, Owners: make([]TeaOwner, 0)})
+			}
+			collection.Teas[i].Owners = append(collection.Teas[i].Owners, owner)
+		default:
+			return Collection{}, newError(ErrValidation, "Row %d of CSV has an unknown kind: %q, must be one of type, owner, tea or ownership", number, kind)
+		}
+	}
+	return collection, nil
+}
+
+func isCSVColumn(column string) bool {
+	for _, known := range csvColumns {
+		if column == known {
+			return true
+		}
+	}
+	return false
+}
+
+// A csvRecord is a row of a collection in CSV, along with the indexes of its columns and its number, counting the
+// header.
+type csvRecord struct {
+	indexes map[string]int
+	record  []string
+	number  int
+}
+
+// get gives the value of a column, which is empty if the CSV or row doesn't have the column.
+func (row csvRecord) get(column string) string {
+	if i, ok := row.indexes[column]; ok && i < len(row.record) {
+		return strings.TrimSpace(row.record[i])
+	}
+	return ""
+}
+
+// brewingGuide reads the brewing guide from the row.
+func (row csvRecord) brewingGuide() (BrewingGuide, error) {
+	guide := BrewingGuide{Caffeine: row.get("caffeine")}
+	var err error
+	if value := row.get("brewTemperature"); value != "" {
+		if guide.BrewTemperature, err = strconv.Atoi(value); err != nil {
+			return BrewingGuide{}, row.invalid("brewTemperature")
+		}
+	}
+	if value := row.get("steepSeconds"); value != "" {
+		if guide.SteepSeconds, err = strconv.Atoi(value); err != nil {
+			return BrewingGuide{}, row.invalid("steepSeconds")
+		}
+	}
+	if value := row.get("leafGrams"); value != "" {
+		if guide.LeafGrams, err = strconv.ParseFloat(value, 64); err != nil {
+			return BrewingGuide{}, row.invalid("leafGrams")
+		}
+	}
+	return guide, nil
+}
+
+// invalid gives the error for a column of the row that can't be read.
+func (row csvRecord) invalid(column string) error {
+	return newError(ErrValidation, "Row %d of CSV has an invalid %s", row.number, column)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCollectionCSV(t *testing.T) {
+	quantity := 12.5
+	collection := Collection{
+		Types:  []TeaType{{Name: "Black Tea", BrewingGuide: BrewingGuide{BrewTemperature: 100, SteepSeconds: 240, LeafGrams: 2.5, Caffeine: CaffeineHigh}}, {Name: "Herbal"}},
+		Owners: []Owner{{Name: "John"}, {Name: "Jane, Jr."}},
+		Teas: []TeaWithOwners{
+			{Tea: Tea{Name: "Earl Grey", TeaType: TeaType{Name: "Black Tea"}, Notes: "Bergamot,\n \"citrus\""}, Owners: []TeaOwner{{Owner: Owner{Name: "John"}, Stock: Stock{Quantity: &quantity, Unit: UnitGrams}}, {Owner: Owner{Name: "Jane, Jr."}}}},
+			{Tea: Tea{Name: "Chamomile", TeaType: TeaType{Name: "Herbal"}, BrewingGuide: BrewingGuide{SteepSeconds: 300}}, Owners: []TeaOwner{}},
+		},
+	}
+
+	var written bytes.Buffer
+	if err := writeCollectionCSV(&written, collection); err != nil {
+		t.Fatalf("Unexpected error writing CSV: %v\n", err)
+	}
+	if header := strings.SplitN(written.String(), "\n", 2)[0]; header != strings.Join(csvColumns, ",") {
+		t.Errorf("Unexpected CSV header: %q\n", header)
+	}
+	read, err := readCollectionCSV(&written)
+	if err != nil {
+		t.Fatalf("Unexpected error reading CSV: %v\n", err)
+	}
+	if !reflect.DeepEqual(read, collection) {
+		t.Errorf("Unexpected collection read from CSV:\n got: %+v\n wanted: %+v\n", read, collection)
+	}
+
+	// Columns can be in any order, or left out, and ownerships of teas that aren't in the CSV are kept
+	read, err = readCollectionCSV(strings.NewReader("name,kind,owner\nJohn,owner,\nAssam,ownership,John\n"))
+	expected := Collection{Types: []TeaType{}, Owners: []Owner{{Name: "John"}}, Teas: []TeaWithOwners{{Tea: Tea{Name: "Assam"}, Owners: []TeaOwner{{Owner: Owner{Name: "John"}}}}}}
+	if err != nil || !reflect.DeepEqual(read, expected) {
+		t.Errorf("Unexpected collection read from CSV with some columns: %+v, error: %v\n", read, err)
+	}
+}
+
+func TestCollectionCSVInvalid(t *testing.T) {
+	tests := map[string]string{
+		"empty":                  "",
+		"unknown column":         "kind,name,colour\n",
+		"no kind":                "name,type\n",
+		"unknown kind":           "kind,name\nbiscuit,Digestive\n",
+		"invalid temperature":    "kind,name,brewTemperature\ntype,Black Tea,hot\n",
+		"invalid quantity":       "kind,name,owner,quantity\nownership,Assam,John,lots\n",
+		"unterminated quotation": "kind,name\ntea,\"Assam\n",
+	}
+	for name, csv := range tests {
+		if _, err := readCollectionCSV(strings.NewReader(csv)); !errors.Is(err, ErrValidation) && !errors.Is(err, ErrBadRequest) {
+			t.Errorf("Unexpected error reading CSV with %s: %v\n", name, err)
+		}
+	}
+}
@@ -15,10 +15,15 @@ type Config struct {
 		SigningKey      string `yaml:"signingkey"`
 	} `yaml:"server"`
 	Database struct {
+		Driver   string   `yaml:"driver"`
 		Location string   `yaml:"location"`
 		TeaTypes []string `yaml:"teaTypes"`
 		Owners   []string `yaml:"owners"`
 	} `yaml:"database"`
+	Login    LoginConfig    `yaml:"login"`
+	Password PasswordConfig `yaml:"password"`
+	OIDC     OIDCConfig     `yaml:"oidc"`
+	Webhooks WebhookConfig  `yaml:"webhooks"`
 }
 
 func getConfig() Config {
@@ -58,7 +63,33 @@ func logConfig(cfg Config) {
 	} else {
 		log.Println(`POST /register endpoint disabled`)
 	}
-	log.Printf("Database Location: %v\n", cfg.Database.Location)
+	switch cfg.Database.Driver {
+	case DriverPostgres:
+		// The location is a connection string, which may contain a password.
+		log.Println("Database: Postgres")
+	case DriverSQLite, "":
+		log.Printf("Database Location: %v\n", cfg.Database.Location)
+	default:
+		log.Fatalf("Error: unknown database driver %q\n", cfg.Database.Driver)
+	}
 	log.Printf("Tea types: %q\n", cfg.Database.TeaTypes)
 	log.Printf("Owners: %q\n", cfg.Database.Owners)
+
+	if cfg.OIDC.Issuer != "" {
+		if cfg.OIDC.ClientID == "" || cfg.OIDC.RedirectURL == "" {
+			log.Fatal("Error: OIDC needs a client ID and redirect URL")
+		}
+		log.Printf("OIDC login enabled with issuer %v\n", cfg.OIDC.Issuer)
+	} else {
+		log.Println("OIDC login disabled")
+	}
+
+	if len(cfg.Webhooks.URLs) > 0 {
+		if cfg.Webhooks.Secret == "" {
+			log.Fatal("Error: no webhook secret in config")
+		}
+		log.Printf("Webhook URLs: %q\n", cfg.Webhooks.URLs)
+	} else {
+		log.Println("Webhooks disabled")
+	}
 }
@@ -3,15 +3,28 @@ package main
 import (
 	"database/sql"
 	"errors"
+	"fmt"
 	"log"
-	"os"
+	"strconv"
 	"strings"
-
-	_ "github.com/mattn/go-sqlite3"
+	"time"
 )
 
-// DB is the database being used.
-var DB *sql.DB
+// An SQLStore keeps the API's data in a SQLite or Postgres database. Every query only reads or changes the rows of
+// the store's household.
+type SQLStore struct {
+	db        *sql.DB
+	conn      queryer // Runs the store's statements, either the database or the transaction the store is in
+	dialect   dialect
+	observer  StockObserver
+	household int
+	fullText  bool // Whether teas are searched with the teaSearch full-text index
+}
+
+// NewSQLStore creates a store for the default household, using a SQLite database that has already been opened.
+func NewSQLStore(db *sql.DB) *SQLStore {
+	return &SQLStore{db: db, conn: db, dialect: sqliteDialect{}, household: DefaultHousehold}
+}
 
 func checkError(s string, e error) {
 	if e != nil {
@@ -19,370 +32,708 @@ func checkError(s string, e error) {
 	}
 }
 
-func initialiseDatabase(cfg Config) {
+// openDatabase connects to the database using the configured driver. A SQLite database is created if it doesn't exist.
+func openDatabase(cfg Config) *SQLStore {
+	dialect, err := dialectFor(cfg.Database.Driver)
+	checkError("opening database", err)
+	database, err := dialect.open(cfg.Database.Location)
+	checkError("opening database", err)
+	return &SQLStore{db: database, conn: database, dialect: dialect, household: DefaultHousehold}
+}
+
+func initialiseDatabase(cfg Config) *SQLStore {
 	log.Println("Initialising the database...")
 
-	// Check if the database doesn't exists
-	if _, err := os.Stat(cfg.Database.Location); os.IsNotExist(err) {
-		log.Println("Database doesn't exist. Creating...")
-		createDatabase(cfg)
-		log.Println("Database created.")
-	} else {
-		database, err := sql.Open("sqlite3", cfg.Database.Location)
-		checkError("opening database", err)
-		DB = database
-		DB.SetMaxOpenConns(1)
-		DB.Exec("PRAGMA foreign_keys = ON;") // Enable foreign key checks
+	store := openDatabase(cfg)
+	applied, err := store.MigrateUp(cfg, 0)
+	checkError("migrating database", err)
+	if applied > 0 {
+		log.Printf("Applied %d migrations.\n", applied)
 	}
+	checkError("setting up search", store.setupSearch())
+
 	log.Println("Database initialised.")
+	return store
 }
 
-func createDatabase(cfg Config) {
-	database, err := sql.Open("sqlite3", cfg.Database.Location)
-	checkError("creating database", err)
-	DB = database
-	DB.SetMaxOpenConns(1)
-	DB.Exec("PRAGMA foreign_keys = ON;") // Enable foreign key checks
+// Close closes the database.
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
+
+// inTransaction runs a step with a store whose statements are all in one transaction, which is committed if the step
+// succeeds and rolled back if it fails. A store that is already in a transaction runs the step in it.
+func (s *SQLStore) inTransaction(step func(tx *SQLStore) error) error {
+	if _, ok := s.conn.(*sql.Tx); ok {
+		return step(s)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	store := *s
+	store.conn = tx
+	if err := step(&store); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
 
-	createTeaTypeTable(cfg.Database.TeaTypes)
-	createTeaTable()
-	createOwnerTable(cfg.Database.Owners)
-	createTeaOwnersTable()
-	createUserTable()
+// SetStockObserver sets who is told about every change to an owner's stock of a tea.
+func (s *SQLStore) SetStockObserver(observer StockObserver) {
+	s.observer = observer
 }
 
-func createTeaTypeTable(types []string) {
-	creationString := `CREATE TABLE types (
-							id INTEGER PRIMARY KEY AUTOINCREMENT,
-							name TEXT NOT NULL UNIQUE
-					   );`
-	_, err := DB.Exec(creationString)
-	checkError("creating types table", err)
+// ForHousehold gives a store using the same database, which only holds the data of the given household.
+func (s *SQLStore) ForHousehold(household int) Store {
+	store := *s
+	store.household = household
+	return &store
+}
 
-	if len(types) > 0 {
-		var insertString strings.Builder
-		insertString.WriteString("INSERT INTO types (name) VALUES ")
+// GetHousehold gets the store's household.
+func (s *SQLStore) GetHousehold() (Household, error) {
+	household := Household{ID: s.household}
+	row := s.conn.QueryRow("SELECT name FROM households WHERE id = $1;", s.household)
+	if err := row.Scan(&household.Name); err != nil {
+		return Household{}, notFound(err, errHouseholdMissing)
+	}
+	return household, nil
+}
 
-		for i, teaType := range types {
-			if i != 0 {
-				insertString.WriteString(", ")
+// CreateHousehold adds a new household along with its first user, who is an admin. Neither is added if the username
+// is taken.
+func (s *SQLStore) CreateHousehold(household *Household, admin UserLogin) error {
+	var id int
+	err := s.inTransaction(func(tx *SQLStore) error {
+		var err error
+		id, err = tx.dialect.insert(tx.conn, "INSERT INTO households (name) VALUES ($1);", household.Name)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.conn.Exec("INSERT INTO \"user\" (username, password, role, householdID) VALUES ($1, $2, $3, $4);", admin.Username, admin.Password, RoleAdmin, id); err != nil {
+			if tx.dialect.isUniqueViolation(err) {
+				return errUsernameTaken
 			}
-			insertString.WriteString("('" + teaType + "')")
+			return err
 		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	household.ID = id
+	return nil
+}
 
-		insertString.WriteString(";")
+// JoinHousehold moves a user into the store's household with the given role, unlinking them from their owner.
+func (s *SQLStore) JoinHousehold(username string, role string) error {
+	result, err := s.conn.Exec("UPDATE \"user\" SET householdID = $1, role = $2, ownerID = NULL WHERE username = $3;", s.household, role, username)
+	if err != nil {
+		if s.dialect.isForeignKeyViolation(err) {
+			return errHouseholdMissing
+		}
+		return err
+	}
 
-		_, err = DB.Exec(insertString.String())
-		checkError("inserting types into the database", err)
+	if updated, err := result.RowsAffected(); err != nil {
+		return err
+	} else if updated == 0 {
+		return errUserMissing
 	}
+	return nil
 }
 
-func createTeaTable() {
-	creationString := `CREATE TABLE tea (
-							id INTEGER PRIMARY KEY AUTOINCREMENT,
-							name TEXT NOT NULL UNIQUE,
-							teaType INTEGER,
-							FOREIGN KEY (teaType) REFERENCES types (id)
-								ON UPDATE CASCADE
-								ON DELETE RESTRICT
-					   );`
-	_, err := DB.Exec(creationString)
-	checkError("creating tea table", err)
+// GetInvite gets an invite that hasn't expired, from any household, by its code.
+func (s *SQLStore) GetInvite(code string) (Invite, error) {
+	invite := Invite{Code: code}
+	var expiresAt int64
+	row := s.conn.QueryRow("SELECT householdID, expiresAt FROM invites WHERE code = $1 AND expiresAt > $2;", code, time.Now().Unix())
+	if err := row.Scan(&invite.Household, &expiresAt); err != nil {
+		return Invite{}, notFound(err, errInviteMissing)
+	}
+	invite.ExpiresAt = time.Unix(expiresAt, 0).UTC()
+	return invite, nil
 }
 
-func createOwnerTable(owners []string) {
-	creationString := `CREATE TABLE owner (
-							id INTEGER PRIMARY KEY AUTOINCREMENT,
-							name TEXT NOT NULL UNIQUE
-					   );`
-	_, err := DB.Exec(creationString)
-	checkError("creating owner table", err)
-
-	if len(owners) > 0 {
-		var insertString strings.Builder
-		insertString.WriteString("INSERT INTO owner (name) VALUES ")
+// GetInvites gets the household's invites that haven't expired, soonest to expire first.
+func (s *SQLStore) GetInvites() ([]Invite, error) {
+	rows, err := s.conn.Query("SELECT code, expiresAt FROM invites WHERE householdID = $1 AND expiresAt > $2 ORDER BY expiresAt, code;", s.household, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-		for i, name := range owners {
-			if i != 0 {
-				insertString.WriteString(", ")
-			}
-			insertString.WriteString("('" + name + "')")
+	invites := make([]Invite, 0)
+	for rows.Next() {
+		invite := Invite{Household: s.household}
+		var expiresAt int64
+		if err := rows.Scan(&invite.Code, &expiresAt); err != nil {
+			return nil, err
 		}
-
-		insertString.WriteString(";")
-
-		_, err = DB.Exec(insertString.String())
-		checkError("inserting owners into the database", err)
+		invite.ExpiresAt = time.Unix(expiresAt, 0).UTC()
+		invites = append(invites, invite)
 	}
+	return invites, rows.Err()
 }
 
-func createTeaOwnersTable() {
-	creationString := `CREATE TABLE teaOwners (
-							teaID INTEGER,
-							ownerID INTEGER,
-							PRIMARY KEY(teaID, ownerID),
-							FOREIGN KEY (teaID) REFERENCES tea (id)
-								ON UPDATE CASCADE
-								ON DELETE RESTRICT,
-							FOREIGN KEY (ownerID) REFERENCES owner (id)
-								ON UPDATE CASCADE
-								ON DELETE RESTRICT
-					   );`
-	_, err := DB.Exec(creationString)
-	checkError("creating owner table", err)
+// CreateInvite adds an invite to the household.
+func (s *SQLStore) CreateInvite(invite *Invite) error {
+	invite.Household = s.household
+	_, err := s.conn.Exec("INSERT INTO invites (code, householdID, expiresAt) VALUES ($1, $2, $3);", invite.Code, s.household, invite.ExpiresAt.Unix())
+	return err
 }
 
-func createUserTable() {
-	creationString := `CREATE TABLE user (
-							username TEXT NOT NULL UNIQUE PRIMARY KEY,
-							password TEXT NOT NULL
-						);`
-	_, err := DB.Exec(creationString)
-	checkError("creating user table", err)
+// DeleteInvite deletes one of the household's invites, so it can't be used any more.
+func (s *SQLStore) DeleteInvite(code string) error {
+	result, err := s.conn.Exec("DELETE FROM invites WHERE code = $1 AND householdID = $2;", code, s.household)
+	if err != nil {
+		return err
+	}
+
+	if deleted, err := result.RowsAffected(); err != nil {
+		return err
+	} else if deleted == 0 {
+		return errInviteMissing
+	}
+	return nil
 }
 
-// GetPasswordFromDatabase retrieves a users hashed password from the datbase.
-func GetPasswordFromDatabase(user string) (string, error) {
-	row := DB.QueryRow("SELECT password FROM user WHERE username=$1;", user)
+// GetPassword retrieves a users hashed password from the datbase.
+func (s *SQLStore) GetPassword(user string) (string, error) {
+	row := s.conn.QueryRow("SELECT password FROM \"user\" WHERE username=$1;", user)
 
 	var password string
 	if err := row.Scan(&password); err != nil {
-		return "", err
+		return "", notFound(err, errUserMissing)
 	}
 
 	return password, nil
 }
 
-// CreateUserInDatabase creates a user in the database using a username and pre-hashed password
-func CreateUserInDatabase(user UserLogin) error {
-	if _, err := DB.Exec("INSERT INTO user(username, password) VALUES ($1, $2)", user.Username, user.Password); err != nil {
+// GetUser gets a user's role and household, from any household.
+func (s *SQLStore) GetUser(username string) (User, error) {
+	user := User{Username: username}
+	var ownerID sql.NullInt64
+	row := s.conn.QueryRow("SELECT role, householdID, ownerID FROM \"user\" WHERE username=$1;", username)
+	if err := row.Scan(&user.Role, &user.Household, &ownerID); err != nil {
+		return User{}, notFound(err, errUserMissing)
+	}
+	user.OwnerID = int(ownerID.Int64)
+	return user, nil
+}
+
+// GetAllUsers gets every user in the household and their role, ordered by username.
+func (s *SQLStore) GetAllUsers() ([]User, error) {
+	rows, err := s.conn.Query("SELECT username, role, ownerID FROM \"user\" WHERE householdID=$1 ORDER BY username;", s.household)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]User, 0)
+	for rows.Next() {
+		user := User{Household: s.household}
+		var ownerID sql.NullInt64
+		if err := rows.Scan(&user.Username, &user.Role, &ownerID); err != nil {
+			return nil, err
+		}
+		user.OwnerID = int(ownerID.Int64)
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// CreateUser creates a user in the household using a username, pre-hashed password and role
+func (s *SQLStore) CreateUser(user UserLogin, role string) error {
+	if _, err := s.conn.Exec("INSERT INTO \"user\" (username, password, role, householdID) VALUES ($1, $2, $3, $4)", user.Username, user.Password, role, s.household); err != nil {
+		if s.dialect.isUniqueViolation(err) {
+			return errUsernameTaken
+		}
 		return err
 	}
 
 	return nil
 }
 
-// ChangePasswordInDatabase updates a user's password.
-func ChangePasswordInDatabase(username string, password string) error {
-	if _, err := DB.Exec("UPDATE user SET password=$1 WHERE username=$2;", password, username); err != nil {
+// CreateFirstUser adds a user to the default household as its admin if there are no users yet. The user table is locked
+// while checking, so that two users registering at the same time can't both be first.
+func (s *SQLStore) CreateFirstUser(user UserLogin) (bool, error) {
+	created := false
+	err := s.inTransaction(func(tx *SQLStore) error {
+		if err := tx.dialect.lockTable(tx.conn, "\"user\""); err != nil {
+			return err
+		}
+		var users int
+		if err := tx.conn.QueryRow("SELECT COUNT(*) FROM \"user\";").Scan(&users); err != nil {
+			return err
+		}
+		if users > 0 {
+			return nil
+		}
+		if _, err := tx.conn.Exec("INSERT INTO \"user\" (username, password, role, householdID) VALUES ($1, $2, $3, $4);", user.Username, user.Password, RoleAdmin, DefaultHousehold); err != nil {
+			return err
+		}
+		created = true
+		return nil
+	})
+	return created, err
+}
+
+// ChangePassword updates a user's password.
+func (s *SQLStore) ChangePassword(username string, password string) error {
+	result, err := s.conn.Exec("UPDATE \"user\" SET password=$1 WHERE username=$2;", password, username)
+	if err != nil {
+		return err
+	}
+
+	if updated, err := result.RowsAffected(); err != nil {
 		return err
+	} else if updated == 0 {
+		return errUserMissing
 	}
 	return nil
 }
 
-// GetAllTeaTypesFromDatabase retrieves all the tea types available in the database.
-func GetAllTeaTypesFromDatabase() ([]TeaType, error) {
-	rows, err := DB.Query("SELECT * FROM types;")
+// ReplacePassword changes a user's password, records their previous one and revokes their sessions in a transaction,
+// so that the password is never changed while sessions that used the old one still work.
+func (s *SQLStore) ReplacePassword(username string, password string, previous string, keep int) error {
+	return s.inTransaction(func(tx *SQLStore) error {
+		if err := tx.ChangePassword(username, password); err != nil {
+			return err
+		}
+		if err := tx.AddPasswordHistory(username, previous, keep); err != nil {
+			return err
+		}
+		return tx.RevokeUserSessions(username)
+	})
+}
+
+// GetPasswordHistory gets the hashes of a user's previous passwords, most recent first.
+func (s *SQLStore) GetPasswordHistory(username string) ([]string, error) {
+	rows, err := s.conn.Query("SELECT password FROM passwordHistory WHERE username = $1 ORDER BY id DESC;", username)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	teaTypes := make([]TeaType, 0)
+	passwords := make([]string, 0)
 	for rows.Next() {
-		teaType := new(TeaType)
-		err := rows.Scan(&teaType.ID, &teaType.Name)
-		if err != nil {
+		var password string
+		if err := rows.Scan(&password); err != nil {
 			return nil, err
 		}
-		teaTypes = append(teaTypes, *teaType)
+		passwords = append(passwords, password)
 	}
-	return teaTypes, nil
+	return passwords, rows.Err()
+}
+
+// AddPasswordHistory records the hash of a user's previous password, only keeping the given number of the most recent.
+func (s *SQLStore) AddPasswordHistory(username string, password string, keep int) error {
+	if keep < 0 {
+		keep = 0
+	}
+	return s.inTransaction(func(tx *SQLStore) error {
+		if keep > 0 {
+			if _, err := tx.conn.Exec("INSERT INTO passwordHistory (username, password, createdAt) VALUES ($1, $2, $3);", username, password, time.Now().Unix()); err != nil {
+				if tx.dialect.isForeignKeyViolation(err) {
+					return errUserMissing
+				}
+				return err
+			}
+		}
+		_, err := tx.conn.Exec(`DELETE FROM passwordHistory WHERE username = $1 AND id NOT IN (
+								SELECT id FROM passwordHistory WHERE username = $1 ORDER BY id DESC LIMIT $2
+							 );`, username, keep)
+		return err
+	})
 }
 
-// GetTeaTypeFromDatabase retrieves a tea type from the database.
-func GetTeaTypeFromDatabase(teaType *TeaType) error {
-	row := DB.QueryRow("SELECT name FROM types WHERE id=$1;", teaType.ID)
+// SetUserOwner links a user to an owner in the same household, or unlinks them if the owner ID is zero.
+func (s *SQLStore) SetUserOwner(username string, ownerID int) error {
+	return s.inTransaction(func(tx *SQLStore) error {
+		if ownerID != 0 {
+			if err := tx.inHousehold("owner", ownerID, errOwnerMissing); err != nil {
+				return err
+			}
+		}
+
+		result, err := tx.conn.Exec("UPDATE \"user\" SET ownerID=$1 WHERE username=$2 AND householdID=$3;", nullableInt(ownerID), username, tx.household)
+		if err != nil {
+			if tx.dialect.isUniqueViolation(err) {
+				return errOwnerLinked
+			}
+			if tx.dialect.isForeignKeyViolation(err) {
+				return errOwnerMissing
+			}
+			return err
+		}
 
-	err := row.Scan(&teaType.Name)
+		if updated, err := result.RowsAffected(); err != nil {
+			return err
+		} else if updated == 0 {
+			return errUserMissing
+		}
+		return nil
+	})
+}
+
+// SetUserRole changes a user's role.
+func (s *SQLStore) SetUserRole(username string, role string) error {
+	result, err := s.conn.Exec("UPDATE \"user\" SET role=$1 WHERE username=$2 AND householdID=$3;", role, username, s.household)
 	if err != nil {
 		return err
 	}
 
+	if updated, err := result.RowsAffected(); err != nil {
+		return err
+	} else if updated == 0 {
+		return errUserMissing
+	}
 	return nil
 }
 
-// CreateTeaTypeInDatabase adds a new tea type to the database
-func CreateTeaTypeInDatabase(teaType *TeaType) error {
-	_, err := DB.Exec("INSERT INTO types (name) VALUES ($1);", teaType.Name)
-	if err != nil {
+// CreateRefreshToken stores a refresh token, removing any tokens that have expired.
+func (s *SQLStore) CreateRefreshToken(token RefreshToken) error {
+	return s.inTransaction(func(tx *SQLStore) error {
+		if err := tx.deleteExpiredTokens(); err != nil {
+			return err
+		}
+
+		_, err := tx.conn.Exec("INSERT INTO refreshTokens (hash, username, accessTokenID, accessExpiresAt, expiresAt) VALUES ($1, $2, $3, $4, $5);",
+			token.Hash, token.Username, token.AccessTokenID, token.AccessExpiresAt.Unix(), token.ExpiresAt.Unix())
+		if tx.dialect.isForeignKeyViolation(err) {
+			return errUserMissing
+		}
 		return err
-	}
+	})
+}
+
+// UseRefreshToken gets a refresh token that hasn't expired by its hash, and deletes it so it can only be used once.
+func (s *SQLStore) UseRefreshToken(hash string) (RefreshToken, error) {
+	token := RefreshToken{Hash: hash}
+	err := s.inTransaction(func(tx *SQLStore) error {
+		var accessExpiresAt, expiresAt int64
+		row := tx.conn.QueryRow("SELECT username, accessTokenID, accessExpiresAt, expiresAt FROM refreshTokens WHERE hash = $1 AND expiresAt > $2;", hash, time.Now().Unix())
+		if err := row.Scan(&token.Username, &token.AccessTokenID, &accessExpiresAt, &expiresAt); err != nil {
+			return notFound(err, errRefreshMissing)
+		}
+		token.AccessExpiresAt = time.Unix(accessExpiresAt, 0).UTC()
+		token.ExpiresAt = time.Unix(expiresAt, 0).UTC()
 
-	rows, err := DB.Query("SELECT ID FROM types WHERE name = ($1);", teaType.Name)
+		// Another request may have used the token since it was read
+		result, err := tx.conn.Exec("DELETE FROM refreshTokens WHERE hash = $1;", hash)
+		if err != nil {
+			return err
+		}
+		if deleted, err := result.RowsAffected(); err != nil {
+			return err
+		} else if deleted == 0 {
+			return errRefreshMissing
+		}
+		return nil
+	})
 	if err != nil {
+		return RefreshToken{}, err
+	}
+	return token, nil
+}
+
+// RevokeSession revokes an access token until it expires, along with the refresh token issued with it.
+func (s *SQLStore) RevokeSession(accessTokenID string, expiresAt time.Time) error {
+	return s.inTransaction(func(tx *SQLStore) error {
+		if err := tx.deleteExpiredTokens(); err != nil {
+			return err
+		}
+		if _, err := tx.conn.Exec("INSERT INTO revokedTokens (id, expiresAt) VALUES ($1, $2);", accessTokenID, expiresAt.Unix()); err != nil {
+			return err
+		}
+		_, err := tx.conn.Exec("DELETE FROM refreshTokens WHERE accessTokenID = $1;", accessTokenID)
+		return err
+	})
+}
+
+// RevokeUserSessions revokes all of a user's refresh tokens, and the access tokens issued with them that haven't
+// expired yet.
+func (s *SQLStore) RevokeUserSessions(username string) error {
+	return s.inTransaction(func(tx *SQLStore) error {
+		if err := tx.deleteExpiredTokens(); err != nil {
+			return err
+		}
+		if _, err := tx.conn.Exec("INSERT INTO revokedTokens (id, expiresAt) SELECT accessTokenID, accessExpiresAt FROM refreshTokens WHERE username = $1 AND accessExpiresAt > $2;", username, time.Now().Unix()); err != nil {
+			return err
+		}
+		_, err := tx.conn.Exec("DELETE FROM refreshTokens WHERE username = $1;", username)
 		return err
+	})
+}
+
+// IsTokenRevoked checks whether an access token has been revoked.
+func (s *SQLStore) IsTokenRevoked(accessTokenID string) (bool, error) {
+	var count int
+	if err := s.conn.QueryRow("SELECT COUNT(*) FROM revokedTokens WHERE id = $1;", accessTokenID).Scan(&count); err != nil {
+		return false, err
 	}
-	defer rows.Close()
+	return count > 0, nil
+}
+
+// deleteExpiredTokens removes refresh tokens and revoked access tokens that have expired, as they can't be used any more.
+func (s *SQLStore) deleteExpiredTokens() error {
+	now := time.Now().Unix()
+	return s.inTransaction(func(tx *SQLStore) error {
+		if _, err := tx.conn.Exec("DELETE FROM refreshTokens WHERE expiresAt <= $1;", now); err != nil {
+			return err
+		}
+		_, err := tx.conn.Exec("DELETE FROM revokedTokens WHERE expiresAt <= $1;", now)
+		return err
+	})
+}
 
-	rows.Next()
-	err = rows.Scan(&teaType.ID)
+// CreateAPIKey stores a new API key, giving it an ID.
+func (s *SQLStore) CreateAPIKey(key *APIKey) error {
+	id, err := s.dialect.insert(s.conn, "INSERT INTO apiKeys (username, name, prefix, hash, permissions, createdAt) VALUES ($1, $2, $3, $4, $5, $6);",
+		key.Username, key.Name, key.Prefix, key.Hash, joinPermissions(key.Permissions), key.CreatedAt.Unix())
 	if err != nil {
+		if s.dialect.isForeignKeyViolation(err) {
+			return errUserMissing
+		}
 		return err
 	}
-	log.Printf("New ID: %d\n", teaType.ID)
-
+	key.ID = id
 	return nil
 }
 
-// DeleteTeaTypeInDatabase deletes a tea type from the database.
-func DeleteTeaTypeInDatabase(teaType *TeaType) error {
-	rows, err := DB.Query("SELECT name FROM types WHERE id=$1;", teaType.ID)
+// UseAPIKey gets an API key by its hash, recording that it was used.
+func (s *SQLStore) UseAPIKey(hash string) (APIKey, error) {
+	key := APIKey{Hash: hash}
+	err := s.inTransaction(func(tx *SQLStore) error {
+		var permissions string
+		var createdAt int64
+		row := tx.conn.QueryRow("SELECT id, username, name, prefix, permissions, createdAt FROM apiKeys WHERE hash = $1;", hash)
+		if err := row.Scan(&key.ID, &key.Username, &key.Name, &key.Prefix, &permissions, &createdAt); err != nil {
+			return notFound(err, errAPIKeyMissing)
+		}
+		key.Permissions = splitPermissions(permissions)
+		key.CreatedAt = time.Unix(createdAt, 0).UTC()
 
-	rows.Next()
-	err = rows.Scan(&teaType.Name)
+		lastUsedAt := time.Unix(time.Now().Unix(), 0).UTC()
+		if _, err := tx.conn.Exec("UPDATE apiKeys SET lastUsedAt = $1 WHERE id = $2;", lastUsedAt.Unix(), key.ID); err != nil {
+			return err
+		}
+		key.LastUsedAt = &lastUsedAt
+		return nil
+	})
 	if err != nil {
-		return err
+		return APIKey{}, err
 	}
-	rows.Close()
-
-	_, err = DB.Exec("DELETE FROM types WHERE id = $1;", teaType.ID)
-	return err
+	return key, nil
 }
 
-// GetAllOwnersFromDatabase gets all the owners from the database.
-func GetAllOwnersFromDatabase() ([]Owner, error) {
-	rows, err := DB.Query("SELECT * FROM owner;")
+// GetAPIKeys gets all of a user's API keys, oldest first.
+func (s *SQLStore) GetAPIKeys(username string) ([]APIKey, error) {
+	rows, err := s.conn.Query("SELECT id, name, prefix, hash, permissions, createdAt, lastUsedAt FROM apiKeys WHERE username = $1 ORDER BY id;", username)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	owners := make([]Owner, 0)
+	keys := make([]APIKey, 0)
 	for rows.Next() {
-		owner := new(Owner)
-		err := rows.Scan(&owner.ID, &owner.Name)
-		if err != nil {
+		key := APIKey{Username: username}
+		var permissions string
+		var createdAt int64
+		var lastUsedAt sql.NullInt64
+		if err := rows.Scan(&key.ID, &key.Name, &key.Prefix, &key.Hash, &permissions, &createdAt, &lastUsedAt); err != nil {
 			return nil, err
 		}
-		owners = append(owners, *owner)
+		key.Permissions = splitPermissions(permissions)
+		key.CreatedAt = time.Unix(createdAt, 0).UTC()
+		if lastUsedAt.Valid {
+			used := time.Unix(lastUsedAt.Int64, 0).UTC()
+			key.LastUsedAt = &used
+		}
+		keys = append(keys, key)
 	}
-	return owners, nil
+	return keys, nil
 }
 
-// GetOwnerFromDatabase gets an owner from the database by their ID.
-func GetOwnerFromDatabase(owner *Owner) error {
-	row := DB.QueryRow("SELECT name FROM owner WHERE id=$1;", owner.ID)
-
-	err := row.Scan(&owner.Name)
+// DeleteAPIKey deletes one of a user's API keys, so it can't be used any more.
+func (s *SQLStore) DeleteAPIKey(username string, id int) error {
+	result, err := s.conn.Exec("DELETE FROM apiKeys WHERE id = $1 AND username = $2;", id, username)
 	if err != nil {
 		return err
 	}
 
+	if deleted, err := result.RowsAffected(); err != nil {
+		return err
+	} else if deleted == 0 {
+		return errAPIKeyMissing
+	}
 	return nil
 }
 
-// CreateOwnerInDatabase adds a new owner to the database
-func CreateOwnerInDatabase(owner *Owner) error {
-	_, err := DB.Exec("INSERT INTO owner (name) VALUES ($1);", owner.Name)
-	if err != nil {
-		return err
+// GetIdentityUser gets the user linked to a subject of an identity provider.
+func (s *SQLStore) GetIdentityUser(issuer string, subject string) (User, error) {
+	var username string
+	row := s.conn.QueryRow("SELECT username FROM identities WHERE issuer = $1 AND subject = $2;", issuer, subject)
+	if err := row.Scan(&username); err != nil {
+		return User{}, notFound(err, errIdentityMissing)
 	}
+	return s.GetUser(username)
+}
 
-	rows, err := DB.Query("SELECT ID FROM owner WHERE name = ($1);", owner.Name)
-	if err != nil {
+// CreateIdentity links a user to a subject of an identity provider.
+func (s *SQLStore) CreateIdentity(identity Identity) error {
+	if _, err := s.conn.Exec("INSERT INTO identities (issuer, subject, username) VALUES ($1, $2, $3);", identity.Issuer, identity.Subject, identity.Username); err != nil {
+		if s.dialect.isUniqueViolation(err) {
+			return errIdentityLinked
+		}
+		if s.dialect.isForeignKeyViolation(err) {
+			return errUserMissing
+		}
 		return err
 	}
-	defer rows.Close()
+	return nil
+}
 
-	rows.Next()
-	err = rows.Scan(&owner.ID)
+// CreateIdentityUser adds a user linked to a subject of an identity provider in a transaction, so that the user isn't
+// left without the link if it can't be made.
+func (s *SQLStore) CreateIdentityUser(identity Identity, household *Household) (User, error) {
+	user := User{Username: identity.Username, Role: RoleAdmin, Household: DefaultHousehold}
+	login := UserLogin{Username: identity.Username}
+	err := s.inTransaction(func(tx *SQLStore) error {
+		created, err := tx.CreateFirstUser(login)
+		if err != nil {
+			return err
+		}
+		if !created {
+			if err := tx.CreateHousehold(household, login); err != nil {
+				return err
+			}
+			user.Household = household.ID
+		}
+		return tx.CreateIdentity(identity)
+	})
 	if err != nil {
-		return err
+		return User{}, err
 	}
-	log.Printf("New ID: %d\n", owner.ID)
-
-	return nil
+	return user, nil
 }
 
-// DeleteOwnerFromDatabase deletes an owner from the database.
-func DeleteOwnerFromDatabase(owner *Owner) error {
-	rows, err := DB.Query("SELECT name FROM owner WHERE id=$1;", owner.ID)
-
-	rows.Next()
-	err = rows.Scan(&owner.Name)
-	if err != nil {
-		return err
+// joinPermissions gives the permissions of an API key as they are stored, separated by commas.
+func joinPermissions(permissions []Permission) string {
+	names := make([]string, len(permissions))
+	for i, permission := range permissions {
+		names[i] = string(permission)
 	}
-	rows.Close()
+	return strings.Join(names, ",")
+}
 
-	_, err = DB.Exec("DELETE FROM owner WHERE id = $1;", owner.ID)
-	return err
+// splitPermissions gives the permissions of an API key from the way they are stored.
+func splitPermissions(stored string) []Permission {
+	permissions := make([]Permission, 0)
+	for _, name := range strings.Split(stored, ",") {
+		if name != "" {
+			permissions = append(permissions, Permission(name))
+		}
+	}
+	return permissions
 }
 
-// GetAllTeasFromDatabase gets all the teas from the database.
-func GetAllTeasFromDatabase() ([]Tea, error) {
-	rows, err := DB.Query("SELECT tea.id, tea.name, types.id, types.name FROM tea INNER JOIN types ON types.ID = tea.teaType;")
+// GetAllTeaTypes retrieves the tea types in a list from the database.
+func (s *SQLStore) GetAllTeaTypes(options ListOptions) ([]TeaType, error) {
+	clauses, args := listClauses(options, "", s.household)
+	rows, err := s.conn.Query("SELECT id, name, brewTemperature, steepSeconds, leafGrams, caffeine FROM types WHERE householdID=$1"+clauses, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	teas := make([]Tea, 0)
+	teaTypes := make([]TeaType, 0)
 	for rows.Next() {
-		tea := new(Tea)
-		err := rows.Scan(&tea.ID, &tea.Name, &tea.TeaType.ID, &tea.TeaType.Name)
+		teaType := new(TeaType)
+		var guide nullBrewingGuide
+		err := rows.Scan(append([]interface{}{&teaType.ID, &teaType.Name}, guide.dest()...)...)
 		if err != nil {
 			return nil, err
 		}
-
-		teas = append(teas, *tea)
+		teaType.BrewingGuide = guide.value()
+		teaTypes = append(teaTypes, *teaType)
 	}
-	return teas, nil
+	return teaTypes, nil
 }
 
-// GetTeaFromDatabase gets information about a tea from the database using it's ID
-func GetTeaFromDatabase(tea *Tea) error {
-	row := DB.QueryRow("SELECT tea.name, types.id, types.name FROM tea INNER JOIN types ON tea.teaType=types.id WHERE tea.teaType=$1", tea.ID)
+// GetTeaType retrieves a tea type from the database.
+func (s *SQLStore) GetTeaType(teaType *TeaType) error {
+	row := s.conn.QueryRow("SELECT name, brewTemperature, steepSeconds, leafGrams, caffeine FROM types WHERE id=$1 AND householdID=$2;", teaType.ID, s.household)
 
-	err := row.Scan(&tea.Name, &tea.TeaType.ID, &tea.TeaType.Name)
+	var guide nullBrewingGuide
+	err := row.Scan(append([]interface{}{&teaType.Name}, guide.dest()...)...)
 	if err != nil {
-		return err
+		return notFound(err, errTeaTypeMissingID)
 	}
+	teaType.BrewingGuide = guide.value()
 
 	return nil
 }
 
-// CreateTeaInDatabase creates a new tea in the database. Uses the type ID to do so.
-func CreateTeaInDatabase(tea *Tea) error {
-	row := DB.QueryRow("SELECT name FROM types WHERE id = $1;", tea.TeaType.ID)
-	err := row.Scan(&tea.TeaType.Name)
+// CreateTeaType adds a new tea type to the database
+func (s *SQLStore) CreateTeaType(teaType *TeaType) error {
+	args := append([]interface{}{teaType.Name}, teaType.BrewingGuide.nullable()...)
+	id, err := s.dialect.insert(s.conn, "INSERT INTO types (name, brewTemperature, steepSeconds, leafGrams, caffeine, householdID) VALUES ($1, $2, $3, $4, $5, $6);", append(args, s.household)...)
 	if err != nil {
-		return errors.New("Tea type does not exist or is missing")
+		if s.dialect.isUniqueViolation(err) {
+			return errTeaTypeNameTaken
+		}
+		return err
 	}
+	teaType.ID = id
+	log.Printf("New ID: %d\n", teaType.ID)
 
-	_, err = DB.Exec("INSERT INTO tea (name, teaType) VALUES ($1, $2);", tea.Name, tea.TeaType.ID)
+	return nil
+}
+
+// UpdateTeaType changes the name and default brewing guide of a tea type in the database.
+func (s *SQLStore) UpdateTeaType(teaType *TeaType) error {
+	args := append([]interface{}{teaType.Name}, teaType.BrewingGuide.nullable()...)
+	result, err := s.conn.Exec("UPDATE types SET name = $1, brewTemperature = $2, steepSeconds = $3, leafGrams = $4, caffeine = $5 WHERE id = $6 AND householdID = $7;", append(args, teaType.ID, s.household)...)
 	if err != nil {
+		if s.dialect.isUniqueViolation(err) {
+			return errTeaTypeNameTaken
+		}
 		return err
 	}
 
-	row = DB.QueryRow("SELECT id FROM tea WHERE name = $1;", tea.Name)
-	err = row.Scan(&tea.ID)
-	if err != nil {
-		return errors.New("Tea ID not found after insert")
+	if updated, err := result.RowsAffected(); err != nil {
+		return err
+	} else if updated == 0 {
+		return errTeaTypeMissingID
 	}
-
 	return nil
 }
 
-// DeleteTeaFromDatabase deletes a tea from the database using it's ID.
-func DeleteTeaFromDatabase(tea *Tea) error {
-	rows, err := DB.Query("SELECT name FROM tea WHERE id=$1;", tea.ID)
+// DeleteTeaType deletes a tea type from the database.
+func (s *SQLStore) DeleteTeaType(teaType *TeaType) error {
+	return s.inTransaction(func(tx *SQLStore) error {
+		row := tx.conn.QueryRow("SELECT name FROM types WHERE id=$1 AND householdID=$2;", teaType.ID, tx.household)
+		if err := row.Scan(&teaType.Name); err != nil {
+			return notFound(err, errTeaTypeMissingID)
+		}
 
-	rows.Next()
-	err = rows.Scan(&tea.Name)
-	if err != nil {
+		_, err := tx.conn.Exec("DELETE FROM types WHERE id = $1 AND householdID = $2;", teaType.ID, tx.household)
+		if tx.dialect.isForeignKeyViolation(err) {
+			return errTeaTypeInUse
+		}
 		return err
-	}
-	rows.Close()
-
-	_, err = DB.Exec("DELETE FROM tea WHERE id = $1;", tea.ID)
-	return err
+	})
 }
 
-// GetTeaOwnersFromDatabase gets all owners of a tea using the tea's ID.
-func GetTeaOwnersFromDatabase(tea *Tea) ([]Owner, error) {
-	rows, err := DB.Query("SELECT owner.id, owner.name FROM teaOwners INNER JOIN owner ON teaOwners.ownerID = owner.id WHERE teaOwners.teaID = $1;", tea.ID)
+// GetAllOwners gets the owners in a list from the database.
+func (s *SQLStore) GetAllOwners(options ListOptions) ([]Owner, error) {
+	clauses, args := listClauses(options, "", s.household)
+	rows, err := s.conn.Query("SELECT id, name FROM owner WHERE householdID=$1"+clauses, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -395,96 +746,707 @@ func GetTeaOwnersFromDatabase(tea *Tea) ([]Owner, error) {
 		if err != nil {
 			return nil, err
 		}
-
 		owners = append(owners, *owner)
 	}
 	return owners, nil
 }
 
-// GetAllTeaOwnersFromDatabase gets all owners for all teas.
-func GetAllTeaOwnersFromDatabase() ([]TeaWithOwners, error) {
-	teaRows, err := DB.Query("SELECT tea.id, tea.name, tea.teaType, types.name FROM tea INNER JOIN types on types.id = tea.teaType;")
+// GetOwner gets an owner from the database by their ID.
+func (s *SQLStore) GetOwner(owner *Owner) error {
+	row := s.conn.QueryRow("SELECT name FROM owner WHERE id=$1 AND householdID=$2;", owner.ID, s.household)
+
+	err := row.Scan(&owner.Name)
 	if err != nil {
-		return nil, err
+		return notFound(err, errOwnerMissing)
 	}
-	defer teaRows.Close()
-
-	teas := make([]Tea, 0)
-	for teaRows.Next() {
-		tea := new(Tea)
-
-		err := teaRows.Scan(&tea.ID, &tea.Name, &tea.TeaType.ID, &tea.TeaType.Name)
-		if err != nil {
-			return nil, err
-		}
 
-		teas = append(teas, *tea)
-	}
+	return nil
+}
 
-	teasWithOwners := make([]TeaWithOwners, 0)
-	for _, tea := range teas {
-		owners, err := GetTeaOwnersFromDatabase(&tea)
-		if err != nil {
-			return nil, err
+// CreateOwner adds a new owner to the database
+func (s *SQLStore) CreateOwner(owner *Owner) error {
+	id, err := s.dialect.insert(s.conn, "INSERT INTO owner (name, householdID) VALUES ($1, $2);", owner.Name, s.household)
+	if err != nil {
+		if s.dialect.isUniqueViolation(err) {
+			return errOwnerNameTaken
 		}
-
-		teasWithOwners = append(teasWithOwners, TeaWithOwners{Tea: tea, Owners: owners})
+		return err
 	}
+	owner.ID = id
+	log.Printf("New ID: %d\n", owner.ID)
 
-	return teasWithOwners, nil
+	return nil
 }
 
-// CreateTeaOwnerInDatabase adds an owner to a tea in the database.
-func CreateTeaOwnerInDatabase(teaID int, owner *Owner) (Tea, error) {
-	tea := new(Tea)
-
-	_, err := DB.Exec("INSERT INTO teaOwners VALUES ($1, $2);", teaID, owner.ID)
+// UpdateOwner renames an owner in the database.
+func (s *SQLStore) UpdateOwner(owner *Owner) error {
+	result, err := s.conn.Exec("UPDATE owner SET name = $1 WHERE id = $2 AND householdID = $3;", owner.Name, owner.ID, s.household)
 	if err != nil {
-		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
-			return *tea, errors.New("This relationship already exists")
-		}
-		if strings.Contains(err.Error(), "FOREIGN KEY constraint failed") {
-			return *tea, errors.New("Either the tea or owner ID do not exist in the database")
+		if s.dialect.isUniqueViolation(err) {
+			return errOwnerNameTaken
 		}
-		return *tea, err
+		return err
 	}
 
-	row := DB.QueryRow("SELECT tea.id, tea.name, types.id, types.name FROM tea INNER JOIN types ON tea.teaType = types.id WHERE tea.id = $1;", teaID)
-	err = row.Scan(&tea.ID, &tea.Name, &tea.TeaType.ID, &tea.TeaType.Name)
-	if err != nil {
-		return *tea, errors.New("Tea ID not found after insert")
+	if updated, err := result.RowsAffected(); err != nil {
+		return err
+	} else if updated == 0 {
+		return errOwnerMissing
 	}
+	return nil
+}
 
-	return *tea, nil
+// DeleteOwner deletes an owner from the database.
+func (s *SQLStore) DeleteOwner(owner *Owner) error {
+	return s.inTransaction(func(tx *SQLStore) error {
+		row := tx.conn.QueryRow("SELECT name FROM owner WHERE id=$1 AND householdID=$2;", owner.ID, tx.household)
+		if err := row.Scan(&owner.Name); err != nil {
+			return notFound(err, errOwnerMissing)
+		}
+
+		_, err := tx.conn.Exec("DELETE FROM owner WHERE id = $1 AND householdID = $2;", owner.ID, tx.household)
+		if tx.dialect.isForeignKeyViolation(err) {
+			return errOwnerInUse
+		}
+		return err
+	})
 }
 
-// DeleteTeaOwnerFromDatabase deletes an owner of a tea from the database.
-func DeleteTeaOwnerFromDatabase(tea *Tea, owner *Owner) error {
-	_, err := DB.Exec("DELETE FROM teaOwners WHERE teaID = $1 AND ownerID = $2;", tea.ID, owner.ID)
-	return err
+// averageRatingJoin joins the average rating of each tea in the household given by the placeholder, as averages.rating.
+// It is NULL for teas without any ratings.
+func averageRatingJoin(household string) string {
+	return "LEFT JOIN (SELECT ratings.teaID, AVG(ratings.rating) AS rating FROM ratings INNER JOIN tea ON tea.id = ratings.teaID" +
+		" WHERE tea.householdID = " + household + " GROUP BY ratings.teaID) AS averages ON averages.teaID = tea.id"
 }
 
-// GetAllTypesTeasFromDatabase gets all teas by types.
-func GetAllTypesTeasFromDatabase() ([]TypeWithTeas, error) {
-	rows, err := DB.Query("SELECT * FROM types;")
+// GetAllTeas gets the teas in a list from the database.
+func (s *SQLStore) GetAllTeas(options ListOptions) ([]Tea, error) {
+	clauses, args := listClauses(options, "tea.", s.household)
+	rows, err := s.conn.Query("SELECT "+teaColumns+", averages.rating FROM tea INNER JOIN types ON types.ID = tea.teaType "+averageRatingJoin("$1")+" WHERE tea.householdID=$1"+clauses, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	typesWithTeas := make([]TypeWithTeas, 0)
+	teas := make([]Tea, 0)
 	for rows.Next() {
-		typeWithTeas := new(TypeWithTeas)
-		err := rows.Scan(&typeWithTeas.Type.ID, &typeWithTeas.Type.Name)
+		tea := new(Tea)
+		var averageRating sql.NullFloat64
+		err := scanTea(rows, tea, &averageRating)
 		if err != nil {
 			return nil, err
 		}
+		tea.AverageRating = averageRating.Float64
 
-		typesWithTeas = append(typesWithTeas, *typeWithTeas)
+		teas = append(teas, *tea)
+	}
+	return teas, nil
+}
+
+// searchTriggers keep the teaSearch index up to date as teas and tea types change.
+var searchTriggers = map[string]string{
+	"teaSearchInsert": `CREATE TRIGGER IF NOT EXISTS teaSearchInsert AFTER INSERT ON tea BEGIN
+							INSERT INTO teaSearch (rowid, name, typeName, notes) VALUES (NEW.id, NEW.name, (SELECT name FROM types WHERE id = NEW.teaType), NEW.notes);
+						END;`,
+	"teaSearchUpdate": `CREATE TRIGGER IF NOT EXISTS teaSearchUpdate AFTER UPDATE ON tea BEGIN
+							DELETE FROM teaSearch WHERE rowid = OLD.id;
+							INSERT INTO teaSearch (rowid, name, typeName, notes) VALUES (NEW.id, NEW.name, (SELECT name FROM types WHERE id = NEW.teaType), NEW.notes);
+						END;`,
+	"teaSearchDelete": `CREATE TRIGGER IF NOT EXISTS teaSearchDelete AFTER DELETE ON tea BEGIN
+							DELETE FROM teaSearch WHERE rowid = OLD.id;
+						END;`,
+	"teaSearchTypeUpdate": `CREATE TRIGGER IF NOT EXISTS teaSearchTypeUpdate AFTER UPDATE OF name ON types BEGIN
+							UPDATE teaSearch SET typeName = NEW.name WHERE rowid IN (SELECT id FROM tea WHERE teaType = NEW.id);
+						END;`,
+}
+
+// setupSearch creates the teaSearch full-text index of teas, along with the triggers keeping it up to date, and fills
+// it with every tea. It is rebuilt each time, as migrations drop the triggers.
+// Full-text search needs SQLite's FTS5 extension, which is only built in with the sqlite_fts5 build tag. Without it,
+// and with Postgres, teas are searched by matching their fields with LIKE instead.
+func (s *SQLStore) setupSearch() error {
+	s.fullText = false
+	if _, ok := s.dialect.(sqliteDialect); !ok {
+		log.Println("Full-text search is only available with SQLite, searching teas without it.")
+		return nil
+	}
+	_, err := s.conn.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS teaSearch USING fts5 (name, typeName, notes, tokenize = 'unicode61 remove_diacritics 2');")
+	if err != nil {
+		log.Printf("Full-text search is unavailable, searching teas without it: %v\n", err)
+		// Triggers left by a build with full-text search would stop teas from being changed
+		return dropSearchTriggers(s.conn)
+	}
+
+	err = s.inTransaction(func(tx *SQLStore) error {
+		for _, trigger := range searchTriggers {
+			if _, err := tx.conn.Exec(trigger); err != nil {
+				return err
+			}
+		}
+		return execAll(tx.conn,
+			"DELETE FROM teaSearch;",
+			"INSERT INTO teaSearch (rowid, name, typeName, notes) SELECT tea.id, tea.name, types.name, tea.notes FROM tea LEFT JOIN types ON types.id = tea.teaType;")
+	})
+	if err != nil {
+		return err
+	}
+	s.fullText = true
+	return nil
+}
+
+// dropSearchTriggers drops the triggers keeping the teaSearch index up to date, if they exist.
+func dropSearchTriggers(db execer) error {
+	for name := range searchTriggers {
+		if _, err := db.Exec("DROP TRIGGER IF EXISTS " + name + ";"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SearchTeas gets the teas matching every term from the database. The teaSearch index is used if it is available,
+// ranking teas with BM25.
+func (s *SQLStore) SearchTeas(terms []string, limit int) ([]Tea, error) {
+	if len(terms) == 0 {
+		return make([]Tea, 0), nil
+	}
+
+	var query string
+	var args []interface{}
+	if s.fullText {
+		household := placeholder(&args, s.household)
+		query = "SELECT " + teaColumns + ", averages.rating FROM teaSearch INNER JOIN tea ON tea.id = teaSearch.rowid INNER JOIN types ON types.id = tea.teaType " + averageRatingJoin(household) +
+			" WHERE teaSearch MATCH " + placeholder(&args, fullTextQuery(terms)) + " AND tea.householdID = " + household +
+			fmt.Sprintf(" ORDER BY bm25(teaSearch, %d, %d, %d), tea.id", searchNameWeight, searchTypeWeight, searchNotesWeight)
+	} else {
+		query, args = s.likeSearchQuery(terms)
+	}
+	if limit > 0 {
+		query += " LIMIT " + placeholder(&args, limit)
+	}
+
+	rows, err := s.conn.Query(query+";", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	teas := make([]Tea, 0)
+	for rows.Next() {
+		tea := new(Tea)
+		var averageRating sql.NullFloat64
+		if err := scanTea(rows, tea, &averageRating); err != nil {
+			return nil, err
+		}
+		tea.AverageRating = averageRating.Float64
+		teas = append(teas, *tea)
+	}
+	if err := rows.Err(); err != nil || len(teas) > 0 {
+		return teas, err
+	}
+
+	// If nothing matched, the search might have a typo in it
+	all, err := s.GetAllTeas(ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return rankTeas(all, terms, limit, fuzzyScore), nil
+}
+
+// searchFields are the fields of a tea matched against each term when searching without the full-text index, with how
+// much a match adds to the tea's relevance.
+var searchFields = []struct {
+	column string
+	weight int
+}{
+	{"LOWER(tea.name)", searchNameWeight},
+	{"LOWER(types.name)", searchTypeWeight},
+	{"LOWER(tea.notes)", searchNotesWeight},
+}
+
+// likeSearchQuery gives the query searching teas without the full-text index, which ranks them in the same way as
+// searchScore.
+func (s *SQLStore) likeSearchQuery(terms []string) (string, []interface{}) {
+	args := []interface{}{s.household}
+	var conditions strings.Builder
+	scores := make([]string, 0, len(terms)*len(searchFields))
+	for _, term := range terms {
+		pattern := placeholder(&args, "%"+likePrefix(term))
+		matches := make([]string, 0, len(searchFields))
+		for _, field := range searchFields {
+			match := field.column + " LIKE " + pattern + ` ESCAPE '\'`
+			matches = append(matches, match)
+			scores = append(scores, fmt.Sprintf("CASE WHEN %s THEN %d ELSE 0 END", match, field.weight))
+		}
+		conditions.WriteString(" AND (" + strings.Join(matches, " OR ") + ")")
+	}
+
+	query := "SELECT " + teaColumns + ", averages.rating FROM tea INNER JOIN types ON types.id = tea.teaType " + averageRatingJoin("$1") +
+		" WHERE tea.householdID = $1" + conditions.String() +
+		" ORDER BY " + strings.Join(scores, " + ") + " DESC, tea.id"
+	return query, args
+}
+
+// GetTea gets information about a tea from the database using it's ID
+func (s *SQLStore) GetTea(tea *Tea) error {
+	row := s.conn.QueryRow("SELECT "+teaColumns+", averages.rating FROM tea INNER JOIN types ON tea.teaType=types.id "+averageRatingJoin("$1")+" WHERE tea.id=$2 AND tea.householdID=$1", s.household, tea.ID)
+
+	var averageRating sql.NullFloat64
+	err := scanTea(row, tea, &averageRating)
+	if err != nil {
+		return notFound(err, errTeaMissing)
+	}
+	tea.AverageRating = averageRating.Float64
+
+	return nil
+}
+
+// CreateTea creates a new tea in the database. Uses the type ID to do so.
+func (s *SQLStore) CreateTea(tea *Tea) error {
+	return s.inTransaction(func(tx *SQLStore) error {
+		if err := tx.getTeaTypeName(&tea.TeaType); err != nil {
+			return err
+		}
+
+		args := append([]interface{}{tea.Name, tea.TeaType.ID}, tea.BrewingGuide.nullable()...)
+		id, err := tx.dialect.insert(tx.conn, "INSERT INTO tea (name, teaType, brewTemperature, steepSeconds, leafGrams, caffeine, notes, householdID) VALUES ($1, $2, $3, $4, $5, $6, $7, $8);", append(args, nullableString(tea.Notes), tx.household)...)
+		if err != nil {
+			if tx.dialect.isUniqueViolation(err) {
+				return errTeaNameTaken
+			}
+			return err
+		}
+		tea.ID = id
+
+		return nil
+	})
+}
+
+// UpdateTea changes the details of a tea in the database. Uses the type ID to do so.
+func (s *SQLStore) UpdateTea(tea *Tea) error {
+	return s.inTransaction(func(tx *SQLStore) error {
+		if err := tx.getTeaTypeName(&tea.TeaType); err != nil {
+			return err
+		}
+
+		args := append([]interface{}{tea.Name, tea.TeaType.ID}, tea.BrewingGuide.nullable()...)
+		args = append(args, nullableString(tea.Notes), tea.ID, tx.household)
+		result, err := tx.conn.Exec("UPDATE tea SET name = $1, teaType = $2, brewTemperature = $3, steepSeconds = $4, leafGrams = $5, caffeine = $6, notes = $7 WHERE id = $8 AND householdID = $9;", args...)
+		if err != nil {
+			if tx.dialect.isUniqueViolation(err) {
+				return errTeaNameTaken
+			}
+			return err
+		}
+
+		if updated, err := result.RowsAffected(); err != nil {
+			return err
+		} else if updated == 0 {
+			return errTeaMissing
+		}
+
+		return tx.GetTea(tea)
+	})
+}
+
+// DeleteTea deletes a tea from the database using it's ID.
+func (s *SQLStore) DeleteTea(tea *Tea) error {
+	return s.inTransaction(func(tx *SQLStore) error {
+		row := tx.conn.QueryRow("SELECT name FROM tea WHERE id=$1 AND householdID=$2;", tea.ID, tx.household)
+		if err := row.Scan(&tea.Name); err != nil {
+			return notFound(err, errTeaMissing)
+		}
+
+		_, err := tx.conn.Exec("DELETE FROM tea WHERE id = $1 AND householdID = $2;", tea.ID, tx.household)
+		if tx.dialect.isForeignKeyViolation(err) {
+			return errTeaInUse
+		}
+		return err
+	})
+}
+
+// getTeaTypeName fills in the name of a tea's type, checking the type exists in the household.
+func (s *SQLStore) getTeaTypeName(teaType *TeaType) error {
+	row := s.conn.QueryRow("SELECT name FROM types WHERE id = $1 AND householdID = $2;", teaType.ID, s.household)
+	if err := row.Scan(&teaType.Name); err != nil {
+		return notFound(err, errTeaTypeMissing)
+	}
+	return nil
+}
+
+// inHousehold checks that the row of a table with the given ID belongs to the household, giving the missing error if
+// it doesn't. IDs are unique across every household, so this stops rows from different households being linked.
+func (s *SQLStore) inHousehold(table string, id int, missing error) error {
+	var count int
+	row := s.conn.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE id = $1 AND householdID = $2;", id, s.household)
+	if err := row.Scan(&count); err != nil {
+		return err
+	}
+	if count == 0 {
+		return missing
+	}
+	return nil
+}
+
+// GetTeaOwners gets all owners of a tea using the tea's ID, along with their stock of the tea.
+func (s *SQLStore) GetTeaOwners(tea *Tea) ([]TeaOwner, error) {
+	rows, err := s.conn.Query("SELECT owner.id, owner.name, teaOwners.quantity, teaOwners.unit FROM teaOwners INNER JOIN owner ON teaOwners.ownerID = owner.id WHERE teaOwners.teaID = $1 AND teaOwners.householdID = $2 ORDER BY owner.id;", tea.ID, s.household)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	owners := make([]TeaOwner, 0)
+	for rows.Next() {
+		owner := new(TeaOwner)
+		var stock nullStock
+		err := rows.Scan(&owner.ID, &owner.Name, &stock.quantity, &stock.unit)
+		if err != nil {
+			return nil, err
+		}
+		owner.Stock = stock.value()
+
+		owners = append(owners, *owner)
+	}
+	return owners, nil
+}
+
+// GetAllTeaOwners gets all owners for the teas in a list.
+func (s *SQLStore) GetAllTeaOwners(options ListOptions) ([]TeaWithOwners, error) {
+	clauses, args := listClauses(options, "tea.", s.household)
+	teaRows, err := s.conn.Query("SELECT tea.id, tea.name, tea.teaType, types.name FROM tea INNER JOIN types on types.id = tea.teaType WHERE tea.householdID = $1"+clauses, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer teaRows.Close()
+
+	teas := make([]Tea, 0)
+	for teaRows.Next() {
+		tea := new(Tea)
+
+		err := teaRows.Scan(&tea.ID, &tea.Name, &tea.TeaType.ID, &tea.TeaType.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		teas = append(teas, *tea)
+	}
+
+	teasWithOwners := make([]TeaWithOwners, 0)
+	for _, tea := range teas {
+		owners, err := s.GetTeaOwners(&tea)
+		if err != nil {
+			return nil, err
+		}
+
+		teasWithOwners = append(teasWithOwners, TeaWithOwners{Tea: tea, Owners: owners})
+	}
+
+	return teasWithOwners, nil
+}
+
+// CreateTeaOwner adds an owner to a tea in the database, with their initial stock of the tea. The tea and owner must
+// both belong to the household. The stock isn't tracked if no quantity is given.
+func (s *SQLStore) CreateTeaOwner(teaID int, owner *Owner, stock Stock) (Tea, error) {
+	tea := new(Tea)
+	err := s.inTransaction(func(tx *SQLStore) error {
+		if err := tx.inHousehold("tea", teaID, errTeaOrOwnerMissing); err != nil {
+			return err
+		}
+		if err := tx.inHousehold("owner", owner.ID, errTeaOrOwnerMissing); err != nil {
+			return err
+		}
+
+		_, err := tx.conn.Exec("INSERT INTO teaOwners (teaID, ownerID, quantity, unit, householdID) VALUES ($1, $2, $3, $4, $5);", teaID, owner.ID, stock.nullQuantity(), nullableString(stock.Unit), tx.household)
+		if err != nil {
+			if tx.dialect.isUniqueViolation(err) {
+				return errTeaOwnerExists
+			}
+			if tx.dialect.isForeignKeyViolation(err) {
+				return errTeaOrOwnerMissing
+			}
+			return err
+		}
+
+		row := tx.conn.QueryRow("SELECT tea.id, tea.name, types.id, types.name FROM tea INNER JOIN types ON tea.teaType = types.id WHERE tea.id = $1 AND tea.householdID = $2;", teaID, tx.household)
+		if err := row.Scan(&tea.ID, &tea.Name, &tea.TeaType.ID, &tea.TeaType.Name); err != nil {
+			return errors.New("Tea ID not found after insert")
+		}
+		return nil
+	})
+	if err != nil {
+		return Tea{}, err
+	}
+
+	return *tea, nil
+}
+
+// CreateTeaWithOwners creates a tea and adds its owners in a transaction, so the tea isn't created if any of its owners
+// can't be added.
+func (s *SQLStore) CreateTeaWithOwners(tea *Tea, owners []TeaOwner, createType bool) (TeaWithOwners, error) {
+	var created TeaWithOwners
+	err := s.inTransaction(func(tx *SQLStore) error {
+		var err error
+		created, err = createTeaWithOwners(tx, tea, owners, createType)
+		return err
+	})
+	return created, err
+}
+
+// DeleteTeaOwner deletes an owner of a tea from the database.
+func (s *SQLStore) DeleteTeaOwner(tea *Tea, owner *Owner) error {
+	result, err := s.conn.Exec("DELETE FROM teaOwners WHERE teaID = $1 AND ownerID = $2 AND householdID = $3;", tea.ID, owner.ID, s.household)
+	if err != nil {
+		return err
+	}
+
+	if deleted, err := result.RowsAffected(); err != nil {
+		return err
+	} else if deleted == 0 {
+		return errTeaOwnerMissing
+	}
+	return nil
+}
+
+// ErrStockNotTracked is returned when consuming a tea that the owner isn't tracking the stock of.
+var ErrStockNotTracked = newError(ErrConflict, "Stock of this tea is not being tracked")
+
+// ErrStockUnitMismatch is returned when restocking a tea using a different unit to the existing stock.
+var ErrStockUnitMismatch = newError(ErrValidation, "Stock of this tea is measured in a different unit")
+
+// getTeaStock gets an owner's stock of a tea.
+func (s *SQLStore) getTeaStock(teaID int, ownerID int) (Stock, error) {
+	var stock nullStock
+	row := s.conn.QueryRow("SELECT quantity, unit FROM teaOwners WHERE teaID = $1 AND ownerID = $2 AND householdID = $3;", teaID, ownerID, s.household)
+	if err := row.Scan(&stock.quantity, &stock.unit); err != nil {
+		return Stock{}, notFound(err, errTeaOwnerMissing)
+	}
+	return stock.value(), nil
+}
+
+// ConsumeTeaStock takes the given quantity from an owner's stock of a tea. The stock will not go below zero.
+func (s *SQLStore) ConsumeTeaStock(teaID int, ownerID int, quantity float64) (Stock, error) {
+	var stock, updated Stock
+	err := s.inTransaction(func(tx *SQLStore) error {
+		var err error
+		if stock, err = tx.getTeaStock(teaID, ownerID); err != nil {
+			return err
+		}
+		if stock.Quantity == nil {
+			return ErrStockNotTracked
+		}
+
+		_, err = tx.conn.Exec("UPDATE teaOwners SET quantity = CASE WHEN quantity > $1 THEN quantity - $1 ELSE 0 END WHERE teaID = $2 AND ownerID = $3 AND householdID = $4;", quantity, teaID, ownerID, tx.household)
+		if err != nil {
+			return err
+		}
+
+		updated, err = tx.getTeaStock(teaID, ownerID)
+		return err
+	})
+	if err != nil {
+		return stock, err
+	}
+	s.stockChanged(teaID, ownerID, stock, updated)
+	return updated, nil
+}
+
+// RestockTea adds the given quantity to an owner's stock of a tea.
+// If the stock wasn't being tracked, tracking starts from the given quantity.
+func (s *SQLStore) RestockTea(teaID int, ownerID int, change Stock) (Stock, error) {
+	var stock, updated Stock
+	err := s.inTransaction(func(tx *SQLStore) error {
+		var err error
+		if stock, err = tx.getTeaStock(teaID, ownerID); err != nil {
+			return err
+		}
+
+		unit := change.Unit
+		if stock.Quantity != nil {
+			if unit != "" && unit != stock.Unit {
+				return ErrStockUnitMismatch
+			}
+			unit = stock.Unit
+		}
+		if unit == "" {
+			unit = UnitBags
+		}
+
+		_, err = tx.conn.Exec("UPDATE teaOwners SET quantity = COALESCE(quantity, 0) + $1, unit = $2 WHERE teaID = $3 AND ownerID = $4 AND householdID = $5;", *change.Quantity, unit, teaID, ownerID, tx.household)
+		if err != nil {
+			return err
+		}
+
+		updated, err = tx.getTeaStock(teaID, ownerID)
+		return err
+	})
+	if err != nil {
+		return stock, err
+	}
+	s.stockChanged(teaID, ownerID, stock, updated)
+	return updated, nil
+}
+
+// ImportCollection imports a collection in a transaction, so nothing is changed if any part of it fails.
+func (s *SQLStore) ImportCollection(collection Collection, dryRun bool, checkOwner func(ownerID int) error) (ImportReport, error) {
+	var report ImportReport
+	err := s.inTransaction(func(tx *SQLStore) error {
+		var err error
+		report, err = importCollection(tx, collection, dryRun, checkOwner)
+		return err
+	})
+	return report, err
+}
+
+// stockChanged tells the stock observer, if there is one, about a change to an owner's stock of a tea.
+func (s *SQLStore) stockChanged(teaID int, ownerID int, before Stock, after Stock) {
+	if s.observer != nil {
+		s.observer.StockChanged(s.household, teaID, ownerID, before, after)
+	}
+}
+
+// GetStock gets the stock of every owner of each tea, by tea ID, only including the given owners.
+// If no owners are given, the stock of all owners is included.
+func (s *SQLStore) GetStock(ownerIDs []int) (map[int][]Stock, error) {
+	ownerIDs = uniqueIDs(ownerIDs)
+
+	var query strings.Builder
+	args := make([]interface{}, 0, len(ownerIDs)+1)
+	query.WriteString("SELECT teaID, quantity, unit FROM teaOwners WHERE householdID = " + placeholder(&args, s.household))
+	if len(ownerIDs) > 0 {
+		placeholders := make([]string, 0, len(ownerIDs))
+		for _, id := range ownerIDs {
+			placeholders = append(placeholders, placeholder(&args, id))
+		}
+		query.WriteString(" AND ownerID IN (" + strings.Join(placeholders, ", ") + ")")
+	}
+	query.WriteString(" ORDER BY teaID, ownerID;")
+
+	rows, err := s.conn.Query(query.String(), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stock := make(map[int][]Stock)
+	for rows.Next() {
+		var teaID int
+		var ownerStock nullStock
+		if err := rows.Scan(&teaID, &ownerStock.quantity, &ownerStock.unit); err != nil {
+			return nil, err
+		}
+		stock[teaID] = append(stock[teaID], ownerStock.value())
+	}
+	return stock, nil
+}
+
+// CreateWebhookDelivery records a new webhook delivery, before any attempts have been made.
+func (s *SQLStore) CreateWebhookDelivery(delivery *WebhookDelivery) error {
+	id, err := s.dialect.insert(s.conn, "INSERT INTO webhookDeliveries (event, url, payload, createdAt, householdID) VALUES ($1, $2, $3, $4, $5);", delivery.Event, delivery.URL, delivery.Payload, delivery.CreatedAt.Unix(), s.household)
+	if err != nil {
+		return err
+	}
+	delivery.ID = id
+	return nil
+}
+
+// UpdateWebhookDelivery records the result of the latest attempt at a webhook delivery.
+func (s *SQLStore) UpdateWebhookDelivery(delivery *WebhookDelivery) error {
+	_, err := s.conn.Exec("UPDATE webhookDeliveries SET attempts = $1, statusCode = $2, delivered = $3, error = $4 WHERE id = $5 AND householdID = $6;", delivery.Attempts, nullableInt(delivery.StatusCode), delivery.Delivered, nullableString(delivery.Error), delivery.ID, s.household)
+	return err
+}
+
+// GetWebhookDeliveries gets a page of the webhook deliveries, most recent first, along with the total number of deliveries.
+func (s *SQLStore) GetWebhookDeliveries(limit int, offset int) ([]WebhookDelivery, int, error) {
+	var total int
+	row := s.conn.QueryRow("SELECT COUNT(*) FROM webhookDeliveries WHERE householdID = $1;", s.household)
+	if err := row.Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := s.conn.Query("SELECT id, event, url, payload, attempts, statusCode, delivered, error, createdAt FROM webhookDeliveries WHERE householdID = $1 ORDER BY id DESC LIMIT $2 OFFSET $3;", s.household, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	deliveries := make([]WebhookDelivery, 0)
+	for rows.Next() {
+		delivery := new(WebhookDelivery)
+		var statusCode sql.NullInt64
+		var deliveryError sql.NullString
+		var createdAt int64
+		err := rows.Scan(&delivery.ID, &delivery.Event, &delivery.URL, &delivery.Payload, &delivery.Attempts, &statusCode, &delivery.Delivered, &deliveryError, &createdAt)
+		if err != nil {
+			return nil, 0, err
+		}
+		delivery.StatusCode = int(statusCode.Int64)
+		delivery.Error = deliveryError.String
+		delivery.CreatedAt = time.Unix(createdAt, 0).UTC()
+
+		deliveries = append(deliveries, *delivery)
+	}
+	return deliveries, total, nil
+}
+
+// CreateAuditEntry adds an entry to the household's audit log.
+func (s *SQLStore) CreateAuditEntry(entry *AuditEntry) error {
+	id, err := s.dialect.insert(s.conn, "INSERT INTO auditLog (event, username, address, message, createdAt, householdID) VALUES ($1, $2, $3, $4, $5, $6);", entry.Event, entry.Username, entry.Address, entry.Message, entry.CreatedAt.Unix(), s.household)
+	if err != nil {
+		return err
+	}
+	entry.ID = id
+	return nil
+}
+
+// GetAuditEntries gets a page of the household's audit log, most recent first, along with the total number of entries.
+func (s *SQLStore) GetAuditEntries(limit int, offset int) ([]AuditEntry, int, error) {
+	var total int
+	row := s.conn.QueryRow("SELECT COUNT(*) FROM auditLog WHERE householdID = $1;", s.household)
+	if err := row.Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := s.conn.Query("SELECT id, event, username, address, message, createdAt FROM auditLog WHERE householdID = $1 ORDER BY id DESC LIMIT $2 OFFSET $3;", s.household, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	entries := make([]AuditEntry, 0)
+	for rows.Next() {
+		var entry AuditEntry
+		var createdAt int64
+		if err := rows.Scan(&entry.ID, &entry.Event, &entry.Username, &entry.Address, &entry.Message, &createdAt); err != nil {
+			return nil, 0, err
+		}
+		entry.CreatedAt = time.Unix(createdAt, 0).UTC()
+		entries = append(entries, entry)
+	}
+	return entries, total, nil
+}
+
+// GetAllTypesTeas gets all teas by types.
+func (s *SQLStore) GetAllTypesTeas() ([]TypeWithTeas, error) {
+	rows, err := s.conn.Query("SELECT id, name FROM types WHERE householdID = $1 ORDER BY id;", s.household)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	typesWithTeas := make([]TypeWithTeas, 0)
+	for rows.Next() {
+		typeWithTeas := new(TypeWithTeas)
+		err := rows.Scan(&typeWithTeas.Type.ID, &typeWithTeas.Type.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		typesWithTeas = append(typesWithTeas, *typeWithTeas)
 	}
 
 	for i := range typesWithTeas {
-		teaRows, err := DB.Query("SELECT tea.id, tea.name FROM tea WHERE tea.teaType = $1;", typesWithTeas[i].Type.ID)
+		teaRows, err := s.conn.Query("SELECT tea.id, tea.name FROM tea WHERE tea.teaType = $1 AND tea.householdID = $2 ORDER BY tea.id;", typesWithTeas[i].Type.ID, s.household)
 		if err != nil {
 			return nil, err
 		}
@@ -505,9 +1467,9 @@ func GetAllTypesTeasFromDatabase() ([]TypeWithTeas, error) {
 	return typesWithTeas, nil
 }
 
-// GetALlOwnersTeasFromDatabase gets all teas for each owner.
-func GetAllOwnersTeasFromDatabase() ([]OwnerWithTeas, error) {
-	rows, err := DB.Query("SELECT * FROM owner;")
+// GetAllOwnersTeas gets all teas for each owner.
+func (s *SQLStore) GetAllOwnersTeas() ([]OwnerWithTeas, error) {
+	rows, err := s.conn.Query("SELECT id, name FROM owner WHERE householdID = $1 ORDER BY id;", s.household)
 	if err != nil {
 		return nil, err
 	}
@@ -525,7 +1487,7 @@ func GetAllOwnersTeasFromDatabase() ([]OwnerWithTeas, error) {
 	}
 
 	for i := range ownersWithTeas {
-		teaRows, err := DB.Query("SELECT tea.id, tea.name, types.id, types.name FROM teaOwners INNER JOIN tea ON teaOwners.teaID = tea.id INNER JOIN types ON types.id = tea.teaType WHERE teaOwners.ownerID = $1;", ownersWithTeas[i].Owner.ID)
+		teaRows, err := s.conn.Query("SELECT tea.id, tea.name, types.id, types.name FROM teaOwners INNER JOIN tea ON teaOwners.teaID = tea.id INNER JOIN types ON types.id = tea.teaType WHERE teaOwners.ownerID = $1 AND teaOwners.householdID = $2 ORDER BY tea.id;", ownersWithTeas[i].Owner.ID, s.household)
 		if err != nil {
 			return nil, err
 		}
@@ -543,3 +1505,455 @@ func GetAllOwnersTeasFromDatabase() ([]OwnerWithTeas, error) {
 
 	return ownersWithTeas, nil
 }
+
+// GetSelectionCandidates gets the teas that can be selected from using the given options.
+// Only teas owned by every one of the given owners are returned. If no owners are given, all teas are candidates.
+func (s *SQLStore) GetSelectionCandidates(options SelectionOptions) ([]Tea, error) {
+	ownerIDs := uniqueIDs(options.OwnerIDs)
+
+	var query strings.Builder
+	args := make([]interface{}, 0)
+	conditions := []string{"tea.householdID = " + placeholder(&args, s.household)}
+	query.WriteString("SELECT " + teaColumns + " FROM tea INNER JOIN types ON types.id = tea.teaType")
+
+	if len(ownerIDs) > 0 {
+		query.WriteString(" INNER JOIN teaOwners ON teaOwners.teaID = tea.id")
+		placeholders := make([]string, 0, len(ownerIDs))
+		for _, id := range ownerIDs {
+			placeholders = append(placeholders, placeholder(&args, id))
+		}
+		ownerList := strings.Join(placeholders, ", ")
+		conditions = append(conditions, "teaOwners.ownerID IN ("+ownerList+")")
+		conditions = append(conditions, "tea.id NOT IN (SELECT teaID FROM ratings WHERE neverPick = TRUE AND ownerID IN ("+ownerList+"))")
+		conditions = append(conditions, "tea.id NOT IN (SELECT teaID FROM teaOwners WHERE ownerID IN ("+ownerList+") GROUP BY teaID HAVING "+outOfStock+")")
+	} else {
+		conditions = append(conditions, "tea.id NOT IN (SELECT teaID FROM teaOwners GROUP BY teaID HAVING "+outOfStock+")")
+	}
+	if options.ExcludePicks > 0 {
+		conditions = append(conditions, "tea.id NOT IN (SELECT selections.teaID FROM selections INNER JOIN tea ON tea.id = selections.teaID WHERE tea.householdID = "+placeholder(&args, s.household)+" ORDER BY selections.id DESC LIMIT "+placeholder(&args, options.ExcludePicks)+")")
+	}
+	if !options.ExcludeSince.IsZero() {
+		conditions = append(conditions, "tea.id NOT IN (SELECT selections.teaID FROM selections INNER JOIN tea ON tea.id = selections.teaID WHERE tea.householdID = "+placeholder(&args, s.household)+" AND selections.selectedAt >= "+placeholder(&args, options.ExcludeSince.Unix())+")")
+	}
+	if len(options.Caffeine) > 0 {
+		placeholders := make([]string, 0, len(options.Caffeine))
+		for _, level := range options.Caffeine {
+			placeholders = append(placeholders, placeholder(&args, level))
+		}
+		conditions = append(conditions, "COALESCE(tea.caffeine, types.caffeine) IN ("+strings.Join(placeholders, ", ")+")")
+	}
+
+	query.WriteString(" WHERE " + strings.Join(conditions, " AND "))
+	if len(ownerIDs) > 0 {
+		query.WriteString(" GROUP BY tea.id, types.id HAVING COUNT(DISTINCT teaOwners.ownerID) = " + placeholder(&args, len(ownerIDs)))
+	}
+	query.WriteString(" ORDER BY tea.id;")
+
+	rows, err := s.conn.Query(query.String(), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	teas := make([]Tea, 0)
+	for rows.Next() {
+		tea := new(Tea)
+		err := scanTea(rows, tea)
+		if err != nil {
+			return nil, err
+		}
+
+		teas = append(teas, *tea)
+	}
+	return teas, nil
+}
+
+// outOfStock is a HAVING condition for teaOwners grouped by tea, which is true when none of the owners have any of the tea left.
+// Owners who aren't tracking their stock are assumed to have some left.
+const outOfStock = "MAX(CASE WHEN quantity IS NULL OR quantity > 0 THEN 1 ELSE 0 END) = 0"
+
+// CreateSelection records a selection in the selection history. The tea and owners must belong to the household.
+func (s *SQLStore) CreateSelection(selection *SelectionRecord) error {
+	return s.inTransaction(func(tx *SQLStore) error {
+		if err := tx.inHousehold("tea", selection.Tea.ID, errTeaMissing); err != nil {
+			return err
+		}
+		for _, owner := range selection.Owners {
+			if err := tx.inHousehold("owner", owner.ID, errOwnerMissing); err != nil {
+				return err
+			}
+		}
+
+		id, err := tx.dialect.insert(tx.conn, "INSERT INTO selections (teaID, selectedAt) VALUES ($1, $2);", selection.Tea.ID, selection.SelectedAt.Unix())
+		if err != nil {
+			return err
+		}
+
+		for _, owner := range selection.Owners {
+			_, err := tx.conn.Exec("INSERT INTO selectionOwners (selectionID, ownerID) VALUES ($1, $2);", id, owner.ID)
+			if err != nil {
+				return err
+			}
+		}
+
+		selection.ID = id
+		return nil
+	})
+}
+
+// GetSelections gets a page of the selection history, most recent first, along with the total number of selections.
+func (s *SQLStore) GetSelections(limit int, offset int) ([]SelectionRecord, int, error) {
+	var total int
+	row := s.conn.QueryRow("SELECT COUNT(*) FROM selections INNER JOIN tea ON tea.id = selections.teaID WHERE tea.householdID = $1;", s.household)
+	if err := row.Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := s.conn.Query("SELECT selections.id, selections.selectedAt, tea.id, tea.name, types.id, types.name FROM selections INNER JOIN tea ON tea.id = selections.teaID INNER JOIN types ON types.id = tea.teaType WHERE tea.householdID = $1 ORDER BY selections.id DESC LIMIT $2 OFFSET $3;", s.household, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	selections := make([]SelectionRecord, 0)
+	for rows.Next() {
+		selection := new(SelectionRecord)
+		var selectedAt int64
+		err := rows.Scan(&selection.ID, &selectedAt, &selection.Tea.ID, &selection.Tea.Name, &selection.Tea.TeaType.ID, &selection.Tea.TeaType.Name)
+		if err != nil {
+			return nil, 0, err
+		}
+		selection.SelectedAt = time.Unix(selectedAt, 0).UTC()
+
+		selections = append(selections, *selection)
+	}
+	rows.Close()
+
+	for i := range selections {
+		ownerRows, err := s.conn.Query("SELECT owner.id, owner.name FROM selectionOwners INNER JOIN owner ON owner.id = selectionOwners.ownerID WHERE selectionOwners.selectionID = $1 AND owner.householdID = $2 ORDER BY owner.id;", selections[i].ID, s.household)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		selections[i].Owners = make([]Owner, 0)
+		for ownerRows.Next() {
+			owner := new(Owner)
+			if err := ownerRows.Scan(&owner.ID, &owner.Name); err != nil {
+				ownerRows.Close()
+				return nil, 0, err
+			}
+			selections[i].Owners = append(selections[i].Owners, *owner)
+		}
+		ownerRows.Close()
+	}
+
+	return selections, total, nil
+}
+
+// GetTeaRatings gets all the ratings of a tea using the tea's ID.
+func (s *SQLStore) GetTeaRatings(tea *Tea) ([]Rating, error) {
+	rows, err := s.conn.Query("SELECT owner.id, owner.name, ratings.rating, ratings.neverPick FROM ratings INNER JOIN owner ON ratings.ownerID = owner.id WHERE ratings.teaID = $1 AND owner.householdID = $2 ORDER BY owner.id;", tea.ID, s.household)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ratings := make([]Rating, 0)
+	for rows.Next() {
+		rating := new(Rating)
+		var stars sql.NullInt64
+		err := rows.Scan(&rating.Owner.ID, &rating.Owner.Name, &stars, &rating.NeverPick)
+		if err != nil {
+			return nil, err
+		}
+		rating.Rating = int(stars.Int64)
+
+		ratings = append(ratings, *rating)
+	}
+	return ratings, nil
+}
+
+// CreateTeaRating adds an owner's rating of a tea to the database. The tea and owner must both belong to the household.
+func (s *SQLStore) CreateTeaRating(teaID int, rating *Rating) error {
+	return s.inTransaction(func(tx *SQLStore) error {
+		if err := tx.inHousehold("tea", teaID, errTeaOrOwnerMissing); err != nil {
+			return err
+		}
+		if err := tx.inHousehold("owner", rating.Owner.ID, errTeaOrOwnerMissing); err != nil {
+			return err
+		}
+
+		_, err := tx.conn.Exec("INSERT INTO ratings (teaID, ownerID, rating, neverPick) VALUES ($1, $2, $3, $4);", teaID, rating.Owner.ID, nullableInt(rating.Rating), rating.NeverPick)
+		if err != nil {
+			if tx.dialect.isUniqueViolation(err) {
+				return errRatingExists
+			}
+			if tx.dialect.isForeignKeyViolation(err) {
+				return errTeaOrOwnerMissing
+			}
+			return err
+		}
+
+		row := tx.conn.QueryRow("SELECT name FROM owner WHERE id = $1 AND householdID = $2;", rating.Owner.ID, tx.household)
+		if err := row.Scan(&rating.Owner.Name); err != nil {
+			return errors.New("Owner not found after insert")
+		}
+
+		return nil
+	})
+}
+
+// UpdateTeaRating changes an owner's existing rating of a tea.
+func (s *SQLStore) UpdateTeaRating(teaID int, rating *Rating) error {
+	return s.inTransaction(func(tx *SQLStore) error {
+		result, err := tx.conn.Exec("UPDATE ratings SET rating = $1, neverPick = $2 WHERE teaID = $3 AND ownerID = $4 AND ownerID IN (SELECT id FROM owner WHERE householdID = $5);", nullableInt(rating.Rating), rating.NeverPick, teaID, rating.Owner.ID, tx.household)
+		if err != nil {
+			return err
+		}
+
+		if updated, err := result.RowsAffected(); err != nil {
+			return err
+		} else if updated == 0 {
+			return errRatingMissing
+		}
+
+		row := tx.conn.QueryRow("SELECT name FROM owner WHERE id = $1 AND householdID = $2;", rating.Owner.ID, tx.household)
+		return row.Scan(&rating.Owner.Name)
+	})
+}
+
+// DeleteTeaRating deletes an owner's rating of a tea from the database.
+func (s *SQLStore) DeleteTeaRating(teaID int, owner *Owner) error {
+	result, err := s.conn.Exec("DELETE FROM ratings WHERE teaID = $1 AND ownerID = $2 AND ownerID IN (SELECT id FROM owner WHERE householdID = $3);", teaID, owner.ID, s.household)
+	if err != nil {
+		return err
+	}
+
+	if deleted, err := result.RowsAffected(); err != nil {
+		return err
+	} else if deleted == 0 {
+		return errRatingMissing
+	}
+	return nil
+}
+
+// GetAverageRatings gets the average rating of each tea, by tea ID, only counting the ratings of the given owners.
+// If no owners are given, all ratings are used. Teas without any ratings are not included.
+func (s *SQLStore) GetAverageRatings(ownerIDs []int) (map[int]float64, error) {
+	ownerIDs = uniqueIDs(ownerIDs)
+
+	var query strings.Builder
+	args := make([]interface{}, 0, len(ownerIDs)+1)
+	query.WriteString("SELECT teaID, AVG(rating) FROM ratings WHERE rating IS NOT NULL AND teaID IN (SELECT id FROM tea WHERE householdID = " + placeholder(&args, s.household) + ")")
+	if len(ownerIDs) > 0 {
+		placeholders := make([]string, 0, len(ownerIDs))
+		for _, id := range ownerIDs {
+			placeholders = append(placeholders, placeholder(&args, id))
+		}
+		query.WriteString(" AND ownerID IN (" + strings.Join(placeholders, ", ") + ")")
+	}
+	query.WriteString(" GROUP BY teaID;")
+
+	rows, err := s.conn.Query(query.String(), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	averages := make(map[int]float64)
+	for rows.Next() {
+		var teaID int
+		var average float64
+		if err := rows.Scan(&teaID, &average); err != nil {
+			return nil, err
+		}
+		averages[teaID] = average
+	}
+	return averages, nil
+}
+
+// listClauses gives the clauses that follow the household condition of a query for a list, choosing the rows in the
+// list and their order, along with the arguments of the query. The columns of the table are given the prefix, and the
+// household must be the first argument. Teas can also be filtered by their type and owner.
+func listClauses(options ListOptions, prefix string, args ...interface{}) (string, []interface{}) {
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	var clauses strings.Builder
+	if options.NamePrefix != "" {
+		fmt.Fprintf(&clauses, " AND LOWER(%sname) LIKE %s ESCAPE '\\'", prefix, arg(likePrefix(strings.ToLower(options.NamePrefix))))
+	}
+	if options.TypeID != 0 {
+		fmt.Fprintf(&clauses, " AND %steaType = %s", prefix, arg(options.TypeID))
+	}
+	if options.OwnerID != 0 {
+		fmt.Fprintf(&clauses, " AND %sid IN (SELECT teaOwners.teaID FROM teaOwners INNER JOIN tea ON tea.id = teaOwners.teaID WHERE tea.householdID = $1 AND teaOwners.ownerID = %s)", prefix, arg(options.OwnerID))
+	}
+
+	comparison, direction := ">", ""
+	if options.descending() {
+		comparison, direction = "<", " DESC"
+	}
+	if options.sortField() == SortName {
+		if options.After != nil {
+			name, id := arg(options.After.Name), arg(options.After.ID)
+			fmt.Fprintf(&clauses, " AND (%[1]sname %[2]s %[3]s OR (%[1]sname = %[3]s AND %[1]sid %[2]s %[4]s))", prefix, comparison, name, id)
+		}
+		fmt.Fprintf(&clauses, " ORDER BY %[1]sname%[2]s, %[1]sid%[2]s", prefix, direction)
+	} else {
+		if options.After != nil {
+			fmt.Fprintf(&clauses, " AND %sid %s %s", prefix, comparison, arg(options.After.ID))
+		}
+		fmt.Fprintf(&clauses, " ORDER BY %sid%s", prefix, direction)
+	}
+
+	if options.Limit > 0 {
+		fmt.Fprintf(&clauses, " LIMIT %s", arg(options.Limit))
+	}
+	clauses.WriteString(";")
+	return clauses.String(), args
+}
+
+// likePrefix gives a LIKE pattern matching strings starting with the prefix, escaping any wildcards in it.
+func likePrefix(prefix string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(prefix) + "%"
+}
+
+// notFound replaces the error from a query that found no rows with the store's own not found error.
+// Any other error is returned unchanged.
+func notFound(err error, missing error) error {
+	if err == sql.ErrNoRows {
+		return missing
+	}
+	return err
+}
+
+// nullableInt stores a missing (zero) number as NULL.
+func nullableInt(n int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(n), Valid: n != 0}
+}
+
+// nullableFloat stores a missing (zero) number as NULL.
+func nullableFloat(n float64) sql.NullFloat64 {
+	return sql.NullFloat64{Float64: n, Valid: n != 0}
+}
+
+// nullableString stores a missing (empty) string as NULL.
+func nullableString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// nullable gives the values of a brewing guide to store in the database, in column order.
+func (guide BrewingGuide) nullable() []interface{} {
+	return []interface{}{nullableInt(guide.BrewTemperature), nullableInt(guide.SteepSeconds), nullableFloat(guide.LeafGrams), nullableString(guide.Caffeine)}
+}
+
+// A nullBrewingGuide is used to read a brewing guide from the database, where any of the details may be NULL.
+type nullBrewingGuide struct {
+	brewTemperature sql.NullInt64
+	steepSeconds    sql.NullInt64
+	leafGrams       sql.NullFloat64
+	caffeine        sql.NullString
+}
+
+// dest gives the scan destinations for the brewing guide columns, in column order.
+func (guide *nullBrewingGuide) dest() []interface{} {
+	return []interface{}{&guide.brewTemperature, &guide.steepSeconds, &guide.leafGrams, &guide.caffeine}
+}
+
+// value converts the brewing guide read from the database, leaving missing details empty.
+func (guide nullBrewingGuide) value() BrewingGuide {
+	return BrewingGuide{
+		BrewTemperature: int(guide.brewTemperature.Int64),
+		SteepSeconds:    int(guide.steepSeconds.Int64),
+		LeafGrams:       guide.leafGrams.Float64,
+		Caffeine:        guide.caffeine.String,
+	}
+}
+
+// A nullStock is used to read an owner's stock of a tea from the database, where the stock may not be tracked.
+type nullStock struct {
+	quantity sql.NullFloat64
+	unit     sql.NullString
+}
+
+// value converts the stock read from the database, leaving the quantity nil if it isn't tracked.
+func (stock nullStock) value() Stock {
+	value := Stock{Unit: stock.unit.String}
+	if stock.quantity.Valid {
+		quantity := stock.quantity.Float64
+		value.Quantity = &quantity
+	}
+	return value
+}
+
+// nullQuantity gives the quantity of stock to store in the database, which is NULL when the stock isn't tracked.
+func (stock Stock) nullQuantity() sql.NullFloat64 {
+	if stock.Quantity == nil {
+		return sql.NullFloat64{}
+	}
+	return sql.NullFloat64{Float64: *stock.Quantity, Valid: true}
+}
+
+// teaColumns are the columns read by scanTea, from the tea table joined with the types table.
+const teaColumns = "tea.id, tea.name, tea.brewTemperature, tea.steepSeconds, tea.leafGrams, tea.caffeine, tea.notes, types.id, types.name, types.brewTemperature, types.steepSeconds, types.leafGrams, types.caffeine"
+
+// scanTea reads a tea and its type from teaColumns, followed by any extra columns.
+func scanTea(row interface{ Scan(...interface{}) error }, tea *Tea, extra ...interface{}) error {
+	var teaGuide, typeGuide nullBrewingGuide
+	var notes sql.NullString
+
+	dest := []interface{}{&tea.ID, &tea.Name}
+	dest = append(dest, teaGuide.dest()...)
+	dest = append(dest, &notes, &tea.TeaType.ID, &tea.TeaType.Name)
+	dest = append(dest, typeGuide.dest()...)
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return err
+	}
+
+	tea.BrewingGuide = teaGuide.value()
+	tea.Notes = notes.String
+	tea.TeaType.BrewingGuide = typeGuide.value()
+	return nil
+}
+
+// GetLastSelected gets when each tea was last selected, by tea ID. Teas that have never been selected are not included.
+func (s *SQLStore) GetLastSelected() (map[int]time.Time, error) {
+	rows, err := s.conn.Query("SELECT selections.teaID, MAX(selections.selectedAt) FROM selections INNER JOIN tea ON tea.id = selections.teaID WHERE tea.householdID = $1 GROUP BY selections.teaID;", s.household)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lastSelected := make(map[int]time.Time)
+	for rows.Next() {
+		var teaID int
+		var selectedAt int64
+		if err := rows.Scan(&teaID, &selectedAt); err != nil {
+			return nil, err
+		}
+		lastSelected[teaID] = time.Unix(selectedAt, 0).UTC()
+	}
+	return lastSelected, nil
+}
+
+// placeholder adds a value to a list of query arguments, returning the placeholder to use for it.
+func placeholder(args *[]interface{}, value interface{}) string {
+	*args = append(*args, value)
+	return "$" + strconv.Itoa(len(*args))
+}
+
+// uniqueIDs removes any duplicate IDs, keeping the original order.
+func uniqueIDs(ids []int) []int {
+	seen := make(map[int]bool, len(ids))
+	unique := make([]int, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
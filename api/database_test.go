@@ -2,12 +2,29 @@ package main
 
 import (
 	"database/sql"
+	"database/sql/driver"
 	"errors"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/mattn/go-sqlite3"
 )
 
+// teaColumnNames are the columns read by scanTea, for use in mock rows.
+var teaColumnNames = []string{"id", "name", "brewTemperature", "steepSeconds", "leafGrams", "caffeine", "notes", "id", "name", "brewTemperature", "steepSeconds", "leafGrams", "caffeine"}
+
+// expectInHousehold expects a check that the row of a table with the given ID belongs to the default household.
+func expectInHousehold(mock sqlmock.Sqlmock, table string, id int) {
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM "+table+" WHERE id = \\$1 AND householdID = \\$2;").WithArgs(id, DefaultHousehold).WillReturnRows(mock.NewRows([]string{"count"}).AddRow(1))
+}
+
+// teaRow gives the values of teaColumnNames for a tea without a brewing guide.
+func teaRow(id int, name string, typeID int, typeName string) []driver.Value {
+	return []driver.Value{id, name, nil, nil, nil, nil, nil, typeID, typeName, nil, nil, nil, nil}
+}
+
 func TestCreateTeaTypeTable(t *testing.T) {
 	createTeaTypeString := "CREATE TABLE types"
 	teaTypes := []string{"Black Tea", "Green Tea"}
@@ -16,14 +33,13 @@ func TestCreateTeaTypeTable(t *testing.T) {
 		t.Fatalf("Error occurred setting up mock database: %v", err)
 	}
 	defer db.Close()
-	oldDB := DB
-	defer func() { DB = oldDB }()
-	DB = db
 
 	mock.ExpectExec(createTeaTypeString).WillReturnResult(sqlmock.NewResult(0, 0))
-	mock.ExpectExec("INSERT INTO types \\(name\\) VALUES \\('" + teaTypes[0] + "'\\), \\('" + teaTypes[1] + "'\\);").WillReturnResult(sqlmock.NewResult(2, 2))
+	mock.ExpectExec("INSERT INTO types \\(name\\) VALUES \\(\\$1\\), \\(\\$2\\);").WithArgs(teaTypes[0], teaTypes[1]).WillReturnResult(sqlmock.NewResult(2, 2))
 
-	createTeaTypeTable(teaTypes)
+	if err := createTeaTypeTable(db, teaTypes); err != nil {
+		t.Errorf("Unexpected error creating types table: %v", err)
+	}
 
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("There were unfulfilled expections: %s", err)
@@ -38,13 +54,12 @@ func TestCreateEmptyTeaTypeTable(t *testing.T) {
 		t.Fatalf("Error occurred setting up mock database: %v", err)
 	}
 	defer db.Close()
-	oldDB := DB
-	defer func() { DB = oldDB }()
-	DB = db
 
 	mock.ExpectExec(createTeaTypeString).WillReturnResult(sqlmock.NewResult(0, 0))
 
-	createTeaTypeTable(teaTypes)
+	if err := createTeaTypeTable(db, teaTypes); err != nil {
+		t.Errorf("Unexpected error creating types table: %v", err)
+	}
 
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("There were unfulfilled expections: %s", err)
@@ -58,13 +73,12 @@ func TestCreateTeaTable(t *testing.T) {
 		t.Fatalf("Error occurred setting up mock database: %v", err)
 	}
 	defer db.Close()
-	oldDB := DB
-	defer func() { DB = oldDB }()
-	DB = db
 
 	mock.ExpectExec(createTeaString).WillReturnResult(sqlmock.NewResult(0, 0))
 
-	createTeaTable()
+	if err := createTeaTable(db); err != nil {
+		t.Errorf("Unexpected error creating tea table: %v", err)
+	}
 
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("There were unfulfilled expections: %s", err)
@@ -79,14 +93,13 @@ func TestCreateOwnerTable(t *testing.T) {
 		t.Fatalf("Error occurred setting up mock database: %v", err)
 	}
 	defer db.Close()
-	oldDB := DB
-	defer func() { DB = oldDB }()
-	DB = db
 
 	mock.ExpectExec(createOwnerString).WillReturnResult(sqlmock.NewResult(0, 0))
-	mock.ExpectExec("INSERT INTO owner \\(name\\) VALUES \\('" + owners[0] + "'\\), \\('" + owners[1] + "'\\);").WillReturnResult(sqlmock.NewResult(2, 2))
+	mock.ExpectExec("INSERT INTO owner \\(name\\) VALUES \\(\\$1\\), \\(\\$2\\);").WithArgs(owners[0], owners[1]).WillReturnResult(sqlmock.NewResult(2, 2))
 
-	createOwnerTable(owners)
+	if err := createOwnerTable(db, owners); err != nil {
+		t.Errorf("Unexpected error creating owner table: %v", err)
+	}
 
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("There were unfulfilled expections: %s", err)
@@ -101,13 +114,12 @@ func TestCreateEmptyOwnerTable(t *testing.T) {
 		t.Fatalf("Error occurred setting up mock database: %v", err)
 	}
 	defer db.Close()
-	oldDB := DB
-	defer func() { DB = oldDB }()
-	DB = db
 
 	mock.ExpectExec(createOwnerString).WillReturnResult(sqlmock.NewResult(0, 0))
 
-	createOwnerTable(owners)
+	if err := createOwnerTable(db, owners); err != nil {
+		t.Errorf("Unexpected error creating owner table: %v", err)
+	}
 
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("There were unfulfilled expections: %s", err)
@@ -121,13 +133,12 @@ func TestCreateTeaOwnersTable(t *testing.T) {
 		t.Fatalf("Error occurred setting up mock database: %v", err)
 	}
 	defer db.Close()
-	oldDB := DB
-	defer func() { DB = oldDB }()
-	DB = db
 
 	mock.ExpectExec(createTeaOwnersString).WillReturnResult(sqlmock.NewResult(0, 0))
 
-	createTeaOwnersTable()
+	if err := createTeaOwnersTable(db); err != nil {
+		t.Errorf("Unexpected error creating tea owners table: %v", err)
+	}
 
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("There were unfulfilled expections: %s", err)
@@ -140,27 +151,25 @@ func TestGetAllTeaTypesFromDatabase(t *testing.T) {
 		t.Fatalf("Error occurred setting up mock database: %v", err)
 	}
 	defer db.Close()
-	oldDB := DB
-	defer func() { DB = oldDB }()
-	DB = db
+	store := NewSQLStore(db)
 
-	rows := mock.NewRows([]string{"id", "name"})
-	rows.AddRow("1", "Black Tea")
-	rows.AddRow("2", "Green Tea")
+	rows := mock.NewRows([]string{"id", "name", "brewTemperature", "steepSeconds", "leafGrams", "caffeine"})
+	rows.AddRow("1", "Black Tea", 100, 240, 2.5, "high")
+	rows.AddRow("2", "Green Tea", nil, nil, nil, nil)
 
-	mock.ExpectQuery("SELECT \\* FROM types;").WillReturnRows(rows)
+	mock.ExpectQuery("SELECT (.)+ FROM types WHERE householdID=\\$1 ORDER BY id;").WithArgs(DefaultHousehold).WillReturnRows(rows)
 
-	teaTypes, err := GetAllTeaTypesFromDatabase()
+	teaTypes, err := store.GetAllTeaTypes(ListOptions{})
 	if err != nil {
 		t.Errorf("Database returned unexpected error: %v\n", err)
 	}
-	expected := TeaType{1, "Black Tea"}
+	expected := TeaType{ID: 1, Name: "Black Tea", BrewingGuide: BrewingGuide{BrewTemperature: 100, SteepSeconds: 240, LeafGrams: 2.5, Caffeine: "high"}}
 	if teaTypes[0] != expected {
 		t.Errorf("Database returned unexpected result:\n got: %v\n wanted: %v\n", teaTypes[0], expected)
 	}
-	expected = TeaType{2, "Green Tea"}
+	expected = TeaType{ID: 2, Name: "Green Tea"}
 	if teaTypes[1] != expected {
-		t.Errorf("Database returned unexpected result:\n got: %q\n wanted: %q\n", teaTypes[1], expected)
+		t.Errorf("Database returned unexpected result:\n got: %v\n wanted: %v\n", teaTypes[1], expected)
 	}
 
 	if err := mock.ExpectationsWereMet(); err != nil {
@@ -174,24 +183,26 @@ func TestGetTeaTypeFromDatabase(t *testing.T) {
 		t.Fatalf("Error occurred setting up mock database: %v\n", err)
 	}
 	defer db.Close()
-	oldDB := DB
-	defer func() { DB = oldDB }()
-	DB = db
+	store := NewSQLStore(db)
 
 	expected := "Black Tea"
-	rows := mock.NewRows([]string{"name"})
-	rows.AddRow(expected)
+	expectedGuide := BrewingGuide{BrewTemperature: 100, Caffeine: "high"}
+	rows := mock.NewRows([]string{"name", "brewTemperature", "steepSeconds", "leafGrams", "caffeine"})
+	rows.AddRow(expected, 100, nil, nil, "high")
 	teaType := TeaType{ID: 1}
 
-	mock.ExpectQuery("SELECT name FROM types").WithArgs(1).WillReturnRows(rows)
+	mock.ExpectQuery("SELECT name, (.)+ FROM types").WithArgs(1, DefaultHousehold).WillReturnRows(rows)
 
-	err = GetTeaTypeFromDatabase(&teaType)
+	err = store.GetTeaType(&teaType)
 	if err != nil {
 
 	}
 	if teaType.Name != expected {
 		t.Errorf("Database returned unexpected result:\n got: %q\n wanted: %q\n", teaType.Name, expected)
 	}
+	if teaType.BrewingGuide != expectedGuide {
+		t.Errorf("Database returned unexpected result:\n got: %v\n wanted: %v\n", teaType.BrewingGuide, expectedGuide)
+	}
 
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("There were unfulfilled expectations: %s\n", err)
@@ -204,20 +215,18 @@ func TestGetNonExistantTeaTypeFromDatabase(t *testing.T) {
 		t.Fatalf("Error occurred setting up mock database: %v\n", err)
 	}
 	defer db.Close()
-	oldDB := DB
-	defer func() { DB = oldDB }()
-	DB = db
+	store := NewSQLStore(db)
 
 	expected := ""
 	rows := mock.NewRows([]string{"name"})
 	rows.AddRow(expected)
 	teaType := TeaType{ID: 1}
 
-	mock.ExpectQuery("SELECT name FROM types").WithArgs(1).WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("SELECT name, (.)+ FROM types").WithArgs(1, DefaultHousehold).WillReturnError(sql.ErrNoRows)
 
-	err = GetTeaTypeFromDatabase(&teaType)
-	if err != sql.ErrNoRows {
-		t.Errorf("Method returned unexpected error:\n got: %v\n wanted: %v\n", err, sql.ErrNoRows)
+	err = store.GetTeaType(&teaType)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Method returned unexpected error:\n got: %v\n wanted: %v\n", err, ErrNotFound)
 	}
 	if teaType.Name != expected {
 		t.Errorf("Database returned unexpected result:\n got: %q\n wanted: %q\n", teaType.Name, expected)
@@ -234,18 +243,13 @@ func TestCreateTeaTypeInDatabase(t *testing.T) {
 		t.Fatalf("Error occurred setting up mock database: %v\n", err)
 	}
 	defer db.Close()
-	oldDB := DB
-	defer func() { DB = oldDB }()
-	DB = db
+	store := NewSQLStore(db)
 
 	teaName := "Black Tea"
-	rows := mock.NewRows([]string{"id"})
-	rows.AddRow("1")
-	mock.ExpectExec("INSERT INTO types").WithArgs("Black Tea").WillReturnResult(sqlmock.NewResult(1, 1))
-	mock.ExpectQuery("SELECT ID FROM types").WillReturnRows(rows)
+	mock.ExpectExec("INSERT INTO types").WithArgs("Black Tea", nil, nil, nil, nil, DefaultHousehold).WillReturnResult(sqlmock.NewResult(1, 1))
 
 	teaType := TeaType{ID: 1, Name: teaName}
-	err = CreateTeaTypeInDatabase(&teaType)
+	err = store.CreateTeaType(&teaType)
 	if err != nil {
 		t.Errorf("Error whilst trying to insert tea type into database: %v\n", err)
 	}
@@ -267,20 +271,20 @@ func TestDeleteTeaTypeInDatabase(t *testing.T) {
 		t.Fatalf("Error occurred setting up mock database: %v\n", err)
 	}
 	defer db.Close()
-	oldDB := DB
-	defer func() { DB = oldDB }()
-	DB = db
+	store := NewSQLStore(db)
 
 	teaName := "Black Tea"
 	teaID := 1
 	rows := mock.NewRows([]string{"name"})
 	rows.AddRow(teaName)
 
-	mock.ExpectQuery("SELECT name FROM types").WithArgs(1).WillReturnRows(rows)
-	mock.ExpectExec("DELETE FROM types").WithArgs(1).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT name FROM types").WithArgs(1, DefaultHousehold).WillReturnRows(rows)
+	mock.ExpectExec("DELETE FROM types").WithArgs(1, DefaultHousehold).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
 
 	teaType := TeaType{ID: teaID}
-	err = DeleteTeaTypeInDatabase(&teaType)
+	err = store.DeleteTeaType(&teaType)
 	if err != nil {
 		t.Errorf("Error whilst trying to delete tea type from database: %v\n", err)
 	}
@@ -302,19 +306,19 @@ func TestDeleteNonExistantTeaTypeInDatabase(t *testing.T) {
 		t.Fatalf("Error occurred setting up mock database: %v\n\n", err)
 	}
 	defer db.Close()
-	oldDB := DB
-	defer func() { DB = oldDB }()
-	DB = db
+	store := NewSQLStore(db)
 
 	// teaName := "Black Tea"
 	teaID := 1
 	rows := mock.NewRows([]string{"name"})
 
-	mock.ExpectQuery("SELECT name FROM types").WithArgs(1).WillReturnRows(rows)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT name FROM types").WithArgs(1, DefaultHousehold).WillReturnRows(rows)
+	mock.ExpectRollback()
 
 	teaType := TeaType{ID: teaID}
-	err = DeleteTeaTypeInDatabase(&teaType)
-	if err.Error() != "sql: Rows are closed" {
+	err = store.DeleteTeaType(&teaType)
+	if !errors.Is(err, ErrNotFound) {
 		t.Errorf("Error whilst trying to delete tea type from database: %v\n", err)
 	}
 	if teaType.ID != teaID {
@@ -335,17 +339,15 @@ func TestGetAllOwnersFromDatabase(t *testing.T) {
 		t.Fatalf("Error occurred setting up mock database: %v", err)
 	}
 	defer db.Close()
-	oldDB := DB
-	defer func() { DB = oldDB }()
-	DB = db
+	store := NewSQLStore(db)
 
 	rows := mock.NewRows([]string{"id", "name"})
 	rows.AddRow("1", "John")
 	rows.AddRow("2", "Jane")
 
-	mock.ExpectQuery("SELECT \\* FROM owner;").WillReturnRows(rows)
+	mock.ExpectQuery("SELECT id, name FROM owner WHERE householdID=\\$1 ORDER BY id;").WithArgs(DefaultHousehold).WillReturnRows(rows)
 
-	owners, err := GetAllOwnersFromDatabase()
+	owners, err := store.GetAllOwners(ListOptions{})
 	if err != nil {
 		t.Errorf("Database returned unexpected error: %v\n", err)
 	}
@@ -369,18 +371,16 @@ func TestGetOwnerFromDatabase(t *testing.T) {
 		t.Fatalf("Error occurred setting up mock database: %v\n", err)
 	}
 	defer db.Close()
-	oldDB := DB
-	defer func() { DB = oldDB }()
-	DB = db
+	store := NewSQLStore(db)
 
 	expected := "John"
 	rows := mock.NewRows([]string{"name"})
 	rows.AddRow(expected)
 	owner := Owner{ID: 1}
 
-	mock.ExpectQuery("SELECT name FROM owner").WithArgs(1).WillReturnRows(rows)
+	mock.ExpectQuery("SELECT name FROM owner").WithArgs(1, DefaultHousehold).WillReturnRows(rows)
 
-	err = GetOwnerFromDatabase(&owner)
+	err = store.GetOwner(&owner)
 	if err != nil {
 
 	}
@@ -399,20 +399,18 @@ func TestGetNonExistentOwnerFromDatabase(t *testing.T) {
 		t.Fatalf("Error occurred setting up mock database: %v\n", err)
 	}
 	defer db.Close()
-	oldDB := DB
-	defer func() { DB = oldDB }()
-	DB = db
+	store := NewSQLStore(db)
 
 	expected := ""
 	rows := mock.NewRows([]string{"name"})
 	rows.AddRow(expected)
 	owner := Owner{ID: 1}
 
-	mock.ExpectQuery("SELECT name FROM owner").WithArgs(1).WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("SELECT name FROM owner").WithArgs(1, DefaultHousehold).WillReturnError(sql.ErrNoRows)
 
-	err = GetOwnerFromDatabase(&owner)
-	if err != sql.ErrNoRows {
-		t.Errorf("Method returned unexpected error:\n got: %v\n wanted: %v\n", err, sql.ErrNoRows)
+	err = store.GetOwner(&owner)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Method returned unexpected error:\n got: %v\n wanted: %v\n", err, ErrNotFound)
 	}
 	if owner.Name != expected {
 		t.Errorf("Database returned unexpected result:\n got: %q\n wanted: %q\n", owner.Name, expected)
@@ -429,18 +427,13 @@ func TestCreateOwnerInDatabase(t *testing.T) {
 		t.Fatalf("Error occurred setting up mock database: %v\n", err)
 	}
 	defer db.Close()
-	oldDB := DB
-	defer func() { DB = oldDB }()
-	DB = db
+	store := NewSQLStore(db)
 
 	ownerName := "John"
-	rows := mock.NewRows([]string{"id"})
-	rows.AddRow("1")
-	mock.ExpectExec("INSERT INTO owner").WithArgs(ownerName).WillReturnResult(sqlmock.NewResult(1, 1))
-	mock.ExpectQuery("SELECT ID FROM owner").WillReturnRows(rows)
+	mock.ExpectExec("INSERT INTO owner").WithArgs(ownerName, DefaultHousehold).WillReturnResult(sqlmock.NewResult(1, 1))
 
 	owner := Owner{ID: 1, Name: ownerName}
-	err = CreateOwnerInDatabase(&owner)
+	err = store.CreateOwner(&owner)
 	if err != nil {
 		t.Errorf("Error whilst trying to insert tea type into database: %v\n", err)
 	}
@@ -462,20 +455,20 @@ func TestDeleteOwnerFromDatabase(t *testing.T) {
 		t.Fatalf("Error occurred setting up mock database: %v\n", err)
 	}
 	defer db.Close()
-	oldDB := DB
-	defer func() { DB = oldDB }()
-	DB = db
+	store := NewSQLStore(db)
 
 	ownerName := "John"
 	ownerID := 1
 	rows := mock.NewRows([]string{"name"})
 	rows.AddRow(ownerName)
 
-	mock.ExpectQuery("SELECT name FROM owner").WithArgs(1).WillReturnRows(rows)
-	mock.ExpectExec("DELETE FROM owner").WithArgs(1).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT name FROM owner").WithArgs(1, DefaultHousehold).WillReturnRows(rows)
+	mock.ExpectExec("DELETE FROM owner").WithArgs(1, DefaultHousehold).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
 
 	owner := Owner{ID: ownerID}
-	err = DeleteOwnerFromDatabase(&owner)
+	err = store.DeleteOwner(&owner)
 	if err != nil {
 		t.Errorf("Error whilst trying to delete owner from database: %v\n", err)
 	}
@@ -497,19 +490,19 @@ func TestDeleteNonExistantOwnerFromDatabase(t *testing.T) {
 		t.Fatalf("Error occurred setting up mock database: %v\n\n", err)
 	}
 	defer db.Close()
-	oldDB := DB
-	defer func() { DB = oldDB }()
-	DB = db
+	store := NewSQLStore(db)
 
 	// teaName := "Black Tea"
 	ownerID := 1
 	rows := mock.NewRows([]string{"name"})
 
-	mock.ExpectQuery("SELECT name FROM owner").WithArgs(1).WillReturnRows(rows)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT name FROM owner").WithArgs(1, DefaultHousehold).WillReturnRows(rows)
+	mock.ExpectRollback()
 
 	owner := Owner{ID: ownerID}
-	err = DeleteOwnerFromDatabase(&owner)
-	if err.Error() != "sql: Rows are closed" {
+	err = store.DeleteOwner(&owner)
+	if !errors.Is(err, ErrNotFound) {
 		t.Errorf("Error whilst trying to delete owner from database: %v\n", err)
 	}
 	if owner.ID != ownerID {
@@ -530,27 +523,25 @@ func TestGetAllTeasFromDatabase(t *testing.T) {
 		t.Fatalf("Error occurred setting up mock database: %v", err)
 	}
 	defer db.Close()
-	oldDB := DB
-	defer func() { DB = oldDB }()
-	DB = db
+	store := NewSQLStore(db)
 
-	rows := mock.NewRows([]string{"id", "name", "id", "name"})
-	rows.AddRow("1", "Snowball", "1", "Black Tea")
-	rows.AddRow("2", "Nearly Nirvana", "2", "White Tea")
+	rows := mock.NewRows(append(teaColumnNames, "rating"))
+	rows.AddRow(append(teaRow(1, "Snowball", 1, "Black Tea"), 4.5)...)
+	rows.AddRow(append(teaRow(2, "Nearly Nirvana", 2, "White Tea"), nil)...)
 
-	mock.ExpectQuery("SELECT (.)+ FROM tea").WillReturnRows(rows)
+	mock.ExpectQuery("SELECT (.)+ FROM tea (.)+ WHERE tea.householdID=\\$1 ORDER BY tea.id;").WithArgs(DefaultHousehold).WillReturnRows(rows)
 
-	teas, err := GetAllTeasFromDatabase()
+	teas, err := store.GetAllTeas(ListOptions{})
 	if err != nil {
 		t.Errorf("Database returned unexpected error: %v\n", err)
 	}
-	expected := Tea{1, "Snowball", TeaType{1, "Black Tea"}}
+	expected := Tea{ID: 1, Name: "Snowball", TeaType: TeaType{ID: 1, Name: "Black Tea"}, AverageRating: 4.5}
 	if teas[0] != expected {
 		t.Errorf("Database returned unexpected result:\n got: %v\n wanted: %v\n", teas[0], expected)
 	}
-	expected = Tea{2, "Nearly Nirvana", TeaType{2, "White Tea"}}
+	expected = Tea{ID: 2, Name: "Nearly Nirvana", TeaType: TeaType{ID: 2, Name: "White Tea"}}
 	if teas[1] != expected {
-		t.Errorf("Database returned unexpected result:\n got: %q\n wanted: %q\n", teas[1], expected)
+		t.Errorf("Database returned unexpected result:\n got: %v\n wanted: %v\n", teas[1], expected)
 	}
 
 	if err := mock.ExpectationsWereMet(); err != nil {
@@ -564,21 +555,20 @@ func TestGetTeaFromDatabase(t *testing.T) {
 		t.Fatalf("Error occurred setting up mock database: %v\n", err)
 	}
 	defer db.Close()
-	oldDB := DB
-	defer func() { DB = oldDB }()
-	DB = db
+	store := NewSQLStore(db)
 
 	expectedTeaID := 1
 	expectedTeaName := "Snowball"
 	expectedTypeID := 1
 	expectedTypeName := "Black Tea"
+	expectedRating := 3.5
 	tea := Tea{ID: expectedTeaID}
-	rows := mock.NewRows([]string{"name", "id", "name"})
-	rows.AddRow(expectedTeaName, expectedTypeID, expectedTypeName)
+	rows := mock.NewRows(append(teaColumnNames, "rating"))
+	rows.AddRow(append(teaRow(expectedTeaID, expectedTeaName, expectedTypeID, expectedTypeName), expectedRating)...)
 
-	mock.ExpectQuery("SELECT (.)+ FROM tea").WithArgs(1).WillReturnRows(rows)
+	mock.ExpectQuery("SELECT (.)+ FROM tea (.)+ WHERE tea.id=\\$2 AND tea.householdID=\\$1").WithArgs(DefaultHousehold, 1).WillReturnRows(rows)
 
-	err = GetTeaFromDatabase(&tea)
+	err = store.GetTea(&tea)
 	if err != nil {
 		t.Errorf("Database returned unexpected error: %v\n", err)
 	}
@@ -594,6 +584,9 @@ func TestGetTeaFromDatabase(t *testing.T) {
 	if tea.TeaType.Name != expectedTypeName {
 		t.Errorf("Database returned unexpected result:\n got: %q\n wanted: %q\n", tea.TeaType.Name, expectedTypeName)
 	}
+	if tea.AverageRating != expectedRating {
+		t.Errorf("Database returned unexpected result:\n got: %v\n wanted: %v\n", tea.AverageRating, expectedRating)
+	}
 
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("There were unfulfilled expectations: %s\n", err)
@@ -606,17 +599,15 @@ func TestGetNonExistentTeaFromDatabase(t *testing.T) {
 		t.Fatalf("Error occurred setting up mock database: %v\n", err)
 	}
 	defer db.Close()
-	oldDB := DB
-	defer func() { DB = oldDB }()
-	DB = db
+	store := NewSQLStore(db)
 
-	mock.ExpectQuery("SELECT (.)+ FROM tea").WithArgs(10).WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("SELECT (.)+ FROM tea").WithArgs(DefaultHousehold, 10).WillReturnError(sql.ErrNoRows)
 
 	expectedTeaID := 10
 	tea := Tea{ID: expectedTeaID}
-	err = GetTeaFromDatabase(&tea)
-	if err != sql.ErrNoRows {
-		t.Errorf("Method returned unexpected error:\n got: %v\n wanted: %v\n", err, sql.ErrNoRows)
+	err = store.GetTea(&tea)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Method returned unexpected error:\n got: %v\n wanted: %v\n", err, ErrNotFound)
 	}
 	if tea.ID != expectedTeaID {
 		t.Errorf("Database returned unexpected result:\n got: %q\n wanted: %q\n", tea.ID, expectedTeaID)
@@ -633,9 +624,7 @@ func TestCreateTeaInDatabase(t *testing.T) {
 		t.Fatalf("Error occurred setting up mock database: %v\n", err)
 	}
 	defer db.Close()
-	oldDB := DB
-	defer func() { DB = oldDB }()
-	DB = db
+	store := NewSQLStore(db)
 
 	teaID := 1
 	teaName := "Snowball"
@@ -643,15 +632,14 @@ func TestCreateTeaInDatabase(t *testing.T) {
 	typeName := "Black Tea"
 	typeRows := mock.NewRows([]string{"name"})
 	typeRows.AddRow(typeName)
-	teaRows := mock.NewRows([]string{"id"})
-	teaRows.AddRow("1")
 
-	mock.ExpectQuery("SELECT name FROM types").WithArgs(typeID).WillReturnRows(typeRows)
-	mock.ExpectExec("INSERT INTO tea").WithArgs(teaName, typeID).WillReturnResult(sqlmock.NewResult(1, 1))
-	mock.ExpectQuery("SELECT id FROM tea").WillReturnRows(teaRows)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT name FROM types").WithArgs(typeID, DefaultHousehold).WillReturnRows(typeRows)
+	mock.ExpectExec("INSERT INTO tea").WithArgs(teaName, typeID, nil, nil, nil, nil, nil, DefaultHousehold).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
 
 	tea := Tea{Name: teaName, TeaType: TeaType{ID: typeID}}
-	err = CreateTeaInDatabase(&tea)
+	err = store.CreateTea(&tea)
 	if err != nil {
 		t.Errorf("Error whilst trying to insert tea into database: %s\n", err)
 	}
@@ -679,18 +667,18 @@ func TestCreateTeaInDatabaseBadType(t *testing.T) {
 		t.Fatalf("Error occurred setting up mock database: %v\n", err)
 	}
 	defer db.Close()
-	oldDB := DB
-	defer func() { DB = oldDB }()
-	DB = db
+	store := NewSQLStore(db)
 
 	teaName := "Snowball"
 	teaTypeID := 1
 	expectedError := "Tea type does not exist or is missing"
 
-	mock.ExpectQuery("SELECT name FROM types").WithArgs(1).WillReturnError(sql.ErrNoRows)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT name FROM types").WithArgs(1, DefaultHousehold).WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
 
 	tea := Tea{Name: teaName, TeaType: TeaType{ID: teaTypeID}}
-	err = CreateTeaInDatabase(&tea)
+	err = store.CreateTea(&tea)
 	if err.Error() != expectedError {
 		t.Errorf("Wrong error returned:\n Got: %s\n Expected: %s\n", err, expectedError)
 	}
@@ -712,9 +700,7 @@ func TestCreateTeaInDatabaseInsertError(t *testing.T) {
 		t.Fatalf("Error occurred setting up mock database: %v\n", err)
 	}
 	defer db.Close()
-	oldDB := DB
-	defer func() { DB = oldDB }()
-	DB = db
+	store := NewSQLStore(db)
 
 	teaName := "Snowball"
 	teaTypeID := 1
@@ -723,11 +709,13 @@ func TestCreateTeaInDatabaseInsertError(t *testing.T) {
 	typeRows.AddRow(typeName)
 	expectedError := "UNIQUE constraint not met"
 
-	mock.ExpectQuery("SELECT name FROM types").WithArgs(1).WillReturnRows(typeRows)
-	mock.ExpectExec("INSERT INTO tea").WithArgs(teaName, teaTypeID).WillReturnError(errors.New(expectedError))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT name FROM types").WithArgs(1, DefaultHousehold).WillReturnRows(typeRows)
+	mock.ExpectExec("INSERT INTO tea").WithArgs(teaName, teaTypeID, nil, nil, nil, nil, nil, DefaultHousehold).WillReturnError(errors.New(expectedError))
+	mock.ExpectRollback()
 
 	tea := Tea{Name: teaName, TeaType: TeaType{ID: teaTypeID}}
-	err = CreateTeaInDatabase(&tea)
+	err = store.CreateTea(&tea)
 	if err.Error() != expectedError {
 		t.Errorf("Wrong error returned:\n Got: %s\n Expected: %s\n", err, expectedError)
 	}
@@ -752,20 +740,20 @@ func TestDeleteTeaFromDatabase(t *testing.T) {
 		t.Fatalf("Error occurred setting up mock database: %v\n", err)
 	}
 	defer db.Close()
-	oldDB := DB
-	defer func() { DB = oldDB }()
-	DB = db
+	store := NewSQLStore(db)
 
 	teaName := "Snowball"
 	teaID := 1
 	rows := mock.NewRows([]string{"name"})
 	rows.AddRow(teaName)
 
-	mock.ExpectQuery("SELECT name FROM tea").WithArgs(teaID).WillReturnRows(rows)
-	mock.ExpectExec("DELETE FROM tea").WithArgs(teaID).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT name FROM tea").WithArgs(teaID, DefaultHousehold).WillReturnRows(rows)
+	mock.ExpectExec("DELETE FROM tea").WithArgs(teaID, DefaultHousehold).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
 
 	tea := Tea{ID: teaID}
-	err = DeleteTeaFromDatabase(&tea)
+	err = store.DeleteTea(&tea)
 	if err != nil {
 		t.Errorf("Error whilst trying to delete tea from database: %v\n", err)
 	}
@@ -787,18 +775,18 @@ func TestDeleteNonExistantTeaFromDatabase(t *testing.T) {
 		t.Fatalf("Error occurred setting up mock database: %v\n\n", err)
 	}
 	defer db.Close()
-	oldDB := DB
-	defer func() { DB = oldDB }()
-	DB = db
+	store := NewSQLStore(db)
 
 	teaID := 1
 	rows := mock.NewRows([]string{"name"})
 
-	mock.ExpectQuery("SELECT name FROM tea").WithArgs(teaID).WillReturnRows(rows)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT name FROM tea").WithArgs(teaID, DefaultHousehold).WillReturnRows(rows)
+	mock.ExpectRollback()
 
 	tea := Tea{ID: teaID}
-	err = DeleteTeaFromDatabase(&tea)
-	if err.Error() != "sql: Rows are closed" {
+	err = store.DeleteTea(&tea)
+	if !errors.Is(err, ErrNotFound) {
 		t.Errorf("Error whilst trying to delete tea from database: %v\n", err)
 	}
 	if tea.ID != teaID {
@@ -819,20 +807,18 @@ func TestGetTeaOwnerFromDatabase(t *testing.T) {
 		t.Fatalf("Error occurred setting up mock database: %v\n", err)
 	}
 	defer db.Close()
-	oldDB := DB
-	defer func() { DB = oldDB }()
-	DB = db
+	store := NewSQLStore(db)
 
 	expectedTeaID := 1
 	tea := Tea{ID: expectedTeaID}
 	expectedOwners := []Owner{{1, "John"}, {2, "Jane"}}
-	rows := mock.NewRows([]string{"id", "name"})
-	rows.AddRow(expectedOwners[0].ID, expectedOwners[0].Name)
-	rows.AddRow(expectedOwners[1].ID, expectedOwners[1].Name)
+	rows := mock.NewRows([]string{"id", "name", "quantity", "unit"})
+	rows.AddRow(expectedOwners[0].ID, expectedOwners[0].Name, 12.5, "grams")
+	rows.AddRow(expectedOwners[1].ID, expectedOwners[1].Name, nil, nil)
 
-	mock.ExpectQuery("SELECT (.)+ FROM teaOwners").WithArgs(expectedTeaID).WillReturnRows(rows)
+	mock.ExpectQuery("SELECT (.)+ FROM teaOwners").WithArgs(expectedTeaID, DefaultHousehold).WillReturnRows(rows)
 
-	owners, err := GetTeaOwnersFromDatabase(&tea)
+	owners, err := store.GetTeaOwners(&tea)
 	if err != nil {
 		t.Errorf("Database returned unexpected error: %v\n", err)
 	}
@@ -848,6 +834,12 @@ func TestGetTeaOwnerFromDatabase(t *testing.T) {
 	if owners[1].Name != expectedOwners[1].Name {
 		t.Errorf("Database returned unexpected result:\n got: %q\n wanted: %q\n", owners[1].Name, expectedOwners[1].Name)
 	}
+	if owners[0].Quantity == nil || *owners[0].Quantity != 12.5 || owners[0].Unit != UnitGrams {
+		t.Errorf("Database returned unexpected stock: %+v\n", owners[0].Stock)
+	}
+	if owners[1].Quantity != nil {
+		t.Errorf("Database returned stock for an owner not tracking it: %v\n", *owners[1].Quantity)
+	}
 
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("There were unfulfilled expectations: %s\n", err)
@@ -860,16 +852,14 @@ func TestGetNonExistentTeaOwnerFromDatabase(t *testing.T) {
 		t.Fatalf("Error occurred setting up mock database: %v\n", err)
 	}
 	defer db.Close()
-	oldDB := DB
-	defer func() { DB = oldDB }()
-	DB = db
+	store := NewSQLStore(db)
 
 	expectedTeaID := 1
 	tea := Tea{ID: expectedTeaID}
 
-	mock.ExpectQuery("SELECT (.)+ FROM teaOwners").WithArgs(expectedTeaID).WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("SELECT (.)+ FROM teaOwners").WithArgs(expectedTeaID, DefaultHousehold).WillReturnError(sql.ErrNoRows)
 
-	_, err = GetTeaOwnersFromDatabase(&tea)
+	_, err = store.GetTeaOwners(&tea)
 	if err != sql.ErrNoRows {
 		t.Errorf("Database returned unexpected error: %v\n", err)
 	}
@@ -885,19 +875,23 @@ func TestCreateTeaOwnerFromDatabase(t *testing.T) {
 		t.Fatalf("Error occurred setting up mock database: %v\n", err)
 	}
 	defer db.Close()
-	oldDB := DB
-	defer func() { DB = oldDB }()
-	DB = db
+	store := NewSQLStore(db)
 
 	teaID := 1
 	owner := Owner{ID: 1}
 	rows := mock.NewRows([]string{"id", "name", "typeID", "typeName"})
 	rows.AddRow(teaID, "Snowball", 1, "Black Tea")
 
-	mock.ExpectExec("INSERT INTO teaOwners").WithArgs(teaID, owner.ID).WillReturnResult(sqlmock.NewResult(1, 1))
-	mock.ExpectQuery("SELECT *(.)+ FROM tea").WithArgs(teaID).WillReturnRows(rows)
+	quantity := 20.0
+	stock := Stock{Quantity: &quantity, Unit: UnitBags}
+	mock.ExpectBegin()
+	expectInHousehold(mock, "tea", teaID)
+	expectInHousehold(mock, "owner", owner.ID)
+	mock.ExpectExec("INSERT INTO teaOwners").WithArgs(teaID, owner.ID, quantity, UnitBags, DefaultHousehold).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("SELECT *(.)+ FROM tea").WithArgs(teaID, DefaultHousehold).WillReturnRows(rows)
+	mock.ExpectCommit()
 
-	tea, err := CreateTeaOwnerInDatabase(teaID, &owner)
+	tea, err := store.CreateTeaOwner(teaID, &owner, stock)
 	if err != nil {
 		t.Errorf("Database returned unexpected error: %v\n", err)
 	}
@@ -925,16 +919,18 @@ func TestCreateTeaOwnerFromDatabaseRelationshipExists(t *testing.T) {
 		t.Fatalf("Error occurred setting up mock database: %v\n", err)
 	}
 	defer db.Close()
-	oldDB := DB
-	defer func() { DB = oldDB }()
-	DB = db
+	store := NewSQLStore(db)
 
 	teaID := 1
 	owner := Owner{ID: 1}
 
-	mock.ExpectExec("INSERT INTO teaOwners").WithArgs(teaID, owner.ID).WillReturnError(errors.New("UNIQUE constraint failed"))
+	mock.ExpectBegin()
+	expectInHousehold(mock, "tea", teaID)
+	expectInHousehold(mock, "owner", owner.ID)
+	mock.ExpectExec("INSERT INTO teaOwners").WithArgs(teaID, owner.ID, nil, nil, DefaultHousehold).WillReturnError(sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintPrimaryKey})
+	mock.ExpectRollback()
 
-	if _,err := CreateTeaOwnerInDatabase(teaID, &owner); err.Error() != "This relationship already exists" {
+	if _,err := store.CreateTeaOwner(teaID, &owner, Stock{}); err.Error() != "This relationship already exists" {
 		t.Errorf("Database returned unexpected error: %v\n", err)
 	}
 
@@ -949,16 +945,18 @@ func TestCreateTeaOwnerFromDatabaseDoesntExists(t *testing.T) {
 		t.Fatalf("Error occurred setting up mock database: %v\n", err)
 	}
 	defer db.Close()
-	oldDB := DB
-	defer func() { DB = oldDB }()
-	DB = db
+	store := NewSQLStore(db)
 
 	teaID := 1
 	owner := Owner{ID: 1}
 
-	mock.ExpectExec("INSERT INTO teaOwners").WithArgs(teaID, owner.ID).WillReturnError(errors.New("FOREIGN KEY constraint failed"))
+	mock.ExpectBegin()
+	expectInHousehold(mock, "tea", teaID)
+	expectInHousehold(mock, "owner", owner.ID)
+	mock.ExpectExec("INSERT INTO teaOwners").WithArgs(teaID, owner.ID, nil, nil, DefaultHousehold).WillReturnError(sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintForeignKey})
+	mock.ExpectRollback()
 
-	if _, err := CreateTeaOwnerInDatabase(teaID, &owner); err.Error() != "Either the tea or owner ID do not exist in the database" {
+	if _, err := store.CreateTeaOwner(teaID, &owner, Stock{}); err.Error() != "Either the tea or owner ID do not exist in the database" {
 		t.Errorf("Database returned unexpected error: %q\n", err)
 	}
 
@@ -973,16 +971,14 @@ func TestDeleteTeaOwnerFromDatabase(t *testing.T) {
 		t.Fatalf("Error occurred setting up mock database: %v\n", err)
 	}
 	defer db.Close()
-	oldDB := DB
-	defer func() { DB = oldDB }()
-	DB = db
+	store := NewSQLStore(db)
 
 	tea := Tea{ID: 1}
 	owner := Owner{ID: 1}
 
-	mock.ExpectExec("DELETE FROM tea").WithArgs(tea.ID, owner.ID).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("DELETE FROM tea").WithArgs(tea.ID, owner.ID, DefaultHousehold).WillReturnResult(sqlmock.NewResult(1, 1))
 
-	err = DeleteTeaOwnerFromDatabase(&tea, &owner)
+	err = store.DeleteTeaOwner(&tea, &owner)
 	if err != nil {
 		t.Errorf("Unexpected error whilst trying to delete tea owner from database: %v\n", err)
 	}
@@ -998,17 +994,15 @@ func TestDeleteNonExistantTeaOwnerFromDatabase(t *testing.T) {
 		t.Fatalf("Error occurred setting up mock database: %v\n\n", err)
 	}
 	defer db.Close()
-	oldDB := DB
-	defer func() { DB = oldDB }()
-	DB = db
+	store := NewSQLStore(db)
 
 	tea := Tea{ID: 1}
 	owner := Owner{ID: 1}
 
-	mock.ExpectExec("DELETE FROM tea").WithArgs(tea.ID, owner.ID).WillReturnError(sql.ErrNoRows)
+	mock.ExpectExec("DELETE FROM tea").WithArgs(tea.ID, owner.ID, DefaultHousehold).WillReturnResult(sqlmock.NewResult(0, 0))
 
-	err = DeleteTeaOwnerFromDatabase(&tea, &owner)
-	if err != sql.ErrNoRows {
+	err = store.DeleteTeaOwner(&tea, &owner)
+	if !errors.Is(err, ErrNotFound) {
 		t.Errorf("Unexpected error whilst trying to delete tea from database: %v\n", err)
 	}
 
@@ -1016,3 +1010,889 @@ func TestDeleteNonExistantTeaOwnerFromDatabase(t *testing.T) {
 		t.Errorf("There were unfulfilled expectations: %s\n", err)
 	}
 }
+
+func TestCreateSelectionTables(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error occurred setting up mock database: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec("CREATE TABLE selections").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE selectionOwners").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if err := createSelectionTables(db, Config{}); err != nil {
+		t.Errorf("Unexpected error creating selection tables: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expections: %s", err)
+	}
+}
+
+func TestGetSelectionCandidatesFromDatabase(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error occurred setting up mock database: %v\n", err)
+	}
+	defer db.Close()
+	store := NewSQLStore(db)
+
+	rows := mock.NewRows(teaColumnNames)
+	rows.AddRow(teaRow(2, "Nearly Nirvana", 2, "White Tea")...)
+
+	mock.ExpectQuery("SELECT (.)+ FROM tea (.)+ WHERE tea.householdID = \\$1 AND teaOwners.ownerID IN \\(\\$2, \\$3\\) AND tea.id NOT IN \\(SELECT teaID FROM ratings WHERE neverPick = TRUE AND ownerID IN \\(\\$2, \\$3\\)\\) AND tea.id NOT IN \\(SELECT teaID FROM teaOwners WHERE ownerID IN \\(\\$2, \\$3\\) GROUP BY teaID HAVING (.)+\\) GROUP BY tea.id, types.id HAVING COUNT\\(DISTINCT teaOwners.ownerID\\) = \\$4 ORDER BY tea.id;").WithArgs(DefaultHousehold, 1, 2, 2).WillReturnRows(rows)
+
+	teas, err := store.GetSelectionCandidates(SelectionOptions{OwnerIDs: []int{1, 2, 1}})
+	if err != nil {
+		t.Errorf("Database returned unexpected error: %v\n", err)
+	}
+	expected := Tea{ID: 2, Name: "Nearly Nirvana", TeaType: TeaType{ID: 2, Name: "White Tea"}}
+	if len(teas) != 1 || teas[0] != expected {
+		t.Errorf("Database returned unexpected result:\n got: %v\n wanted: %v\n", teas, []Tea{expected})
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s\n", err)
+	}
+}
+
+func TestGetSelectionCandidatesFromDatabaseNoOwners(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error occurred setting up mock database: %v\n", err)
+	}
+	defer db.Close()
+	store := NewSQLStore(db)
+
+	rows := mock.NewRows(teaColumnNames)
+	rows.AddRow(teaRow(1, "Snowball", 1, "Black Tea")...)
+	rows.AddRow(teaRow(2, "Nearly Nirvana", 2, "White Tea")...)
+
+	mock.ExpectQuery("SELECT (.)+ FROM tea INNER JOIN types ON types.id = tea.teaType WHERE tea.householdID = \\$1 AND tea.id NOT IN \\(SELECT teaID FROM teaOwners GROUP BY teaID HAVING (.)+\\) ORDER BY tea.id;").WithArgs(DefaultHousehold).WillReturnRows(rows)
+
+	teas, err := store.GetSelectionCandidates(SelectionOptions{})
+	if err != nil {
+		t.Errorf("Database returned unexpected error: %v\n", err)
+	}
+	if len(teas) != 2 {
+		t.Errorf("Database returned unexpected number of teas:\n got: %d\n wanted: %d\n", len(teas), 2)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s\n", err)
+	}
+}
+
+func TestGetSelectionCandidatesFromDatabaseExcludeRecent(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error occurred setting up mock database: %v\n", err)
+	}
+	defer db.Close()
+	store := NewSQLStore(db)
+
+	since := time.Date(2020, 7, 1, 12, 0, 0, 0, time.UTC)
+	rows := mock.NewRows(teaColumnNames)
+	rows.AddRow(teaRow(1, "Snowball", 1, "Black Tea")...)
+
+	mock.ExpectQuery("AND tea.id NOT IN \\(SELECT selections.teaID FROM selections INNER JOIN tea ON tea.id = selections.teaID WHERE tea.householdID = \\$2 ORDER BY selections.id DESC LIMIT \\$3\\) AND tea.id NOT IN \\(SELECT selections.teaID FROM selections INNER JOIN tea ON tea.id = selections.teaID WHERE tea.householdID = \\$4 AND selections.selectedAt >= \\$5\\) ORDER BY tea.id;").WithArgs(DefaultHousehold, DefaultHousehold, 3, DefaultHousehold, since.Unix()).WillReturnRows(rows)
+
+	teas, err := store.GetSelectionCandidates(SelectionOptions{ExcludePicks: 3, ExcludeSince: since})
+	if err != nil {
+		t.Errorf("Database returned unexpected error: %v\n", err)
+	}
+	if len(teas) != 1 {
+		t.Errorf("Database returned unexpected number of teas:\n got: %d\n wanted: %d\n", len(teas), 1)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s\n", err)
+	}
+}
+
+func TestCreateSelectionInDatabase(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error occurred setting up mock database: %v\n", err)
+	}
+	defer db.Close()
+	store := NewSQLStore(db)
+
+	selectedAt := time.Date(2020, 7, 1, 12, 0, 0, 0, time.UTC)
+	mock.ExpectBegin()
+	expectInHousehold(mock, "tea", 1)
+	expectInHousehold(mock, "owner", 1)
+	expectInHousehold(mock, "owner", 2)
+	mock.ExpectExec("INSERT INTO selections").WithArgs(1, selectedAt.Unix()).WillReturnResult(sqlmock.NewResult(5, 1))
+	mock.ExpectExec("INSERT INTO selectionOwners").WithArgs(5, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO selectionOwners").WithArgs(5, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	selection := SelectionRecord{Tea: Tea{ID: 1}, Owners: []Owner{{ID: 1}, {ID: 2}}, SelectedAt: selectedAt}
+	if err := store.CreateSelection(&selection); err != nil {
+		t.Errorf("Database returned unexpected error: %v\n", err)
+	}
+	if selection.ID != 5 {
+		t.Errorf("Selection ID not updated:\n got: %d\n wanted: %d\n", selection.ID, 5)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s\n", err)
+	}
+}
+
+func TestGetSelectionsFromDatabase(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error occurred setting up mock database: %v\n", err)
+	}
+	defer db.Close()
+	store := NewSQLStore(db)
+
+	selectedAt := time.Date(2020, 7, 1, 12, 0, 0, 0, time.UTC)
+	countRows := mock.NewRows([]string{"count"})
+	countRows.AddRow(3)
+	selectionRows := mock.NewRows([]string{"id", "selectedAt", "id", "name", "id", "name"})
+	selectionRows.AddRow(2, selectedAt.Unix(), 1, "Snowball", 1, "Black Tea")
+	ownerRows := mock.NewRows([]string{"id", "name"})
+	ownerRows.AddRow(1, "John")
+
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM selections").WithArgs(DefaultHousehold).WillReturnRows(countRows)
+	mock.ExpectQuery("SELECT (.)+ FROM selections (.)+ LIMIT \\$2 OFFSET \\$3").WithArgs(DefaultHousehold, 1, 1).WillReturnRows(selectionRows)
+	mock.ExpectQuery("SELECT owner.id, owner.name FROM selectionOwners").WithArgs(2, DefaultHousehold).WillReturnRows(ownerRows)
+
+	selections, total, err := store.GetSelections(1, 1)
+	if err != nil {
+		t.Errorf("Database returned unexpected error: %v\n", err)
+	}
+	if total != 3 {
+		t.Errorf("Database returned unexpected total:\n got: %d\n wanted: %d\n", total, 3)
+	}
+	if len(selections) != 1 {
+		t.Fatalf("Database returned unexpected number of selections:\n got: %d\n wanted: %d\n", len(selections), 1)
+	}
+	if selections[0].ID != 2 || !selections[0].SelectedAt.Equal(selectedAt) || selections[0].Tea.Name != "Snowball" {
+		t.Errorf("Database returned unexpected selection: %+v\n", selections[0])
+	}
+	if len(selections[0].Owners) != 1 || selections[0].Owners[0] != (Owner{ID: 1, Name: "John"}) {
+		t.Errorf("Database returned unexpected owners: %v\n", selections[0].Owners)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s\n", err)
+	}
+}
+
+func TestGetLastSelectedFromDatabase(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error occurred setting up mock database: %v\n", err)
+	}
+	defer db.Close()
+	store := NewSQLStore(db)
+
+	selectedAt := time.Date(2020, 7, 1, 12, 0, 0, 0, time.UTC)
+	rows := mock.NewRows([]string{"teaID", "selectedAt"})
+	rows.AddRow(1, selectedAt.Unix())
+
+	mock.ExpectQuery("SELECT selections.teaID, MAX\\(selections.selectedAt\\) FROM selections INNER JOIN tea ON tea.id = selections.teaID WHERE tea.householdID = \\$1 GROUP BY selections.teaID;").WithArgs(DefaultHousehold).WillReturnRows(rows)
+
+	lastSelected, err := store.GetLastSelected()
+	if err != nil {
+		t.Errorf("Database returned unexpected error: %v\n", err)
+	}
+	if len(lastSelected) != 1 || !lastSelected[1].Equal(selectedAt) {
+		t.Errorf("Database returned unexpected result:\n got: %v\n wanted: %v\n", lastSelected, map[int]time.Time{1: selectedAt})
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s\n", err)
+	}
+}
+
+func TestCreateRatingsTable(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error occurred setting up mock database: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec("CREATE TABLE ratings").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if err := createRatingsTable(db, Config{}); err != nil {
+		t.Errorf("Unexpected error creating ratings table: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expections: %s", err)
+	}
+}
+
+func TestGetTeaRatingsFromDatabase(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error occurred setting up mock database: %v\n", err)
+	}
+	defer db.Close()
+	store := NewSQLStore(db)
+
+	rows := mock.NewRows([]string{"id", "name", "rating", "neverPick"})
+	rows.AddRow(1, "John", 4, false)
+	rows.AddRow(2, "Jane", nil, true)
+
+	mock.ExpectQuery("SELECT (.)+ FROM ratings").WithArgs(1, DefaultHousehold).WillReturnRows(rows)
+
+	ratings, err := store.GetTeaRatings(&Tea{ID: 1})
+	if err != nil {
+		t.Errorf("Database returned unexpected error: %v\n", err)
+	}
+	expected := []Rating{
+		{Owner: Owner{ID: 1, Name: "John"}, Rating: 4},
+		{Owner: Owner{ID: 2, Name: "Jane"}, NeverPick: true},
+	}
+	if len(ratings) != len(expected) || ratings[0] != expected[0] || ratings[1] != expected[1] {
+		t.Errorf("Database returned unexpected result:\n got: %v\n wanted: %v\n", ratings, expected)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s\n", err)
+	}
+}
+
+func TestCreateTeaRatingInDatabase(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error occurred setting up mock database: %v\n", err)
+	}
+	defer db.Close()
+	store := NewSQLStore(db)
+
+	ownerRows := mock.NewRows([]string{"name"})
+	ownerRows.AddRow("John")
+
+	mock.ExpectBegin()
+	expectInHousehold(mock, "tea", 1)
+	expectInHousehold(mock, "owner", 2)
+	mock.ExpectExec("INSERT INTO ratings").WithArgs(1, 2, 4, false).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("SELECT name FROM owner").WithArgs(2, DefaultHousehold).WillReturnRows(ownerRows)
+	mock.ExpectCommit()
+
+	rating := Rating{Owner: Owner{ID: 2}, Rating: 4}
+	if err := store.CreateTeaRating(1, &rating); err != nil {
+		t.Errorf("Database returned unexpected error: %v\n", err)
+	}
+	if rating.Owner.Name != "John" {
+		t.Errorf("Owner name not updated:\n got: %q\n wanted: %q\n", rating.Owner.Name, "John")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s\n", err)
+	}
+}
+
+func TestCreateTeaRatingInDatabaseAlreadyExists(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error occurred setting up mock database: %v\n", err)
+	}
+	defer db.Close()
+	store := NewSQLStore(db)
+
+	mock.ExpectBegin()
+	expectInHousehold(mock, "tea", 1)
+	expectInHousehold(mock, "owner", 2)
+	mock.ExpectExec("INSERT INTO ratings").WithArgs(1, 2, nil, true).WillReturnError(sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintPrimaryKey})
+	mock.ExpectRollback()
+
+	rating := Rating{Owner: Owner{ID: 2}, NeverPick: true}
+	if err := store.CreateTeaRating(1, &rating); err == nil || err.Error() != "This owner has already rated this tea" {
+		t.Errorf("Database returned unexpected error: %v\n", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s\n", err)
+	}
+}
+
+func TestUpdateTeaRatingInDatabase(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error occurred setting up mock database: %v\n", err)
+	}
+	defer db.Close()
+	store := NewSQLStore(db)
+
+	ownerRows := mock.NewRows([]string{"name"})
+	ownerRows.AddRow("John")
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE ratings").WithArgs(5, false, 1, 2, DefaultHousehold).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT name FROM owner").WithArgs(2, DefaultHousehold).WillReturnRows(ownerRows)
+	mock.ExpectCommit()
+
+	rating := Rating{Owner: Owner{ID: 2}, Rating: 5}
+	if err := store.UpdateTeaRating(1, &rating); err != nil {
+		t.Errorf("Database returned unexpected error: %v\n", err)
+	}
+	if rating.Owner.Name != "John" {
+		t.Errorf("Owner name not updated:\n got: %q\n wanted: %q\n", rating.Owner.Name, "John")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s\n", err)
+	}
+}
+
+func TestUpdateNonExistentTeaRatingInDatabase(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error occurred setting up mock database: %v\n", err)
+	}
+	defer db.Close()
+	store := NewSQLStore(db)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE ratings").WithArgs(5, false, 1, 2, DefaultHousehold).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	rating := Rating{Owner: Owner{ID: 2}, Rating: 5}
+	if err := store.UpdateTeaRating(1, &rating); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Method returned unexpected error:\n got: %v\n wanted: %v\n", err, ErrNotFound)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s\n", err)
+	}
+}
+
+func TestDeleteTeaRatingFromDatabase(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error occurred setting up mock database: %v\n", err)
+	}
+	defer db.Close()
+	store := NewSQLStore(db)
+
+	mock.ExpectExec("DELETE FROM ratings").WithArgs(1, 2, DefaultHousehold).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := store.DeleteTeaRating(1, &Owner{ID: 2}); err != nil {
+		t.Errorf("Database returned unexpected error: %v\n", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s\n", err)
+	}
+}
+
+func TestDeleteNonExistentTeaRatingFromDatabase(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error occurred setting up mock database: %v\n", err)
+	}
+	defer db.Close()
+	store := NewSQLStore(db)
+
+	mock.ExpectExec("DELETE FROM ratings").WithArgs(1, 2, DefaultHousehold).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if err := store.DeleteTeaRating(1, &Owner{ID: 2}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Method returned unexpected error:\n got: %v\n wanted: %v\n", err, ErrNotFound)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s\n", err)
+	}
+}
+
+func TestGetAverageRatingsFromDatabase(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error occurred setting up mock database: %v\n", err)
+	}
+	defer db.Close()
+	store := NewSQLStore(db)
+
+	rows := mock.NewRows([]string{"teaID", "average"})
+	rows.AddRow(1, 4.5)
+
+	mock.ExpectQuery("SELECT teaID, AVG\\(rating\\) FROM ratings WHERE rating IS NOT NULL AND teaID IN \\(SELECT id FROM tea WHERE householdID = \\$1\\) AND ownerID IN \\(\\$2, \\$3\\) GROUP BY teaID;").WithArgs(DefaultHousehold, 1, 2).WillReturnRows(rows)
+
+	averages, err := store.GetAverageRatings([]int{1, 2})
+	if err != nil {
+		t.Errorf("Database returned unexpected error: %v\n", err)
+	}
+	if len(averages) != 1 || averages[1] != 4.5 {
+		t.Errorf("Database returned unexpected result:\n got: %v\n wanted: %v\n", averages, map[int]float64{1: 4.5})
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s\n", err)
+	}
+}
+
+func TestUpdateTeaTypeInDatabase(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error occurred setting up mock database: %v\n", err)
+	}
+	defer db.Close()
+	store := NewSQLStore(db)
+
+	mock.ExpectExec("UPDATE types SET name").WithArgs("Breakfast Tea", 95, nil, nil, "high", 1, DefaultHousehold).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	teaType := TeaType{ID: 1, Name: "Breakfast Tea", BrewingGuide: BrewingGuide{BrewTemperature: 95, Caffeine: "high"}}
+	if err := store.UpdateTeaType(&teaType); err != nil {
+		t.Errorf("Database returned unexpected error: %v\n", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s\n", err)
+	}
+}
+
+func TestUpdateNonExistentTeaTypeInDatabase(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error occurred setting up mock database: %v\n", err)
+	}
+	defer db.Close()
+	store := NewSQLStore(db)
+
+	mock.ExpectExec("UPDATE types SET name").WithArgs("Breakfast Tea", nil, nil, nil, nil, 10, DefaultHousehold).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	teaType := TeaType{ID: 10, Name: "Breakfast Tea"}
+	if err := store.UpdateTeaType(&teaType); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Method returned unexpected error:\n got: %v\n wanted: %v\n", err, ErrNotFound)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s\n", err)
+	}
+}
+
+func TestUpdateOwnerInDatabase(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error occurred setting up mock database: %v\n", err)
+	}
+	defer db.Close()
+	store := NewSQLStore(db)
+
+	mock.ExpectExec("UPDATE owner SET name").WithArgs("Johnny", 1, DefaultHousehold).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	owner := Owner{ID: 1, Name: "Johnny"}
+	if err := store.UpdateOwner(&owner); err != nil {
+		t.Errorf("Database returned unexpected error: %v\n", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s\n", err)
+	}
+}
+
+func TestUpdateNonExistentOwnerInDatabase(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error occurred setting up mock database: %v\n", err)
+	}
+	defer db.Close()
+	store := NewSQLStore(db)
+
+	mock.ExpectExec("UPDATE owner SET name").WithArgs("Johnny", 10, DefaultHousehold).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	owner := Owner{ID: 10, Name: "Johnny"}
+	if err := store.UpdateOwner(&owner); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Method returned unexpected error:\n got: %v\n wanted: %v\n", err, ErrNotFound)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s\n", err)
+	}
+}
+
+func TestUpdateTeaInDatabase(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error occurred setting up mock database: %v\n", err)
+	}
+	defer db.Close()
+	store := NewSQLStore(db)
+
+	typeRows := mock.NewRows([]string{"name"})
+	typeRows.AddRow("Green Tea")
+	teaRows := mock.NewRows(append(teaColumnNames, "rating"))
+	teaRows.AddRow(append(teaRow(1, "Snowball", 2, "Green Tea"), nil)...)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT name FROM types").WithArgs(2, DefaultHousehold).WillReturnRows(typeRows)
+	mock.ExpectExec("UPDATE tea SET name").WithArgs("Snowball", 2, nil, nil, nil, nil, nil, 1, DefaultHousehold).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT (.)+ FROM tea").WithArgs(DefaultHousehold, 1).WillReturnRows(teaRows)
+	mock.ExpectCommit()
+
+	tea := Tea{ID: 1, Name: "Snowball", TeaType: TeaType{ID: 2}}
+	if err := store.UpdateTea(&tea); err != nil {
+		t.Errorf("Database returned unexpected error: %v\n", err)
+	}
+	expected := Tea{ID: 1, Name: "Snowball", TeaType: TeaType{ID: 2, Name: "Green Tea"}}
+	if tea != expected {
+		t.Errorf("Database returned unexpected result:\n got: %v\n wanted: %v\n", tea, expected)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s\n", err)
+	}
+}
+
+func TestUpdateTeaInDatabaseBadType(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error occurred setting up mock database: %v\n", err)
+	}
+	defer db.Close()
+	store := NewSQLStore(db)
+
+	expectedError := "Tea type does not exist or is missing"
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT name FROM types").WithArgs(20, DefaultHousehold).WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	tea := Tea{ID: 1, Name: "Snowball", TeaType: TeaType{ID: 20}}
+	if err := store.UpdateTea(&tea); err == nil || err.Error() != expectedError {
+		t.Errorf("Wrong error returned:\n Got: %v\n Expected: %s\n", err, expectedError)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s\n", err)
+	}
+}
+
+func TestUpdateNonExistentTeaInDatabase(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error occurred setting up mock database: %v\n", err)
+	}
+	defer db.Close()
+	store := NewSQLStore(db)
+
+	typeRows := mock.NewRows([]string{"name"})
+	typeRows.AddRow("Green Tea")
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT name FROM types").WithArgs(2, DefaultHousehold).WillReturnRows(typeRows)
+	mock.ExpectExec("UPDATE tea SET name").WithArgs("Snowball", 2, nil, nil, nil, nil, nil, 10, DefaultHousehold).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	tea := Tea{ID: 10, Name: "Snowball", TeaType: TeaType{ID: 2}}
+	if err := store.UpdateTea(&tea); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Method returned unexpected error:\n got: %v\n wanted: %v\n", err, ErrNotFound)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s\n", err)
+	}
+}
+
+func TestGetSelectionCandidatesFromDatabaseCaffeine(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error occurred setting up mock database: %v\n", err)
+	}
+	defer db.Close()
+	store := NewSQLStore(db)
+
+	rows := mock.NewRows(teaColumnNames)
+	rows.AddRow(3, "Rooibos", nil, nil, nil, nil, nil, 9, "Rooibos", 100, 300, 2.5, "none")
+
+	mock.ExpectQuery("AND COALESCE\\(tea.caffeine, types.caffeine\\) IN \\(\\$2, \\$3\\) ORDER BY tea.id;").WithArgs(DefaultHousehold, "none", "low").WillReturnRows(rows)
+
+	teas, err := store.GetSelectionCandidates(SelectionOptions{Caffeine: []string{"none", "low"}})
+	if err != nil {
+		t.Errorf("Database returned unexpected error: %v\n", err)
+	}
+	expected := Tea{ID: 3, Name: "Rooibos", TeaType: TeaType{ID: 9, Name: "Rooibos", BrewingGuide: BrewingGuide{BrewTemperature: 100, SteepSeconds: 300, LeafGrams: 2.5, Caffeine: "none"}}}
+	if len(teas) != 1 || teas[0] != expected {
+		t.Errorf("Database returned unexpected result:\n got: %v\n wanted: %v\n", teas, []Tea{expected})
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s\n", err)
+	}
+}
+
+func TestConsumeTeaStockInDatabase(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error occurred setting up mock database: %v\n", err)
+	}
+	defer db.Close()
+	store := NewSQLStore(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT quantity, unit FROM teaOwners").WithArgs(1, 2, DefaultHousehold).WillReturnRows(mock.NewRows([]string{"quantity", "unit"}).AddRow(12, "bags"))
+	mock.ExpectExec("UPDATE teaOwners SET quantity = CASE WHEN quantity > \\$1 THEN quantity - \\$1 ELSE 0 END").WithArgs(1.0, 1, 2, DefaultHousehold).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT quantity, unit FROM teaOwners").WithArgs(1, 2, DefaultHousehold).WillReturnRows(mock.NewRows([]string{"quantity", "unit"}).AddRow(11, "bags"))
+	mock.ExpectCommit()
+
+	stock, err := store.ConsumeTeaStock(1, 2, 1)
+	if err != nil {
+		t.Errorf("Database returned unexpected error: %v\n", err)
+	}
+	if stock.Quantity == nil || *stock.Quantity != 11 || stock.Unit != UnitBags {
+		t.Errorf("Database returned unexpected stock: %+v\n", stock)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s\n", err)
+	}
+}
+
+func TestConsumeUntrackedTeaStockInDatabase(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error occurred setting up mock database: %v\n", err)
+	}
+	defer db.Close()
+	store := NewSQLStore(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT quantity, unit FROM teaOwners").WithArgs(1, 2, DefaultHousehold).WillReturnRows(mock.NewRows([]string{"quantity", "unit"}).AddRow(nil, nil))
+	mock.ExpectRollback()
+
+	if _, err := store.ConsumeTeaStock(1, 2, 1); err != ErrStockNotTracked {
+		t.Errorf("Database returned unexpected error: %v\n", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s\n", err)
+	}
+}
+
+func TestConsumeNonExistentTeaStockInDatabase(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error occurred setting up mock database: %v\n", err)
+	}
+	defer db.Close()
+	store := NewSQLStore(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT quantity, unit FROM teaOwners").WithArgs(1, 2, DefaultHousehold).WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	if _, err := store.ConsumeTeaStock(1, 2, 1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Database returned unexpected error: %v\n", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s\n", err)
+	}
+}
+
+func TestRestockUntrackedTeaInDatabase(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error occurred setting up mock database: %v\n", err)
+	}
+	defer db.Close()
+	store := NewSQLStore(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT quantity, unit FROM teaOwners").WithArgs(1, 2, DefaultHousehold).WillReturnRows(mock.NewRows([]string{"quantity", "unit"}).AddRow(nil, nil))
+	mock.ExpectExec("UPDATE teaOwners SET quantity = COALESCE\\(quantity, 0\\) \\+ \\$1, unit = \\$2").WithArgs(20.0, UnitBags, 1, 2, DefaultHousehold).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT quantity, unit FROM teaOwners").WithArgs(1, 2, DefaultHousehold).WillReturnRows(mock.NewRows([]string{"quantity", "unit"}).AddRow(20, "bags"))
+	mock.ExpectCommit()
+
+	quantity := 20.0
+	stock, err := store.RestockTea(1, 2, Stock{Quantity: &quantity})
+	if err != nil {
+		t.Errorf("Database returned unexpected error: %v\n", err)
+	}
+	if stock.Quantity == nil || *stock.Quantity != 20 || stock.Unit != UnitBags {
+		t.Errorf("Database returned unexpected stock: %+v\n", stock)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s\n", err)
+	}
+}
+
+func TestRestockTeaInDatabaseUnitMismatch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error occurred setting up mock database: %v\n", err)
+	}
+	defer db.Close()
+	store := NewSQLStore(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT quantity, unit FROM teaOwners").WithArgs(1, 2, DefaultHousehold).WillReturnRows(mock.NewRows([]string{"quantity", "unit"}).AddRow(12, "bags"))
+	mock.ExpectRollback()
+
+	quantity := 50.0
+	if _, err := store.RestockTea(1, 2, Stock{Quantity: &quantity, Unit: UnitGrams}); err != ErrStockUnitMismatch {
+		t.Errorf("Database returned unexpected error: %v\n", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s\n", err)
+	}
+}
+
+func TestGetStockFromDatabase(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error occurred setting up mock database: %v\n", err)
+	}
+	defer db.Close()
+	store := NewSQLStore(db)
+
+	rows := mock.NewRows([]string{"teaID", "quantity", "unit"})
+	rows.AddRow(1, 12, "bags")
+	rows.AddRow(1, nil, nil)
+	rows.AddRow(2, 50, "grams")
+
+	mock.ExpectQuery("SELECT teaID, quantity, unit FROM teaOwners WHERE householdID = \\$1 AND ownerID IN \\(\\$2, \\$3\\) ORDER BY teaID, ownerID;").WithArgs(DefaultHousehold, 1, 2).WillReturnRows(rows)
+
+	stock, err := store.GetStock([]int{1, 2, 1})
+	if err != nil {
+		t.Errorf("Database returned unexpected error: %v\n", err)
+	}
+	if len(stock[1]) != 2 || len(stock[2]) != 1 {
+		t.Fatalf("Database returned unexpected stock: %v\n", stock)
+	}
+	if *stock[1][0].Quantity != 12 || stock[1][1].Quantity != nil || *stock[2][0].Quantity != 50 || stock[2][0].Unit != UnitGrams {
+		t.Errorf("Database returned unexpected stock: %v\n", stock)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s\n", err)
+	}
+}
+
+// A stockChange is a change to an owner's stock of a tea, as told to a stock observer.
+type stockChange struct {
+	household, teaID, ownerID int
+	before, after             Stock
+}
+
+// recordingObserver is a stock observer that records every change it is told about.
+type recordingObserver struct {
+	changes []stockChange
+}
+
+func (o *recordingObserver) StockChanged(household int, teaID int, ownerID int, before Stock, after Stock) {
+	o.changes = append(o.changes, stockChange{household, teaID, ownerID, before, after})
+}
+
+func TestConsumeTeaStockInDatabaseObserved(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error occurred setting up mock database: %v\n", err)
+	}
+	defer db.Close()
+	store := NewSQLStore(db)
+	observer := new(recordingObserver)
+	store.SetStockObserver(observer)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT quantity, unit FROM teaOwners").WithArgs(1, 2, DefaultHousehold).WillReturnRows(mock.NewRows([]string{"quantity", "unit"}).AddRow(5, "bags"))
+	mock.ExpectExec("UPDATE teaOwners").WithArgs(1.0, 1, 2, DefaultHousehold).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT quantity, unit FROM teaOwners").WithArgs(1, 2, DefaultHousehold).WillReturnRows(mock.NewRows([]string{"quantity", "unit"}).AddRow(4, "bags"))
+	mock.ExpectCommit()
+
+	if _, err := store.ConsumeTeaStock(1, 2, 1); err != nil {
+		t.Errorf("Database returned unexpected error: %v\n", err)
+	}
+
+	if len(observer.changes) != 1 {
+		t.Fatalf("Database told the observer about unexpected number of changes:\n got: %v\n wanted: %v\n", len(observer.changes), 1)
+	}
+	change := observer.changes[0]
+	if change.household != DefaultHousehold || change.teaID != 1 || change.ownerID != 2 || *change.before.Quantity != 5 || *change.after.Quantity != 4 || change.after.Unit != UnitBags {
+		t.Errorf("Database told the observer about unexpected change: %+v\n", change)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s\n", err)
+	}
+}
+
+func TestCreateWebhookDeliveryInDatabase(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error occurred setting up mock database: %v\n", err)
+	}
+	defer db.Close()
+	store := NewSQLStore(db)
+
+	createdAt := time.Date(2020, 7, 1, 12, 0, 0, 0, time.UTC)
+	delivery := WebhookDelivery{Event: WebhookLowStock, URL: "http://localhost/hook", Payload: "{}", CreatedAt: createdAt}
+	mock.ExpectExec("INSERT INTO webhookDeliveries").WithArgs(WebhookLowStock, "http://localhost/hook", "{}", createdAt.Unix(), DefaultHousehold).WillReturnResult(sqlmock.NewResult(3, 1))
+
+	if err := store.CreateWebhookDelivery(&delivery); err != nil {
+		t.Errorf("Database returned unexpected error: %v\n", err)
+	}
+	if delivery.ID != 3 {
+		t.Errorf("Database returned unexpected delivery ID: %d\n", delivery.ID)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s\n", err)
+	}
+}
+
+func TestUpdateWebhookDeliveryInDatabase(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error occurred setting up mock database: %v\n", err)
+	}
+	defer db.Close()
+	store := NewSQLStore(db)
+
+	delivery := WebhookDelivery{ID: 3, Attempts: 2, Error: "Received status code 500", StatusCode: 500}
+	mock.ExpectExec("UPDATE webhookDeliveries").WithArgs(2, 500, false, "Received status code 500", 3, DefaultHousehold).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := store.UpdateWebhookDelivery(&delivery); err != nil {
+		t.Errorf("Database returned unexpected error: %v\n", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s\n", err)
+	}
+}
+
+func TestGetWebhookDeliveriesFromDatabase(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error occurred setting up mock database: %v\n", err)
+	}
+	defer db.Close()
+	store := NewSQLStore(db)
+
+	createdAt := time.Date(2020, 7, 1, 12, 0, 0, 0, time.UTC)
+	rows := mock.NewRows([]string{"id", "event", "url", "payload", "attempts", "statusCode", "delivered", "error", "createdAt"})
+	rows.AddRow(2, WebhookLowStock, "http://localhost/hook", "{}", 1, 200, true, nil, createdAt.Unix())
+	rows.AddRow(1, WebhookLowStock, "http://localhost/hook", "{}", 5, nil, false, "connection refused", createdAt.Unix())
+
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM webhookDeliveries WHERE householdID = \\$1;").WithArgs(DefaultHousehold).WillReturnRows(mock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectQuery("SELECT (.)+ FROM webhookDeliveries WHERE householdID = \\$1 ORDER BY id DESC LIMIT \\$2 OFFSET \\$3;").WithArgs(DefaultHousehold, 20, 0).WillReturnRows(rows)
+
+	deliveries, total, err := store.GetWebhookDeliveries(20, 0)
+	if err != nil {
+		t.Errorf("Database returned unexpected error: %v\n", err)
+	}
+	if total != 2 {
+		t.Errorf("Database returned unexpected total: %d\n", total)
+	}
+	expected := []WebhookDelivery{
+		{ID: 2, Event: WebhookLowStock, URL: "http://localhost/hook", Payload: "{}", Attempts: 1, StatusCode: 200, Delivered: true, CreatedAt: createdAt},
+		{ID: 1, Event: WebhookLowStock, URL: "http://localhost/hook", Payload: "{}", Attempts: 5, Error: "connection refused", CreatedAt: createdAt},
+	}
+	if !reflect.DeepEqual(deliveries, expected) {
+		t.Errorf("Database returned unexpected deliveries:\n got: %+v\n wanted: %+v\n", deliveries, expected)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s\n", err)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// testPostgresConfig gives the config connecting to the Postgres database given by TEA_SELECTOR_TEST_POSTGRES, using the
+// tea_selector_test schema, skipping the test if it isn't set.
+func testPostgresConfig(t *testing.T) Config {
+	location := os.Getenv("TEA_SELECTOR_TEST_POSTGRES")
+	if location == "" {
+		t.Skip("TEA_SELECTOR_TEST_POSTGRES isn't set to a Postgres connection string")
+	}
+
+	// The schema is set for every connection as a run-time parameter, so stores can use as many connections as they need.
+	switch {
+	case !strings.Contains(location, "://"):
+		location += " search_path=tea_selector_test"
+	case strings.Contains(location, "?"):
+		location += "&search_path=tea_selector_test"
+	default:
+		location += "?search_path=tea_selector_test"
+	}
+
+	var cfg Config
+	cfg.Database.Driver = DriverPostgres
+	cfg.Database.Location = location
+	return cfg
+}
+
+// openTestPostgres connects to the Postgres database given by TEA_SELECTOR_TEST_POSTGRES, skipping the test if it isn't
+// set. The store uses an empty schema, which is dropped when the returned function is called.
+func openTestPostgres(t *testing.T) (*SQLStore, func()) {
+	store := openDatabase(testPostgresConfig(t))
+	for _, statement := range []string{"DROP SCHEMA IF EXISTS tea_selector_test CASCADE;", "CREATE SCHEMA tea_selector_test;"} {
+		if _, err := store.db.Exec(statement); err != nil {
+			store.Close()
+			t.Fatalf("Error creating test schema: %v\n", err)
+		}
+	}
+
+	return store, func() {
+		store.db.Exec("DROP SCHEMA tea_selector_test CASCADE;")
+		store.Close()
+	}
+}
+
+func TestPostgresStore(t *testing.T) {
+	store, closeDatabase := openTestPostgres(t)
+	defer closeDatabase()
+
+	if _, err := store.MigrateUp(storeTestConfig(), 0); err != nil {
+		t.Fatalf("Unexpected error migrating database: %v\n", err)
+	}
+	testStore(t, store)
+
+	if reverted, err := store.MigrateDown(len(migrations)); err != nil || reverted != len(migrations) {
+		t.Errorf("Reverting every migration reverted %d, with error: %v\n", reverted, err)
+	}
+	if exists, err := store.dialect.tableExists(store.db, "teaOwners"); err != nil || exists {
+		t.Errorf("Table teaOwners still exists after reverting migrations, error: %v\n", err)
+	}
+}
+
+func TestPostgresConcurrentMigrations(t *testing.T) {
+	store, closeDatabase := openTestPostgres(t)
+	defer closeDatabase()
+	other := openDatabase(testPostgresConfig(t))
+	defer other.Close()
+
+	// Instances of the API starting at the same time take turns migrating, so only one applies each migration
+	results := make(chan int, 2)
+	errs := make(chan error, 2)
+	for _, s := range []*SQLStore{store, other} {
+		go func(s *SQLStore) {
+			applied, err := s.MigrateUp(storeTestConfig(), 0)
+			results <- applied
+			errs <- err
+		}(s)
+	}
+	total := 0
+	for i := 0; i < 2; i++ {
+		total += <-results
+		if err := <-errs; err != nil {
+			t.Errorf("Unexpected error migrating at the same time: %v\n", err)
+		}
+	}
+	if total != len(migrations) {
+		t.Errorf("Unexpected number of migrations applied:\n got: %d\n wanted: %d\n", total, len(migrations))
+	}
+}
+
+func TestDialectFor(t *testing.T) {
+	if d, err := dialectFor(""); err != nil || d != (sqliteDialect{}) {
+		t.Errorf("Unexpected dialect when no driver is given: %T, error: %v\n", d, err)
+	}
+	if d, err := dialectFor(DriverPostgres); err != nil || d != (postgresDialect{}) {
+		t.Errorf("Unexpected dialect for Postgres: %T, error: %v\n", d, err)
+	}
+	if _, err := dialectFor("mysql"); err == nil {
+		t.Errorf("Expected an error for an unknown driver\n")
+	}
+}
+
+func TestPostgresTranslate(t *testing.T) {
+	statement := `CREATE TABLE example (
+						id INTEGER PRIMARY KEY AUTOINCREMENT,
+						count INTEGER NOT NULL DEFAULT 0,
+						amount REAL,
+						done BOOLEAN NOT NULL DEFAULT 0
+				   );`
+	expected := `CREATE TABLE example (
+						id BIGSERIAL PRIMARY KEY,
+						count BIGINT NOT NULL DEFAULT 0,
+						amount DOUBLE PRECISION,
+						done BOOLEAN NOT NULL DEFAULT FALSE
+				   );`
+	if actual := (postgresDialect{}).translate(statement); actual != expected {
+		t.Errorf("Unexpected translation:\n got: %s\n wanted: %s\n", actual, expected)
+	}
+}
+
+func TestConstraintViolations(t *testing.T) {
+	tests := []struct {
+		dialect    dialect
+		err        error
+		unique     bool
+		foreignKey bool
+	}{
+		{sqliteDialect{}, sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintUnique}, true, false},
+		{sqliteDialect{}, sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintPrimaryKey}, true, false},
+		{sqliteDialect{}, sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintForeignKey}, false, true},
+		{sqliteDialect{}, sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintTrigger}, false, false},
+		{sqliteDialect{}, errors.New("UNIQUE constraint failed"), false, false},
+		{postgresDialect{}, &pq.Error{Code: "23505"}, true, false},
+		{postgresDialect{}, &pq.Error{Code: "23503"}, false, true},
+		{postgresDialect{}, sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintUnique}, false, false},
+	}
+
+	for _, test := range tests {
+		if actual := test.dialect.isUniqueViolation(test.err); actual != test.unique {
+			t.Errorf("Unexpected unique violation for %T %v:\n got: %v\n wanted: %v\n", test.dialect, test.err, actual, test.unique)
+		}
+		if actual := test.dialect.isForeignKeyViolation(test.err); actual != test.foreignKey {
+			t.Errorf("Unexpected foreign key violation for %T %v:\n got: %v\n wanted: %v\n", test.dialect, test.err, actual, test.foreignKey)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// An ErrorKind is a category of error, which decides the status code and machine readable code that are responded with.
+// Check the kind of an error using errors.Is, such as errors.Is(err, ErrNotFound).
+type ErrorKind struct {
+	Code   string
+	Status int
+}
+
+func (kind *ErrorKind) Error() string {
+	return kind.Code
+}
+
+// The kinds of error the API responds with. Any other error is an internal error.
+var (
+	ErrBadRequest   = &ErrorKind{Code: "bad_request", Status: http.StatusBadRequest}                // The request couldn't be read
+	ErrUnauthorized = &ErrorKind{Code: "unauthorized", Status: http.StatusUnauthorized}             // The user isn't logged in
+	ErrForbidden    = &ErrorKind{Code: "forbidden", Status: http.StatusForbidden}                   // The user isn't allowed to do this
+	ErrNotFound     = &ErrorKind{Code: "not_found", Status: http.StatusNotFound}                    // Something doesn't exist
+	ErrConflict     = &ErrorKind{Code: "conflict", Status: http.StatusConflict}                     // Something already exists, or is still in use
+	ErrValidation   = &ErrorKind{Code: "validation_failed", Status: http.StatusUnprocessableEntity} // The request had an invalid value
+	ErrRateLimited  = &ErrorKind{Code: "too_many_requests", Status: http.StatusTooManyRequests}     // The user has to wait before trying again
+	ErrInternal     = &ErrorKind{Code: "internal_error", Status: http.StatusInternalServerError}
+)
+
+// An Error is an error of a particular kind, with a message that can be shown to the user.
+type Error struct {
+	Kind    *ErrorKind
+	Message string
+}
+
+// newError creates an error of the given kind, formatting the message with any arguments.
+func newError(kind *ErrorKind, format string, args ...interface{}) error {
+	return &Error{Kind: kind, Message: fmt.Sprintf(format, args...)}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Is reports whether the error is of the target kind.
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+// An ErrorResponse is the body of every error response.
+type ErrorResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+// respondWithError responds with the status and code of the error's kind. The message of an internal error isn't
+// shown to the user, as it may give away details of the database.
+func respondWithError(w http.ResponseWriter, err error) {
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		log.Printf("Internal error: %v\n", err)
+		apiErr = &Error{Kind: ErrInternal, Message: "Internal server error"}
+	}
+	respondWithJSON(w, apiErr.Kind.Status, ErrorResponse{Error: apiErr.Message, Code: apiErr.Kind.Code})
+}
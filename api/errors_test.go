@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRespondWithError(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		body   string
+	}{
+		{newError(ErrBadRequest, "Bad request body"), http.StatusBadRequest, `{"error":"Bad request body","code":"bad_request"}`},
+		{newError(ErrUnauthorized, "Not Authorized"), http.StatusUnauthorized, `{"error":"Not Authorized","code":"unauthorized"}`},
+		{newError(ErrForbidden, "Incorrect password"), http.StatusForbidden, `{"error":"Incorrect password","code":"forbidden"}`},
+		{errTeaMissing, http.StatusNotFound, `{"error":"Tea does not exist","code":"not_found"}`},
+		{errTeaNameTaken, http.StatusConflict, `{"error":"A tea with this name already exists","code":"conflict"}`},
+		{newError(ErrRateLimited, "Too many failed logins"), http.StatusTooManyRequests, `{"error":"Too many failed logins","code":"too_many_requests"}`},
+		{ErrStockUnitMismatch, http.StatusUnprocessableEntity, `{"error":"Stock of this tea is measured in a different unit","code":"validation_failed"}`},
+		{fmt.Errorf("restocking: %w", errTeaOwnerMissing), http.StatusNotFound, `{"error":"This owner doesn't own this tea","code":"not_found"}`},
+		{errors.New("database is locked"), http.StatusInternalServerError, `{"error":"Internal server error","code":"internal_error"}`},
+	}
+
+	for _, test := range tests {
+		rr := httptest.NewRecorder()
+		respondWithError(rr, test.err)
+
+		if rr.Code != test.status {
+			t.Errorf("Unexpected status for error %q:\n got: %d\n wanted: %d\n", test.err, rr.Code, test.status)
+		}
+		if actual := rr.Body.String(); actual != test.body {
+			t.Errorf("Unexpected body for error %q:\n got: %s\n wanted: %s\n", test.err, actual, test.body)
+		}
+	}
+}
+
+func TestErrorKinds(t *testing.T) {
+	err := fmt.Errorf("deleting tea: %w", errTeaInUse)
+	if !errors.Is(err, ErrConflict) {
+		t.Errorf("Wrapped error %q is not a conflict\n", err)
+	}
+	if errors.Is(err, ErrNotFound) {
+		t.Errorf("Wrapped error %q is unexpectedly not found\n", err)
+	}
+}
@@ -1,12 +1,15 @@
 package main
 
 import (
-	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"golang.org/x/crypto/bcrypt"
@@ -18,23 +21,71 @@ type UserLogin struct {
 	Password string `json:"password"`
 }
 
+// A RegisterRequest stores the username and password for a new user, along with an optional invite to a household.
+// Users without an invite get a household of their own, which can be given a name.
+type RegisterRequest struct {
+	UserLogin
+	Invite    string `json:"invite"`
+	Household string `json:"household"`
+}
+
 // A NewPasswordRequest stores the old and new password for a reset request.
 type NewPasswordRequest struct {
 	OldPassword string `json:"old"`
 	NewPassword string `json:"new"`
 }
 
-// A TeaType gives the ID and name for a type of tea.
+// Caffeine levels that can be given to a tea or tea type.
+const (
+	CaffeineNone   = "none"
+	CaffeineLow    = "low"
+	CaffeineMedium = "medium"
+	CaffeineHigh   = "high"
+)
+
+// A BrewingGuide details how to brew a tea. Any details that aren't known are left empty.
+type BrewingGuide struct {
+	BrewTemperature int     `json:"brewTemperature,omitempty"` // In degrees Celsius
+	SteepSeconds    int     `json:"steepSeconds,omitempty"`
+	LeafGrams       float64 `json:"leafGrams,omitempty"`
+	Caffeine        string  `json:"caffeine,omitempty"`
+}
+
+// A TeaType gives the ID and name for a type of tea, along with the default brewing guide for teas of that type.
 type TeaType struct {
 	ID   int    `json:"id"`
 	Name string `json:"name"`
+	BrewingGuide
 }
 
 // A Tea details a tea within the system, with an ID, name and type of the tea.
+// Any details missing from the brewing guide are inherited from the tea's type.
 type Tea struct {
-	ID      int     `json:"id"`
-	Name    string  `json:"name"`
-	TeaType TeaType `json:"type"`
+	ID            int     `json:"id"`
+	Name          string  `json:"name"`
+	TeaType       TeaType `json:"type"`
+	AverageRating float64 `json:"averageRating,omitempty"`
+	BrewingGuide
+	Notes string `json:"notes,omitempty"`
+}
+
+// Brewing gives the brewing guide for a tea, using the defaults of its type for any missing details.
+func (tea Tea) Brewing() BrewingGuide {
+	guide := tea.BrewingGuide
+	defaults := tea.TeaType.BrewingGuide
+	if guide.BrewTemperature == 0 {
+		guide.BrewTemperature = defaults.BrewTemperature
+	}
+	if guide.SteepSeconds == 0 {
+		guide.SteepSeconds = defaults.SteepSeconds
+	}
+	if guide.LeafGrams == 0 {
+		guide.LeafGrams = defaults.LeafGrams
+	}
+	if guide.Caffeine == "" {
+		guide.Caffeine = defaults.Caffeine
+	}
+	return guide
 }
 
 // An Owner is someone who has some tea that is in the system.
@@ -43,10 +94,35 @@ type Owner struct {
 	Name string `json:"name"`
 }
 
+// Units that an owner's stock of a tea can be measured in.
+const (
+	UnitBags  = "bags"
+	UnitGrams = "grams"
+)
+
+// A Stock details how much of a tea an owner has left. Stock is only tracked for owners who have given a quantity.
+type Stock struct {
+	Quantity *float64 `json:"quantity,omitempty"`
+	Unit     string   `json:"unit,omitempty"`
+}
+
+// A TeaOwner is an owner of a tea, along with how much of the tea they have left.
+type TeaOwner struct {
+	Owner
+	Stock
+}
+
+// A Rating is an owner's opinion of a tea, from 1 to 5 stars. Owners can also ask never to have a tea picked for them.
+type Rating struct {
+	Owner     Owner `json:"owner"`
+	Rating    int   `json:"rating,omitempty"`
+	NeverPick bool  `json:"neverPick"`
+}
+
 // A TeaWithOwners details a relationship between a tea Owner and a Tea.
 type TeaWithOwners struct {
-	Tea    Tea     `json:"tea"`
-	Owners []Owner `json:"owners"`
+	Tea    Tea        `json:"tea"`
+	Owners []TeaOwner `json:"owners"`
 }
 
 // A TypeWithTeas details all the teas of a single type.
@@ -61,8 +137,79 @@ type OwnerWithTeas struct {
 	Teas  []Tea `json:"teas"`
 }
 
-func respondWithError(w http.ResponseWriter, code int, message string) {
-	respondWithJSON(w, code, map[string]string{"error": message})
+// A Profile is the logged in user, along with the owner they are linked to and the owner's teas.
+type Profile struct {
+	User
+	Owner *Owner `json:"owner"`
+	Teas  []Tea  `json:"teas"`
+}
+
+// A Selection is a randomly chosen tea, along with the number of teas it was chosen from.
+type Selection struct {
+	Tea        Tea          `json:"tea"`
+	Brewing    BrewingGuide `json:"brewing"`
+	Candidates int          `json:"candidates"`
+	Strategy   string       `json:"strategy"`
+}
+
+// SelectionOptions details which teas can be chosen from when selecting a tea.
+type SelectionOptions struct {
+	OwnerIDs     []int     // Only teas owned by all of these owners can be chosen
+	ExcludePicks int       // Exclude teas chosen in this many of the most recent selections
+	ExcludeSince time.Time // Exclude teas chosen at or after this time
+	Caffeine     []string  // Only teas with one of these caffeine levels can be chosen
+}
+
+// A SelectionRecord is an entry in the selection history.
+type SelectionRecord struct {
+	ID         int       `json:"id"`
+	Tea        Tea       `json:"tea"`
+	Owners     []Owner   `json:"owners"`
+	SelectedAt time.Time `json:"selectedAt"`
+}
+
+// A SelectionHistory is a page of the selection history.
+type SelectionHistory struct {
+	Selections []SelectionRecord `json:"selections"`
+	Total      int               `json:"total"`
+	Limit      int               `json:"limit"`
+	Offset     int               `json:"offset"`
+}
+
+// validateBrewingGuide checks the details of a brewing guide are sensible.
+func validateBrewingGuide(guide BrewingGuide) error {
+	if guide.BrewTemperature < 0 || guide.BrewTemperature > 100 {
+		return newError(ErrValidation, "Brew temperature must be between 0 and 100")
+	}
+	if guide.SteepSeconds < 0 {
+		return newError(ErrValidation, "Steep time must not be negative")
+	}
+	if guide.LeafGrams < 0 {
+		return newError(ErrValidation, "Leaf quantity must not be negative")
+	}
+	if guide.Caffeine != "" && !isCaffeineLevel(guide.Caffeine) {
+		return newError(ErrValidation, "Caffeine must be one of none, low, medium or high")
+	}
+	return nil
+}
+
+func isCaffeineLevel(level string) bool {
+	switch level {
+	case CaffeineNone, CaffeineLow, CaffeineMedium, CaffeineHigh:
+		return true
+	}
+	return false
+}
+
+// validateStock checks the quantity and unit of some stock are sensible.
+func validateStock(stock Stock) error {
+	if stock.Quantity != nil && *stock.Quantity < 0 {
+		return newError(ErrValidation, "Quantity must not be negative")
+	}
+	if stock.Unit != "" && stock.Unit != UnitBags && stock.Unit != UnitGrams {
+		return newError(ErrValidation, "Unit must be either bags or grams")
+	}
+	return nil
 }
 
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
@@ -73,113 +220,213 @@ func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	w.Write(response)
 }
 
-func loginHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) loginHandler(w http.ResponseWriter, r *http.Request) {
 	log.Println(`Received request "POST /login"`)
 
 	var userLogin UserLogin
 	decoder := json.NewDecoder(r.Body)
 	if err := decoder.Decode(&userLogin); err != nil {
 		log.Println("Failed to extract username and password")
-		respondWithError(w, http.StatusBadRequest, "Bad request body")
+		respondWithError(w, newError(ErrBadRequest, "Bad request body"))
 		return
 	}
 	defer r.Body.Close()
 
 	userLogin.Username = strings.ToLower(userLogin.Username)
+	userKey := loginKey{loginKeyUser, userLogin.Username}
+	addressKey := loginKey{loginKeyAddress, clientAddress(r)}
 
-	// Retrieve from DB
-	storedPassword, err := GetPasswordFromDatabase(userLogin.Username)
-	if err != nil {
+	if wait := s.logins.lockedFor(userKey, addressKey); wait > 0 {
+		log.Printf("Refused login for %q from %s, which is locked out for %v\n", userLogin.Username, addressKey.value, wait)
+		w.Header().Set("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+		respondWithError(w, newError(ErrRateLimited, "Too many failed logins, please try again later"))
+		return
+	}
+
+	// Retrieve from DB. A missing user is compared against a dummy hash, so that it takes as long as a wrong password.
+	storedPassword, err := s.store.GetPassword(userLogin.Username)
+	if err != nil && !errors.Is(err, ErrNotFound) {
 		log.Printf("Failed to get password from database for user %q\n", userLogin.Username)
-		respondWithError(w, http.StatusBadRequest, "User doesn't exist")
+		respondWithError(w, err)
 		return
 	}
+	userExists := err == nil
+	if !userExists {
+		storedPassword = s.passwords.dummyHash()
+	}
 
-	// Compare hash with sent password.
+	// Compare hash with sent password. The same message is given whether or not the user exists, so that usernames
+	// can't be guessed.
 	storedPasswordBytes := []byte(storedPassword)
 	passwordBytes := []byte(userLogin.Password)
-	if err := bcrypt.CompareHashAndPassword(storedPasswordBytes, passwordBytes); err != nil {
-		log.Printf("Password incorrect for user %q\n", userLogin.Username)
-		respondWithError(w, http.StatusBadRequest, "Incorrect password")
+	if err := bcrypt.CompareHashAndPassword(storedPasswordBytes, passwordBytes); err != nil || !userExists {
+		log.Printf("Failed login for user %q from %s\n", userLogin.Username, addressKey.value)
+		s.loginFailed(userLogin.Username, addressKey.value)
+		respondWithError(w, newError(ErrUnauthorized, "Incorrect username or password"))
+		return
+	}
+	s.logins.succeed(userKey)
+	s.rehashPassword(userLogin.Username, storedPassword, userLogin.Password)
+
+	user, err := s.store.GetUser(userLogin.Username)
+	if err != nil {
+		log.Printf("Failed to get role of user %q: %v\n", userLogin.Username, err)
+		respondWithError(w, err)
 		return
 	}
 
-	validToken, err := GenerateJWT(userLogin.Username)
+	session, err := s.startSession(user)
 	if err != nil {
 		log.Printf("Error generating token: %v\n", err)
-		respondWithError(w, http.StatusInternalServerError, "Error generating token")
+		respondWithError(w, newError(ErrInternal, "Error generating token"))
 		return
 	}
 
 	log.Printf("Successfully logged in %q\n", userLogin.Username)
-	respondWithJSON(w, http.StatusOK, map[string]string{"token": validToken})
+	respondWithJSON(w, http.StatusOK, session)
+}
+
+// rehashPassword hashes a user's password again if its hash has a lower cost than is configured, now that the password
+// is known. The login still succeeds if it fails, as the old hash can be replaced next time.
+func (s *Server) rehashPassword(username string, storedPassword string, password string) {
+	if !s.passwords.needsRehash(storedPassword) {
+		return
+	}
+	hash, err := s.passwords.hash(password)
+	if err == nil {
+		err = s.store.ChangePassword(username, hash)
+	}
+	if err != nil {
+		log.Printf("Failed to rehash password of user %q: %v\n", username, err)
+		return
+	}
+	log.Printf("Rehashed password of user %q with cost %d\n", username, s.passwords.cost)
+}
+
+// loginFailed records a failed login for the username and address, adding an entry to the audit log of the user's
+// household if either of them is locked out. Lockouts of usernames that don't exist are only logged.
+func (s *Server) loginFailed(username string, address string) {
+	lockable := []struct {
+		key   loginKey
+		event string
+	}{
+		{loginKey{loginKeyUser, username}, AuditUserLocked},
+		{loginKey{loginKeyAddress, address}, AuditAddressLocked},
+	}
+	for _, l := range lockable {
+		lockout := s.logins.fail(l.key)
+		if lockout == 0 {
+			continue
+		}
+
+		message := fmt.Sprintf("Locked out %s %q for %v after too many failed logins", l.key.kind, l.key.value, lockout)
+		log.Printf("Audit: %s\n", message)
+		user, err := s.store.GetUser(username)
+		if err != nil {
+			continue
+		}
+		entry := AuditEntry{Event: l.event, Username: username, Address: address, Message: message, CreatedAt: time.Now()}
+		if err := s.store.ForHousehold(user.Household).CreateAuditEntry(&entry); err != nil {
+			log.Printf("Failed to add lockout to audit log: %v\n", err)
+		}
+	}
 }
 
-func registerHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) registerHandler(w http.ResponseWriter, r *http.Request) {
 	log.Println(`Received request "POST /register"`)
 
-	var userLogin UserLogin
+	var request RegisterRequest
 	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&userLogin); err != nil {
+	if err := decoder.Decode(&request); err != nil {
 		log.Println(`Failed to extract username and password`)
-		respondWithError(w, http.StatusBadRequest, "Bad request body")
+		respondWithError(w, newError(ErrBadRequest, "Bad request body"))
 		return
 	}
 	defer r.Body.Close()
 
+	userLogin := request.UserLogin
 	userLogin.Username = strings.ToLower(userLogin.Username)
-	passwordBytes := []byte(userLogin.Password)
+	if err := s.passwords.check(userLogin.Password); err != nil {
+		log.Printf("Password of user %q doesn't meet the policy: %v\n", userLogin.Username, err)
+		respondWithError(w, err)
+		return
+	}
 
-	hash, err := bcrypt.GenerateFromPassword(passwordBytes, bcrypt.MinCost)
+	hash, err := s.passwords.hash(userLogin.Password)
 	if err != nil {
 		log.Println(`Error hashing password`)
-		respondWithError(w, http.StatusInternalServerError, "Unable to create user")
+		respondWithError(w, newError(ErrInternal, "Unable to create user"))
 		return
 	}
 
-	userLogin.Password = string(hash)
-	if err := CreateUserInDatabase(userLogin); err != nil {
+	userLogin.Password = hash
+	user, err := s.createUser(userLogin, request)
+	if err != nil {
 		log.Printf("Error creating user: %v\n", err)
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+		respondWithError(w, err)
 		return
 	}
 
-	validToken, err := GenerateJWT(userLogin.Username)
+	session, err := s.startSession(user)
 	if err != nil {
 		log.Printf("Error generating token: %v\n", err)
-		respondWithError(w, http.StatusInternalServerError, "Error generating token")
+		respondWithError(w, newError(ErrInternal, "Error generating token"))
 		return
 	}
 
-	log.Printf("Successfully registered and logged in %q\n", userLogin.Username)
-	respondWithJSON(w, http.StatusOK, map[string]string{"token": validToken})
+	log.Printf("Successfully registered and logged in %q to household %d\n", user.Username, user.Household)
+	respondWithJSON(w, http.StatusOK, session)
+}
+
+// createUser adds a registering user to a household. Users with an invite join its household as a member. The first
+// user is the admin of the default household, so that someone can manage it, and anyone else gets a household of their
+// own, which they are the admin of.
+func (s *Server) createUser(userLogin UserLogin, request RegisterRequest) (User, error) {
+	if request.Invite != "" {
+		invite, err := s.store.GetInvite(request.Invite)
+		if err != nil {
+			return User{}, err
+		}
+		user := User{Username: userLogin.Username, Role: RoleMember, Household: invite.Household}
+		return user, s.store.ForHousehold(invite.Household).CreateUser(userLogin, user.Role)
+	}
+
+	// The very first user takes over the default household, and everyone after them gets their own
+	if created, err := s.store.CreateFirstUser(userLogin); err != nil {
+		return User{}, err
+	} else if created {
+		return User{Username: userLogin.Username, Role: RoleAdmin, Household: DefaultHousehold}, nil
+	}
+
+	household := Household{Name: request.Household}
+	if household.Name == "" {
+		household.Name = defaultHouseholdName(userLogin.Username)
+	}
+	if err := s.store.CreateHousehold(&household, userLogin); err != nil {
+		return User{}, err
+	}
+	return User{Username: userLogin.Username, Role: RoleAdmin, Household: household.ID}, nil
 }
 
-func changePasswordHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) changePasswordHandler(w http.ResponseWriter, r *http.Request) {
 	log.Println(`Received request "POST /changepassword"`)
 
 	var newPasswordBody NewPasswordRequest
 	decoder := json.NewDecoder(r.Body)
 	if err := decoder.Decode(&newPasswordBody); err != nil {
 		log.Println("Failed to extract old and new password")
-		respondWithError(w, http.StatusBadRequest, "Bad request body")
+		respondWithError(w, newError(ErrBadRequest, "Bad request body"))
 		return
 	}
 	defer r.Body.Close()
 
-	// Get the username of the JWT
-	username, err := GetJWTUser(r.Header["Token"][0])
-	if err != nil {
-		log.Println("Unable to change password")
-		respondWithError(w, http.StatusInternalServerError, "Error changing user password")
-		return
-	}
+	username := authorizationFor(r).username
 
 	// Retrieve old password from DB
-	storedPassword, err := GetPasswordFromDatabase(username)
+	storedPassword, err := s.storeFor(r).GetPassword(username)
 	if err != nil {
 		log.Printf("Failed to get password from database for user %q\n", username)
-		respondWithError(w, http.StatusBadRequest, "User doesn't exist")
+		respondWithError(w, err)
 		return
 	}
 
@@ -188,356 +435,1285 @@ func changePasswordHandler(w http.ResponseWriter, r *http.Request) {
 	passwordBytes := []byte(newPasswordBody.OldPassword)
 	if err := bcrypt.CompareHashAndPassword(storedPasswordBytes, passwordBytes); err != nil {
 		log.Printf("Password incorrect for user %q\n", username)
-		respondWithError(w, http.StatusBadRequest, "Incorrect password")
+		respondWithError(w, newError(ErrForbidden, "Incorrect password"))
+		return
+	}
+
+	if err := s.passwords.check(newPasswordBody.NewPassword); err != nil {
+		log.Printf("New password of user %q doesn't meet the policy: %v\n", username, err)
+		respondWithError(w, err)
+		return
+	}
+	if err := s.checkPasswordReused(username, storedPassword, newPasswordBody.NewPassword); err != nil {
+		log.Printf("User %q tried to reuse a password: %v\n", username, err)
+		respondWithError(w, err)
 		return
 	}
 
 	// Hash new password
-	newPasswordBytes := []byte(newPasswordBody.NewPassword)
-	hash, err := bcrypt.GenerateFromPassword(newPasswordBytes, bcrypt.MinCost)
+	newPassword, err := s.passwords.hash(newPasswordBody.NewPassword)
 	if err != nil {
 		log.Println(`Error hashing password`)
-		respondWithError(w, http.StatusInternalServerError, "Unable to create user")
+		respondWithError(w, newError(ErrInternal, "Unable to create user"))
 		return
 	}
-	newPassword := string(hash)
 
-	if err := ChangePasswordInDatabase(username, newPassword); err != nil {
-		log.Printf("Error changing password: %v\n", err)
-		respondWithError(w, http.StatusInternalServerError, "Error changing user password")
+	// Anyone who knew the old password may have logged in with it, so every session has to log in again
+	if err := s.store.ReplacePassword(username, newPassword, storedPassword, s.passwords.history-1); err != nil {
+		log.Printf("Error changing password of user %q: %v\n", username, err)
+		respondWithError(w, err)
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
-}
-
-// GetAllTeaTypesFunc points to the function to get all tea typevalues. Useful for mocking
-var GetAllTeaTypesFunc = GetAllTeaTypesFromDatabase
-
-func getAllTeaTypesHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println(`Received request "GET /types"`)
-
-	types, err := GetAllTeaTypesFunc()
+	// The user who changed it is given a new session, so they stay logged in
+	user, err := s.store.GetUser(username)
 	if err != nil {
-		log.Printf("Error retrieving all tea types: %v\n", err)
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+		log.Printf("Failed to get user %q after changing their password: %v\n", username, err)
+		respondWithError(w, err)
 		return
 	}
-	log.Println("Successfully handled request to see all tea types")
-	respondWithJSON(w, http.StatusOK, types)
-}
-
-// GetTeaTypeFunc points to the function to get information about a tea type. Useful for mocking.
-var GetTeaTypeFunc = GetTeaTypeFromDatabase
-
-func getTeaTypeHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id, err := strconv.Atoi(vars["id"])
+	session, err := s.startSession(user)
 	if err != nil {
-		log.Printf("Failed to get tea type with ID: %d\n Error: %v\n", id, err)
-		respondWithError(w, http.StatusBadRequest, "Invalid Tea Type ID")
+		log.Printf("Failed to start a new session for user %q: %v\n", username, err)
+		respondWithError(w, err)
 		return
 	}
-	log.Printf("Received request \"GET /type/%d\"\n", id)
 
-	teaType := TeaType{ID: id}
+	log.Printf("Changed password of user %q\n", username)
+	respondWithJSON(w, http.StatusOK, session)
+}
 
-	if err := GetTeaTypeFunc(&teaType); err != nil {
-		if err == sql.ErrNoRows {
-			log.Printf("Failed to get tea type as ID didn't exist. ID: %d\n", id)
-			respondWithError(w, http.StatusInternalServerError, "ID does not exist in database")
-			return
+// checkPasswordReused checks a new password isn't the same as any of the user's most recent passwords, including the
+// current one, if the policy doesn't allow them to be reused.
+func (s *Server) checkPasswordReused(username string, storedPassword string, password string) error {
+	if s.passwords.history <= 0 {
+		return nil
+	}
+	history, err := s.store.GetPasswordHistory(username)
+	if err != nil {
+		return err
+	}
+	recent := append([]string{storedPassword}, history...)
+	if len(recent) > s.passwords.history {
+		recent = recent[:s.passwords.history]
+	}
+	for _, hash := range recent {
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil {
+			return newError(ErrValidation, "Password can't be the same as any of your last %d passwords", s.passwords.history)
 		}
-		log.Printf("Failed to get tea type with id: %d\n Error: %v\n", id, err)
-		respondWithError(w, http.StatusInternalServerError, err.Error())
-		return
 	}
-
-	log.Printf("Got tea with type with ID: %d\n", id)
-	respondWithJSON(w, http.StatusOK, teaType)
+	return nil
 }
 
-// CreateTeaTypeFunc points to the function to create a new type of tea in the database. Useful for mocking.
-var CreateTeaTypeFunc = CreateTeaTypeInDatabase
-
-func createTeaTypeHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println(`Received request "POST /type"`)
+func (s *Server) refreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println(`Received request "POST /token/refresh"`)
 
-	var teaType TeaType
+	var request RefreshRequest
 	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&teaType); err != nil {
-		log.Printf("Failed to create new tea type: %s\n", teaType.Name)
-		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+	if err := decoder.Decode(&request); err != nil {
+		log.Println("Failed to extract refresh token")
+		respondWithError(w, newError(ErrBadRequest, "Bad request body"))
 		return
 	}
 	defer r.Body.Close()
 
-	if err := CreateTeaTypeFunc(&teaType); err != nil {
-		log.Printf("Error creating tea type: %s\n\t Error: %s\n", teaType.Name, err)
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+	token, err := s.store.UseRefreshToken(hashToken(request.RefreshToken))
+	if err != nil {
+		log.Printf("Failed to use refresh token: %v\n", err)
+		if errors.Is(err, ErrNotFound) {
+			err = newError(ErrUnauthorized, "Refresh token is invalid or has expired")
+		}
+		respondWithError(w, err)
 		return
 	}
 
-	log.Printf("Created new tea type. ID: %d, Name: %s\n", teaType.ID, teaType.Name)
-	respondWithJSON(w, http.StatusCreated, teaType)
+	// The user's role or household may have changed since they logged in
+	user, err := s.store.GetUser(token.Username)
+	if err != nil {
+		log.Printf("Failed to get user %q: %v\n", token.Username, err)
+		if errors.Is(err, ErrNotFound) {
+			err = newError(ErrUnauthorized, "User doesn't exist")
+		}
+		respondWithError(w, err)
+		return
+	}
+
+	session, err := s.startSession(user)
+	if err != nil {
+		log.Printf("Error generating token: %v\n", err)
+		respondWithError(w, newError(ErrInternal, "Error generating token"))
+		return
+	}
+
+	log.Printf("Refreshed session of %q\n", user.Username)
+	respondWithJSON(w, http.StatusOK, session)
 }
 
-// DeleteTeaTypeFunc points to the function to delete a type of tea in the database. Useful for mocking.
-var DeleteTeaTypeFunc = DeleteTeaTypeInDatabase
+func (s *Server) oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println(`Received request "GET /auth/oidc/login"`)
 
-func deleteTeaTypeHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id, err := strconv.Atoi(vars["id"])
+	loginURL, state, err := s.oidc.loginURL(r.Context())
 	if err != nil {
-		log.Printf("Failed to delete tea type with ID: %d\n Error: %v\n", id, err)
-		respondWithError(w, http.StatusBadRequest, "Invalid Tea Type ID")
+		log.Printf("Failed to start login with identity provider: %v\n", err)
+		respondWithError(w, newError(ErrInternal, "Unable to log in with the identity provider"))
 		return
 	}
-	log.Printf("Received request \"DELETE /type/%d\"\n", id)
 
-	teaType := TeaType{ID: id}
+	http.SetCookie(w, &http.Cookie{
+		Name:     OIDCStateCookie,
+		Value:    state,
+		Path:     "/auth/oidc",
+		MaxAge:   int(OIDCLoginLifetime / time.Second),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, loginURL, http.StatusFound)
+}
 
-	if err := DeleteTeaTypeFunc(&teaType); err != nil {
-		if err.Error() == "sql: Rows are closed" {
-			log.Printf("Failed to delete tea type as ID didn't exist. ID: %d\n", id)
-			respondWithError(w, http.StatusInternalServerError, "ID does not exist in database")
+// oidcCallbackHandler finishes a login with the identity provider, logging in the user linked to the subject the
+// provider knows them by. If no user is linked yet, one is created if registration is enabled.
+func (s *Server) oidcCallbackHandler(registerEnabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Println(`Received request "GET /auth/oidc/callback"`)
+
+		query := r.URL.Query()
+		if query.Get("error") != "" {
+			log.Printf("Identity provider refused login: %s\n", query.Get("error"))
+			respondWithError(w, newError(ErrUnauthorized, "Identity provider refused the login: %s", query.Get("error")))
 			return
 		}
-		log.Printf("Failed to delete tea type with ID: %d\n Error: %v\n", id, err)
-		respondWithError(w, http.StatusInternalServerError, err.Error())
-		return
-	}
+		state := query.Get("state")
+		if cookie, err := r.Cookie(OIDCStateCookie); err != nil || state == "" || cookie.Value != state {
+			log.Println("OIDC callback state doesn't match the cookie")
+			respondWithError(w, newError(ErrUnauthorized, "Login was started somewhere else, please try again"))
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: OIDCStateCookie, Path: "/auth/oidc", MaxAge: -1})
 
-	log.Printf("Delete tea type with ID: %d\n", id)
-	respondWithJSON(w, http.StatusOK, map[string]string{"name": teaType.Name, "result": "success"})
-}
+		claims, err := s.oidc.finishLogin(r.Context(), state, query.Get("code"))
+		if err != nil {
+			log.Printf("Failed to finish login with identity provider: %v\n", err)
+			respondWithError(w, err)
+			return
+		}
 
-// GetAllOwnersFunc points to a function to get all owners in the database. Useful for mocking.
-var GetAllOwnersFunc = GetAllOwnersFromDatabase
+		user, err := s.store.GetIdentityUser(s.oidc.cfg.Issuer, claims.Subject)
+		if errors.Is(err, ErrNotFound) {
+			if !registerEnabled {
+				log.Printf("No user is linked to subject %q, and registration is disabled\n", claims.Subject)
+				respondWithError(w, newError(ErrForbidden, "No user is linked to this login, and registration is disabled"))
+				return
+			}
+			user, err = s.createOIDCUser(claims)
+		}
+		if err != nil {
+			log.Printf("Failed to get user linked to subject %q: %v\n", claims.Subject, err)
+			respondWithError(w, err)
+			return
+		}
 
-func getAllOwnersHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println(`Received request "GET /owners"`)
+		session, err := s.startSession(user)
+		if err != nil {
+			log.Printf("Error generating token: %v\n", err)
+			respondWithError(w, newError(ErrInternal, "Error generating token"))
+			return
+		}
 
-	owners, err := GetAllOwnersFunc()
-	if err != nil {
-		log.Printf("Error retrieving all owners: %v\n", err)
-		respondWithError(w, http.StatusInternalServerError, err.Error())
-		return
+		log.Printf("Successfully logged in %q with identity provider\n", user.Username)
+		respondWithJSON(w, http.StatusOK, session)
 	}
-	log.Println("Successfully handled request to see all owners")
-	respondWithJSON(w, http.StatusOK, owners)
 }
 
-// GetOwnerFunc points to a function to get information about an owner from the database. Useful for mocking
-var GetOwnerFunc = GetOwnerFromDatabase
+// createOIDCUser creates a user for someone logging in with the identity provider for the first time, in a household
+// in the same way as registering. The user has no password, so can only log in with the provider.
+func (s *Server) createOIDCUser(claims oidcClaims) (User, error) {
+	username := claims.username()
+	if username == "" {
+		return User{}, newError(ErrValidation, "Identity provider didn't give a username or email address")
+	}
 
-func getOwnerHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id, err := strconv.Atoi(vars["id"])
+	household := Household{Name: defaultHouseholdName(username)}
+	user, err := s.store.CreateIdentityUser(Identity{Issuer: s.oidc.cfg.Issuer, Subject: claims.Subject, Username: username}, &household)
 	if err != nil {
-		log.Printf("Failed to get owner with ID: %d\n Error: %v\n", id, err)
-		respondWithError(w, http.StatusBadRequest, "Invalid owner ID")
-		return
+		return User{}, err
 	}
-	log.Printf("Received request \"GET /owner/%d\"\n", id)
+	log.Printf("Created user %q for subject %q of identity provider\n", username, claims.Subject)
+	return user, nil
+}
 
-	owner := Owner{ID: id}
+func (s *Server) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println(`Received request "POST /logout"`)
 
-	if err := GetOwnerFunc(&owner); err != nil {
-		if err == sql.ErrNoRows {
-			log.Printf("Failed to get owner as ID didn't exist. ID: %d\n", id)
-			respondWithError(w, http.StatusInternalServerError, "ID does not exist in database")
-			return
-		}
-		log.Printf("Failed to get owner with id: %d\n Error: %v\n", id, err)
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+	auth := authorizationFor(r)
+	if err := s.store.RevokeSession(auth.tokenID, auth.expiresAt); err != nil {
+		log.Printf("Failed to revoke token %q: %v\n", auth.tokenID, err)
+		respondWithError(w, err)
 		return
 	}
 
-	log.Printf("Got owner with ID: %d\n", id)
-	respondWithJSON(w, http.StatusOK, owner)
+	log.Printf("Logged out %q\n", auth.username)
+	respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
 }
 
-// CreateOwnerFunc points to a function that creates an owner in the database. Useful for mocking.
-var CreateOwnerFunc = CreateOwnerInDatabase
+func (s *Server) getAllUsersHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println(`Received request "GET /users"`)
 
-func createOwnerHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println(`Received request "POST /owner"`)
+	users, err := s.storeFor(r).GetAllUsers()
+	if err != nil {
+		log.Printf("Error retrieving all users: %v\n", err)
+		respondWithError(w, err)
+		return
+	}
+	log.Println("Successfully handled request to see all users")
+	respondWithJSON(w, http.StatusOK, users)
+}
 
-	var owner Owner
+func (s *Server) updateUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	username := strings.ToLower(mux.Vars(r)["username"])
+	log.Printf("Received request \"PUT /user/%s/role\"\n", username)
+
+	var request RoleRequest
 	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&owner); err != nil {
-		log.Printf("Failed to create new owner: %s\n", owner.Name)
-		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+	if err := decoder.Decode(&request); err != nil {
+		log.Printf("Failed to change role of user %q\n", username)
+		respondWithError(w, newError(ErrBadRequest, "Invalid request payload"))
 		return
 	}
 	defer r.Body.Close()
 
-	if err := CreateOwnerFunc(&owner); err != nil {
-		log.Printf("Error creating owner: %s\n\t Error: %s\n", owner.Name, err)
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+	if err := validateRole(request.Role); err != nil {
+		log.Printf("Invalid role for user %q: %q\n", username, request.Role)
+		respondWithError(w, err)
 		return
 	}
 
-	log.Printf("Created new owner. ID: %d, Name: %s\n", owner.ID, owner.Name)
-	respondWithJSON(w, http.StatusCreated, owner)
-}
-
-// DeleteOwnerFunc points to a function to delete an owner from the database. Useful for mocking.
-var DeleteOwnerFunc = DeleteOwnerFromDatabase
-
-func deleteOwnerHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id, err := strconv.Atoi(vars["id"])
+	users, err := s.storeFor(r).GetAllUsers()
 	if err != nil {
-		log.Printf("Failed to delete owner with ID: %d\n Error: %v\n", id, err)
-		respondWithError(w, http.StatusBadRequest, "Invalid owner ID")
+		log.Printf("Error retrieving users: %v\n", err)
+		respondWithError(w, err)
+		return
+	}
+	if request.Role != RoleAdmin && isLastAdmin(users, username) {
+		log.Printf("Refused to remove the last admin, %q\n", username)
+		respondWithError(w, newError(ErrConflict, "There must always be at least one admin"))
 		return
 	}
-	log.Printf("Received request \"DELETE /owner/%d\"\n", id)
-
-	owner := Owner{ID: id}
 
-	if err := DeleteOwnerFunc(&owner); err != nil {
-		if err.Error() == "sql: Rows are closed" {
-			log.Printf("Failed to delete owner as ID didn't exist. ID: %d\n", id)
-			respondWithError(w, http.StatusInternalServerError, "ID does not exist in database")
-			return
-		}
-		log.Printf("Failed to delete owner with ID: %d\n Error: %v\n", id, err)
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+	if err := s.storeFor(r).SetUserRole(username, request.Role); err != nil {
+		log.Printf("Failed to change role of user %q. Error: %v\n", username, err)
+		respondWithError(w, err)
 		return
 	}
 
-	log.Printf("Deleted owner with ID: %d\n", id)
-	respondWithJSON(w, http.StatusOK, map[string]string{"name": owner.Name, "result": "success"})
+	log.Printf("Changed role of user %q to %q\n", username, request.Role)
+	respondWithJSON(w, http.StatusOK, User{Username: username, Role: request.Role})
 }
 
-// GetAllTeasFunc points to a function to get all teas in the database. Useful for mocking.
-var GetAllTeasFunc = GetAllTeasFromDatabase
+func (s *Server) updateUserOwnerHandler(w http.ResponseWriter, r *http.Request) {
+	username := strings.ToLower(mux.Vars(r)["username"])
+	log.Printf("Received request \"PUT /user/%s/owner\"\n", username)
 
-func getAllTeasHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println(`Received request "GET /teas"`)
-
-	teas, err := GetAllTeasFunc()
-	if err != nil {
-		log.Printf("Error retrieving all teas: %v\n", err)
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+	var request LinkOwnerRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&request); err != nil {
+		log.Printf("Failed to link user %q to an owner\n", username)
+		respondWithError(w, newError(ErrBadRequest, "Invalid request payload"))
 		return
 	}
-	log.Println("Successfully handled request to see all teas")
-	respondWithJSON(w, http.StatusOK, teas)
-}
+	defer r.Body.Close()
 
-// GetTeaFunc points to a function to get information about a tea from the database. Useful for mocking.
-var GetTeaFunc = GetTeaFromDatabase
+	if err := s.storeFor(r).SetUserOwner(username, request.OwnerID); err != nil {
+		log.Printf("Failed to link user %q to owner %d. Error: %v\n", username, request.OwnerID, err)
+		respondWithError(w, err)
+		return
+	}
 
-func getTeaHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id, err := strconv.Atoi(vars["id"])
+	user, err := s.storeFor(r).GetUser(username)
 	if err != nil {
-		log.Printf("Failed to get tea with ID: %d\n Error: %v\n", id, err)
-		respondWithError(w, http.StatusBadRequest, "Invalid tea ID")
+		log.Printf("Failed to get user %q. Error: %v\n", username, err)
+		respondWithError(w, err)
 		return
 	}
-	log.Printf("Received request \"GET /tea/%d\"\n", id)
 
-	tea := Tea{ID: id}
+	log.Printf("Linked user %q to owner %d\n", username, request.OwnerID)
+	respondWithJSON(w, http.StatusOK, user)
+}
 
-	if err := GetTeaFunc(&tea); err != nil {
-		if err == sql.ErrNoRows {
-			log.Printf("Failed to get tea as ID didn't exist. ID: %d\n", id)
-			respondWithError(w, http.StatusInternalServerError, "ID does not exist in database")
-			return
-		}
-		log.Printf("Failed to get tea with id: %d\n Error: %v\n", id, err)
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+func (s *Server) getAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println(`Received request "GET /me/keys"`)
+
+	username := authorizationFor(r).username
+	keys, err := s.store.GetAPIKeys(username)
+	if err != nil {
+		log.Printf("Error retrieving API keys of user %q: %v\n", username, err)
+		respondWithError(w, err)
 		return
 	}
 
-	log.Printf("Got tea with ID: %d\n", id)
-	respondWithJSON(w, http.StatusOK, tea)
+	log.Println("Successfully handled request to see API keys")
+	respondWithJSON(w, http.StatusOK, keys)
 }
 
-// CreateTeaFunc points to a function to create a tea in the database. Useful for mocking.
-var CreateTeaFunc = CreateTeaInDatabase
+func (s *Server) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println(`Received request "POST /me/keys"`)
 
-func createTeaHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println(`Received request "POST /tea"`)
-
-	var tea Tea
+	var request APIKeyRequest
 	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&tea); err != nil {
-		log.Printf("Failed to create new tea: %s\n", tea.Name)
-		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+	if err := decoder.Decode(&request); err != nil {
+		log.Println("Failed to extract API key")
+		respondWithError(w, newError(ErrBadRequest, "Invalid request payload"))
 		return
 	}
 	defer r.Body.Close()
 
-	if err := CreateTeaFunc(&tea); err != nil {
-		log.Printf("Error creating tea: %s\n\t Error: %s\n", tea.Name, err)
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+	auth := authorizationFor(r)
+	if err := validateAPIKeyRequest(request, auth.role); err != nil {
+		log.Printf("Invalid API key for user %q: %v\n", auth.username, err)
+		respondWithError(w, err)
 		return
 	}
 
-	log.Printf("Created new tea. ID: %d, Name: %q, Type: %q\n", tea.ID, tea.Name, tea.TeaType.Name)
-	respondWithJSON(w, http.StatusCreated, tea)
-}
+	key, err := newAPIKey(auth.username, request)
+	if err != nil {
+		log.Printf("Error generating API key: %v\n", err)
+		respondWithError(w, newError(ErrInternal, "Error generating API key"))
+		return
+	}
+	if err := s.store.CreateAPIKey(&key.APIKey); err != nil {
+		log.Printf("Failed to create API key for user %q. Error: %v\n", auth.username, err)
+		respondWithError(w, err)
+		return
+	}
 
-// DeleteTeaFunc points to a function to delete a tea from the database. Useful for mocking.
-var DeleteTeaFunc = DeleteTeaFromDatabase
+	log.Printf("Created API key %d for user %q\n", key.ID, auth.username)
+	respondWithJSON(w, http.StatusCreated, key)
+}
 
-func deleteTeaHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id, err := strconv.Atoi(vars["id"])
+func (s *Server) deleteAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
 	if err != nil {
-		log.Printf("Failed to delete tea with ID: %d\n Error: %v\n", id, err)
-		respondWithError(w, http.StatusBadRequest, "Invalid tea ID")
+		log.Printf("Failed to delete API key with ID: %d\n Error: %v\n", id, err)
+		respondWithError(w, newError(ErrBadRequest, "Invalid API key ID"))
 		return
 	}
-	log.Printf("Received request \"DELETE /tea/%d\"\n", id)
+	log.Printf("Received request \"DELETE /me/key/%d\"\n", id)
 
-	tea := Tea{ID: id}
-
-	if err := DeleteTeaFunc(&tea); err != nil {
-		if err.Error() == "sql: Rows are closed" {
-			log.Printf("Failed to delete tea as ID didn't exist. ID: %d\n", id)
-			respondWithError(w, http.StatusInternalServerError, "ID does not exist in database")
-			return
-		}
-		log.Printf("Failed to delete tea with ID: %d\n Error: %v\n", id, err)
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+	username := authorizationFor(r).username
+	if err := s.store.DeleteAPIKey(username, id); err != nil {
+		log.Printf("Failed to delete API key %d of user %q. Error: %v\n", id, username, err)
+		respondWithError(w, err)
 		return
 	}
 
-	log.Printf("Deleted tea with ID: %d\n", id)
-	respondWithJSON(w, http.StatusOK, map[string]string{"name": tea.Name, "result": "success"})
+	log.Printf("Deleted API key %d of user %q\n", id, username)
+	respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
 }
 
-// GetTeaOwnersFunc points to a function to get owners of a tea from the database. Useful for mocking.
-var GetTeaOwnersFunc = GetTeaOwnersFromDatabase
+// errNoLinkedOwner is returned when a user who isn't linked to an owner tries to change their own teas.
+var errNoLinkedOwner = newError(ErrConflict, "Your account isn't linked to an owner")
 
-func getTeaOwnersHandler(w http.ResponseWriter, r *http.Request) {
+// linkedOwnerID gives the ID of the owner linked to the user making a request.
+func (s *Server) linkedOwnerID(r *http.Request) (int, error) {
+	user, err := s.storeFor(r).GetUser(authorizationFor(r).username)
+	if err != nil {
+		return 0, err
+	}
+	if user.OwnerID == 0 {
+		return 0, errNoLinkedOwner
+	}
+	return user.OwnerID, nil
+}
+
+// errOtherOwner is returned when a user linked to an owner tries to change the teas of another owner.
+var errOtherOwner = newError(ErrForbidden, "You can only change the teas of the owner you're linked to")
+
+// ownerCheck gives a function that checks whether the user making a request can change the teas of an owner. Admins can
+// change any owner's teas, but other users can only change those of the owner they're linked to. The user is only got
+// once, so the function can be used while the store is in a transaction.
+func (s *Server) ownerCheck(r *http.Request) (func(ownerID int) error, error) {
+	auth := authorizationFor(r)
+	if auth.role == RoleAdmin {
+		return func(int) error { return nil }, nil
+	}
+	user, err := s.storeFor(r).GetUser(auth.username)
+	if err != nil {
+		return nil, err
+	}
+	return func(ownerID int) error {
+		if user.OwnerID == 0 {
+			return errNoLinkedOwner
+		}
+		if user.OwnerID != ownerID {
+			return errOtherOwner
+		}
+		return nil
+	}, nil
+}
+
+// checkOwner makes sure the user making a request can change the teas of an owner, as for ownerCheck.
+func (s *Server) checkOwner(r *http.Request, ownerID int) error {
+	check, err := s.ownerCheck(r)
+	if err != nil {
+		return err
+	}
+	return check(ownerID)
+}
+
+func (s *Server) getProfileHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println(`Received request "GET /me"`)
+
+	username := authorizationFor(r).username
+
+	user, err := s.storeFor(r).GetUser(username)
+	if err != nil {
+		log.Printf("Failed to get user %q. Error: %v\n", username, err)
+		respondWithError(w, err)
+		return
+	}
+
+	profile := Profile{User: user, Teas: make([]Tea, 0)}
+	if user.OwnerID != 0 {
+		ownersWithTeas, err := s.storeFor(r).GetAllOwnersTeas()
+		if err != nil {
+			log.Printf("Error retrieving teas for owner %d: %v\n", user.OwnerID, err)
+			respondWithError(w, err)
+			return
+		}
+
+		owner := Owner{ID: user.OwnerID}
+		if err := s.storeFor(r).GetOwner(&owner); err != nil {
+			log.Printf("Failed to get owner %d. Error: %v\n", user.OwnerID, err)
+			respondWithError(w, err)
+			return
+		}
+		profile.Owner = &owner
+
+		for _, ownerWithTeas := range ownersWithTeas {
+			if ownerWithTeas.Owner.ID == owner.ID {
+				profile.Teas = ownerWithTeas.Teas
+			}
+		}
+	}
+
+	log.Printf("Successfully handled request to see profile of %q\n", username)
+	respondWithJSON(w, http.StatusOK, profile)
+}
+
+func (s *Server) addMyTeaHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		log.Printf("Failed to add tea with ID: %d\n Error: %v\n", id, err)
+		respondWithError(w, newError(ErrBadRequest, "Invalid tea ID"))
+		return
+	}
+	log.Printf("Received request \"POST /me/tea/%d\"\n", id)
+
+	// The body is optional, as stock doesn't have to be tracked
+	var stock Stock
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&stock); err != nil && err != io.EOF {
+		log.Printf("Failed to add tea with ID: %d\n", id)
+		respondWithError(w, newError(ErrBadRequest, "Invalid request payload"))
+		return
+	}
+	defer r.Body.Close()
+
+	if err := validateStock(stock); err != nil {
+		log.Printf("Invalid stock for tea with ID: %d\n Error: %v\n", id, err)
+		respondWithError(w, err)
+		return
+	}
+	if stock.Quantity != nil && stock.Unit == "" {
+		stock.Unit = UnitBags
+	}
+
+	ownerID, err := s.linkedOwnerID(r)
+	if err != nil {
+		log.Printf("Failed to find owner to add tea with ID %d to. Error: %v\n", id, err)
+		respondWithError(w, err)
+		return
+	}
+
+	tea, err := s.storeFor(r).CreateTeaOwner(id, &Owner{ID: ownerID}, stock)
+	if err != nil {
+		log.Printf("Error adding tea with ID %d for owner %d\n\t Error: %s\n", id, ownerID, err)
+		respondWithError(w, err)
+		return
+	}
+
+	log.Printf("Added tea to collection. teaID: %d, ownerID: %d\n", id, ownerID)
+	respondWithJSON(w, http.StatusCreated, tea)
+}
+
+func (s *Server) removeMyTeaHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		log.Printf("Failed to remove tea with ID: %d\n Error: %v\n", id, err)
+		respondWithError(w, newError(ErrBadRequest, "Invalid tea ID"))
+		return
+	}
+	log.Printf("Received request \"DELETE /me/tea/%d\"\n", id)
+
+	ownerID, err := s.linkedOwnerID(r)
+	if err != nil {
+		log.Printf("Failed to find owner to remove tea with ID %d from. Error: %v\n", id, err)
+		respondWithError(w, err)
+		return
+	}
+
+	if err := s.storeFor(r).DeleteTeaOwner(&Tea{ID: id}, &Owner{ID: ownerID}); err != nil {
+		log.Printf("Failed to remove tea from collection. Error: %v\n", err)
+		respondWithError(w, err)
+		return
+	}
+
+	log.Printf("Removed tea from collection. teaID: %d \t ownerID: %d\n", id, ownerID)
+	respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
+}
+
+func (s *Server) consumeMyTeaHandler(w http.ResponseWriter, r *http.Request) {
+	s.changeTeaStock(w, r, "consume", s.linkedOwnerID, consumeTeaStock)
+}
+
+func (s *Server) restockMyTeaHandler(w http.ResponseWriter, r *http.Request) {
+	s.changeTeaStock(w, r, "restock", s.linkedOwnerID, Store.RestockTea)
+}
+
+// isLastAdmin checks whether a user is the only admin.
+func isLastAdmin(users []User, username string) bool {
+	admins := 0
+	isAdmin := false
+	for _, user := range users {
+		if user.Role == RoleAdmin {
+			admins++
+			isAdmin = isAdmin || user.Username == username
+		}
+	}
+	return isAdmin && admins == 1
+}
+
+func (s *Server) getHouseholdHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println(`Received request "GET /household"`)
+
+	household, err := s.storeFor(r).GetHousehold()
+	if err != nil {
+		log.Printf("Error retrieving household: %v\n", err)
+		respondWithError(w, err)
+		return
+	}
+	log.Printf("Successfully handled request to see household %d\n", household.ID)
+	respondWithJSON(w, http.StatusOK, household)
+}
+
+func (s *Server) joinHouseholdHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println(`Received request "POST /household/join"`)
+
+	var request JoinRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&request); err != nil {
+		log.Println("Failed to extract invite code")
+		respondWithError(w, newError(ErrBadRequest, "Invalid request payload"))
+		return
+	}
+	defer r.Body.Close()
+
+	username := authorizationFor(r).username
+
+	invite, err := s.store.GetInvite(request.Code)
+	if err != nil {
+		log.Printf("Failed to get invite for user %q. Error: %v\n", username, err)
+		respondWithError(w, err)
+		return
+	}
+
+	user, err := s.store.GetUser(username)
+	if err != nil {
+		log.Printf("Failed to get user %q. Error: %v\n", username, err)
+		respondWithError(w, err)
+		return
+	}
+	if user.Household == invite.Household {
+		respondWithError(w, newError(ErrConflict, "You're already in this household"))
+		return
+	}
+
+	// A household that is being left by its last admin must have no one left to manage
+	users, err := s.store.ForHousehold(user.Household).GetAllUsers()
+	if err != nil {
+		log.Printf("Error retrieving users: %v\n", err)
+		respondWithError(w, err)
+		return
+	}
+	if len(users) > 1 && isLastAdmin(users, username) {
+		log.Printf("Refused to let the last admin, %q, leave household %d\n", username, user.Household)
+		respondWithError(w, newError(ErrConflict, "Make someone else an admin before leaving the household"))
+		return
+	}
+
+	if err := s.store.ForHousehold(invite.Household).JoinHousehold(username, RoleMember); err != nil {
+		log.Printf("Failed to move user %q to household %d. Error: %v\n", username, invite.Household, err)
+		respondWithError(w, err)
+		return
+	}
+
+	// Tokens for the old household stop working, so the user's other devices have to log in again
+	if err := s.store.RevokeUserSessions(username); err != nil {
+		log.Printf("Failed to revoke sessions of user %q. Error: %v\n", username, err)
+		respondWithError(w, err)
+		return
+	}
+	session, err := s.startSession(User{Username: username, Role: RoleMember, Household: invite.Household})
+	if err != nil {
+		log.Printf("Error generating token: %v\n", err)
+		respondWithError(w, newError(ErrInternal, "Error generating token"))
+		return
+	}
+
+	log.Printf("Moved user %q to household %d\n", username, invite.Household)
+	respondWithJSON(w, http.StatusOK, session)
+}
+
+func (s *Server) getInvitesHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println(`Received request "GET /household/invites"`)
+
+	invites, err := s.storeFor(r).GetInvites()
+	if err != nil {
+		log.Printf("Error retrieving invites: %v\n", err)
+		respondWithError(w, err)
+		return
+	}
+	log.Println("Successfully handled request to see all invites")
+	respondWithJSON(w, http.StatusOK, invites)
+}
+
+func (s *Server) createInviteHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println(`Received request "POST /household/invite"`)
+
+	invite, err := newInvite()
+	if err != nil {
+		log.Printf("Error generating invite code: %v\n", err)
+		respondWithError(w, newError(ErrInternal, "Unable to create invite"))
+		return
+	}
+	if err := s.storeFor(r).CreateInvite(&invite); err != nil {
+		log.Printf("Error creating invite: %v\n", err)
+		respondWithError(w, err)
+		return
+	}
+
+	log.Printf("Created invite to household %d\n", invite.Household)
+	respondWithJSON(w, http.StatusCreated, invite)
+}
+
+func (s *Server) deleteInviteHandler(w http.ResponseWriter, r *http.Request) {
+	code := mux.Vars(r)["code"]
+	log.Println(`Received request "DELETE /household/invite/{code}"`)
+
+	if err := s.storeFor(r).DeleteInvite(code); err != nil {
+		log.Printf("Failed to delete invite. Error: %v\n", err)
+		respondWithError(w, err)
+		return
+	}
+
+	log.Println("Deleted invite")
+	respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
+}
+
+func (s *Server) getAllTeaTypesHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println(`Received request "GET /types"`)
+
+	options, err := parseListOptions(r, false)
+	if err != nil {
+		log.Printf("Invalid options for list of tea types: %v\n", err)
+		respondWithError(w, err)
+		return
+	}
+
+	types, err := s.storeFor(r).GetAllTeaTypes(pageLimit(options))
+	if err != nil {
+		log.Printf("Error retrieving all tea types: %v\n", err)
+		respondWithError(w, err)
+		return
+	}
+	if hasNextPage(options, len(types)) {
+		types = types[:options.Limit]
+		last := types[len(types)-1]
+		setNextPage(w, r, options.cursor(last.ID, last.Name))
+	}
+	log.Println("Successfully handled request to see all tea types")
+	respondWithJSON(w, http.StatusOK, types)
+}
+
+func (s *Server) getTeaTypeHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		log.Printf("Failed to get tea type with ID: %d\n Error: %v\n", id, err)
+		respondWithError(w, newError(ErrBadRequest, "Invalid Tea Type ID"))
+		return
+	}
+	log.Printf("Received request \"GET /type/%d\"\n", id)
+
+	teaType := TeaType{ID: id}
+
+	if err := s.storeFor(r).GetTeaType(&teaType); err != nil {
+		log.Printf("Failed to get tea type with id: %d\n Error: %v\n", id, err)
+		respondWithError(w, err)
+		return
+	}
+
+	log.Printf("Got tea with type with ID: %d\n", id)
+	respondWithJSON(w, http.StatusOK, teaType)
+}
+
+func (s *Server) createTeaTypeHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println(`Received request "POST /type"`)
+
+	var teaType TeaType
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&teaType); err != nil {
+		log.Printf("Failed to create new tea type: %s\n", teaType.Name)
+		respondWithError(w, newError(ErrBadRequest, "Invalid request payload"))
+		return
+	}
+	defer r.Body.Close()
+
+	if err := validateBrewingGuide(teaType.BrewingGuide); err != nil {
+		log.Printf("Invalid brewing guide for new tea type: %s\n\t Error: %s\n", teaType.Name, err)
+		respondWithError(w, err)
+		return
+	}
+
+	if err := s.storeFor(r).CreateTeaType(&teaType); err != nil {
+		log.Printf("Error creating tea type: %s\n\t Error: %s\n", teaType.Name, err)
+		respondWithError(w, err)
+		return
+	}
+
+	log.Printf("Created new tea type. ID: %d, Name: %s\n", teaType.ID, teaType.Name)
+	respondWithJSON(w, http.StatusCreated, teaType)
+}
+
+func (s *Server) updateTeaTypeHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		log.Printf("Failed to update tea type with ID: %d\n Error: %v\n", id, err)
+		respondWithError(w, newError(ErrBadRequest, "Invalid Tea Type ID"))
+		return
+	}
+	log.Printf("Received request \"PUT /type/%d\"\n", id)
+
+	var teaType TeaType
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&teaType); err != nil {
+		log.Printf("Failed to update tea type with ID: %d\n", id)
+		respondWithError(w, newError(ErrBadRequest, "Invalid request payload"))
+		return
+	}
+	defer r.Body.Close()
+	teaType.ID = id
+
+	if err := validateBrewingGuide(teaType.BrewingGuide); err != nil {
+		log.Printf("Invalid brewing guide for tea type with ID: %d\n Error: %v\n", id, err)
+		respondWithError(w, err)
+		return
+	}
+
+	if err := s.storeFor(r).UpdateTeaType(&teaType); err != nil {
+		log.Printf("Failed to update tea type with ID: %d\n Error: %v\n", id, err)
+		respondWithError(w, err)
+		return
+	}
+
+	log.Printf("Updated tea type. ID: %d, Name: %s\n", teaType.ID, teaType.Name)
+	respondWithJSON(w, http.StatusOK, teaType)
+}
+
+func (s *Server) deleteTeaTypeHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		log.Printf("Failed to delete tea type with ID: %d\n Error: %v\n", id, err)
+		respondWithError(w, newError(ErrBadRequest, "Invalid Tea Type ID"))
+		return
+	}
+	log.Printf("Received request \"DELETE /type/%d\"\n", id)
+
+	teaType := TeaType{ID: id}
+
+	if err := s.storeFor(r).DeleteTeaType(&teaType); err != nil {
+		log.Printf("Failed to delete tea type with ID: %d\n Error: %v\n", id, err)
+		respondWithError(w, err)
+		return
+	}
+
+	log.Printf("Delete tea type with ID: %d\n", id)
+	respondWithJSON(w, http.StatusOK, map[string]string{"name": teaType.Name, "result": "success"})
+}
+
+func (s *Server) getAllOwnersHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println(`Received request "GET /owners"`)
+
+	options, err := parseListOptions(r, false)
+	if err != nil {
+		log.Printf("Invalid options for list of owners: %v\n", err)
+		respondWithError(w, err)
+		return
+	}
+
+	owners, err := s.storeFor(r).GetAllOwners(pageLimit(options))
+	if err != nil {
+		log.Printf("Error retrieving all owners: %v\n", err)
+		respondWithError(w, err)
+		return
+	}
+	if hasNextPage(options, len(owners)) {
+		owners = owners[:options.Limit]
+		last := owners[len(owners)-1]
+		setNextPage(w, r, options.cursor(last.ID, last.Name))
+	}
+	log.Println("Successfully handled request to see all owners")
+	respondWithJSON(w, http.StatusOK, owners)
+}
+
+func (s *Server) getOwnerHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		log.Printf("Failed to get owner with ID: %d\n Error: %v\n", id, err)
+		respondWithError(w, newError(ErrBadRequest, "Invalid owner ID"))
+		return
+	}
+	log.Printf("Received request \"GET /owner/%d\"\n", id)
+
+	owner := Owner{ID: id}
+
+	if err := s.storeFor(r).GetOwner(&owner); err != nil {
+		log.Printf("Failed to get owner with id: %d\n Error: %v\n", id, err)
+		respondWithError(w, err)
+		return
+	}
+
+	log.Printf("Got owner with ID: %d\n", id)
+	respondWithJSON(w, http.StatusOK, owner)
+}
+
+func (s *Server) createOwnerHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println(`Received request "POST /owner"`)
+
+	var owner Owner
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&owner); err != nil {
+		log.Printf("Failed to create new owner: %s\n", owner.Name)
+		respondWithError(w, newError(ErrBadRequest, "Invalid request payload"))
+		return
+	}
+	defer r.Body.Close()
+
+	if err := s.storeFor(r).CreateOwner(&owner); err != nil {
+		log.Printf("Error creating owner: %s\n\t Error: %s\n", owner.Name, err)
+		respondWithError(w, err)
+		return
+	}
+
+	log.Printf("Created new owner. ID: %d, Name: %s\n", owner.ID, owner.Name)
+	respondWithJSON(w, http.StatusCreated, owner)
+}
+
+func (s *Server) updateOwnerHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		log.Printf("Failed to update owner with ID: %d\n Error: %v\n", id, err)
+		respondWithError(w, newError(ErrBadRequest, "Invalid owner ID"))
+		return
+	}
+	log.Printf("Received request \"PUT /owner/%d\"\n", id)
+
+	var owner Owner
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&owner); err != nil {
+		log.Printf("Failed to update owner with ID: %d\n", id)
+		respondWithError(w, newError(ErrBadRequest, "Invalid request payload"))
+		return
+	}
+	defer r.Body.Close()
+	owner.ID = id
+
+	if err := s.storeFor(r).UpdateOwner(&owner); err != nil {
+		log.Printf("Failed to update owner with ID: %d\n Error: %v\n", id, err)
+		respondWithError(w, err)
+		return
+	}
+
+	log.Printf("Updated owner. ID: %d, Name: %s\n", owner.ID, owner.Name)
+	respondWithJSON(w, http.StatusOK, owner)
+}
+
+func (s *Server) deleteOwnerHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		log.Printf("Failed to delete owner with ID: %d\n Error: %v\n", id, err)
+		respondWithError(w, newError(ErrBadRequest, "Invalid owner ID"))
+		return
+	}
+	log.Printf("Received request \"DELETE /owner/%d\"\n", id)
+
+	owner := Owner{ID: id}
+
+	if err := s.storeFor(r).DeleteOwner(&owner); err != nil {
+		log.Printf("Failed to delete owner with ID: %d\n Error: %v\n", id, err)
+		respondWithError(w, err)
+		return
+	}
+
+	log.Printf("Deleted owner with ID: %d\n", id)
+	respondWithJSON(w, http.StatusOK, map[string]string{"name": owner.Name, "result": "success"})
+}
+
+func (s *Server) getAllTeasHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println(`Received request "GET /teas"`)
+
+	options, err := parseListOptions(r, true)
+	if err != nil {
+		log.Printf("Invalid options for list of teas: %v\n", err)
+		respondWithError(w, err)
+		return
+	}
+
+	teas, err := s.storeFor(r).GetAllTeas(pageLimit(options))
+	if err != nil {
+		log.Printf("Error retrieving all teas: %v\n", err)
+		respondWithError(w, err)
+		return
+	}
+	if hasNextPage(options, len(teas)) {
+		teas = teas[:options.Limit]
+		last := teas[len(teas)-1]
+		setNextPage(w, r, options.cursor(last.ID, last.Name))
+	}
+	log.Println("Successfully handled request to see all teas")
+	respondWithJSON(w, http.StatusOK, teas)
+}
+
+func (s *Server) searchTeasHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println(`Received request "GET /search"`)
+
+	terms := parseSearchTerms(r.URL.Query().Get("q"))
+	if len(terms) == 0 {
+		log.Println("Search has no terms")
+		respondWithError(w, newError(ErrValidation, "Search must have at least one word in it"))
+		return
+	}
+	limit, err := parseQueryInt(r, "limit", DefaultSearchLimit)
+	if err != nil || limit == 0 || limit > MaxSearchLimit {
+		log.Printf("Invalid limit for search: %q\n", r.URL.Query().Get("limit"))
+		respondWithError(w, newError(ErrValidation, "Invalid limit, must be between 1 and %d", MaxSearchLimit))
+		return
+	}
+
+	teas, err := s.storeFor(r).SearchTeas(terms, limit)
+	if err != nil {
+		log.Printf("Error searching teas: %v\n", err)
+		respondWithError(w, err)
+		return
+	}
+	log.Printf("Successfully handled request to search teas, found %d\n", len(teas))
+	respondWithJSON(w, http.StatusOK, teas)
+}
+
+func (s *Server) exportHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println(`Received request "GET /export"`)
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = FormatJSON
+	}
+	if format != FormatJSON && format != FormatCSV {
+		log.Printf("Unknown export format: %q\n", format)
+		respondWithError(w, newError(ErrValidation, "Format must be either json or csv"))
+		return
+	}
+
+	collection, err := exportCollection(s.storeFor(r))
+	if err != nil {
+		log.Printf("Error exporting collection: %v\n", err)
+		respondWithError(w, err)
+		return
+	}
+	log.Printf("Successfully handled request to export the collection as %s\n", format)
+
+	if format == FormatJSON {
+		respondWithJSON(w, http.StatusOK, collection)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="tea-collection.csv"`)
+	w.WriteHeader(http.StatusOK)
+	if err := writeCollectionCSV(w, collection); err != nil {
+		log.Printf("Error writing collection as CSV: %v\n", err)
+	}
+}
+
+func (s *Server) importHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println(`Received request "POST /import"`)
+	defer r.Body.Close()
+
+	query := r.URL.Query()
+	dryRun := false
+	if value := query.Get("dryRun"); value != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(value); err != nil {
+			log.Printf("Invalid dry run for import: %q\n", value)
+			respondWithError(w, newError(ErrValidation, "Invalid dryRun, must be true or false"))
+			return
+		}
+	}
+	// The format can also be given by the content type, as it is by most tools sending CSV
+	format := query.Get("format")
+	if format == "" && strings.HasPrefix(r.Header.Get("Content-Type"), "text/csv") {
+		format = FormatCSV
+	}
+
+	var collection Collection
+	switch format {
+	case FormatJSON, "":
+		if err := json.NewDecoder(r.Body).Decode(&collection); err != nil {
+			log.Printf("Failed to decode collection to import: %v\n", err)
+			respondWithError(w, newError(ErrBadRequest, "Invalid request payload"))
+			return
+		}
+	case FormatCSV:
+		var err error
+		if collection, err = readCollectionCSV(r.Body); err != nil {
+			log.Printf("Failed to read collection to import: %v\n", err)
+			respondWithError(w, err)
+			return
+		}
+	default:
+		log.Printf("Unknown import format: %q\n", format)
+		respondWithError(w, newError(ErrValidation, "Format must be either json or csv"))
+		return
+	}
+
+	checkOwner, err := s.ownerCheck(r)
+	if err != nil {
+		log.Printf("Failed to find which owners can be imported: %v\n", err)
+		respondWithError(w, err)
+		return
+	}
+
+	report, err := s.storeFor(r).ImportCollection(collection, dryRun, checkOwner)
+	if err != nil {
+		log.Printf("Error importing collection: %v\n", err)
+		respondWithError(w, err)
+		return
+	}
+	log.Printf("Successfully handled request to import a collection. Dry run: %t, created: %d, updated: %d, skipped: %d, failed: %d\n",
+		dryRun, report.Created, report.Updated, report.Skipped, report.Failed)
+	respondWithJSON(w, http.StatusOK, report)
+}
+
+func (s *Server) getTeaHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		log.Printf("Failed to get tea with ID: %d\n Error: %v\n", id, err)
+		respondWithError(w, newError(ErrBadRequest, "Invalid tea ID"))
+		return
+	}
+	log.Printf("Received request \"GET /tea/%d\"\n", id)
+
+	tea := Tea{ID: id}
+
+	if err := s.storeFor(r).GetTea(&tea); err != nil {
+		log.Printf("Failed to get tea with id: %d\n Error: %v\n", id, err)
+		respondWithError(w, err)
+		return
+	}
+
+	log.Printf("Got tea with ID: %d\n", id)
+	respondWithJSON(w, http.StatusOK, tea)
+}
+
+func (s *Server) createTeaHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println(`Received request "POST /tea"`)
+
+	createType := false
+	if value := r.URL.Query().Get("createType"); value != "" {
+		var err error
+		if createType, err = strconv.ParseBool(value); err != nil {
+			log.Printf("Invalid createType for new tea: %q\n", value)
+			respondWithError(w, newError(ErrValidation, "Invalid createType, must be true or false"))
+			return
+		}
+	}
+
+	var newTea NewTea
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&newTea); err != nil {
+		log.Printf("Failed to create new tea: %s\n", newTea.Name)
+		respondWithError(w, newError(ErrBadRequest, "Invalid request payload"))
+		return
+	}
+	defer r.Body.Close()
+	tea := newTea.Tea
+
+	if err := validateBrewingGuide(tea.BrewingGuide); err != nil {
+		log.Printf("Invalid brewing guide for new tea: %s\n\t Error: %s\n", tea.Name, err)
+		respondWithError(w, err)
+		return
+	}
+
+	typeByName := tea.TeaType.ID == 0 && tea.TeaType.Name != ""
+	if newTea.Owners == nil && !typeByName {
+		if err := s.storeFor(r).CreateTea(&tea); err != nil {
+			log.Printf("Error creating tea: %s\n\t Error: %s\n", tea.Name, err)
+			respondWithError(w, err)
+			return
+		}
+
+		log.Printf("Created new tea. ID: %d, Name: %q, Type: %q\n", tea.ID, tea.Name, tea.TeaType.Name)
+		respondWithJSON(w, http.StatusCreated, tea)
+		return
+	}
+
+	if createType {
+		if err := validateBrewingGuide(tea.TeaType.BrewingGuide); err != nil {
+			log.Printf("Invalid brewing guide for type of new tea: %s\n\t Error: %s\n", tea.Name, err)
+			respondWithError(w, err)
+			return
+		}
+	}
+	owners := make([]TeaOwner, len(newTea.Owners))
+	for i, owner := range newTea.Owners {
+		if err := validateStock(owner.Stock); err != nil {
+			log.Printf("Invalid stock for owner of new tea: %s\n\t Error: %s\n", tea.Name, err)
+			respondWithError(w, err)
+			return
+		}
+		if owner.Quantity != nil && owner.Unit == "" {
+			owner.Unit = UnitBags
+		}
+		owners[i] = owner.TeaOwner
+	}
+
+	// Owners can be given by name, so they have to be found before checking the user can add teas to them
+	if err := findOwners(s.storeFor(r), owners); err != nil {
+		log.Printf("Invalid owners of new tea: %s\n\t Error: %s\n", tea.Name, err)
+		respondWithError(w, err)
+		return
+	}
+	for _, owner := range owners {
+		if err := s.checkOwner(r, owner.ID); err != nil {
+			log.Printf("Not allowed to add new tea %s to owner %d. Error: %v\n", tea.Name, owner.ID, err)
+			respondWithError(w, err)
+			return
+		}
+	}
+
+	created, err := s.storeFor(r).CreateTeaWithOwners(&tea, owners, createType)
+	if err != nil {
+		log.Printf("Error creating tea: %s\n\t Error: %s\n", tea.Name, err)
+		respondWithError(w, err)
+		return
+	}
+
+	log.Printf("Created new tea. ID: %d, Name: %q, Type: %q, Owners: %d\n", tea.ID, tea.Name, tea.TeaType.Name, len(created.Owners))
+	if newTea.Owners == nil {
+		respondWithJSON(w, http.StatusCreated, created.Tea)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, created)
+}
+
+func (s *Server) updateTeaHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		log.Printf("Failed to update tea with ID: %d\n Error: %v\n", id, err)
+		respondWithError(w, newError(ErrBadRequest, "Invalid tea ID"))
+		return
+	}
+	log.Printf("Received request \"%s /tea/%d\"\n", r.Method, id)
+
+	tea := Tea{ID: id}
+
+	// A PATCH only changes the fields given, so start from the tea as it currently is.
+	if r.Method == http.MethodPatch {
+		if err := s.storeFor(r).GetTea(&tea); err != nil {
+			log.Printf("Failed to get tea with ID: %d\n Error: %v\n", id, err)
+			respondWithError(w, err)
+			return
+		}
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&tea); err != nil {
+		log.Printf("Failed to update tea with ID: %d\n", id)
+		respondWithError(w, newError(ErrBadRequest, "Invalid request payload"))
+		return
+	}
+	defer r.Body.Close()
+	tea.ID = id
+
+	if err := validateBrewingGuide(tea.BrewingGuide); err != nil {
+		log.Printf("Invalid brewing guide for tea with ID: %d\n Error: %v\n", id, err)
+		respondWithError(w, err)
+		return
+	}
+
+	if err := s.storeFor(r).UpdateTea(&tea); err != nil {
+		log.Printf("Failed to update tea with ID: %d\n Error: %v\n", id, err)
+		respondWithError(w, err)
+		return
+	}
+
+	log.Printf("Updated tea. ID: %d, Name: %q, Type: %q\n", tea.ID, tea.Name, tea.TeaType.Name)
+	respondWithJSON(w, http.StatusOK, tea)
+}
+
+func (s *Server) deleteTeaHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		log.Printf("Failed to delete tea with ID: %d\n Error: %v\n", id, err)
+		respondWithError(w, newError(ErrBadRequest, "Invalid tea ID"))
+		return
+	}
+	log.Printf("Received request \"DELETE /tea/%d\"\n", id)
+
+	tea := Tea{ID: id}
+
+	if err := s.storeFor(r).DeleteTea(&tea); err != nil {
+		log.Printf("Failed to delete tea with ID: %d\n Error: %v\n", id, err)
+		respondWithError(w, err)
+		return
+	}
+
+	log.Printf("Deleted tea with ID: %d\n", id)
+	respondWithJSON(w, http.StatusOK, map[string]string{"name": tea.Name, "result": "success"})
+}
+
+func (s *Server) getTeaOwnersHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
 		log.Printf("Failed to get owners of tea with ID: %d\n Error: %v\n", id, err)
-		respondWithError(w, http.StatusBadRequest, "Invalid tea ID")
+		respondWithError(w, newError(ErrBadRequest, "Invalid tea ID"))
 		return
 	}
 	log.Printf("Received request \"GET /tea/%d/owners\"\n", id)
 
 	tea := Tea{ID: id}
 
-	owners, err := GetTeaOwnersFunc(&tea)
+	owners, err := s.storeFor(r).GetTeaOwners(&tea)
 	if err != nil {
-		if err.Error() == "sql: Rows are closed" {
-			log.Printf("Failed to get tea owners as tea ID didn't exist. ID: %d\n", id)
-			respondWithError(w, http.StatusInternalServerError, "ID does not exist in database")
-			return
-		}
 		log.Printf("Failed to get tea owners with tea ID: %d\n Error: %v\n", id, err)
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+		respondWithError(w, err)
 		return
 	}
 
@@ -545,48 +1721,69 @@ func getTeaOwnersHandler(w http.ResponseWriter, r *http.Request) {
 	respondWithJSON(w, http.StatusOK, owners)
 }
 
-// GetAllTeaOwnersFunc points to a function to get a list of teas and their owners. Useful for mocking.
-var GetAllTeaOwnersFunc = GetAllTeaOwnersFromDatabase
-
-func getAllTeaOwnersHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) getAllTeaOwnersHandler(w http.ResponseWriter, r *http.Request) {
 	log.Println(`Received request "GET /tea/owners"`)
 
-	teasWithOwners, err := GetAllTeaOwnersFunc()
+	options, err := parseListOptions(r, true)
+	if err != nil {
+		log.Printf("Invalid options for list of teas with owners: %v\n", err)
+		respondWithError(w, err)
+		return
+	}
+
+	teasWithOwners, err := s.storeFor(r).GetAllTeaOwners(pageLimit(options))
 	if err != nil {
 		log.Printf("Error retrieving all teas with owners: %v\n", err)
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+		respondWithError(w, err)
 		return
 	}
+	if hasNextPage(options, len(teasWithOwners)) {
+		teasWithOwners = teasWithOwners[:options.Limit]
+		last := teasWithOwners[len(teasWithOwners)-1]
+		setNextPage(w, r, options.cursor(last.Tea.ID, last.Tea.Name))
+	}
 	log.Println("Successfully handled request to see all teas with owners")
 	respondWithJSON(w, http.StatusOK, teasWithOwners)
 }
 
-// CreateTeaOwnerFunc points to a function to add an owner to a tea in the database. Useful for mocking.
-var CreateTeaOwnerFunc = CreateTeaOwnerInDatabase
-
-func createTeaOwnerHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) createTeaOwnerHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
 		log.Printf("Failed to get owners of tea with ID: %d\n Error: %v\n", id, err)
-		respondWithError(w, http.StatusBadRequest, "Invalid tea ID")
+		respondWithError(w, newError(ErrBadRequest, "Invalid tea ID"))
 		return
 	}
 	log.Printf("Received request \"POST /tea/%d/owner\n\"", id)
 
-	var owner Owner
+	var owner TeaOwner
 	decoder := json.NewDecoder(r.Body)
 	if err := decoder.Decode(&owner); err != nil {
 		log.Printf("Failed to create new owner of tea with ID: %d\n", id)
-		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		respondWithError(w, newError(ErrBadRequest, "Invalid request payload"))
 		return
 	}
 	defer r.Body.Close()
 
-	tea, err := CreateTeaOwnerFunc(id, &owner)
+	if err := validateStock(owner.Stock); err != nil {
+		log.Printf("Invalid stock for owner of tea with ID: %d\n Error: %v\n", id, err)
+		respondWithError(w, err)
+		return
+	}
+	if owner.Quantity != nil && owner.Unit == "" {
+		owner.Unit = UnitBags
+	}
+
+	if err := s.checkOwner(r, owner.ID); err != nil {
+		log.Printf("Not allowed to add owner %d to tea %d. Error: %v\n", owner.ID, id, err)
+		respondWithError(w, err)
+		return
+	}
+
+	tea, err := s.storeFor(r).CreateTeaOwner(id, &owner.Owner, owner.Stock)
 	if err != nil {
 		log.Printf("Error creating owner for tea with ID: %d\n\t Error: %s\n", id, err)
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+		respondWithError(w, err)
 		return
 	}
 
@@ -594,36 +1791,34 @@ func createTeaOwnerHandler(w http.ResponseWriter, r *http.Request) {
 	respondWithJSON(w, http.StatusCreated, tea)
 }
 
-// DeleteTeaOwnerFunc points to a function to delete an owner from a tea in the database. Useful for mocking.
-var DeleteTeaOwnerFunc = DeleteTeaOwnerFromDatabase
-
-func deleteTeaOwnerHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) deleteTeaOwnerHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	teaID, err := strconv.Atoi(vars["teaID"])
 	if err != nil {
 		log.Printf("Failed to delete owner of tea with teaID: %d\n Error: %v\n", teaID, err)
-		respondWithError(w, http.StatusBadRequest, "Invalid tea ID")
+		respondWithError(w, newError(ErrBadRequest, "Invalid tea ID"))
 		return
 	}
 	ownerID, err := strconv.Atoi(vars["ownerID"])
 	if err != nil {
 		log.Printf("Failed to delete owner of tea with ownerID: %d\n Error: %v\n", ownerID, err)
-		respondWithError(w, http.StatusBadRequest, "Invalid owner ID")
+		respondWithError(w, newError(ErrBadRequest, "Invalid owner ID"))
 		return
 	}
 	log.Printf("Received request \"DELETE /tea/%d/owner/%d\"\n", teaID, ownerID)
 
+	if err := s.checkOwner(r, ownerID); err != nil {
+		log.Printf("Not allowed to delete owner %d of tea %d. Error: %v\n", ownerID, teaID, err)
+		respondWithError(w, err)
+		return
+	}
+
 	tea := Tea{ID: teaID}
 	owner := Owner{ID: ownerID}
 
-	if err := DeleteTeaOwnerFunc(&tea, &owner); err != nil {
-		if err.Error() == "sql: Rows are closed" {
-			log.Println("Failed to delete tea owner as relationship doesn't exist")
-			respondWithError(w, http.StatusInternalServerError, "Relationship does not exist in database")
-			return
-		}
+	if err := s.storeFor(r).DeleteTeaOwner(&tea, &owner); err != nil {
 		log.Printf("Failed to delete tea owner. Error: %v\n", err)
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+		respondWithError(w, err)
 		return
 	}
 
@@ -631,34 +1826,481 @@ func deleteTeaOwnerHandler(w http.ResponseWriter, r *http.Request) {
 	respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
 }
 
-// GetAllTypesTeasFunc Func gets all teas by type from the database. Useful for mocking.
-var GetAllTypesTeasFunc = GetAllTypesTeasFromDatabase
+func (s *Server) consumeTeaStockHandler(w http.ResponseWriter, r *http.Request) {
+	s.changeTeaStock(w, r, "consume", s.pathOwnerID, consumeTeaStock)
+}
+
+func (s *Server) restockTeaHandler(w http.ResponseWriter, r *http.Request) {
+	s.changeTeaStock(w, r, "restock", s.pathOwnerID, Store.RestockTea)
+}
+
+// consumeTeaStock takes the quantity of a stock change from an owner's stock of a tea.
+func consumeTeaStock(store Store, teaID int, ownerID int, change Stock) (Stock, error) {
+	return store.ConsumeTeaStock(teaID, ownerID, *change.Quantity)
+}
+
+// pathOwnerID gives the owner ID from the path of a request, if the user making it can change that owner's teas.
+func (s *Server) pathOwnerID(r *http.Request) (int, error) {
+	ownerID, err := strconv.Atoi(mux.Vars(r)["ownerID"])
+	if err != nil {
+		return 0, newError(ErrBadRequest, "Invalid owner ID")
+	}
+	if err := s.checkOwner(r, ownerID); err != nil {
+		return 0, err
+	}
+	return ownerID, nil
+}
+
+// changeTeaStock handles a request to change an owner's stock of a tea by the quantity given in the request body.
+// The owner is found using ownerIDFunc, and the change is made to the store of the user's household.
+func (s *Server) changeTeaStock(w http.ResponseWriter, r *http.Request, action string, ownerIDFunc func(*http.Request) (int, error), changeFunc func(Store, int, int, Stock) (Stock, error)) {
+	vars := mux.Vars(r)
+	teaID, err := strconv.Atoi(vars["teaID"])
+	if err != nil {
+		log.Printf("Failed to %s tea with teaID: %d\n Error: %v\n", action, teaID, err)
+		respondWithError(w, newError(ErrBadRequest, "Invalid tea ID"))
+		return
+	}
+	ownerID, err := ownerIDFunc(r)
+	if err != nil {
+		log.Printf("Failed to %s tea as the owner couldn't be found. Error: %v\n", action, err)
+		respondWithError(w, err)
+		return
+	}
+	log.Printf("Received request \"POST %s\" for owner %d\n", r.URL.Path, ownerID)
+
+	var change Stock
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&change); err != nil {
+		log.Printf("Failed to %s tea with ID: %d\n", action, teaID)
+		respondWithError(w, newError(ErrBadRequest, "Invalid request payload"))
+		return
+	}
+	defer r.Body.Close()
+
+	if change.Quantity == nil || *change.Quantity <= 0 {
+		log.Printf("Failed to %s tea as no quantity was given. teaID: %d \t ownerID: %d\n", action, teaID, ownerID)
+		respondWithError(w, newError(ErrValidation, "Quantity must be greater than zero"))
+		return
+	}
+	if err := validateStock(change); err != nil {
+		log.Printf("Invalid stock change for tea with ID: %d\n Error: %v\n", teaID, err)
+		respondWithError(w, err)
+		return
+	}
+
+	stock, err := changeFunc(s.storeFor(r), teaID, ownerID, change)
+	if err != nil {
+		log.Printf("Failed to %s tea. Error: %v\n", action, err)
+		respondWithError(w, err)
+		return
+	}
+
+	log.Printf("Changed stock of tea. teaID: %d \t ownerID: %d\n", teaID, ownerID)
+	respondWithJSON(w, http.StatusOK, stock)
+}
 
-func getAllTeasTypesHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) getAllTeasTypesHandler(w http.ResponseWriter, r *http.Request) {
 	log.Println(`Received request "GET /types/teas"`)
 
-	typesWithTeas, err := GetAllTypesTeasFunc()
+	typesWithTeas, err := s.storeFor(r).GetAllTypesTeas()
 	if err != nil {
 		log.Printf("Error retrieving all types with teas: %v\n", err)
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+		respondWithError(w, err)
 		return
 	}
 	log.Println("Successfully handled request to see all teas by type")
 	respondWithJSON(w, http.StatusOK, typesWithTeas)
 }
 
-// GetAllOwnersTeasFunc gets a list of owners, and their teas. Useful for mocking
-var GetAllOwnersTeasFunc = GetAllOwnersTeasFromDatabase
-
-func getAllOwnersTeasHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) getAllOwnersTeasHandler(w http.ResponseWriter, r *http.Request) {
 	log.Println(`Receieved request "GET /owners/teas"`)
 
-	ownersWithTeas, err := GetAllOwnersTeasFunc()
+	ownersWithTeas, err := s.storeFor(r).GetAllOwnersTeas()
 	if err != nil {
 		log.Printf("Error retrieving all teas for all owners: %v\n", err)
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+		respondWithError(w, err)
 		return
 	}
 	log.Println("Successfully handled request to see all teas for each owner")
 	respondWithJSON(w, http.StatusOK, ownersWithTeas)
 }
+
+// parseIDList parses a comma separated list of IDs, such as "1,2,3".
+func parseIDList(list string) ([]int, error) {
+	ids := make([]int, 0)
+	if list == "" {
+		return ids, nil
+	}
+
+	for _, value := range strings.Split(list, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// parseQueryInt parses a non-negative integer query parameter, using a default value if it isn't present.
+func parseQueryInt(r *http.Request, name string, defaultValue int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, errors.New("Value must not be negative")
+	}
+	return n, nil
+}
+
+// selectTeaHandler picks a tea. Only a POST records the pick in the selection history, so that GET requests from
+// prefetching, retries and link previews don't change which teas are picked next.
+func (s *Server) selectTeaHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Received request \"%s /select\"\n", r.Method)
+
+	ownerIDs, err := parseIDList(r.URL.Query().Get("owners"))
+	if err != nil {
+		log.Printf("Failed to parse owner IDs: %q\n Error: %v\n", r.URL.Query().Get("owners"), err)
+		respondWithError(w, newError(ErrValidation, "Invalid owner ID"))
+		return
+	}
+
+	excludePicks, err := parseQueryInt(r, "excludePicks", 0)
+	if err != nil {
+		log.Printf("Failed to parse excludePicks. Error: %v\n", err)
+		respondWithError(w, newError(ErrValidation, "Invalid excludePicks value"))
+		return
+	}
+
+	excludeHours, err := parseQueryInt(r, "excludeHours", 0)
+	if err != nil {
+		log.Printf("Failed to parse excludeHours. Error: %v\n", err)
+		respondWithError(w, newError(ErrValidation, "Invalid excludeHours value"))
+		return
+	}
+
+	caffeine := make([]string, 0)
+	if levels := r.URL.Query().Get("caffeine"); levels != "" {
+		for _, level := range strings.Split(levels, ",") {
+			level = strings.TrimSpace(level)
+			if !isCaffeineLevel(level) {
+				log.Printf("Unknown caffeine level: %q\n", level)
+				respondWithError(w, newError(ErrValidation, "Caffeine must be one of none, low, medium or high"))
+				return
+			}
+			caffeine = append(caffeine, level)
+		}
+	}
+
+	strategyName := r.URL.Query().Get("strategy")
+	if strategyName == "" {
+		strategyName = DefaultSelectionStrategy
+	}
+	strategy, ok := SelectionStrategies[strategyName]
+	if !ok {
+		log.Printf("Unknown selection strategy: %q\n", strategyName)
+		respondWithError(w, newError(ErrValidation, "Unknown selection strategy"))
+		return
+	}
+
+	now := time.Now()
+	options := SelectionOptions{OwnerIDs: ownerIDs, ExcludePicks: excludePicks, Caffeine: caffeine}
+	if excludeHours > 0 {
+		options.ExcludeSince = now.Add(-time.Duration(excludeHours) * time.Hour)
+	}
+
+	teas, err := s.storeFor(r).GetSelectionCandidates(options)
+	if err != nil {
+		log.Printf("Error retrieving teas for owners %v: %v\n", ownerIDs, err)
+		respondWithError(w, err)
+		return
+	}
+
+	if len(teas) == 0 {
+		log.Printf("No teas available to select for owners: %v\n", ownerIDs)
+		respondWithError(w, newError(ErrNotFound, "No teas are available for the selected owners"))
+		return
+	}
+
+	weights, err := strategy.Weights(s.storeFor(r), teas, options)
+	if err != nil {
+		log.Printf("Error weighting teas with strategy %q: %v\n", strategyName, err)
+		respondWithError(w, err)
+		return
+	}
+
+	index, err := pickWeighted(weights)
+	if err != nil {
+		log.Printf("Error picking a tea with strategy %q: %v\n", strategyName, err)
+		respondWithError(w, err)
+		return
+	}
+
+	selection := Selection{Tea: teas[index], Brewing: teas[index].Brewing(), Candidates: len(teas), Strategy: strategyName}
+	log.Printf("Selected tea with ID: %d from %d candidates using strategy %q\n", selection.Tea.ID, selection.Candidates, strategyName)
+	if r.Method != http.MethodPost {
+		respondWithJSON(w, http.StatusOK, selection)
+		return
+	}
+
+	record := SelectionRecord{Tea: selection.Tea, Owners: make([]Owner, 0, len(ownerIDs)), SelectedAt: now}
+	for _, id := range uniqueIDs(ownerIDs) {
+		record.Owners = append(record.Owners, Owner{ID: id})
+	}
+	if err := s.storeFor(r).CreateSelection(&record); err != nil {
+		log.Printf("Error recording selection of tea with ID: %d\n Error: %v\n", selection.Tea.ID, err)
+		respondWithError(w, err)
+		return
+	}
+
+	log.Printf("Recorded selection with ID: %d\n", record.ID)
+	respondWithJSON(w, http.StatusCreated, selection)
+}
+
+func (s *Server) getSelectionsHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println(`Received request "GET /selections"`)
+
+	limit, err := parseQueryInt(r, "limit", 20)
+	if err != nil || limit == 0 || limit > 100 {
+		log.Printf("Invalid limit: %q\n", r.URL.Query().Get("limit"))
+		respondWithError(w, newError(ErrValidation, "Invalid limit, must be between 1 and 100"))
+		return
+	}
+
+	offset, err := parseQueryInt(r, "offset", 0)
+	if err != nil {
+		log.Printf("Invalid offset: %q\n", r.URL.Query().Get("offset"))
+		respondWithError(w, newError(ErrValidation, "Invalid offset"))
+		return
+	}
+
+	selections, total, err := s.storeFor(r).GetSelections(limit, offset)
+	if err != nil {
+		log.Printf("Error retrieving selection history: %v\n", err)
+		respondWithError(w, err)
+		return
+	}
+
+	log.Println("Successfully handled request to see selection history")
+	respondWithJSON(w, http.StatusOK, SelectionHistory{Selections: selections, Total: total, Limit: limit, Offset: offset})
+}
+
+// validateRating checks a rating has between 1 and 5 stars. A rating can have no stars if the tea is never to be picked.
+func validateRating(rating Rating) error {
+	if rating.Rating == 0 && rating.NeverPick {
+		return nil
+	}
+	if rating.Rating < 1 || rating.Rating > 5 {
+		return newError(ErrValidation, "Rating must be between 1 and 5")
+	}
+	return nil
+}
+
+func (s *Server) getTeaRatingsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		log.Printf("Failed to get ratings of tea with ID: %d\n Error: %v\n", id, err)
+		respondWithError(w, newError(ErrBadRequest, "Invalid tea ID"))
+		return
+	}
+	log.Printf("Received request \"GET /tea/%d/ratings\"\n", id)
+
+	tea := Tea{ID: id}
+
+	ratings, err := s.storeFor(r).GetTeaRatings(&tea)
+	if err != nil {
+		log.Printf("Failed to get ratings of tea with ID: %d\n Error: %v\n", id, err)
+		respondWithError(w, err)
+		return
+	}
+
+	log.Printf("Got ratings of tea with ID: %d\n", id)
+	respondWithJSON(w, http.StatusOK, ratings)
+}
+
+func (s *Server) createTeaRatingHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		log.Printf("Failed to rate tea with ID: %d\n Error: %v\n", id, err)
+		respondWithError(w, newError(ErrBadRequest, "Invalid tea ID"))
+		return
+	}
+	log.Printf("Received request \"POST /tea/%d/ratings\"\n", id)
+
+	var rating Rating
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&rating); err != nil {
+		log.Printf("Failed to create new rating of tea with ID: %d\n", id)
+		respondWithError(w, newError(ErrBadRequest, "Invalid request payload"))
+		return
+	}
+	defer r.Body.Close()
+
+	if err := validateRating(rating); err != nil {
+		log.Printf("Invalid rating of tea with ID: %d\n Error: %v\n", id, err)
+		respondWithError(w, err)
+		return
+	}
+
+	if err := s.checkOwner(r, rating.Owner.ID); err != nil {
+		log.Printf("Not allowed to rate tea %d as owner %d. Error: %v\n", id, rating.Owner.ID, err)
+		respondWithError(w, err)
+		return
+	}
+
+	if err := s.storeFor(r).CreateTeaRating(id, &rating); err != nil {
+		log.Printf("Error creating rating for tea with ID: %d\n\t Error: %s\n", id, err)
+		respondWithError(w, err)
+		return
+	}
+
+	log.Printf("Created new rating for tea. teaID: %d, ownerID: %d\n", id, rating.Owner.ID)
+	respondWithJSON(w, http.StatusCreated, rating)
+}
+
+func (s *Server) updateTeaRatingHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	teaID, err := strconv.Atoi(vars["teaID"])
+	if err != nil {
+		log.Printf("Failed to update rating of tea with teaID: %d\n Error: %v\n", teaID, err)
+		respondWithError(w, newError(ErrBadRequest, "Invalid tea ID"))
+		return
+	}
+	ownerID, err := strconv.Atoi(vars["ownerID"])
+	if err != nil {
+		log.Printf("Failed to update rating of tea with ownerID: %d\n Error: %v\n", ownerID, err)
+		respondWithError(w, newError(ErrBadRequest, "Invalid owner ID"))
+		return
+	}
+	log.Printf("Received request \"PUT /tea/%d/ratings/%d\"\n", teaID, ownerID)
+
+	if err := s.checkOwner(r, ownerID); err != nil {
+		log.Printf("Not allowed to update rating of tea %d by owner %d. Error: %v\n", teaID, ownerID, err)
+		respondWithError(w, err)
+		return
+	}
+
+	var rating Rating
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&rating); err != nil {
+		log.Printf("Failed to update rating of tea with ID: %d\n", teaID)
+		respondWithError(w, newError(ErrBadRequest, "Invalid request payload"))
+		return
+	}
+	defer r.Body.Close()
+	rating.Owner = Owner{ID: ownerID}
+
+	if err := validateRating(rating); err != nil {
+		log.Printf("Invalid rating of tea with ID: %d\n Error: %v\n", teaID, err)
+		respondWithError(w, err)
+		return
+	}
+
+	if err := s.storeFor(r).UpdateTeaRating(teaID, &rating); err != nil {
+		log.Printf("Failed to update rating. Error: %v\n", err)
+		respondWithError(w, err)
+		return
+	}
+
+	log.Printf("Updated rating. teaID: %d \t ownerID: %d\n", teaID, ownerID)
+	respondWithJSON(w, http.StatusOK, rating)
+}
+
+func (s *Server) deleteTeaRatingHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	teaID, err := strconv.Atoi(vars["teaID"])
+	if err != nil {
+		log.Printf("Failed to delete rating of tea with teaID: %d\n Error: %v\n", teaID, err)
+		respondWithError(w, newError(ErrBadRequest, "Invalid tea ID"))
+		return
+	}
+	ownerID, err := strconv.Atoi(vars["ownerID"])
+	if err != nil {
+		log.Printf("Failed to delete rating of tea with ownerID: %d\n Error: %v\n", ownerID, err)
+		respondWithError(w, newError(ErrBadRequest, "Invalid owner ID"))
+		return
+	}
+	log.Printf("Received request \"DELETE /tea/%d/ratings/%d\"\n", teaID, ownerID)
+
+	if err := s.checkOwner(r, ownerID); err != nil {
+		log.Printf("Not allowed to delete rating of tea %d by owner %d. Error: %v\n", teaID, ownerID, err)
+		respondWithError(w, err)
+		return
+	}
+
+	owner := Owner{ID: ownerID}
+
+	if err := s.storeFor(r).DeleteTeaRating(teaID, &owner); err != nil {
+		log.Printf("Failed to delete rating. Error: %v\n", err)
+		respondWithError(w, err)
+		return
+	}
+
+	log.Printf("Deleted rating. teaID: %d \t ownerID: %d\n", teaID, ownerID)
+	respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
+}
+
+func (s *Server) getWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println(`Received request "GET /webhooks/deliveries"`)
+
+	limit, err := parseQueryInt(r, "limit", 20)
+	if err != nil || limit == 0 || limit > 100 {
+		log.Printf("Invalid limit: %q\n", r.URL.Query().Get("limit"))
+		respondWithError(w, newError(ErrValidation, "Invalid limit, must be between 1 and 100"))
+		return
+	}
+
+	offset, err := parseQueryInt(r, "offset", 0)
+	if err != nil {
+		log.Printf("Invalid offset: %q\n", r.URL.Query().Get("offset"))
+		respondWithError(w, newError(ErrValidation, "Invalid offset"))
+		return
+	}
+
+	deliveries, total, err := s.storeFor(r).GetWebhookDeliveries(limit, offset)
+	if err != nil {
+		log.Printf("Error retrieving webhook deliveries: %v\n", err)
+		respondWithError(w, err)
+		return
+	}
+
+	log.Println("Successfully handled request to see webhook deliveries")
+	respondWithJSON(w, http.StatusOK, WebhookDeliveryHistory{Deliveries: deliveries, Total: total, Limit: limit, Offset: offset})
+}
+
+func (s *Server) getAuditLogHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println(`Received request "GET /audit"`)
+
+	limit, err := parseQueryInt(r, "limit", 20)
+	if err != nil || limit == 0 || limit > 100 {
+		log.Printf("Invalid limit: %q\n", r.URL.Query().Get("limit"))
+		respondWithError(w, newError(ErrValidation, "Invalid limit, must be between 1 and 100"))
+		return
+	}
+
+	offset, err := parseQueryInt(r, "offset", 0)
+	if err != nil {
+		log.Printf("Invalid offset: %q\n", r.URL.Query().Get("offset"))
+		respondWithError(w, newError(ErrValidation, "Invalid offset"))
+		return
+	}
+
+	entries, total, err := s.storeFor(r).GetAuditEntries(limit, offset)
+	if err != nil {
+		log.Printf("Error retrieving audit log: %v\n", err)
+		respondWithError(w, err)
+		return
+	}
+
+	log.Println("Successfully handled request to see the audit log")
+	respondWithJSON(w, http.StatusOK, AuditLog{Entries: entries, Total: total, Limit: limit, Offset: offset})
+}
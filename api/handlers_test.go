@@ -1,12 +1,12 @@
 package main
 
 import (
-	"database/sql"
 	"errors"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -19,12 +19,11 @@ func TestGetAllTeaTypesHandler(t *testing.T) {
 	}
 
 	// Mock the response from the database
-	oldFunc := GetAllTeaTypesFunc
-	defer func() { GetAllTeaTypesFunc = oldFunc }()
-	GetAllTeaTypesFunc = allTeaTypeResponseMock
+	store := new(mockStore)
+	store.getAllTeaTypes = allTeaTypeResponseMock
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(getAllTeaTypesHandler)
+	handler := http.HandlerFunc(newTestServer(store).getAllTeaTypesHandler)
 	handler.ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusOK {
@@ -37,7 +36,7 @@ func TestGetAllTeaTypesHandler(t *testing.T) {
 	}
 }
 
-func allTeaTypeResponseMock() ([]TeaType, error) {
+func allTeaTypeResponseMock(options ListOptions) ([]TeaType, error) {
 	tea1 := TeaType{ID: 1, Name: "Black Tea"}
 	tea2 := TeaType{ID: 2, Name: "Green Tea"}
 	return []TeaType{tea1, tea2}, nil
@@ -53,12 +52,11 @@ func TestGetTeaTypeHandler(t *testing.T) {
 	req = mux.SetURLVars(req, vars)
 
 	// Mock the response from the database
-	oldFunc := GetTeaTypeFunc
-	defer func() { GetTeaTypeFunc = oldFunc }()
-	GetTeaTypeFunc = getTeaTypeResponseMock
+	store := new(mockStore)
+	store.getTeaType = getTeaTypeResponseMock
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(getTeaTypeHandler)
+	handler := http.HandlerFunc(newTestServer(store).getTeaTypeHandler)
 	handler.ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusOK {
@@ -86,26 +84,25 @@ func TestGetTeaTypeHandlerError(t *testing.T) {
 	req = mux.SetURLVars(req, vars)
 
 	// Mock the response from the database
-	oldFunc := GetTeaTypeFunc
-	defer func() { GetTeaTypeFunc = oldFunc }()
-	GetTeaTypeFunc = getTeaTypeErrorResponseMock
+	store := new(mockStore)
+	store.getTeaType = getTeaTypeErrorResponseMock
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(getTeaTypeHandler)
+	handler := http.HandlerFunc(newTestServer(store).getTeaTypeHandler)
 	handler.ServeHTTP(rr, req)
 
-	if status := rr.Code; status != http.StatusInternalServerError {
-		t.Errorf("GET /type/10 returned wrong status code:\n got: %v\n want: %v", status, http.StatusInternalServerError)
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("GET /type/10 returned wrong status code:\n got: %v\n want: %v", status, http.StatusNotFound)
 	}
 
-	expected := `{"error":"ID does not exist in database"}`
+	expected := `{"error":"Tea type does not exist","code":"not_found"}`
 	if actual := rr.Body.String(); actual != expected {
 		t.Errorf("GET /type/10 returned unexpected body:\n got: %v\n wanted: %v", actual, expected)
 	}
 }
 
 func getTeaTypeErrorResponseMock(teaType *TeaType) error {
-	return sql.ErrNoRows
+	return errTeaTypeMissingID
 }
 
 func TestCreateTeaTypeHandler(t *testing.T) {
@@ -115,12 +112,11 @@ func TestCreateTeaTypeHandler(t *testing.T) {
 	}
 
 	// Mock the response from the database
-	oldFunc := CreateTeaTypeFunc
-	defer func() { CreateTeaTypeFunc = oldFunc }()
-	CreateTeaTypeFunc = createTeaTypeResponseMock
+	store := new(mockStore)
+	store.createTeaType = createTeaTypeResponseMock
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(createTeaTypeHandler)
+	handler := http.HandlerFunc(newTestServer(store).createTeaTypeHandler)
 	handler.ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusCreated {
@@ -145,19 +141,18 @@ func TestErrorCreateTeaTypeHandler(t *testing.T) {
 	}
 
 	// Mock the response from the database
-	oldFunc := CreateTeaTypeFunc
-	defer func() { CreateTeaTypeFunc = oldFunc }()
-	CreateTeaTypeFunc = createTeaTypeResponseErrorMock
+	store := new(mockStore)
+	store.createTeaType = createTeaTypeResponseErrorMock
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(createTeaTypeHandler)
+	handler := http.HandlerFunc(newTestServer(store).createTeaTypeHandler)
 	handler.ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusInternalServerError {
 		t.Errorf("POST /type returned wrong status code:\n got: %v\n want: %v", status, http.StatusInternalServerError)
 	}
 
-	expected := `{"error":"Error"}`
+	expected := `{"error":"Internal server error","code":"internal_error"}`
 	if actual := rr.Body.String(); actual != expected {
 		t.Errorf("POST /type returned unexpected body:\n got: %v\n wanted: %v", actual, expected)
 	}
@@ -177,12 +172,11 @@ func TestDeleteTeaTypeHandler(t *testing.T) {
 	req = mux.SetURLVars(req, vars)
 
 	// Mock the response from the database
-	oldFunc := DeleteTeaTypeFunc
-	defer func() { DeleteTeaTypeFunc = oldFunc }()
-	DeleteTeaTypeFunc = deleteTeaTypeResponseMock
+	store := new(mockStore)
+	store.deleteTeaType = deleteTeaTypeResponseMock
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(deleteTeaTypeHandler)
+	handler := http.HandlerFunc(newTestServer(store).deleteTeaTypeHandler)
 	handler.ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusOK {
@@ -210,26 +204,25 @@ func TestDeleteTeaTypeErrorHandler(t *testing.T) {
 	req = mux.SetURLVars(req, vars)
 
 	// Mock the response from the database
-	oldFunc := DeleteTeaTypeFunc
-	defer func() { DeleteTeaTypeFunc = oldFunc }()
-	DeleteTeaTypeFunc = deleteTeaTypeResponseErrorMock
+	store := new(mockStore)
+	store.deleteTeaType = deleteTeaTypeResponseErrorMock
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(deleteTeaTypeHandler)
+	handler := http.HandlerFunc(newTestServer(store).deleteTeaTypeHandler)
 	handler.ServeHTTP(rr, req)
 
-	if status := rr.Code; status != http.StatusInternalServerError {
-		t.Errorf("DELETE /type returned wrong status code:\n got: %v\n want: %v", status, http.StatusInternalServerError)
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("DELETE /type returned wrong status code:\n got: %v\n want: %v", status, http.StatusNotFound)
 	}
 
-	expected := `{"error":"ID does not exist in database"}`
+	expected := `{"error":"Tea type does not exist","code":"not_found"}`
 	if actual := rr.Body.String(); actual != expected {
 		t.Errorf("DELETE /type returned unexpected body:\n got: %v\n wanted: %v", actual, expected)
 	}
 }
 
 func deleteTeaTypeResponseErrorMock(teaType *TeaType) error {
-	return errors.New("sql: Rows are closed")
+	return errTeaTypeMissingID
 }
 
 func TestGetAllOwnersHandler(t *testing.T) {
@@ -239,12 +232,11 @@ func TestGetAllOwnersHandler(t *testing.T) {
 	}
 
 	// Mock the response from the database
-	oldFunc := GetAllOwnersFunc
-	defer func() { GetAllOwnersFunc = oldFunc }()
-	GetAllOwnersFunc = allTeaOwnersResponseMock
+	store := new(mockStore)
+	store.getAllOwners = allTeaOwnersResponseMock
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(getAllOwnersHandler)
+	handler := http.HandlerFunc(newTestServer(store).getAllOwnersHandler)
 	handler.ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusOK {
@@ -257,7 +249,7 @@ func TestGetAllOwnersHandler(t *testing.T) {
 	}
 }
 
-func allTeaOwnersResponseMock() ([]Owner, error) {
+func allTeaOwnersResponseMock(options ListOptions) ([]Owner, error) {
 	owner1 := Owner{ID: 1, Name: "John"}
 	owner2 := Owner{ID: 2, Name: "Jane"}
 	return []Owner{owner1, owner2}, nil
@@ -273,12 +265,11 @@ func TestGetOwnerHandler(t *testing.T) {
 	req = mux.SetURLVars(req, vars)
 
 	// Mock the response from the database
-	oldFunc := GetOwnerFunc
-	defer func() { GetOwnerFunc = oldFunc }()
-	GetOwnerFunc = getOwnerResponseMock
+	store := new(mockStore)
+	store.getOwner = getOwnerResponseMock
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(getOwnerHandler)
+	handler := http.HandlerFunc(newTestServer(store).getOwnerHandler)
 	handler.ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusOK {
@@ -306,26 +297,25 @@ func TestGetOwnerHandlerError(t *testing.T) {
 	req = mux.SetURLVars(req, vars)
 
 	// Mock the response from the database
-	oldFunc := GetOwnerFunc
-	defer func() { GetOwnerFunc = oldFunc }()
-	GetOwnerFunc = getHandlerErrorResponseMock
+	store := new(mockStore)
+	store.getOwner = getHandlerErrorResponseMock
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(getOwnerHandler)
+	handler := http.HandlerFunc(newTestServer(store).getOwnerHandler)
 	handler.ServeHTTP(rr, req)
 
-	if status := rr.Code; status != http.StatusInternalServerError {
-		t.Errorf("GET /owner/10 returned wrong status code:\n got: %v\n want: %v", status, http.StatusInternalServerError)
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("GET /owner/10 returned wrong status code:\n got: %v\n want: %v", status, http.StatusNotFound)
 	}
 
-	expected := `{"error":"ID does not exist in database"}`
+	expected := `{"error":"Owner does not exist","code":"not_found"}`
 	if actual := rr.Body.String(); actual != expected {
 		t.Errorf("GET /owner/10 returned unexpected body:\n got: %v\n wanted: %v", actual, expected)
 	}
 }
 
 func getHandlerErrorResponseMock(owner *Owner) error {
-	return sql.ErrNoRows
+	return errOwnerMissing
 }
 
 func TestCreateOwnerHandler(t *testing.T) {
@@ -335,12 +325,11 @@ func TestCreateOwnerHandler(t *testing.T) {
 	}
 
 	// Mock the response from the database
-	oldFunc := CreateOwnerFunc
-	defer func() { CreateOwnerFunc = oldFunc }()
-	CreateOwnerFunc = createOwnerResponseMock
+	store := new(mockStore)
+	store.createOwner = createOwnerResponseMock
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(createOwnerHandler)
+	handler := http.HandlerFunc(newTestServer(store).createOwnerHandler)
 	handler.ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusCreated {
@@ -365,19 +354,18 @@ func TestErrorCreateOwnerHandler(t *testing.T) {
 	}
 
 	// Mock the response from the database
-	oldFunc := CreateOwnerFunc
-	defer func() { CreateOwnerFunc = oldFunc }()
-	CreateOwnerFunc = createOwnerResponseErrorMock
+	store := new(mockStore)
+	store.createOwner = createOwnerResponseErrorMock
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(createOwnerHandler)
+	handler := http.HandlerFunc(newTestServer(store).createOwnerHandler)
 	handler.ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusInternalServerError {
 		t.Errorf("POST /owner returned wrong status code:\n got: %v\n want: %v", status, http.StatusInternalServerError)
 	}
 
-	expected := `{"error":"Error"}`
+	expected := `{"error":"Internal server error","code":"internal_error"}`
 	if actual := rr.Body.String(); actual != expected {
 		t.Errorf("POST /owner returned unexpected body:\n got: %v\n wanted: %v", actual, expected)
 	}
@@ -397,12 +385,11 @@ func TestDeleteOwnerHandler(t *testing.T) {
 	req = mux.SetURLVars(req, vars)
 
 	// Mock the response from the database
-	oldFunc := DeleteOwnerFunc
-	defer func() { DeleteOwnerFunc = oldFunc }()
-	DeleteOwnerFunc = deleteOwnerResponseMock
+	store := new(mockStore)
+	store.deleteOwner = deleteOwnerResponseMock
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(deleteOwnerHandler)
+	handler := http.HandlerFunc(newTestServer(store).deleteOwnerHandler)
 	handler.ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusOK {
@@ -430,26 +417,25 @@ func TestDeleteOwnerErrorHandler(t *testing.T) {
 	req = mux.SetURLVars(req, vars)
 
 	// Mock the response from the database
-	oldFunc := DeleteOwnerFunc
-	defer func() { DeleteOwnerFunc = oldFunc }()
-	DeleteOwnerFunc = deleteOwnerResponseErrorMock
+	store := new(mockStore)
+	store.deleteOwner = deleteOwnerResponseErrorMock
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(deleteOwnerHandler)
+	handler := http.HandlerFunc(newTestServer(store).deleteOwnerHandler)
 	handler.ServeHTTP(rr, req)
 
-	if status := rr.Code; status != http.StatusInternalServerError {
-		t.Errorf("DELETE /owner returned wrong status code:\n got: %v\n want: %v", status, http.StatusInternalServerError)
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("DELETE /owner returned wrong status code:\n got: %v\n want: %v", status, http.StatusNotFound)
 	}
 
-	expected := `{"error":"ID does not exist in database"}`
+	expected := `{"error":"Owner does not exist","code":"not_found"}`
 	if actual := rr.Body.String(); actual != expected {
 		t.Errorf("DELETE /owner returned unexpected body:\n got: %v\n wanted: %v", actual, expected)
 	}
 }
 
 func deleteOwnerResponseErrorMock(owner *Owner) error {
-	return errors.New("sql: Rows are closed")
+	return errOwnerMissing
 }
 
 func TestGetAllTeasHandler(t *testing.T) {
@@ -459,12 +445,11 @@ func TestGetAllTeasHandler(t *testing.T) {
 	}
 
 	// Mock the response from the database
-	oldFunc := GetAllTeasFunc
-	defer func() { GetAllTeasFunc = oldFunc }()
-	GetAllTeasFunc = allTeasResponseMock
+	store := new(mockStore)
+	store.getAllTeas = allTeasResponseMock
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(getAllTeasHandler)
+	handler := http.HandlerFunc(newTestServer(store).getAllTeasHandler)
 	handler.ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusOK {
@@ -477,7 +462,7 @@ func TestGetAllTeasHandler(t *testing.T) {
 	}
 }
 
-func allTeasResponseMock() ([]Tea, error) {
+func allTeasResponseMock(options ListOptions) ([]Tea, error) {
 	tea1 := Tea{ID: 1, Name: "Snowball", TeaType: TeaType{ID: 1, Name: "Black Tea"}}
 	tea2 := Tea{ID: 2, Name: "Nearly Nirvana", TeaType: TeaType{ID: 2, Name: "White Tea"}}
 	return []Tea{tea1, tea2}, nil
@@ -493,12 +478,11 @@ func TestGetTeaHandler(t *testing.T) {
 	req = mux.SetURLVars(req, vars)
 
 	// Mock the response from the database
-	oldFunc := GetTeaFunc
-	defer func() { GetTeaFunc = oldFunc }()
-	GetTeaFunc = getTeaResponseMock
+	store := new(mockStore)
+	store.getTea = getTeaResponseMock
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(getTeaHandler)
+	handler := http.HandlerFunc(newTestServer(store).getTeaHandler)
 	handler.ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusOK {
@@ -528,26 +512,25 @@ func TestGetTeaHandlerError(t *testing.T) {
 	req = mux.SetURLVars(req, vars)
 
 	// Mock the response from the database
-	oldFunc := GetTeaFunc
-	defer func() { GetTeaFunc = oldFunc }()
-	GetTeaFunc = getTeaResponseErrorMock
+	store := new(mockStore)
+	store.getTea = getTeaResponseErrorMock
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(getTeaHandler)
+	handler := http.HandlerFunc(newTestServer(store).getTeaHandler)
 	handler.ServeHTTP(rr, req)
 
-	if status := rr.Code; status != http.StatusInternalServerError {
-		t.Errorf("GET /tea/10 returned wrong status code:\n got: %v\n want: %v", status, http.StatusInternalServerError)
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("GET /tea/10 returned wrong status code:\n got: %v\n want: %v", status, http.StatusNotFound)
 	}
 
-	expected := `{"error":"ID does not exist in database"}`
+	expected := `{"error":"Tea does not exist","code":"not_found"}`
 	if actual := rr.Body.String(); actual != expected {
 		t.Errorf("GET /tea/10 returned unexpected body:\n got: %v\n wanted: %v", actual, expected)
 	}
 }
 
 func getTeaResponseErrorMock(tea *Tea) error {
-	return sql.ErrNoRows
+	return errTeaMissing
 }
 
 func TestCreateTeaHandler(t *testing.T) {
@@ -557,12 +540,11 @@ func TestCreateTeaHandler(t *testing.T) {
 	}
 
 	// Mock the response from the database
-	oldFunc := CreateTeaFunc
-	defer func() { CreateTeaFunc = oldFunc }()
-	CreateTeaFunc = createTeaResponseMock
+	store := new(mockStore)
+	store.createTea = createTeaResponseMock
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(createTeaHandler)
+	handler := http.HandlerFunc(newTestServer(store).createTeaHandler)
 	handler.ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusCreated {
@@ -588,19 +570,18 @@ func TestErrorCreateTeaHandler(t *testing.T) {
 	}
 
 	// Mock the response from the database
-	oldFunc := CreateTeaFunc
-	defer func() { CreateTeaFunc = oldFunc }()
-	CreateTeaFunc = createTeaResponseErrorMock
+	store := new(mockStore)
+	store.createTea = createTeaResponseErrorMock
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(createTeaHandler)
+	handler := http.HandlerFunc(newTestServer(store).createTeaHandler)
 	handler.ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusInternalServerError {
 		t.Errorf("POST /tea returned wrong status code:\n got: %v\n want: %v", status, http.StatusInternalServerError)
 	}
 
-	expected := `{"error":"Error"}`
+	expected := `{"error":"Internal server error","code":"internal_error"}`
 	if actual := rr.Body.String(); actual != expected {
 		t.Errorf("POST /tea returned unexpected body:\n got: %v\n wanted: %v", actual, expected)
 	}
@@ -620,12 +601,11 @@ func TestDeleteTeaHandler(t *testing.T) {
 	req = mux.SetURLVars(req, vars)
 
 	// Mock the response from the database
-	oldFunc := DeleteTeaFunc
-	defer func() { DeleteTeaFunc = oldFunc }()
-	DeleteTeaFunc = deleteTeaResponseMock
+	store := new(mockStore)
+	store.deleteTea = deleteTeaResponseMock
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(deleteTeaHandler)
+	handler := http.HandlerFunc(newTestServer(store).deleteTeaHandler)
 	handler.ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusOK {
@@ -653,26 +633,25 @@ func TestDeleteTeaErrorHandler(t *testing.T) {
 	req = mux.SetURLVars(req, vars)
 
 	// Mock the response from the database
-	oldFunc := DeleteTeaFunc
-	defer func() { DeleteTeaFunc = oldFunc }()
-	DeleteTeaFunc = deleteTeaResponseErrorMock
+	store := new(mockStore)
+	store.deleteTea = deleteTeaResponseErrorMock
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(deleteTeaHandler)
+	handler := http.HandlerFunc(newTestServer(store).deleteTeaHandler)
 	handler.ServeHTTP(rr, req)
 
-	if status := rr.Code; status != http.StatusInternalServerError {
-		t.Errorf("DELETE /tea returned wrong status code:\n got: %v\n want: %v", status, http.StatusInternalServerError)
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("DELETE /tea returned wrong status code:\n got: %v\n want: %v", status, http.StatusNotFound)
 	}
 
-	expected := `{"error":"ID does not exist in database"}`
+	expected := `{"error":"Tea does not exist","code":"not_found"}`
 	if actual := rr.Body.String(); actual != expected {
 		t.Errorf("DELETE /tea returned unexpected body:\n got: %v\n wanted: %v", actual, expected)
 	}
 }
 
 func deleteTeaResponseErrorMock(tea *Tea) error {
-	return errors.New("sql: Rows are closed")
+	return errTeaMissing
 }
 
 func TestGetTeaOwnersHandler(t *testing.T) {
@@ -685,28 +664,28 @@ func TestGetTeaOwnersHandler(t *testing.T) {
 	req = mux.SetURLVars(req, vars)
 
 	// Mock the response from the database
-	oldFunc := GetTeaOwnersFunc
-	defer func() { GetTeaOwnersFunc = oldFunc }()
-	GetTeaOwnersFunc = getTeaOwnersResponseMock
+	store := new(mockStore)
+	store.getTeaOwners = getTeaOwnersResponseMock
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(getTeaOwnersHandler)
+	handler := http.HandlerFunc(newTestServer(store).getTeaOwnersHandler)
 	handler.ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusOK {
 		t.Errorf("GET /tea/{id}/owners returned wrong status code:\n got: %v\n want: %v", status, http.StatusOK)
 	}
 
-	expected := `[{"id":1,"name":"John"},{"id":2,"name":"Jane"}]`
+	expected := `[{"id":1,"name":"John","quantity":12,"unit":"bags"},{"id":2,"name":"Jane"}]`
 	if actual := rr.Body.String(); actual != expected {
 		t.Errorf("GET /tea/{id}/owners returned unexpected body:\n got: %v\n wanted: %v", actual, expected)
 	}
 }
 
-func getTeaOwnersResponseMock(tea *Tea) ([]Owner, error) {
-	owner1 := Owner{ID: 1, Name: "John"}
-	owner2 := Owner{ID: 2, Name: "Jane"}
-	return []Owner{owner1, owner2}, nil
+func getTeaOwnersResponseMock(tea *Tea) ([]TeaOwner, error) {
+	quantity := 12.0
+	owner1 := TeaOwner{Owner: Owner{ID: 1, Name: "John"}, Stock: Stock{Quantity: &quantity, Unit: UnitBags}}
+	owner2 := TeaOwner{Owner: Owner{ID: 2, Name: "Jane"}}
+	return []TeaOwner{owner1, owner2}, nil
 }
 
 func TestGetTeaOwnersErrorHandler(t *testing.T) {
@@ -719,25 +698,24 @@ func TestGetTeaOwnersErrorHandler(t *testing.T) {
 	req = mux.SetURLVars(req, vars)
 
 	// Mock the response from the database
-	oldFunc := GetTeaOwnersFunc
-	defer func() { GetTeaOwnersFunc = oldFunc }()
-	GetTeaOwnersFunc = getTeaOwnersErrorResponseMock
+	store := new(mockStore)
+	store.getTeaOwners = getTeaOwnersErrorResponseMock
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(getTeaOwnersHandler)
+	handler := http.HandlerFunc(newTestServer(store).getTeaOwnersHandler)
 	handler.ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusInternalServerError {
 		t.Errorf("GET /tea/{id}/owners returned wrong status code:\n got: %v\n want: %v", status, http.StatusInternalServerError)
 	}
 
-	expected := `{"error":"Error"}`
+	expected := `{"error":"Internal server error","code":"internal_error"}`
 	if actual := rr.Body.String(); actual != expected {
 		t.Errorf("GET /tea/{id}/owners returned unexpected body:\n got: %v\n wanted: %v", actual, expected)
 	}
 }
 
-func getTeaOwnersErrorResponseMock(tea *Tea) ([]Owner, error) {
+func getTeaOwnersErrorResponseMock(tea *Tea) ([]TeaOwner, error) {
 	return nil, errors.New("Error")
 }
 
@@ -748,12 +726,11 @@ func TestGetAllTeaOwnersHandler(t *testing.T) {
 	}
 
 	// Mock the response from the database
-	oldFunc := GetAllTeaOwnersFunc
-	defer func() { GetAllTeaOwnersFunc = oldFunc }()
-	GetAllTeaOwnersFunc = getAllTeaOwnersResponseMock
+	store := new(mockStore)
+	store.getAllTeaOwners = getAllTeaOwnersResponseMock
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(getAllTeaOwnersHandler)
+	handler := http.HandlerFunc(newTestServer(store).getAllTeaOwnersHandler)
 	handler.ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusOK {
@@ -770,18 +747,25 @@ func TestGetAllTeaOwnersHandler(t *testing.T) {
 	}
 }
 
-func getAllTeaOwnersResponseMock() ([]TeaWithOwners, error) {
-	tea1 := Tea{1, "Snowball", TeaType{1, "Black Tea"}}
-	tea2 := Tea{2, "Nearly Nirvana", TeaType{2, "White Tea"}}
-	tea3 := Tea{3, "Earl Grey", TeaType{1, "Black Tea"}}
-	owner1 := Owner{1, "John"}
-	owner2 := Owner{2, "Jane"}
-	teaWithOwners1 := TeaWithOwners{tea1, []Owner{owner1}}
-	teaWithOwners2 := TeaWithOwners{tea2, []Owner{owner1, owner2}}
-	teaWithOwners3 := TeaWithOwners{Tea: tea3, Owners: []Owner{}}
+func getAllTeaOwnersResponseMock(options ListOptions) ([]TeaWithOwners, error) {
+	tea1 := Tea{ID: 1, Name: "Snowball", TeaType: TeaType{ID: 1, Name: "Black Tea"}}
+	tea2 := Tea{ID: 2, Name: "Nearly Nirvana", TeaType: TeaType{ID: 2, Name: "White Tea"}}
+	tea3 := Tea{ID: 3, Name: "Earl Grey", TeaType: TeaType{ID: 1, Name: "Black Tea"}}
+	owner1 := TeaOwner{Owner: Owner{1, "John"}}
+	owner2 := TeaOwner{Owner: Owner{2, "Jane"}}
+	teaWithOwners1 := TeaWithOwners{tea1, []TeaOwner{owner1}}
+	teaWithOwners2 := TeaWithOwners{tea2, []TeaOwner{owner1, owner2}}
+	teaWithOwners3 := TeaWithOwners{Tea: tea3, Owners: []TeaOwner{}}
 	return []TeaWithOwners{teaWithOwners1, teaWithOwners2, teaWithOwners3}, nil
 }
 
+// linkedUserMock gives a mock of getting a member who is linked to an owner, so can change that owner's teas.
+func linkedUserMock(ownerID int) func(string) (User, error) {
+	return func(username string) (User, error) {
+		return User{Username: username, Role: RoleMember, OwnerID: ownerID}, nil
+	}
+}
+
 func TestCreateTeaOwnerHandler(t *testing.T) {
 	req, err := http.NewRequest(http.MethodPost, "/tea/1/owner", strings.NewReader(`{"id": 1}`))
 	if err != nil {
@@ -792,12 +776,12 @@ func TestCreateTeaOwnerHandler(t *testing.T) {
 	req = mux.SetURLVars(req, vars)
 
 	// Mock the response from the database
-	oldFunc := CreateTeaOwnerFunc
-	defer func() { CreateTeaOwnerFunc = oldFunc }()
-	CreateTeaOwnerFunc = createTeaOwnerResponseMock
+	store := new(mockStore)
+	store.getUser = linkedUserMock(1)
+	store.createTeaOwner = createTeaOwnerResponseMock
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(createTeaOwnerHandler)
+	handler := http.HandlerFunc(newTestServer(store).createTeaOwnerHandler)
 	handler.ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusCreated {
@@ -810,7 +794,7 @@ func TestCreateTeaOwnerHandler(t *testing.T) {
 	}
 }
 
-func createTeaOwnerResponseMock(teaID int, owner *Owner) (Tea, error) {
+func createTeaOwnerResponseMock(teaID int, owner *Owner, stock Stock) (Tea, error) {
 	return Tea{ID: 1, Name: "Snowball", TeaType: TeaType{ID: 1, Name: "Black Tea"}}, nil
 }
 
@@ -824,25 +808,25 @@ func TestCreateTeaOwnerErrorHandler(t *testing.T) {
 	req = mux.SetURLVars(req, vars)
 
 	// Mock the response from the database
-	oldFunc := CreateTeaOwnerFunc
-	defer func() { CreateTeaOwnerFunc = oldFunc }()
-	CreateTeaOwnerFunc = createTeaOwnerResponseErrorMock
+	store := new(mockStore)
+	store.getUser = linkedUserMock(10)
+	store.createTeaOwner = createTeaOwnerResponseErrorMock
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(createTeaOwnerHandler)
+	handler := http.HandlerFunc(newTestServer(store).createTeaOwnerHandler)
 	handler.ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusInternalServerError {
 		t.Errorf("POST /tea/{id}/owner returned wrong status code:\n got: %v\n want: %v", status, http.StatusInternalServerError)
 	}
 
-	expected := `{"error":"Error"}`
+	expected := `{"error":"Internal server error","code":"internal_error"}`
 	if actual := rr.Body.String(); actual != expected {
 		t.Errorf("POST /tea/{id}/owner returned unexpected body:\n got: %v\n wanted: %v", actual, expected)
 	}
 }
 
-func createTeaOwnerResponseErrorMock(teaID int, owner *Owner) (Tea, error) {
+func createTeaOwnerResponseErrorMock(teaID int, owner *Owner, stock Stock) (Tea, error) {
 	tea := new(Tea)
 	return *tea, errors.New("Error")
 }
@@ -857,12 +841,12 @@ func TestDeleteTeaOwnerHandler(t *testing.T) {
 	req = mux.SetURLVars(req, vars)
 
 	// Mock the response from the database
-	oldFunc := DeleteTeaOwnerFunc
-	defer func() { DeleteTeaOwnerFunc = oldFunc }()
-	DeleteTeaOwnerFunc = deleteTeaOwnerResponseMock
+	store := new(mockStore)
+	store.getUser = linkedUserMock(1)
+	store.deleteTeaOwner = deleteTeaOwnerResponseMock
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(deleteTeaOwnerHandler)
+	handler := http.HandlerFunc(newTestServer(store).deleteTeaOwnerHandler)
 	handler.ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusOK {
@@ -889,24 +873,925 @@ func TestDeleteTeaOwnerErrorHandler(t *testing.T) {
 	req = mux.SetURLVars(req, vars)
 
 	// Mock the response from the database
-	oldFunc := DeleteTeaOwnerFunc
-	defer func() { DeleteTeaOwnerFunc = oldFunc }()
-	DeleteTeaOwnerFunc = deleteTeaOwnerResponseErrorMock
+	store := new(mockStore)
+	store.getUser = linkedUserMock(10)
+	store.deleteTeaOwner = deleteTeaOwnerResponseErrorMock
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(deleteTeaOwnerHandler)
+	handler := http.HandlerFunc(newTestServer(store).deleteTeaOwnerHandler)
 	handler.ServeHTTP(rr, req)
 
-	if status := rr.Code; status != http.StatusInternalServerError {
-		t.Errorf("DELETE /tea/{id}/owner/{id} returned wrong status code:\n got: %v\n want: %v", status, http.StatusInternalServerError)
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("DELETE /tea/{id}/owner/{id} returned wrong status code:\n got: %v\n want: %v", status, http.StatusNotFound)
 	}
 
-	expected := `{"error":"Relationship does not exist in database"}`
+	expected := `{"error":"This owner doesn't own this tea","code":"not_found"}`
 	if actual := rr.Body.String(); actual != expected {
 		t.Errorf("DELETE /tea/{id}/owner/{id} returned unexpected body:\n got: %v\n wanted: %v", actual, expected)
 	}
 }
 
 func deleteTeaOwnerResponseErrorMock(tea *Tea, owner *Owner) error {
-	return errors.New("sql: Rows are closed")
+	return errTeaOwnerMissing
+}
+
+func TestCreateTeaOwnerHandlerWithStock(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPost, "/tea/1/owner", strings.NewReader(`{"id": 1, "quantity": 20}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vars := map[string]string{"id": "1"}
+	req = mux.SetURLVars(req, vars)
+
+	// Mock the response from the database
+	store := new(mockStore)
+	store.getUser = linkedUserMock(1)
+	var stock Stock
+	store.createTeaOwner = func(teaID int, owner *Owner, s Stock) (Tea, error) {
+		stock = s
+		return createTeaOwnerResponseMock(teaID, owner, s)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(newTestServer(store).createTeaOwnerHandler)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusCreated {
+		t.Errorf("POST /tea/{id}/owner returned wrong status code:\n got: %v\n want: %v", status, http.StatusCreated)
+	}
+	if stock.Quantity == nil || *stock.Quantity != 20 || stock.Unit != UnitBags {
+		t.Errorf("POST /tea/{id}/owner passed unexpected stock to database: %+v", stock)
+	}
+}
+
+func TestCreateTeaOwnerHandlerInvalidStock(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPost, "/tea/1/owner", strings.NewReader(`{"id": 1, "quantity": -1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vars := map[string]string{"id": "1"}
+	req = mux.SetURLVars(req, vars)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(newTestServer(new(mockStore)).createTeaOwnerHandler)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusUnprocessableEntity {
+		t.Errorf("POST /tea/{id}/owner returned wrong status code:\n got: %v\n want: %v", status, http.StatusUnprocessableEntity)
+	}
+
+	expected := `{"error":"Quantity must not be negative","code":"validation_failed"}`
+	if actual := rr.Body.String(); actual != expected {
+		t.Errorf("POST /tea/{id}/owner returned unexpected body:\n got: %v\n wanted: %v", actual, expected)
+	}
+}
+
+func TestConsumeTeaStockHandler(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPost, "/tea/1/owner/2/consume", strings.NewReader(`{"quantity": 1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vars := map[string]string{"teaID": "1", "ownerID": "2"}
+	req = mux.SetURLVars(req, vars)
+
+	// Mock the response from the database
+	store := new(mockStore)
+	store.getUser = linkedUserMock(2)
+	store.consumeTeaStock = func(teaID int, ownerID int, quantity float64) (Stock, error) {
+		if teaID != 1 || ownerID != 2 || quantity != 1 {
+			t.Errorf("POST /tea/{teaID}/owner/{ownerID}/consume passed unexpected values to database: %d, %d, %v", teaID, ownerID, quantity)
+		}
+		left := 11.0
+		return Stock{Quantity: &left, Unit: UnitBags}, nil
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(newTestServer(store).consumeTeaStockHandler)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("POST /tea/{teaID}/owner/{ownerID}/consume returned wrong status code:\n got: %v\n want: %v", status, http.StatusOK)
+	}
+
+	expected := `{"quantity":11,"unit":"bags"}`
+	if actual := rr.Body.String(); actual != expected {
+		t.Errorf("POST /tea/{teaID}/owner/{ownerID}/consume returned unexpected body:\n got: %v\n wanted: %v", actual, expected)
+	}
+}
+
+func TestConsumeTeaStockHandlerErrors(t *testing.T) {
+	tests := []struct {
+		body     string
+		err      error
+		status   int
+		expected string
+	}{
+		{`{}`, nil, http.StatusUnprocessableEntity, `{"error":"Quantity must be greater than zero","code":"validation_failed"}`},
+		{`{"quantity": 1}`, errTeaOwnerMissing, http.StatusNotFound, `{"error":"This owner doesn't own this tea","code":"not_found"}`},
+		{`{"quantity": 1}`, ErrStockNotTracked, http.StatusConflict, `{"error":"Stock of this tea is not being tracked","code":"conflict"}`},
+		{`{"quantity": 1}`, errors.New("Error"), http.StatusInternalServerError, `{"error":"Internal server error","code":"internal_error"}`},
+	}
+
+	// Mock the response from the database
+	store := new(mockStore)
+	store.getUser = linkedUserMock(2)
+
+	for _, test := range tests {
+		req, err := http.NewRequest(http.MethodPost, "/tea/1/owner/2/consume", strings.NewReader(test.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		vars := map[string]string{"teaID": "1", "ownerID": "2"}
+		req = mux.SetURLVars(req, vars)
+
+		store.consumeTeaStock = func(teaID int, ownerID int, quantity float64) (Stock, error) {
+			return Stock{}, test.err
+		}
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(newTestServer(store).consumeTeaStockHandler)
+		handler.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != test.status {
+			t.Errorf("POST /tea/{teaID}/owner/{ownerID}/consume returned wrong status code:\n got: %v\n want: %v", status, test.status)
+		}
+		if actual := rr.Body.String(); actual != test.expected {
+			t.Errorf("POST /tea/{teaID}/owner/{ownerID}/consume returned unexpected body:\n got: %v\n wanted: %v", actual, test.expected)
+		}
+	}
+}
+
+func TestRestockTeaHandler(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPost, "/tea/1/owner/2/restock", strings.NewReader(`{"quantity": 100, "unit": "grams"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vars := map[string]string{"teaID": "1", "ownerID": "2"}
+	req = mux.SetURLVars(req, vars)
+
+	// Mock the response from the database
+	store := new(mockStore)
+	store.getUser = linkedUserMock(2)
+	store.restockTea = func(teaID int, ownerID int, change Stock) (Stock, error) {
+		if teaID != 1 || ownerID != 2 || *change.Quantity != 100 || change.Unit != UnitGrams {
+			t.Errorf("POST /tea/{teaID}/owner/{ownerID}/restock passed unexpected values to database: %d, %d, %+v", teaID, ownerID, change)
+		}
+		total := 150.0
+		return Stock{Quantity: &total, Unit: UnitGrams}, nil
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(newTestServer(store).restockTeaHandler)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("POST /tea/{teaID}/owner/{ownerID}/restock returned wrong status code:\n got: %v\n want: %v", status, http.StatusOK)
+	}
+
+	expected := `{"quantity":150,"unit":"grams"}`
+	if actual := rr.Body.String(); actual != expected {
+		t.Errorf("POST /tea/{teaID}/owner/{ownerID}/restock returned unexpected body:\n got: %v\n wanted: %v", actual, expected)
+	}
+}
+
+func TestRestockTeaHandlerUnitMismatch(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPost, "/tea/1/owner/2/restock", strings.NewReader(`{"quantity": 100, "unit": "grams"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vars := map[string]string{"teaID": "1", "ownerID": "2"}
+	req = mux.SetURLVars(req, vars)
+
+	// Mock the response from the database
+	store := new(mockStore)
+	store.getUser = linkedUserMock(2)
+	store.restockTea = func(teaID int, ownerID int, change Stock) (Stock, error) {
+		return Stock{}, ErrStockUnitMismatch
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(newTestServer(store).restockTeaHandler)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusUnprocessableEntity {
+		t.Errorf("POST /tea/{teaID}/owner/{ownerID}/restock returned wrong status code:\n got: %v\n want: %v", status, http.StatusUnprocessableEntity)
+	}
+
+	expected := `{"error":"Stock of this tea is measured in a different unit","code":"validation_failed"}`
+	if actual := rr.Body.String(); actual != expected {
+		t.Errorf("POST /tea/{teaID}/owner/{ownerID}/restock returned unexpected body:\n got: %v\n wanted: %v", actual, expected)
+	}
+}
+
+func TestSelectTeaHandler(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "/select?owners=1,2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Mock the response from the database
+	store := new(mockStore)
+	var options SelectionOptions
+	store.getSelectionCandidates = func(o SelectionOptions) ([]Tea, error) {
+		options = o
+		teas, err := allTeasResponseMock(ListOptions{})
+		teas[1].TeaType.BrewTemperature = 85
+		teas[1].SteepSeconds = 120
+		return teas, err
+	}
+	var record SelectionRecord
+	store.createSelection = func(s *SelectionRecord) error {
+		record = *s
+		return nil
+	}
+	oldRandomFunc := RandomFloatFunc
+	defer func() { RandomFloatFunc = oldRandomFunc }()
+	RandomFloatFunc = func() float64 { return 0.99 }
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(newTestServer(store).selectTeaHandler)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("GET /select returned wrong status code:\n got: %v\n want: %v", status, http.StatusOK)
+	}
+
+	if len(options.OwnerIDs) != 2 || options.OwnerIDs[0] != 1 || options.OwnerIDs[1] != 2 {
+		t.Errorf("GET /select passed wrong owner IDs to database:\n got: %v\n want: %v", options.OwnerIDs, []int{1, 2})
+	}
+	if options.ExcludePicks != 0 || !options.ExcludeSince.IsZero() {
+		t.Errorf("GET /select unexpectedly excluded recent teas: %+v", options)
+	}
+
+	if record.Tea.ID != 0 {
+		t.Errorf("GET /select unexpectedly recorded selection: %+v", record)
+	}
+
+	expected := `{"tea":{"id":2,"name":"Nearly Nirvana","type":{"id":2,"name":"White Tea","brewTemperature":85},"steepSeconds":120},"brewing":{"brewTemperature":85,"steepSeconds":120},"candidates":2,"strategy":"uniform"}`
+	if actual := rr.Body.String(); actual != expected {
+		t.Errorf("GET /select returned unexpected body:\n got: %v\n wanted: %v", actual, expected)
+	}
+
+	// Only a POST records the selection
+	req, err = http.NewRequest(http.MethodPost, "/select?owners=1,2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusCreated {
+		t.Errorf("POST /select returned wrong status code:\n got: %v\n want: %v", status, http.StatusCreated)
+	}
+	if record.Tea.ID != 2 || len(record.Owners) != 2 || record.SelectedAt.IsZero() {
+		t.Errorf("POST /select recorded unexpected selection: %+v", record)
+	}
+	if actual := rr.Body.String(); actual != expected {
+		t.Errorf("POST /select returned unexpected body:\n got: %v\n wanted: %v", actual, expected)
+	}
+}
+
+func TestSelectTeaHandlerExcludeRecent(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "/select?excludePicks=3&excludeHours=12&caffeine=none,low", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Mock the response from the database
+	store := new(mockStore)
+	var options SelectionOptions
+	store.getSelectionCandidates = func(o SelectionOptions) ([]Tea, error) {
+		options = o
+		return allTeasResponseMock(ListOptions{})
+	}
+	store.createSelection = func(s *SelectionRecord) error { return nil }
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(newTestServer(store).selectTeaHandler)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("GET /select returned wrong status code:\n got: %v\n want: %v", status, http.StatusOK)
+	}
+
+	if options.ExcludePicks != 3 {
+		t.Errorf("GET /select passed wrong excludePicks to database:\n got: %d\n want: %d", options.ExcludePicks, 3)
+	}
+	if len(options.Caffeine) != 2 || options.Caffeine[0] != "none" || options.Caffeine[1] != "low" {
+		t.Errorf("GET /select passed wrong caffeine levels to database:\n got: %v\n want: %v", options.Caffeine, []string{"none", "low"})
+	}
+	since := time.Since(options.ExcludeSince)
+	if since < 12*time.Hour || since > 12*time.Hour+time.Minute {
+		t.Errorf("GET /select passed wrong excludeSince to database: %v", options.ExcludeSince)
+	}
+}
+
+func TestSelectTeaHandlerNoCandidates(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "/select?owners=1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Mock the response from the database
+	store := new(mockStore)
+	store.getSelectionCandidates = func(o SelectionOptions) ([]Tea, error) { return []Tea{}, nil }
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(newTestServer(store).selectTeaHandler)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("GET /select returned wrong status code:\n got: %v\n want: %v", status, http.StatusNotFound)
+	}
+
+	expected := `{"error":"No teas are available for the selected owners","code":"not_found"}`
+	if actual := rr.Body.String(); actual != expected {
+		t.Errorf("GET /select returned unexpected body:\n got: %v\n wanted: %v", actual, expected)
+	}
+}
+
+func TestSelectTeaHandlerBadOwner(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "/select?owners=1,abc", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(newTestServer(new(mockStore)).selectTeaHandler)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusUnprocessableEntity {
+		t.Errorf("GET /select returned wrong status code:\n got: %v\n want: %v", status, http.StatusUnprocessableEntity)
+	}
+
+	expected := `{"error":"Invalid owner ID","code":"validation_failed"}`
+	if actual := rr.Body.String(); actual != expected {
+		t.Errorf("GET /select returned unexpected body:\n got: %v\n wanted: %v", actual, expected)
+	}
+}
+
+func TestSelectTeaHandlerUnknownCaffeine(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "/select?caffeine=decaf", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(newTestServer(new(mockStore)).selectTeaHandler)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusUnprocessableEntity {
+		t.Errorf("GET /select returned wrong status code:\n got: %v\n want: %v", status, http.StatusUnprocessableEntity)
+	}
+
+	expected := `{"error":"Caffeine must be one of none, low, medium or high","code":"validation_failed"}`
+	if actual := rr.Body.String(); actual != expected {
+		t.Errorf("GET /select returned unexpected body:\n got: %v\n wanted: %v", actual, expected)
+	}
+}
+
+func TestSelectTeaHandlerUnknownStrategy(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "/select?strategy=favourite", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(newTestServer(new(mockStore)).selectTeaHandler)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusUnprocessableEntity {
+		t.Errorf("GET /select returned wrong status code:\n got: %v\n want: %v", status, http.StatusUnprocessableEntity)
+	}
+
+	expected := `{"error":"Unknown selection strategy","code":"validation_failed"}`
+	if actual := rr.Body.String(); actual != expected {
+		t.Errorf("GET /select returned unexpected body:\n got: %v\n wanted: %v", actual, expected)
+	}
+}
+
+func TestGetSelectionsHandler(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "/selections?limit=1&offset=1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Mock the response from the database
+	store := new(mockStore)
+	store.getSelections = func(limit int, offset int) ([]SelectionRecord, int, error) {
+		if limit != 1 || offset != 1 {
+			t.Errorf("GET /selections passed wrong paging to database:\n got: %d, %d\n want: 1, 1", limit, offset)
+		}
+		selection := SelectionRecord{
+			ID:         1,
+			Tea:        Tea{ID: 1, Name: "Snowball", TeaType: TeaType{ID: 1, Name: "Black Tea"}},
+			Owners:     []Owner{{ID: 1, Name: "John"}},
+			SelectedAt: time.Date(2020, 7, 1, 12, 0, 0, 0, time.UTC),
+		}
+		return []SelectionRecord{selection}, 2, nil
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(newTestServer(store).getSelectionsHandler)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("GET /selections returned wrong status code:\n got: %v\n want: %v", status, http.StatusOK)
+	}
+
+	expected := `{"selections":[{"id":1,"tea":{"id":1,"name":"Snowball","type":{"id":1,"name":"Black Tea"}},"owners":[{"id":1,"name":"John"}],"selectedAt":"2020-07-01T12:00:00Z"}],"total":2,"limit":1,"offset":1}`
+	if actual := rr.Body.String(); actual != expected {
+		t.Errorf("GET /selections returned unexpected body:\n got: %v\n wanted: %v", actual, expected)
+	}
+}
+
+func TestGetSelectionsHandlerBadLimit(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "/selections?limit=0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(newTestServer(new(mockStore)).getSelectionsHandler)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusUnprocessableEntity {
+		t.Errorf("GET /selections returned wrong status code:\n got: %v\n want: %v", status, http.StatusUnprocessableEntity)
+	}
+
+	expected := `{"error":"Invalid limit, must be between 1 and 100","code":"validation_failed"}`
+	if actual := rr.Body.String(); actual != expected {
+		t.Errorf("GET /selections returned unexpected body:\n got: %v\n wanted: %v", actual, expected)
+	}
+}
+
+func TestGetTeaRatingsHandler(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "/tea/1/ratings", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vars := map[string]string{"id": "1"}
+	req = mux.SetURLVars(req, vars)
+
+	// Mock the response from the database
+	store := new(mockStore)
+	store.getTeaRatings = getTeaRatingsResponseMock
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(newTestServer(store).getTeaRatingsHandler)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("GET /tea/1/ratings returned wrong status code:\n got: %v\n want: %v", status, http.StatusOK)
+	}
+
+	expected := `[{"owner":{"id":1,"name":"John"},"rating":4,"neverPick":false},{"owner":{"id":2,"name":"Jane"},"neverPick":true}]`
+	if actual := rr.Body.String(); actual != expected {
+		t.Errorf("GET /tea/1/ratings returned unexpected body:\n got: %v\n wanted: %v", actual, expected)
+	}
+}
+
+func getTeaRatingsResponseMock(tea *Tea) ([]Rating, error) {
+	rating1 := Rating{Owner: Owner{ID: 1, Name: "John"}, Rating: 4}
+	rating2 := Rating{Owner: Owner{ID: 2, Name: "Jane"}, NeverPick: true}
+	return []Rating{rating1, rating2}, nil
+}
+
+func TestCreateTeaRatingHandler(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPost, "/tea/1/ratings", strings.NewReader(`{"owner": {"id": 1}, "rating": 4}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vars := map[string]string{"id": "1"}
+	req = mux.SetURLVars(req, vars)
+
+	// Mock the response from the database
+	store := new(mockStore)
+	store.getUser = linkedUserMock(1)
+	store.createTeaRating = createTeaRatingResponseMock
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(newTestServer(store).createTeaRatingHandler)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusCreated {
+		t.Errorf("POST /tea/1/ratings returned wrong status code:\n got: %v\n want: %v", status, http.StatusCreated)
+	}
+
+	expected := `{"owner":{"id":1,"name":"John"},"rating":4,"neverPick":false}`
+	if actual := rr.Body.String(); actual != expected {
+		t.Errorf("POST /tea/1/ratings returned unexpected body:\n got: %v\n wanted: %v", actual, expected)
+	}
+}
+
+func createTeaRatingResponseMock(teaID int, rating *Rating) error {
+	rating.Owner.Name = "John"
+	return nil
+}
+
+func TestCreateTeaRatingHandlerInvalidRating(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPost, "/tea/1/ratings", strings.NewReader(`{"owner": {"id": 1}, "rating": 6}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vars := map[string]string{"id": "1"}
+	req = mux.SetURLVars(req, vars)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(newTestServer(new(mockStore)).createTeaRatingHandler)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusUnprocessableEntity {
+		t.Errorf("POST /tea/1/ratings returned wrong status code:\n got: %v\n want: %v", status, http.StatusUnprocessableEntity)
+	}
+
+	expected := `{"error":"Rating must be between 1 and 5","code":"validation_failed"}`
+	if actual := rr.Body.String(); actual != expected {
+		t.Errorf("POST /tea/1/ratings returned unexpected body:\n got: %v\n wanted: %v", actual, expected)
+	}
+}
+
+func TestUpdateTeaRatingHandler(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPut, "/tea/1/ratings/1", strings.NewReader(`{"rating": 2, "neverPick": true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vars := map[string]string{"teaID": "1", "ownerID": "1"}
+	req = mux.SetURLVars(req, vars)
+
+	// Mock the response from the database
+	store := new(mockStore)
+	store.getUser = linkedUserMock(1)
+	store.updateTeaRating = createTeaRatingResponseMock
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(newTestServer(store).updateTeaRatingHandler)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("PUT /tea/1/ratings/1 returned wrong status code:\n got: %v\n want: %v", status, http.StatusOK)
+	}
+
+	expected := `{"owner":{"id":1,"name":"John"},"rating":2,"neverPick":true}`
+	if actual := rr.Body.String(); actual != expected {
+		t.Errorf("PUT /tea/1/ratings/1 returned unexpected body:\n got: %v\n wanted: %v", actual, expected)
+	}
+}
+
+func TestUpdateTeaRatingHandlerNotFound(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPut, "/tea/1/ratings/1", strings.NewReader(`{"rating": 2}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vars := map[string]string{"teaID": "1", "ownerID": "1"}
+	req = mux.SetURLVars(req, vars)
+
+	// Mock the response from the database
+	store := new(mockStore)
+	store.getUser = linkedUserMock(1)
+	store.updateTeaRating = func(teaID int, rating *Rating) error { return errRatingMissing }
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(newTestServer(store).updateTeaRatingHandler)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("PUT /tea/1/ratings/1 returned wrong status code:\n got: %v\n want: %v", status, http.StatusNotFound)
+	}
+
+	expected := `{"error":"This owner hasn't rated this tea","code":"not_found"}`
+	if actual := rr.Body.String(); actual != expected {
+		t.Errorf("PUT /tea/1/ratings/1 returned unexpected body:\n got: %v\n wanted: %v", actual, expected)
+	}
+}
+
+func TestDeleteTeaRatingHandler(t *testing.T) {
+	req, err := http.NewRequest(http.MethodDelete, "/tea/1/ratings/1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vars := map[string]string{"teaID": "1", "ownerID": "1"}
+	req = mux.SetURLVars(req, vars)
+
+	// Mock the response from the database
+	store := new(mockStore)
+	store.getUser = linkedUserMock(1)
+	store.deleteTeaRating = func(teaID int, owner *Owner) error { return nil }
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(newTestServer(store).deleteTeaRatingHandler)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("DELETE /tea/1/ratings/1 returned wrong status code:\n got: %v\n want: %v", status, http.StatusOK)
+	}
+
+	expected := `{"result":"success"}`
+	if actual := rr.Body.String(); actual != expected {
+		t.Errorf("DELETE /tea/1/ratings/1 returned unexpected body:\n got: %v\n wanted: %v", actual, expected)
+	}
+}
+
+func TestUpdateTeaTypeHandler(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPut, "/type/1", strings.NewReader(`{"name": "Breakfast Tea"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vars := map[string]string{"id": "1"}
+	req = mux.SetURLVars(req, vars)
+
+	// Mock the response from the database
+	store := new(mockStore)
+	store.updateTeaType = func(teaType *TeaType) error { return nil }
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(newTestServer(store).updateTeaTypeHandler)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("PUT /type/1 returned wrong status code:\n got: %v\n want: %v", status, http.StatusOK)
+	}
+
+	expected := `{"id":1,"name":"Breakfast Tea"}`
+	if actual := rr.Body.String(); actual != expected {
+		t.Errorf("PUT /type/1 returned unexpected body:\n got: %v\n wanted: %v", actual, expected)
+	}
+}
+
+func TestUpdateTeaTypeHandlerError(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPut, "/type/10", strings.NewReader(`{"name": "Breakfast Tea"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vars := map[string]string{"id": "10"}
+	req = mux.SetURLVars(req, vars)
+
+	// Mock the response from the database
+	store := new(mockStore)
+	store.updateTeaType = func(teaType *TeaType) error { return errTeaTypeMissingID }
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(newTestServer(store).updateTeaTypeHandler)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("PUT /type/10 returned wrong status code:\n got: %v\n want: %v", status, http.StatusNotFound)
+	}
+
+	expected := `{"error":"Tea type does not exist","code":"not_found"}`
+	if actual := rr.Body.String(); actual != expected {
+		t.Errorf("PUT /type/10 returned unexpected body:\n got: %v\n wanted: %v", actual, expected)
+	}
+}
+
+func TestUpdateOwnerHandler(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPut, "/owner/1", strings.NewReader(`{"name": "Johnny"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vars := map[string]string{"id": "1"}
+	req = mux.SetURLVars(req, vars)
+
+	// Mock the response from the database
+	store := new(mockStore)
+	store.updateOwner = func(owner *Owner) error { return nil }
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(newTestServer(store).updateOwnerHandler)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("PUT /owner/1 returned wrong status code:\n got: %v\n want: %v", status, http.StatusOK)
+	}
+
+	expected := `{"id":1,"name":"Johnny"}`
+	if actual := rr.Body.String(); actual != expected {
+		t.Errorf("PUT /owner/1 returned unexpected body:\n got: %v\n wanted: %v", actual, expected)
+	}
+}
+
+func TestUpdateOwnerHandlerError(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPut, "/owner/1", strings.NewReader(`{"name": "Jane"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vars := map[string]string{"id": "1"}
+	req = mux.SetURLVars(req, vars)
+
+	// Mock the response from the database
+	store := new(mockStore)
+	store.updateOwner = func(owner *Owner) error { return errOwnerNameTaken }
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(newTestServer(store).updateOwnerHandler)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusConflict {
+		t.Errorf("PUT /owner/1 returned wrong status code:\n got: %v\n want: %v", status, http.StatusConflict)
+	}
+
+	expected := `{"error":"An owner with this name already exists","code":"conflict"}`
+	if actual := rr.Body.String(); actual != expected {
+		t.Errorf("PUT /owner/1 returned unexpected body:\n got: %v\n wanted: %v", actual, expected)
+	}
+}
+
+func TestUpdateTeaHandler(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPut, "/tea/1", strings.NewReader(`{"name": "Snowball", "type": {"id": 2}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vars := map[string]string{"id": "1"}
+	req = mux.SetURLVars(req, vars)
+
+	// Mock the response from the database
+	store := new(mockStore)
+	store.updateTea = updateTeaResponseMock
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(newTestServer(store).updateTeaHandler)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("PUT /tea/1 returned wrong status code:\n got: %v\n want: %v", status, http.StatusOK)
+	}
+
+	expected := `{"id":1,"name":"Snowball","type":{"id":2,"name":"Green Tea"}}`
+	if actual := rr.Body.String(); actual != expected {
+		t.Errorf("PUT /tea/1 returned unexpected body:\n got: %v\n wanted: %v", actual, expected)
+	}
+}
+
+func updateTeaResponseMock(tea *Tea) error {
+	tea.TeaType.Name = map[int]string{1: "Black Tea", 2: "Green Tea"}[tea.TeaType.ID]
+	return nil
+}
+
+func TestPatchTeaHandler(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPatch, "/tea/1", strings.NewReader(`{"name": "Snowball Deluxe"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vars := map[string]string{"id": "1"}
+	req = mux.SetURLVars(req, vars)
+
+	// Mock the response from the database
+	store := new(mockStore)
+	store.getTea = getTeaResponseMock
+	store.updateTea = updateTeaResponseMock
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(newTestServer(store).updateTeaHandler)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("PATCH /tea/1 returned wrong status code:\n got: %v\n want: %v", status, http.StatusOK)
+	}
+
+	expected := `{"id":1,"name":"Snowball Deluxe","type":{"id":1,"name":"Black Tea"}}`
+	if actual := rr.Body.String(); actual != expected {
+		t.Errorf("PATCH /tea/1 returned unexpected body:\n got: %v\n wanted: %v", actual, expected)
+	}
+}
+
+func TestPatchTeaHandlerError(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPatch, "/tea/10", strings.NewReader(`{"name": "Snowball Deluxe"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vars := map[string]string{"id": "10"}
+	req = mux.SetURLVars(req, vars)
+
+	// Mock the response from the database
+	store := new(mockStore)
+	store.getTea = func(tea *Tea) error { return errTeaMissing }
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(newTestServer(store).updateTeaHandler)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("PATCH /tea/10 returned wrong status code:\n got: %v\n want: %v", status, http.StatusNotFound)
+	}
+
+	expected := `{"error":"Tea does not exist","code":"not_found"}`
+	if actual := rr.Body.String(); actual != expected {
+		t.Errorf("PATCH /tea/10 returned unexpected body:\n got: %v\n wanted: %v", actual, expected)
+	}
+}
+
+func TestCreateTeaHandlerWithBrewingGuide(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPost, "/tea", strings.NewReader(`{"name": "Sencha", "type": {"id": 5}, "steepSeconds": 90, "caffeine": "medium", "notes": "Grassy"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Mock the response from the database
+	store := new(mockStore)
+	var created Tea
+	store.createTea = func(tea *Tea) error {
+		tea.ID = 3
+		tea.TeaType.Name = "Green Tea"
+		created = *tea
+		return nil
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(newTestServer(store).createTeaHandler)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusCreated {
+		t.Errorf("POST /tea returned wrong status code:\n got: %v\n want: %v", status, http.StatusCreated)
+	}
+
+	expectedGuide := BrewingGuide{SteepSeconds: 90, Caffeine: CaffeineMedium}
+	if created.BrewingGuide != expectedGuide || created.Notes != "Grassy" {
+		t.Errorf("POST /tea passed unexpected tea to database: %+v", created)
+	}
+
+	expected := `{"id":3,"name":"Sencha","type":{"id":5,"name":"Green Tea"},"steepSeconds":90,"caffeine":"medium","notes":"Grassy"}`
+	if actual := rr.Body.String(); actual != expected {
+		t.Errorf("POST /tea returned unexpected body:\n got: %v\n wanted: %v", actual, expected)
+	}
+}
+
+func TestCreateTeaHandlerInvalidBrewingGuide(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPost, "/tea", strings.NewReader(`{"name": "Sencha", "type": {"id": 5}, "caffeine": "lots"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(newTestServer(new(mockStore)).createTeaHandler)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusUnprocessableEntity {
+		t.Errorf("POST /tea returned wrong status code:\n got: %v\n want: %v", status, http.StatusUnprocessableEntity)
+	}
+
+	expected := `{"error":"Caffeine must be one of none, low, medium or high","code":"validation_failed"}`
+	if actual := rr.Body.String(); actual != expected {
+		t.Errorf("POST /tea returned unexpected body:\n got: %v\n wanted: %v", actual, expected)
+	}
+}
+
+func TestTeaBrewing(t *testing.T) {
+	tea := Tea{
+		TeaType:      TeaType{BrewingGuide: BrewingGuide{BrewTemperature: 80, SteepSeconds: 120, LeafGrams: 2.5, Caffeine: CaffeineMedium}},
+		BrewingGuide: BrewingGuide{SteepSeconds: 60, Caffeine: CaffeineLow},
+	}
+
+	expected := BrewingGuide{BrewTemperature: 80, SteepSeconds: 60, LeafGrams: 2.5, Caffeine: CaffeineLow}
+	if actual := tea.Brewing(); actual != expected {
+		t.Errorf("Tea returned unexpected brewing guide:\n got: %+v\n wanted: %+v", actual, expected)
+	}
+}
+
+func TestGetWebhookDeliveriesHandler(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "/webhooks/deliveries?limit=1&offset=1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Mock the response from the database
+	store := new(mockStore)
+	store.getWebhookDeliveries = func(limit int, offset int) ([]WebhookDelivery, int, error) {
+		if limit != 1 || offset != 1 {
+			t.Errorf("GET /webhooks/deliveries passed unexpected page to database: limit %d, offset %d", limit, offset)
+		}
+		createdAt := time.Date(2020, 7, 1, 12, 0, 0, 0, time.UTC)
+		return []WebhookDelivery{{ID: 1, Event: WebhookLowStock, URL: "http://localhost/hook", Payload: "{}", Attempts: 1, StatusCode: 200, Delivered: true, CreatedAt: createdAt}}, 2, nil
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(newTestServer(store).getWebhookDeliveriesHandler)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("GET /webhooks/deliveries returned wrong status code:\n got: %v\n want: %v", status, http.StatusOK)
+	}
+
+	expected := `{"deliveries":[{"id":1,"event":"stock.low","url":"http://localhost/hook","payload":"{}","attempts":1,"statusCode":200,"delivered":true,"createdAt":"2020-07-01T12:00:00Z"}],"total":2,"limit":1,"offset":1}`
+	if actual := rr.Body.String(); actual != expected {
+		t.Errorf("GET /webhooks/deliveries returned unexpected body:\n got: %v\n wanted: %v", actual, expected)
+	}
 }
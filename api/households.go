@@ -0,0 +1,40 @@
+package main
+
+import "time"
+
+// A Household is a group of users sharing the same teas, owners and tea types, such as the people living in a flat.
+// Several households can use the same API without seeing each other's data.
+type Household struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// An Invite lets a user join a household, either when registering or afterwards. It can be used until it expires.
+type Invite struct {
+	Code      string    `json:"code"`
+	Household int       `json:"household"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// A JoinRequest asks to move the logged in user into the household of an invite.
+type JoinRequest struct {
+	Code string `json:"code"`
+}
+
+// InviteLifetime is how long an invite can be used for after it is created.
+const InviteLifetime = 7 * 24 * time.Hour
+
+// newInvite creates an invite with a random code that is hard to guess. It is added to a household by the household's store.
+// The expiry is rounded to the second, as that is how it is stored.
+func newInvite() (Invite, error) {
+	code, err := randomToken(8)
+	if err != nil {
+		return Invite{}, err
+	}
+	return Invite{Code: code, ExpiresAt: time.Now().Add(InviteLifetime).Truncate(time.Second).UTC()}, nil
+}
+
+// defaultHouseholdName gives the name of the household created for a user who registers without an invite.
+func defaultHouseholdName(username string) string {
+	return username + "'s household"
+}
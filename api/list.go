@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Fields lists of teas, owners and tea types can be sorted by. A sort starting with "-" is in descending order.
+const (
+	SortID   = "id"
+	SortName = "name"
+)
+
+// MaxListLimit is the most items a page of a list can have.
+const MaxListLimit = 100
+
+// DefaultListLimit is the number of items on a page of a list when a request doesn't give a limit.
+const DefaultListLimit = 50
+
+// NextCursorHeader gives the cursor of the next page of a list, if there is one.
+const NextCursorHeader = "X-Next-Cursor"
+
+// ListOptions choose which teas, owners or tea types are listed, and in what order. Lists requested through the API
+// always have a limit, so are split into pages, while the store gives everything if there is no limit.
+type ListOptions struct {
+	Sort       string      // SortID or SortName, with a "-" in front to sort in descending order
+	After      *ListCursor // The last item of the previous page
+	Limit      int         // Zero for no limit
+	NamePrefix string      // Only items with a name starting with this, ignoring case
+	TypeID     int         // Only teas of this type
+	OwnerID    int         // Only teas the owner has
+}
+
+// A ListCursor is the position in a list after which the next page starts, along with the sort it is for.
+type ListCursor struct {
+	Sort string `json:"s"`
+	ID   int    `json:"i"`
+	Name string `json:"n,omitempty"`
+}
+
+// descending checks whether the list is sorted in descending order.
+func (options ListOptions) descending() bool {
+	return strings.HasPrefix(options.Sort, "-")
+}
+
+// sortField gives the field the list is sorted by.
+func (options ListOptions) sortField() string {
+	if field := strings.TrimPrefix(options.Sort, "-"); field != "" {
+		return field
+	}
+	return SortID
+}
+
+// compare gives whether an item comes before (-1) or after (1) another in the list's order, by its name and ID.
+func (options ListOptions) compare(id int, name string, otherID int, otherName string) int {
+	order := 0
+	if options.sortField() == SortName {
+		order = strings.Compare(name, otherName)
+	}
+	if order == 0 && id != otherID {
+		order = 1
+		if id < otherID {
+			order = -1
+		}
+	}
+	if options.descending() {
+		return -order
+	}
+	return order
+}
+
+// includes checks whether an item comes after the cursor, and matches the name prefix.
+func (options ListOptions) includes(id int, name string) bool {
+	if options.NamePrefix != "" && !strings.HasPrefix(strings.ToLower(name), strings.ToLower(options.NamePrefix)) {
+		return false
+	}
+	return options.After == nil || options.compare(options.After.ID, options.After.Name, id, name) < 0
+}
+
+// cursor gives the cursor of the page that starts after an item.
+func (options ListOptions) cursor(id int, name string) ListCursor {
+	cursor := ListCursor{Sort: options.Sort, ID: id}
+	if options.sortField() == SortName {
+		cursor.Name = name
+	}
+	return cursor
+}
+
+// parseListOptions reads the sort, cursor, limit and name prefix of a list from the query of a request, along with the
+// filters by type and owner if they are allowed. The limit is DefaultListLimit if none is given.
+func parseListOptions(r *http.Request, filterTeas bool) (ListOptions, error) {
+	query := r.URL.Query()
+	options := ListOptions{Sort: query.Get("sort"), NamePrefix: query.Get("name")}
+	if field := options.sortField(); field != SortID && field != SortName {
+		return ListOptions{}, newError(ErrValidation, "Invalid sort, must be one of id, name, -id or -name")
+	}
+	if options.Sort == "" {
+		options.Sort = SortID
+	}
+
+	var err error
+	if options.Limit, err = parseQueryInt(r, "limit", DefaultListLimit); err != nil || options.Limit < 1 || options.Limit > MaxListLimit {
+		return ListOptions{}, newError(ErrValidation, "Invalid limit, must be between 1 and %d", MaxListLimit)
+	}
+	if cursor := query.Get("cursor"); cursor != "" {
+		after, err := decodeListCursor(cursor)
+		if err != nil {
+			return ListOptions{}, newError(ErrValidation, "Invalid cursor")
+		}
+		if after.Sort != options.Sort {
+			return ListOptions{}, newError(ErrValidation, "Cursor is for another sort, it must be used with the same sort")
+		}
+		options.After = &after
+	}
+
+	if filterTeas {
+		if options.TypeID, err = parseQueryInt(r, "type", 0); err != nil {
+			return ListOptions{}, newError(ErrValidation, "Invalid tea type ID")
+		}
+		if options.OwnerID, err = parseQueryInt(r, "owner", 0); err != nil {
+			return ListOptions{}, newError(ErrValidation, "Invalid owner ID")
+		}
+	}
+	return options, nil
+}
+
+// encodeListCursor gives a cursor as it is sent to clients, which should treat it as opaque.
+func encodeListCursor(cursor ListCursor) string {
+	encoded, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+// decodeListCursor reads a cursor sent by a client.
+func decodeListCursor(encoded string) (ListCursor, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return ListCursor{}, err
+	}
+	var cursor ListCursor
+	if err := json.Unmarshal(decoded, &cursor); err != nil {
+		return ListCursor{}, err
+	}
+	return cursor, nil
+}
+
+// setNextPage adds the cursor of the next page of a list to the response, in the X-Next-Cursor header and as the next
+// link in the Link header.
+func setNextPage(w http.ResponseWriter, r *http.Request, cursor ListCursor) {
+	encoded := encodeListCursor(cursor)
+	query := r.URL.Query()
+	query.Set("cursor", encoded)
+	next := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+
+	w.Header().Set(NextCursorHeader, encoded)
+	w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", next.String()))
+}
+
+// pageLimit gives the limit to get a page of a list from the store with, which is one more than the page's limit, so
+// that whether there is a next page is known.
+func pageLimit(options ListOptions) ListOptions {
+	if options.Limit > 0 {
+		options.Limit++
+	}
+	return options
+}
+
+// hasNextPage checks whether more items were got from the store than fit on a page.
+func hasNextPage(options ListOptions, count int) bool {
+	return options.Limit > 0 && count > options.Limit
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// A LoginConfig details how many failed logins are allowed before a username or address is locked out, and for how long.
+type LoginConfig struct {
+	MaxAttempts           int `yaml:"maxAttempts"`
+	MaxAttemptsPerAddress int `yaml:"maxAttemptsPerAddress"`
+	LockoutSeconds        int `yaml:"lockoutSeconds"`
+	MaxLockoutSeconds     int `yaml:"maxLockoutSeconds"`
+}
+
+// Limits on failed logins, used if the config doesn't say. Addresses are allowed more attempts than usernames, as
+// several people may share one.
+const (
+	DefaultLoginAttempts        = 5
+	DefaultLoginAttemptsAddress = 20
+	DefaultLockout              = time.Minute
+	DefaultMaxLockout           = time.Hour
+)
+
+// A loginLimiter tracks failed logins for each username and address. Once too many logins have failed, the username or
+// address is locked out, for twice as long as the previous time, up to a maximum.
+// A username or address that hasn't failed a login for the maximum lockout is forgotten, starting again from the
+// shortest lockout.
+type loginLimiter struct {
+	mutex      sync.Mutex
+	now        func() time.Time
+	lockout    time.Duration
+	maxLockout time.Duration
+
+	maxAttempts map[string]int // By kind of key
+	attempts    map[string]*loginAttempts
+}
+
+// A loginAttempts records the failed logins of a username or address.
+type loginAttempts struct {
+	failures    int // Since the last lockout
+	lockouts    int
+	lockedUntil time.Time
+	lastFailure time.Time
+}
+
+// The kinds of key that failed logins are tracked by.
+const (
+	loginKeyUser    = "user"
+	loginKeyAddress = "address"
+)
+
+// newLoginLimiter creates a limiter with the config's limits, using the defaults for any that aren't set.
+func newLoginLimiter(cfg LoginConfig) *loginLimiter {
+	limiter := &loginLimiter{
+		now:        time.Now,
+		lockout:    time.Duration(cfg.LockoutSeconds) * time.Second,
+		maxLockout: time.Duration(cfg.MaxLockoutSeconds) * time.Second,
+		maxAttempts: map[string]int{
+			loginKeyUser:    cfg.MaxAttempts,
+			loginKeyAddress: cfg.MaxAttemptsPerAddress,
+		},
+		attempts: make(map[string]*loginAttempts),
+	}
+	if limiter.lockout <= 0 {
+		limiter.lockout = DefaultLockout
+	}
+	if limiter.maxLockout <= 0 {
+		limiter.maxLockout = DefaultMaxLockout
+	}
+	if limiter.maxLockout < limiter.lockout {
+		limiter.maxLockout = limiter.lockout
+	}
+	if limiter.maxAttempts[loginKeyUser] <= 0 {
+		limiter.maxAttempts[loginKeyUser] = DefaultLoginAttempts
+	}
+	if limiter.maxAttempts[loginKeyAddress] <= 0 {
+		limiter.maxAttempts[loginKeyAddress] = DefaultLoginAttemptsAddress
+	}
+	return limiter
+}
+
+// lockedFor gives how long until logins are allowed for all of the keys, or zero if none of them are locked out.
+func (l *loginLimiter) lockedFor(keys ...loginKey) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	var wait time.Duration
+	now := l.now()
+	for _, key := range keys {
+		if attempts, ok := l.attempts[key.String()]; ok && attempts.lockedUntil.Sub(now) > wait {
+			wait = attempts.lockedUntil.Sub(now)
+		}
+	}
+	return wait
+}
+
+// fail records a failed login for the key, giving how long it is locked out for if this failure locked it out.
+func (l *loginLimiter) fail(key loginKey) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.now()
+	l.forgetExpired(now)
+
+	attempts, ok := l.attempts[key.String()]
+	if !ok {
+		attempts = new(loginAttempts)
+		l.attempts[key.String()] = attempts
+	}
+	attempts.failures++
+	attempts.lastFailure = now
+	if attempts.failures < l.maxAttempts[key.kind] {
+		return 0
+	}
+
+	lockout := l.lockout
+	for i := 0; i < attempts.lockouts && lockout < l.maxLockout; i++ {
+		lockout *= 2
+	}
+	if lockout > l.maxLockout {
+		lockout = l.maxLockout
+	}
+	attempts.failures = 0
+	attempts.lockouts++
+	attempts.lockedUntil = now.Add(lockout)
+	return lockout
+}
+
+// succeed forgets the failed logins of the key, after a successful login.
+func (l *loginLimiter) succeed(key loginKey) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	delete(l.attempts, key.String())
+}
+
+// forgetExpired forgets the keys that aren't locked out, and haven't failed a login for the maximum lockout.
+func (l *loginLimiter) forgetExpired(now time.Time) {
+	for key, attempts := range l.attempts {
+		if now.After(attempts.lockedUntil) && now.Sub(attempts.lastFailure) > l.maxLockout {
+			delete(l.attempts, key)
+		}
+	}
+}
+
+// A loginKey is a username or address that failed logins are tracked by.
+type loginKey struct {
+	kind  string
+	value string
+}
+
+func (key loginKey) String() string {
+	return key.kind + ":" + key.value
+}
+
+// clientAddress gives the IP address a request was sent from, without the port.
+func clientAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
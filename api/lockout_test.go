@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginLimiterLockout(t *testing.T) {
+	now := time.Date(2020, 7, 1, 12, 0, 0, 0, time.UTC)
+	limiter := newLoginLimiter(LoginConfig{MaxAttempts: 3, LockoutSeconds: 60, MaxLockoutSeconds: 200})
+	limiter.now = func() time.Time { return now }
+	john := loginKey{loginKeyUser, "john"}
+	jane := loginKey{loginKeyUser, "jane"}
+
+	// Each lockout is twice as long as the last, up to the maximum
+	for _, expected := range []time.Duration{time.Minute, 2 * time.Minute, 200 * time.Second, 200 * time.Second} {
+		for attempt := 1; attempt < 3; attempt++ {
+			if lockout := limiter.fail(john); lockout != 0 {
+				t.Fatalf("Unexpected lockout after %d failed logins: %v\n", attempt, lockout)
+			}
+		}
+		if lockout := limiter.fail(john); lockout != expected {
+			t.Errorf("Unexpected lockout:\n got: %v\n wanted: %v\n", lockout, expected)
+		}
+		if wait := limiter.lockedFor(jane, john); wait != expected {
+			t.Errorf("Unexpected wait while locked out:\n got: %v\n wanted: %v\n", wait, expected)
+		}
+		now = now.Add(expected)
+	}
+
+	if wait := limiter.lockedFor(john); wait != 0 {
+		t.Errorf("Unexpected wait after lockout ended: %v\n", wait)
+	}
+	if wait := limiter.lockedFor(jane); wait != 0 {
+		t.Errorf("Unexpected wait for another user: %v\n", wait)
+	}
+
+	// Failed logins are forgotten after a successful login
+	limiter.fail(john)
+	limiter.fail(john)
+	limiter.succeed(john)
+	if lockout := limiter.fail(john); lockout != 0 {
+		t.Errorf("Unexpected lockout after a successful login: %v\n", lockout)
+	}
+}
+
+func TestLoginLimiterForgets(t *testing.T) {
+	now := time.Date(2020, 7, 1, 12, 0, 0, 0, time.UTC)
+	limiter := newLoginLimiter(LoginConfig{MaxAttemptsPerAddress: 1, LockoutSeconds: 60, MaxLockoutSeconds: 600})
+	limiter.now = func() time.Time { return now }
+	address := loginKey{loginKeyAddress, "127.0.0.1"}
+
+	limiter.fail(address)
+	now = now.Add(time.Minute)
+	if lockout := limiter.fail(address); lockout != 2*time.Minute {
+		t.Errorf("Unexpected second lockout:\n got: %v\n wanted: %v\n", lockout, 2*time.Minute)
+	}
+
+	// A key that hasn't failed for the maximum lockout starts again from the shortest lockout
+	now = now.Add(2*time.Minute + 10*time.Minute + time.Second)
+	if lockout := limiter.fail(address); lockout != time.Minute {
+		t.Errorf("Unexpected lockout after being forgotten:\n got: %v\n wanted: %v\n", lockout, time.Minute)
+	}
+}
+
+func TestNewLoginLimiterDefaults(t *testing.T) {
+	limiter := newLoginLimiter(LoginConfig{LockoutSeconds: 7200})
+	if limiter.maxAttempts[loginKeyUser] != DefaultLoginAttempts || limiter.maxAttempts[loginKeyAddress] != DefaultLoginAttemptsAddress {
+		t.Errorf("Unexpected default attempts: %v\n", limiter.maxAttempts)
+	}
+	if limiter.lockout != 2*time.Hour || limiter.maxLockout != 2*time.Hour {
+		t.Errorf("Unexpected lockouts: %v, max: %v\n", limiter.lockout, limiter.maxLockout)
+	}
+}
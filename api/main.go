@@ -2,49 +2,30 @@ package main
 
 import (
 	"log"
+	"math/rand"
 	"net/http"
-
-	"github.com/gorilla/mux"
+	"os"
+	"time"
 )
 
 func main() {
-	cfg := getConfig()
-	SetSigningKey(cfg.Server.SigningKey)
-	initialiseDatabase(cfg)
+	rand.Seed(time.Now().UnixNano())
 
-	router := mux.NewRouter().StrictSlash(true)
-
-	// Account functions
-	router.HandleFunc("/login", loginHandler).Methods(http.MethodPost)
-	router.Handle("/changepassword", isAuthorized(changePasswordHandler)).Methods(http.MethodPost)
-	if cfg.Server.RegisterEnabled {
-		router.HandleFunc("/register", registerHandler).Methods(http.MethodPost)
+	cfg := getConfig()
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrateCommand(cfg, os.Args[2:])
+		return
 	}
 
-	// Tea Types
-	router.Handle("/types", isAuthorized(getAllTeaTypesHandler)).Methods(http.MethodGet)
-	router.Handle("/types/teas", isAuthorized(getAllTeasTypesHandler)).Methods(http.MethodGet)
-	router.Handle("/type/{id:[0-9]+}", isAuthorized(getTeaTypeHandler)).Methods(http.MethodGet)
-	router.Handle("/type", isAuthorized(createTeaTypeHandler)).Methods(http.MethodPost)
-	router.Handle("/type/{id:[0-9]+}", isAuthorized(deleteTeaTypeHandler)).Methods(http.MethodDelete)
-
-	// Tea Owners
-	router.Handle("/owners", isAuthorized(getAllOwnersHandler)).Methods(http.MethodGet)
-	router.Handle("/owners/teas", isAuthorized(getAllOwnersTeasHandler)).Methods(http.MethodGet)
-	router.Handle("/owner/{id:[0-9]+}", isAuthorized(getOwnerHandler)).Methods(http.MethodGet)
-	router.Handle("/owner", isAuthorized(createOwnerHandler)).Methods(http.MethodPost)
-	router.Handle("/owner/{id:[0-9]+}", isAuthorized(deleteOwnerHandler)).Methods(http.MethodDelete)
-
-	// Tea
-	router.Handle("/teas", isAuthorized(getAllTeasHandler)).Methods(http.MethodGet)
-	router.Handle("/teas/owners", isAuthorized(getAllTeaOwnersHandler)).Methods(http.MethodGet)
-	router.Handle("/tea/{id:[0-9]+}", isAuthorized(getTeaHandler)).Methods(http.MethodGet)
-	router.Handle("/tea", isAuthorized(createTeaHandler)).Methods(http.MethodPost)
-	router.Handle("/tea/{id:[0-9]+}", isAuthorized(deleteTeaHandler)).Methods(http.MethodDelete)
-	router.Handle("/tea/{id:[0-9]+}/owners", isAuthorized(getTeaOwnersHandler)).Methods(http.MethodGet)
-	router.Handle("/tea/{id:[0-9]+}/owner", isAuthorized(createTeaOwnerHandler)).Methods(http.MethodPost)
-	router.Handle("/tea/{teaID:[0-9]+}/owner/{ownerID:[0-9]+}", isAuthorized(deleteTeaOwnerHandler)).Methods(http.MethodDelete)
+	store := initialiseDatabase(cfg)
+	store.SetStockObserver(NewWebhooks(cfg.Webhooks, store))
+	server := NewServer(store, cfg.Server.SigningKey)
+	server.SetLoginConfig(cfg.Login)
+	if err := server.SetPasswordConfig(cfg.Password); err != nil {
+		log.Fatalf("Error in password config: %v\n", err)
+	}
+	server.SetOIDCConfig(cfg.OIDC)
 
 	addr := ":" + cfg.Server.Port
-	log.Fatal(http.ListenAndServe(addr, router))
+	log.Fatal(http.ListenAndServe(addr, server.Router(cfg.Server.RegisterEnabled)))
 }
@@ -0,0 +1,1518 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// A MemoryStore keeps the API's data in memory, so everything is lost when it is thrown away.
+// It follows the same rules as the database, such as unique names, and is useful for tests and trying out the API.
+// The store for each household shares the users and households, but has its own teas, owners and tea types.
+type MemoryStore struct {
+	*memoryServer
+	*memoryHousehold
+	household int
+}
+
+// A memoryServer holds the data shared by every household. IDs are given out here, so they are unique across every
+// household, as they are in the database.
+type memoryServer struct {
+	mutex    sync.Mutex
+	observer StockObserver
+
+	users      map[string]memoryUser // By username
+	households map[int]*memoryHousehold
+	invites    map[string]Invite // By code
+
+	refreshTokens map[string]RefreshToken // By hash
+	revokedTokens map[string]time.Time    // When each revoked access token expires, by ID
+	apiKeys       []APIKey
+	identities    map[memoryIdentity]string // Usernames, by issuer and subject
+
+	lastHouseholdID, lastTypeID, lastOwnerID, lastTeaID, lastSelectionID, lastDeliveryID, lastAuditID, lastAPIKeyID int
+}
+
+// A memoryHousehold holds a household's name and data.
+type memoryHousehold struct {
+	name       string
+	types      []TeaType
+	owners     []Owner
+	teas       []Tea // Only the ID of each tea's type is kept
+	teaOwners  []memoryTeaOwner
+	ratings    []memoryRating
+	selections []SelectionRecord // Only the IDs of each selection's tea and owners are kept
+	deliveries []WebhookDelivery
+	audit      []AuditEntry
+}
+
+// A memoryUser is a user's hashed password, role, household, and the ID of the owner they are linked to, along with
+// the hashes of their previous passwords, most recent first.
+type memoryUser struct {
+	password  string
+	role      string
+	household int
+	ownerID   int
+	history   []string
+}
+
+// A memoryIdentity is the subject an identity provider knows a user by.
+type memoryIdentity struct {
+	issuer  string
+	subject string
+}
+
+// A memoryTeaOwner records that an owner has a tea, along with their stock of it.
+type memoryTeaOwner struct {
+	teaID   int
+	ownerID int
+	stock   Stock
+}
+
+// A memoryRating is an owner's rating of a tea.
+type memoryRating struct {
+	teaID     int
+	ownerID   int
+	rating    int
+	neverPick bool
+}
+
+// NewMemoryStore creates an empty store for the default household, with the tea types and owners from the config.
+// Tea types with a default brewing guide are given it, as they are in a new database.
+func NewMemoryStore(cfg Config) *MemoryStore {
+	server := &memoryServer{users: make(map[string]memoryUser), households: make(map[int]*memoryHousehold), invites: make(map[string]Invite),
+		refreshTokens: make(map[string]RefreshToken), revokedTokens: make(map[string]time.Time), identities: make(map[memoryIdentity]string)}
+	server.lastHouseholdID = DefaultHousehold
+	server.households[DefaultHousehold] = &memoryHousehold{name: "Home"}
+
+	m := &MemoryStore{memoryServer: server, memoryHousehold: server.households[DefaultHousehold], household: DefaultHousehold}
+	for _, name := range cfg.Database.TeaTypes {
+		m.lastTypeID++
+		m.types = append(m.types, TeaType{ID: m.lastTypeID, Name: name, BrewingGuide: defaultBrewingGuides[name]})
+	}
+	for _, name := range cfg.Database.Owners {
+		m.lastOwnerID++
+		m.owners = append(m.owners, Owner{ID: m.lastOwnerID, Name: name})
+	}
+	return m
+}
+
+// SetStockObserver sets who is told about every change to an owner's stock of a tea.
+func (m *MemoryStore) SetStockObserver(observer StockObserver) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.observer = observer
+}
+
+// ForHousehold gives a store sharing the same users and households, which only holds the data of the given household.
+// A household that doesn't exist has no data, and anything added to it is thrown away.
+func (m *MemoryStore) ForHousehold(household int) Store {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	data, ok := m.households[household]
+	if !ok {
+		data = &memoryHousehold{}
+	}
+	return &MemoryStore{memoryServer: m.memoryServer, memoryHousehold: data, household: household}
+}
+
+// GetHousehold gets the store's household.
+func (m *MemoryStore) GetHousehold() (Household, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	data, ok := m.households[m.household]
+	if !ok {
+		return Household{}, errHouseholdMissing
+	}
+	return Household{ID: m.household, Name: data.name}, nil
+}
+
+// CreateHousehold adds a new household along with its first user, who is an admin.
+func (m *MemoryStore) CreateHousehold(household *Household, admin UserLogin) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, exists := m.users[admin.Username]; exists {
+		return errUsernameTaken
+	}
+	m.lastHouseholdID++
+	household.ID = m.lastHouseholdID
+	m.households[household.ID] = &memoryHousehold{name: household.Name}
+	m.users[admin.Username] = memoryUser{password: admin.Password, role: RoleAdmin, household: household.ID}
+	return nil
+}
+
+// JoinHousehold moves a user into the store's household with the given role, unlinking them from their owner.
+func (m *MemoryStore) JoinHousehold(username string, role string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	user, exists := m.users[username]
+	if !exists {
+		return errUserMissing
+	}
+	if _, ok := m.households[m.household]; !ok {
+		return errHouseholdMissing
+	}
+	m.users[username] = memoryUser{password: user.password, role: role, household: m.household}
+	return nil
+}
+
+// GetInvite gets an invite that hasn't expired, from any household, by its code.
+func (m *MemoryStore) GetInvite(code string) (Invite, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	invite, ok := m.invites[code]
+	if !ok || !invite.ExpiresAt.After(time.Now()) {
+		return Invite{}, errInviteMissing
+	}
+	return invite, nil
+}
+
+// GetInvites gets the household's invites that haven't expired, soonest to expire first.
+func (m *MemoryStore) GetInvites() ([]Invite, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	invites := make([]Invite, 0)
+	for _, invite := range m.invites {
+		if invite.Household == m.household && invite.ExpiresAt.After(time.Now()) {
+			invites = append(invites, invite)
+		}
+	}
+	sort.Slice(invites, func(i, j int) bool {
+		if !invites[i].ExpiresAt.Equal(invites[j].ExpiresAt) {
+			return invites[i].ExpiresAt.Before(invites[j].ExpiresAt)
+		}
+		return invites[i].Code < invites[j].Code
+	})
+	return invites, nil
+}
+
+// CreateInvite adds an invite to the household.
+func (m *MemoryStore) CreateInvite(invite *Invite) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	invite.Household = m.household
+	invite.ExpiresAt = time.Unix(invite.ExpiresAt.Unix(), 0).UTC()
+	m.invites[invite.Code] = *invite
+	return nil
+}
+
+// DeleteInvite deletes one of the household's invites, so it can't be used any more.
+func (m *MemoryStore) DeleteInvite(code string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if invite, ok := m.invites[code]; !ok || invite.Household != m.household {
+		return errInviteMissing
+	}
+	delete(m.invites, code)
+	return nil
+}
+
+// GetPassword gets a user's hashed password.
+func (m *MemoryStore) GetPassword(username string) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	user, ok := m.users[username]
+	if !ok {
+		return "", errUserMissing
+	}
+	return user.password, nil
+}
+
+// GetUser gets a user's role and household, from any household.
+func (m *MemoryStore) GetUser(username string) (User, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	user, ok := m.users[username]
+	if !ok {
+		return User{}, errUserMissing
+	}
+	return User{Username: username, Role: user.role, Household: user.household, OwnerID: user.ownerID}, nil
+}
+
+// GetAllUsers gets every user in the household and their role, ordered by username.
+func (m *MemoryStore) GetAllUsers() ([]User, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	users := make([]User, 0)
+	for username, user := range m.users {
+		if user.household == m.household {
+			users = append(users, User{Username: username, Role: user.role, Household: user.household, OwnerID: user.ownerID})
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	return users, nil
+}
+
+// CreateUser adds a user to the household, using a username, pre-hashed password and role.
+func (m *MemoryStore) CreateUser(user UserLogin, role string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, exists := m.users[user.Username]; exists {
+		return errUsernameTaken
+	}
+	m.users[user.Username] = memoryUser{password: user.Password, role: role, household: m.household}
+	return nil
+}
+
+// CreateFirstUser adds a user to the default household as its admin if there are no users in any household yet.
+func (m *MemoryStore) CreateFirstUser(user UserLogin) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if len(m.users) > 0 {
+		return false, nil
+	}
+	m.users[user.Username] = memoryUser{password: user.Password, role: RoleAdmin, household: DefaultHousehold}
+	return true, nil
+}
+
+// ChangePassword updates a user's password.
+func (m *MemoryStore) ChangePassword(username string, password string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	user, exists := m.users[username]
+	if !exists {
+		return errUserMissing
+	}
+	user.password = password
+	m.users[username] = user
+	return nil
+}
+
+// GetPasswordHistory gets the hashes of a user's previous passwords, most recent first.
+func (m *MemoryStore) GetPasswordHistory(username string) ([]string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	user, exists := m.users[username]
+	if !exists {
+		return make([]string, 0), nil
+	}
+	return append(make([]string, 0, len(user.history)), user.history...), nil
+}
+
+// AddPasswordHistory records the hash of a user's previous password, only keeping the given number of the most recent.
+func (m *MemoryStore) AddPasswordHistory(username string, password string, keep int) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	user, exists := m.users[username]
+	if !exists {
+		return errUserMissing
+	}
+	user.addHistory(password, keep)
+	m.users[username] = user
+	return nil
+}
+
+// addHistory records the hash of a user's previous password, only keeping the given number of the most recent.
+func (user *memoryUser) addHistory(password string, keep int) {
+	user.history = append([]string{password}, user.history...)
+	if keep < 0 {
+		keep = 0
+	}
+	if len(user.history) > keep {
+		user.history = user.history[:keep]
+	}
+}
+
+// ReplacePassword changes a user's password, records their previous one and revokes their sessions.
+func (m *MemoryStore) ReplacePassword(username string, password string, previous string, keep int) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	user, exists := m.users[username]
+	if !exists {
+		return errUserMissing
+	}
+	user.password = password
+	user.addHistory(previous, keep)
+	m.users[username] = user
+	m.revokeUserSessions(username)
+	return nil
+}
+
+// SetUserOwner links a user to an owner in the same household, or unlinks them if the owner ID is zero.
+func (m *MemoryStore) SetUserOwner(username string, ownerID int) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	user, exists := m.users[username]
+	if !exists || user.household != m.household {
+		return errUserMissing
+	}
+	if ownerID != 0 {
+		if m.ownerIndex(ownerID) < 0 {
+			return errOwnerMissing
+		}
+		for other, otherUser := range m.users {
+			if other != username && otherUser.ownerID == ownerID {
+				return errOwnerLinked
+			}
+		}
+	}
+	user.ownerID = ownerID
+	m.users[username] = user
+	return nil
+}
+
+// SetUserRole changes the role of a user in the household.
+func (m *MemoryStore) SetUserRole(username string, role string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	user, exists := m.users[username]
+	if !exists || user.household != m.household {
+		return errUserMissing
+	}
+	user.role = role
+	m.users[username] = user
+	return nil
+}
+
+// typeIndex gives the position of a tea type, or -1 if it doesn't exist.
+func (m *MemoryStore) typeIndex(id int) int {
+	for i, teaType := range m.types {
+		if teaType.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// typeNameTaken checks whether a tea type other than the given one already has the name.
+func (m *MemoryStore) typeNameTaken(name string, id int) bool {
+	for _, teaType := range m.types {
+		if teaType.Name == name && teaType.ID != id {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateRefreshToken stores a refresh token, removing any tokens that have expired.
+func (m *MemoryStore) CreateRefreshToken(token RefreshToken) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.deleteExpiredTokens()
+	if _, exists := m.users[token.Username]; !exists {
+		return errUserMissing
+	}
+	m.refreshTokens[token.Hash] = token
+	return nil
+}
+
+// UseRefreshToken gets a refresh token that hasn't expired by its hash, and deletes it so it can only be used once.
+func (m *MemoryStore) UseRefreshToken(hash string) (RefreshToken, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	token, ok := m.refreshTokens[hash]
+	if !ok || !token.ExpiresAt.After(time.Now()) {
+		return RefreshToken{}, errRefreshMissing
+	}
+	delete(m.refreshTokens, hash)
+	return token, nil
+}
+
+// RevokeSession revokes an access token until it expires, along with the refresh token issued with it.
+func (m *MemoryStore) RevokeSession(accessTokenID string, expiresAt time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.deleteExpiredTokens()
+	m.revokedTokens[accessTokenID] = expiresAt
+	for hash, token := range m.refreshTokens {
+		if token.AccessTokenID == accessTokenID {
+			delete(m.refreshTokens, hash)
+		}
+	}
+	return nil
+}
+
+// RevokeUserSessions revokes all of a user's refresh tokens, and the access tokens issued with them that haven't
+// expired yet.
+func (m *MemoryStore) RevokeUserSessions(username string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.revokeUserSessions(username)
+	return nil
+}
+
+// revokeUserSessions revokes all of a user's refresh tokens, and the access tokens issued with them, while the store is
+// locked.
+func (m *MemoryStore) revokeUserSessions(username string) {
+	m.deleteExpiredTokens()
+	for hash, token := range m.refreshTokens {
+		if token.Username == username {
+			if token.AccessExpiresAt.After(time.Now()) {
+				m.revokedTokens[token.AccessTokenID] = token.AccessExpiresAt
+			}
+			delete(m.refreshTokens, hash)
+		}
+	}
+}
+
+// IsTokenRevoked checks whether an access token has been revoked.
+func (m *MemoryStore) IsTokenRevoked(accessTokenID string) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	_, revoked := m.revokedTokens[accessTokenID]
+	return revoked, nil
+}
+
+// deleteExpiredTokens removes refresh tokens and revoked access tokens that have expired.
+func (m *MemoryStore) deleteExpiredTokens() {
+	now := time.Now()
+	for hash, token := range m.refreshTokens {
+		if !token.ExpiresAt.After(now) {
+			delete(m.refreshTokens, hash)
+		}
+	}
+	for id, expiresAt := range m.revokedTokens {
+		if !expiresAt.After(now) {
+			delete(m.revokedTokens, id)
+		}
+	}
+}
+
+// CreateAPIKey stores a new API key, giving it an ID.
+func (m *MemoryStore) CreateAPIKey(key *APIKey) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, exists := m.users[key.Username]; !exists {
+		return errUserMissing
+	}
+	m.lastAPIKeyID++
+	key.ID = m.lastAPIKeyID
+	stored := *key
+	stored.CreatedAt = time.Unix(key.CreatedAt.Unix(), 0).UTC()
+	stored.LastUsedAt = nil
+	m.apiKeys = append(m.apiKeys, stored)
+	return nil
+}
+
+// UseAPIKey gets an API key by its hash, recording that it was used.
+func (m *MemoryStore) UseAPIKey(hash string) (APIKey, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for i := range m.apiKeys {
+		if m.apiKeys[i].Hash == hash {
+			lastUsedAt := time.Unix(time.Now().Unix(), 0).UTC()
+			m.apiKeys[i].LastUsedAt = &lastUsedAt
+			return m.apiKeys[i], nil
+		}
+	}
+	return APIKey{}, errAPIKeyMissing
+}
+
+// GetAPIKeys gets all of a user's API keys, oldest first.
+func (m *MemoryStore) GetAPIKeys(username string) ([]APIKey, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	keys := make([]APIKey, 0)
+	for _, key := range m.apiKeys {
+		if key.Username == username {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// DeleteAPIKey deletes one of a user's API keys, so it can't be used any more.
+func (m *MemoryStore) DeleteAPIKey(username string, id int) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for i, key := range m.apiKeys {
+		if key.ID == id && key.Username == username {
+			m.apiKeys = append(m.apiKeys[:i], m.apiKeys[i+1:]...)
+			return nil
+		}
+	}
+	return errAPIKeyMissing
+}
+
+// GetIdentityUser gets the user linked to a subject of an identity provider.
+func (m *MemoryStore) GetIdentityUser(issuer string, subject string) (User, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	username, ok := m.identities[memoryIdentity{issuer, subject}]
+	if !ok {
+		return User{}, errIdentityMissing
+	}
+	user, ok := m.users[username]
+	if !ok {
+		return User{}, errUserMissing
+	}
+	return User{Username: username, Role: user.role, Household: user.household, OwnerID: user.ownerID}, nil
+}
+
+// CreateIdentity links a user to a subject of an identity provider.
+func (m *MemoryStore) CreateIdentity(identity Identity) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, exists := m.users[identity.Username]; !exists {
+		return errUserMissing
+	}
+	key := memoryIdentity{identity.Issuer, identity.Subject}
+	if _, exists := m.identities[key]; exists {
+		return errIdentityLinked
+	}
+	m.identities[key] = identity.Username
+	return nil
+}
+
+// CreateIdentityUser adds a user linked to a subject of an identity provider, checking both can be added first.
+func (m *MemoryStore) CreateIdentityUser(identity Identity, household *Household) (User, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, exists := m.users[identity.Username]; exists {
+		return User{}, errUsernameTaken
+	}
+	key := memoryIdentity{identity.Issuer, identity.Subject}
+	if _, exists := m.identities[key]; exists {
+		return User{}, errIdentityLinked
+	}
+
+	user := User{Username: identity.Username, Role: RoleAdmin, Household: DefaultHousehold}
+	if len(m.users) > 0 {
+		m.lastHouseholdID++
+		household.ID = m.lastHouseholdID
+		m.households[household.ID] = &memoryHousehold{name: household.Name}
+		user.Household = household.ID
+	}
+	m.users[user.Username] = memoryUser{role: user.Role, household: user.Household}
+	m.identities[key] = user.Username
+	return user, nil
+}
+
+// GetAllTeaTypes gets the tea types in a list.
+func (m *MemoryStore) GetAllTeaTypes(options ListOptions) ([]TeaType, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	indexes := listIndexes(options, len(m.types), func(i int) (int, string) { return m.types[i].ID, m.types[i].Name })
+	types := make([]TeaType, 0, len(indexes))
+	for _, i := range indexes {
+		types = append(types, m.types[i])
+	}
+	return types, nil
+}
+
+// GetTeaType gets a tea type by its ID.
+func (m *MemoryStore) GetTeaType(teaType *TeaType) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	i := m.typeIndex(teaType.ID)
+	if i < 0 {
+		return errTeaTypeMissingID
+	}
+	*teaType = m.types[i]
+	return nil
+}
+
+// CreateTeaType adds a new tea type, giving it an ID.
+func (m *MemoryStore) CreateTeaType(teaType *TeaType) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.typeNameTaken(teaType.Name, 0) {
+		return errTeaTypeNameTaken
+	}
+	m.lastTypeID++
+	teaType.ID = m.lastTypeID
+	m.types = append(m.types, *teaType)
+	return nil
+}
+
+// UpdateTeaType changes the name and default brewing guide of a tea type.
+func (m *MemoryStore) UpdateTeaType(teaType *TeaType) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	i := m.typeIndex(teaType.ID)
+	if i < 0 {
+		return errTeaTypeMissingID
+	}
+	if m.typeNameTaken(teaType.Name, teaType.ID) {
+		return errTeaTypeNameTaken
+	}
+	m.types[i] = *teaType
+	return nil
+}
+
+// DeleteTeaType deletes a tea type, which must not have any teas.
+func (m *MemoryStore) DeleteTeaType(teaType *TeaType) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	i := m.typeIndex(teaType.ID)
+	if i < 0 {
+		return errTeaTypeMissingID
+	}
+	teaType.Name = m.types[i].Name
+	for _, tea := range m.teas {
+		if tea.TeaType.ID == teaType.ID {
+			return errTeaTypeInUse
+		}
+	}
+	m.types = append(m.types[:i], m.types[i+1:]...)
+	return nil
+}
+
+// GetAllTypesTeas gets all teas by type.
+func (m *MemoryStore) GetAllTypesTeas() ([]TypeWithTeas, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	typesWithTeas := make([]TypeWithTeas, 0, len(m.types))
+	for _, teaType := range m.types {
+		typeWithTeas := TypeWithTeas{Type: TeaType{ID: teaType.ID, Name: teaType.Name}}
+		for _, tea := range m.teas {
+			if tea.TeaType.ID == teaType.ID {
+				typeWithTeas.Teas = append(typeWithTeas.Teas, Tea{ID: tea.ID, Name: tea.Name, TeaType: typeWithTeas.Type})
+			}
+		}
+		typesWithTeas = append(typesWithTeas, typeWithTeas)
+	}
+	return typesWithTeas, nil
+}
+
+// ownerIndex gives the position of an owner, or -1 if they don't exist.
+func (m *MemoryStore) ownerIndex(id int) int {
+	for i, owner := range m.owners {
+		if owner.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// ownerNameTaken checks whether an owner other than the given one already has the name.
+func (m *MemoryStore) ownerNameTaken(name string, id int) bool {
+	for _, owner := range m.owners {
+		if owner.Name == name && owner.ID != id {
+			return true
+		}
+	}
+	return false
+}
+
+// GetAllOwners gets the owners in a list.
+func (m *MemoryStore) GetAllOwners(options ListOptions) ([]Owner, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	indexes := listIndexes(options, len(m.owners), func(i int) (int, string) { return m.owners[i].ID, m.owners[i].Name })
+	owners := make([]Owner, 0, len(indexes))
+	for _, i := range indexes {
+		owners = append(owners, m.owners[i])
+	}
+	return owners, nil
+}
+
+// GetOwner gets an owner by their ID.
+func (m *MemoryStore) GetOwner(owner *Owner) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	i := m.ownerIndex(owner.ID)
+	if i < 0 {
+		return errOwnerMissing
+	}
+	*owner = m.owners[i]
+	return nil
+}
+
+// CreateOwner adds a new owner, giving them an ID.
+func (m *MemoryStore) CreateOwner(owner *Owner) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.ownerNameTaken(owner.Name, 0) {
+		return errOwnerNameTaken
+	}
+	m.lastOwnerID++
+	owner.ID = m.lastOwnerID
+	m.owners = append(m.owners, *owner)
+	return nil
+}
+
+// UpdateOwner renames an owner.
+func (m *MemoryStore) UpdateOwner(owner *Owner) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	i := m.ownerIndex(owner.ID)
+	if i < 0 {
+		return errOwnerMissing
+	}
+	if m.ownerNameTaken(owner.Name, owner.ID) {
+		return errOwnerNameTaken
+	}
+	m.owners[i] = *owner
+	return nil
+}
+
+// DeleteOwner deletes an owner, which must not own any teas. Their ratings are deleted, and they are removed from
+// the selection history.
+func (m *MemoryStore) DeleteOwner(owner *Owner) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	i := m.ownerIndex(owner.ID)
+	if i < 0 {
+		return errOwnerMissing
+	}
+	owner.Name = m.owners[i].Name
+	for _, teaOwner := range m.teaOwners {
+		if teaOwner.ownerID == owner.ID {
+			return errOwnerInUse
+		}
+	}
+	m.owners = append(m.owners[:i], m.owners[i+1:]...)
+
+	for username, user := range m.users {
+		if user.ownerID == owner.ID {
+			user.ownerID = 0
+			m.users[username] = user
+		}
+	}
+
+	ratings := m.ratings[:0]
+	for _, rating := range m.ratings {
+		if rating.ownerID != owner.ID {
+			ratings = append(ratings, rating)
+		}
+	}
+	m.ratings = ratings
+
+	for i, selection := range m.selections {
+		owners := make([]Owner, 0, len(selection.Owners))
+		for _, selectionOwner := range selection.Owners {
+			if selectionOwner.ID != owner.ID {
+				owners = append(owners, selectionOwner)
+			}
+		}
+		m.selections[i].Owners = owners
+	}
+	return nil
+}
+
+// GetAllOwnersTeas gets all teas for each owner.
+func (m *MemoryStore) GetAllOwnersTeas() ([]OwnerWithTeas, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	ownersWithTeas := make([]OwnerWithTeas, 0, len(m.owners))
+	for _, owner := range m.owners {
+		ownerWithTeas := OwnerWithTeas{Owner: owner}
+		for _, teaOwner := range m.teaOwners {
+			if teaOwner.ownerID == owner.ID {
+				ownerWithTeas.Teas = append(ownerWithTeas.Teas, m.teaSummary(teaOwner.teaID))
+			}
+		}
+		ownersWithTeas = append(ownersWithTeas, ownerWithTeas)
+	}
+	return ownersWithTeas, nil
+}
+
+// teaIndex gives the position of a tea, or -1 if it doesn't exist.
+func (m *MemoryStore) teaIndex(id int) int {
+	for i, tea := range m.teas {
+		if tea.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// teaNameTaken checks whether a tea other than the given one already has the name.
+func (m *MemoryStore) teaNameTaken(name string, id int) bool {
+	for _, tea := range m.teas {
+		if tea.Name == name && tea.ID != id {
+			return true
+		}
+	}
+	return false
+}
+
+// withType gives a tea along with all the details of its type.
+func (m *MemoryStore) withType(tea Tea) Tea {
+	if i := m.typeIndex(tea.TeaType.ID); i >= 0 {
+		tea.TeaType = m.types[i]
+	}
+	return tea
+}
+
+// teaSummary gives the ID and name of a tea, along with the ID and name of its type.
+func (m *MemoryStore) teaSummary(id int) Tea {
+	tea := m.withType(m.teas[m.teaIndex(id)])
+	return Tea{ID: tea.ID, Name: tea.Name, TeaType: TeaType{ID: tea.TeaType.ID, Name: tea.TeaType.Name}}
+}
+
+// averageRating gives the average of all the ratings of a tea, or 0 if it hasn't been rated.
+func (m *MemoryStore) averageRating(teaID int) float64 {
+	var total, count int
+	for _, rating := range m.ratings {
+		if rating.teaID == teaID && rating.rating != 0 {
+			total += rating.rating
+			count++
+		}
+	}
+	if count == 0 {
+		return 0
+	}
+	return float64(total) / float64(count)
+}
+
+// GetAllTeas gets the teas in a list.
+func (m *MemoryStore) GetAllTeas(options ListOptions) ([]Tea, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	filtered := m.filterTeas(options)
+	teas := make([]Tea, 0, len(filtered))
+	for _, i := range listIndexes(options, len(filtered), func(i int) (int, string) { return filtered[i].ID, filtered[i].Name }) {
+		tea := m.withType(filtered[i])
+		tea.AverageRating = m.averageRating(tea.ID)
+		teas = append(teas, tea)
+	}
+	return teas, nil
+}
+
+// SearchTeas gets the teas matching every term, ranked by which of their fields each term is in.
+func (m *MemoryStore) SearchTeas(terms []string, limit int) ([]Tea, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	teas := make([]Tea, 0, len(m.teas))
+	for _, tea := range m.teas {
+		teas = append(teas, m.withType(tea))
+	}
+	matches := rankTeas(teas, terms, limit, searchScore)
+	if len(matches) == 0 {
+		matches = rankTeas(teas, terms, limit, fuzzyScore)
+	}
+	for i := range matches {
+		matches[i].AverageRating = m.averageRating(matches[i].ID)
+	}
+	return matches, nil
+}
+
+// GetTea gets a tea by its ID.
+func (m *MemoryStore) GetTea(tea *Tea) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	i := m.teaIndex(tea.ID)
+	if i < 0 {
+		return errTeaMissing
+	}
+	*tea = m.withType(m.teas[i])
+	tea.AverageRating = m.averageRating(tea.ID)
+	return nil
+}
+
+// CreateTea adds a new tea of an existing type, giving it an ID.
+func (m *MemoryStore) CreateTea(tea *Tea) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	i := m.typeIndex(tea.TeaType.ID)
+	if i < 0 {
+		return errTeaTypeMissing
+	}
+	tea.TeaType.Name = m.types[i].Name
+	if m.teaNameTaken(tea.Name, 0) {
+		return errTeaNameTaken
+	}
+
+	m.lastTeaID++
+	tea.ID = m.lastTeaID
+	m.teas = append(m.teas, Tea{ID: tea.ID, Name: tea.Name, TeaType: TeaType{ID: tea.TeaType.ID}, BrewingGuide: tea.BrewingGuide, Notes: tea.Notes})
+	return nil
+}
+
+// UpdateTea changes the details of a tea, then gets the tea as it now is.
+func (m *MemoryStore) UpdateTea(tea *Tea) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.typeIndex(tea.TeaType.ID) < 0 {
+		return errTeaTypeMissing
+	}
+	i := m.teaIndex(tea.ID)
+	if i < 0 {
+		return errTeaMissing
+	}
+	if m.teaNameTaken(tea.Name, tea.ID) {
+		return errTeaNameTaken
+	}
+
+	m.teas[i] = Tea{ID: tea.ID, Name: tea.Name, TeaType: TeaType{ID: tea.TeaType.ID}, BrewingGuide: tea.BrewingGuide, Notes: tea.Notes}
+	*tea = m.withType(m.teas[i])
+	tea.AverageRating = m.averageRating(tea.ID)
+	return nil
+}
+
+// DeleteTea deletes a tea, which must not have any owners. Its ratings and selections are deleted too.
+func (m *MemoryStore) DeleteTea(tea *Tea) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	i := m.teaIndex(tea.ID)
+	if i < 0 {
+		return errTeaMissing
+	}
+	tea.Name = m.teas[i].Name
+	for _, teaOwner := range m.teaOwners {
+		if teaOwner.teaID == tea.ID {
+			return errTeaInUse
+		}
+	}
+	m.teas = append(m.teas[:i], m.teas[i+1:]...)
+
+	ratings := m.ratings[:0]
+	for _, rating := range m.ratings {
+		if rating.teaID != tea.ID {
+			ratings = append(ratings, rating)
+		}
+	}
+	m.ratings = ratings
+
+	selections := m.selections[:0]
+	for _, selection := range m.selections {
+		if selection.Tea.ID != tea.ID {
+			selections = append(selections, selection)
+		}
+	}
+	m.selections = selections
+	return nil
+}
+
+// teaOwnerIndex gives the position of an owner's ownership of a tea, or -1 if they don't own it.
+func (m *MemoryStore) teaOwnerIndex(teaID int, ownerID int) int {
+	for i, teaOwner := range m.teaOwners {
+		if teaOwner.teaID == teaID && teaOwner.ownerID == ownerID {
+			return i
+		}
+	}
+	return -1
+}
+
+// GetTeaOwners gets all owners of a tea, along with their stock of the tea.
+func (m *MemoryStore) GetTeaOwners(tea *Tea) ([]TeaOwner, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.teaOwnersOf(tea.ID), nil
+}
+
+// teaOwnersOf gives all owners of a tea, along with their stock of the tea.
+func (m *MemoryStore) teaOwnersOf(teaID int) []TeaOwner {
+	owners := make([]TeaOwner, 0)
+	for _, teaOwner := range m.teaOwners {
+		if teaOwner.teaID == teaID {
+			owner := m.owners[m.ownerIndex(teaOwner.ownerID)]
+			owners = append(owners, TeaOwner{Owner: owner, Stock: teaOwner.stock.copy()})
+		}
+	}
+	return owners
+}
+
+// GetAllTeaOwners gets all owners for the teas in a list.
+func (m *MemoryStore) GetAllTeaOwners(options ListOptions) ([]TeaWithOwners, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	filtered := m.filterTeas(options)
+	teasWithOwners := make([]TeaWithOwners, 0, len(filtered))
+	for _, i := range listIndexes(options, len(filtered), func(i int) (int, string) { return filtered[i].ID, filtered[i].Name }) {
+		teasWithOwners = append(teasWithOwners, TeaWithOwners{Tea: m.teaSummary(filtered[i].ID), Owners: m.teaOwnersOf(filtered[i].ID)})
+	}
+	return teasWithOwners, nil
+}
+
+// filterTeas gives the teas of the type, and that the owner has, if the list is filtered by them.
+func (m *MemoryStore) filterTeas(options ListOptions) []Tea {
+	teas := make([]Tea, 0, len(m.teas))
+	for _, tea := range m.teas {
+		if options.TypeID != 0 && tea.TeaType.ID != options.TypeID {
+			continue
+		}
+		if options.OwnerID != 0 && m.teaOwnerIndex(tea.ID, options.OwnerID) < 0 {
+			continue
+		}
+		teas = append(teas, tea)
+	}
+	return teas
+}
+
+// ImportCollection imports a collection. Unlike the database, the memory store can't roll back an import that fails
+// part way through, which can only happen if another request adds one of the same names at the same time.
+func (m *MemoryStore) ImportCollection(collection Collection, dryRun bool, checkOwner func(ownerID int) error) (ImportReport, error) {
+	return importCollection(m, collection, dryRun, checkOwner)
+}
+
+// CreateTeaWithOwners creates a tea and adds its owners. Everything is checked first, but unlike the database, the
+// memory store can't roll back if another request adds a tea with the same name at the same time.
+func (m *MemoryStore) CreateTeaWithOwners(tea *Tea, owners []TeaOwner, createType bool) (TeaWithOwners, error) {
+	return createTeaWithOwners(m, tea, owners, createType)
+}
+
+// CreateTeaOwner adds an owner to a tea, with their initial stock of the tea. The stock isn't tracked if no quantity is given.
+func (m *MemoryStore) CreateTeaOwner(teaID int, owner *Owner, stock Stock) (Tea, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.teaOwnerIndex(teaID, owner.ID) >= 0 {
+		return Tea{}, errTeaOwnerExists
+	}
+	if m.teaIndex(teaID) < 0 || m.ownerIndex(owner.ID) < 0 {
+		return Tea{}, errTeaOrOwnerMissing
+	}
+
+	m.teaOwners = append(m.teaOwners, memoryTeaOwner{teaID: teaID, ownerID: owner.ID, stock: stock.copy()})
+	return m.teaSummary(teaID), nil
+}
+
+// DeleteTeaOwner removes an owner from a tea.
+func (m *MemoryStore) DeleteTeaOwner(tea *Tea, owner *Owner) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	i := m.teaOwnerIndex(tea.ID, owner.ID)
+	if i < 0 {
+		return errTeaOwnerMissing
+	}
+	m.teaOwners = append(m.teaOwners[:i], m.teaOwners[i+1:]...)
+	return nil
+}
+
+// ConsumeTeaStock takes the given quantity from an owner's stock of a tea. The stock will not go below zero.
+func (m *MemoryStore) ConsumeTeaStock(teaID int, ownerID int, quantity float64) (Stock, error) {
+	return m.changeStock(teaID, ownerID, func(stock Stock) (Stock, error) {
+		if stock.Quantity == nil {
+			return stock, ErrStockNotTracked
+		}
+
+		remaining := *stock.Quantity - quantity
+		if remaining < 0 {
+			remaining = 0
+		}
+		return Stock{Quantity: &remaining, Unit: stock.Unit}, nil
+	})
+}
+
+// RestockTea adds the given quantity to an owner's stock of a tea.
+// If the stock wasn't being tracked, tracking starts from the given quantity.
+func (m *MemoryStore) RestockTea(teaID int, ownerID int, change Stock) (Stock, error) {
+	return m.changeStock(teaID, ownerID, func(stock Stock) (Stock, error) {
+		unit := change.Unit
+		var quantity float64
+		if stock.Quantity != nil {
+			if unit != "" && unit != stock.Unit {
+				return stock, ErrStockUnitMismatch
+			}
+			unit = stock.Unit
+			quantity = *stock.Quantity
+		}
+		if unit == "" {
+			unit = UnitBags
+		}
+
+		quantity += *change.Quantity
+		return Stock{Quantity: &quantity, Unit: unit}, nil
+	})
+}
+
+// changeStock replaces an owner's stock of a tea with the result of the change, then tells the stock observer about it.
+func (m *MemoryStore) changeStock(teaID int, ownerID int, change func(Stock) (Stock, error)) (Stock, error) {
+	m.mutex.Lock()
+	i := m.teaOwnerIndex(teaID, ownerID)
+	if i < 0 {
+		m.mutex.Unlock()
+		return Stock{}, errTeaOwnerMissing
+	}
+
+	before := m.teaOwners[i].stock.copy()
+	after, err := change(before.copy())
+	if err != nil {
+		m.mutex.Unlock()
+		return before, err
+	}
+	m.teaOwners[i].stock = after.copy()
+	observer := m.observer
+	m.mutex.Unlock()
+
+	// The observer is told after unlocking, as it may need to read from the store.
+	if observer != nil {
+		observer.StockChanged(m.household, teaID, ownerID, before, after)
+	}
+	return after, nil
+}
+
+// GetStock gets the stock of every owner of each tea, by tea ID, only including the given owners.
+// If no owners are given, the stock of all owners is included.
+func (m *MemoryStore) GetStock(ownerIDs []int) (map[int][]Stock, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	stock := make(map[int][]Stock)
+	for _, teaOwner := range m.teaOwners {
+		if len(ownerIDs) == 0 || containsID(ownerIDs, teaOwner.ownerID) {
+			stock[teaOwner.teaID] = append(stock[teaOwner.teaID], teaOwner.stock.copy())
+		}
+	}
+	return stock, nil
+}
+
+// copy gives a copy of the stock that doesn't share its quantity.
+func (stock Stock) copy() Stock {
+	if stock.Quantity != nil {
+		quantity := *stock.Quantity
+		stock.Quantity = &quantity
+	}
+	return stock
+}
+
+// containsID checks whether an ID is in a list of IDs.
+func containsID(ids []int, id int) bool {
+	for _, other := range ids {
+		if other == id {
+			return true
+		}
+	}
+	return false
+}
+
+// GetSelectionCandidates gets the teas that can be selected from using the given options.
+// Only teas owned by every one of the given owners are returned. If no owners are given, all teas are candidates.
+func (m *MemoryStore) GetSelectionCandidates(options SelectionOptions) ([]Tea, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	ownerIDs := uniqueIDs(options.OwnerIDs)
+	excluded := make(map[int]bool)
+	if options.ExcludePicks > 0 {
+		for i := len(m.selections) - 1; i >= 0 && i >= len(m.selections)-options.ExcludePicks; i-- {
+			excluded[m.selections[i].Tea.ID] = true
+		}
+	}
+	if !options.ExcludeSince.IsZero() {
+		for _, selection := range m.selections {
+			if selection.SelectedAt.Unix() >= options.ExcludeSince.Unix() {
+				excluded[selection.Tea.ID] = true
+			}
+		}
+	}
+	for _, rating := range m.ratings {
+		if rating.neverPick && containsID(ownerIDs, rating.ownerID) {
+			excluded[rating.teaID] = true
+		}
+	}
+
+	teas := make([]Tea, 0)
+	for _, tea := range m.teas {
+		tea = m.withType(tea)
+		if excluded[tea.ID] || (len(options.Caffeine) > 0 && !containsString(options.Caffeine, tea.Brewing().Caffeine)) {
+			continue
+		}
+
+		// Owners who aren't tracking their stock are assumed to have some left.
+		owned, inStock := 0, false
+		for _, teaOwner := range m.teaOwners {
+			if teaOwner.teaID != tea.ID || (len(ownerIDs) > 0 && !containsID(ownerIDs, teaOwner.ownerID)) {
+				continue
+			}
+			owned++
+			if teaOwner.stock.Quantity == nil || *teaOwner.stock.Quantity > 0 {
+				inStock = true
+			}
+		}
+		if owned < len(ownerIDs) || (owned > 0 && !inStock) {
+			continue
+		}
+		teas = append(teas, tea)
+	}
+	return teas, nil
+}
+
+// containsString checks whether a string is in a list of strings.
+func containsString(values []string, value string) bool {
+	for _, other := range values {
+		if other == value {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateSelection records a selection in the selection history, giving it an ID.
+func (m *MemoryStore) CreateSelection(selection *SelectionRecord) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.teaIndex(selection.Tea.ID) < 0 {
+		return errTeaMissing
+	}
+	owners := make([]Owner, 0, len(selection.Owners))
+	for _, owner := range selection.Owners {
+		if m.ownerIndex(owner.ID) < 0 {
+			return errOwnerMissing
+		}
+		owners = append(owners, Owner{ID: owner.ID})
+	}
+
+	m.lastSelectionID++
+	selection.ID = m.lastSelectionID
+	m.selections = append(m.selections, SelectionRecord{
+		ID:         selection.ID,
+		Tea:        Tea{ID: selection.Tea.ID},
+		Owners:     owners,
+		SelectedAt: time.Unix(selection.SelectedAt.Unix(), 0).UTC(),
+	})
+	return nil
+}
+
+// GetSelections gets a page of the selection history, most recent first, along with the total number of selections.
+func (m *MemoryStore) GetSelections(limit int, offset int) ([]SelectionRecord, int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	selections := make([]SelectionRecord, 0)
+	for _, i := range pageNewestFirst(len(m.selections), limit, offset) {
+		selection := m.selections[i]
+		selection.Tea = m.teaSummary(selection.Tea.ID)
+		owners := make([]Owner, 0, len(selection.Owners))
+		for _, owner := range selection.Owners {
+			owners = append(owners, m.owners[m.ownerIndex(owner.ID)])
+		}
+		selection.Owners = owners
+		selections = append(selections, selection)
+	}
+	return selections, len(m.selections), nil
+}
+
+// listIndexes gives the indexes of the items in a list, in the list's order, given the ID and name of each item.
+func listIndexes(options ListOptions, count int, item func(i int) (int, string)) []int {
+	indexes := make([]int, 0, count)
+	for i := 0; i < count; i++ {
+		if options.includes(item(i)) {
+			indexes = append(indexes, i)
+		}
+	}
+	sort.Slice(indexes, func(a, b int) bool {
+		id, name := item(indexes[a])
+		otherID, otherName := item(indexes[b])
+		return options.compare(id, name, otherID, otherName) < 0
+	})
+	if options.Limit > 0 && len(indexes) > options.Limit {
+		indexes = indexes[:options.Limit]
+	}
+	return indexes
+}
+
+// pageNewestFirst gives the positions of the records on a page, when the newest records are listed first.
+func pageNewestFirst(total int, limit int, offset int) []int {
+	positions := make([]int, 0, limit)
+	for i := total - 1 - offset; i >= 0 && len(positions) < limit; i-- {
+		positions = append(positions, i)
+	}
+	return positions
+}
+
+// GetLastSelected gets when each tea was last selected, by tea ID. Teas that have never been selected are not included.
+func (m *MemoryStore) GetLastSelected() (map[int]time.Time, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	lastSelected := make(map[int]time.Time)
+	for _, selection := range m.selections {
+		if last, ok := lastSelected[selection.Tea.ID]; !ok || selection.SelectedAt.After(last) {
+			lastSelected[selection.Tea.ID] = selection.SelectedAt
+		}
+	}
+	return lastSelected, nil
+}
+
+// ratingIndex gives the position of an owner's rating of a tea, or -1 if they haven't rated it.
+func (m *MemoryStore) ratingIndex(teaID int, ownerID int) int {
+	for i, rating := range m.ratings {
+		if rating.teaID == teaID && rating.ownerID == ownerID {
+			return i
+		}
+	}
+	return -1
+}
+
+// GetTeaRatings gets all the ratings of a tea.
+func (m *MemoryStore) GetTeaRatings(tea *Tea) ([]Rating, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	ratings := make([]Rating, 0)
+	for _, rating := range m.ratings {
+		if rating.teaID == tea.ID {
+			owner := m.owners[m.ownerIndex(rating.ownerID)]
+			ratings = append(ratings, Rating{Owner: owner, Rating: rating.rating, NeverPick: rating.neverPick})
+		}
+	}
+	return ratings, nil
+}
+
+// CreateTeaRating adds an owner's rating of a tea.
+func (m *MemoryStore) CreateTeaRating(teaID int, rating *Rating) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.ratingIndex(teaID, rating.Owner.ID) >= 0 {
+		return errRatingExists
+	}
+	i := m.ownerIndex(rating.Owner.ID)
+	if m.teaIndex(teaID) < 0 || i < 0 {
+		return errTeaOrOwnerMissing
+	}
+
+	m.ratings = append(m.ratings, memoryRating{teaID: teaID, ownerID: rating.Owner.ID, rating: rating.Rating, neverPick: rating.NeverPick})
+	rating.Owner.Name = m.owners[i].Name
+	return nil
+}
+
+// UpdateTeaRating changes an owner's existing rating of a tea.
+func (m *MemoryStore) UpdateTeaRating(teaID int, rating *Rating) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	i := m.ratingIndex(teaID, rating.Owner.ID)
+	if i < 0 {
+		return errRatingMissing
+	}
+	m.ratings[i].rating = rating.Rating
+	m.ratings[i].neverPick = rating.NeverPick
+	rating.Owner.Name = m.owners[m.ownerIndex(rating.Owner.ID)].Name
+	return nil
+}
+
+// DeleteTeaRating deletes an owner's rating of a tea.
+func (m *MemoryStore) DeleteTeaRating(teaID int, owner *Owner) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	i := m.ratingIndex(teaID, owner.ID)
+	if i < 0 {
+		return errRatingMissing
+	}
+	m.ratings = append(m.ratings[:i], m.ratings[i+1:]...)
+	return nil
+}
+
+// GetAverageRatings gets the average rating of each tea, by tea ID, only counting the ratings of the given owners.
+// If no owners are given, all ratings are used. Teas without any ratings are not included.
+func (m *MemoryStore) GetAverageRatings(ownerIDs []int) (map[int]float64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	totals := make(map[int]int)
+	counts := make(map[int]int)
+	for _, rating := range m.ratings {
+		if rating.rating != 0 && (len(ownerIDs) == 0 || containsID(ownerIDs, rating.ownerID)) {
+			totals[rating.teaID] += rating.rating
+			counts[rating.teaID]++
+		}
+	}
+
+	averages := make(map[int]float64, len(totals))
+	for teaID, total := range totals {
+		averages[teaID] = float64(total) / float64(counts[teaID])
+	}
+	return averages, nil
+}
+
+// CreateWebhookDelivery records a new webhook delivery, giving it an ID.
+func (m *MemoryStore) CreateWebhookDelivery(delivery *WebhookDelivery) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.lastDeliveryID++
+	delivery.ID = m.lastDeliveryID
+	stored := *delivery
+	stored.CreatedAt = time.Unix(delivery.CreatedAt.Unix(), 0).UTC()
+	m.deliveries = append(m.deliveries, stored)
+	return nil
+}
+
+// UpdateWebhookDelivery records the result of the latest attempt at a webhook delivery.
+func (m *MemoryStore) UpdateWebhookDelivery(delivery *WebhookDelivery) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	i := sort.Search(len(m.deliveries), func(i int) bool { return m.deliveries[i].ID >= delivery.ID })
+	if i < len(m.deliveries) && m.deliveries[i].ID == delivery.ID {
+		stored := &m.deliveries[i]
+		stored.Attempts, stored.StatusCode, stored.Delivered, stored.Error = delivery.Attempts, delivery.StatusCode, delivery.Delivered, delivery.Error
+	}
+	return nil
+}
+
+// GetWebhookDeliveries gets a page of the webhook deliveries, most recent first, along with the total number of deliveries.
+func (m *MemoryStore) GetWebhookDeliveries(limit int, offset int) ([]WebhookDelivery, int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	deliveries := make([]WebhookDelivery, 0)
+	for _, i := range pageNewestFirst(len(m.deliveries), limit, offset) {
+		deliveries = append(deliveries, m.deliveries[i])
+	}
+	return deliveries, len(m.deliveries), nil
+}
+
+// CreateAuditEntry adds an entry to the household's audit log.
+func (m *MemoryStore) CreateAuditEntry(entry *AuditEntry) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.lastAuditID++
+	entry.ID = m.lastAuditID
+	stored := *entry
+	stored.CreatedAt = time.Unix(entry.CreatedAt.Unix(), 0).UTC()
+	m.audit = append(m.audit, stored)
+	return nil
+}
+
+// GetAuditEntries gets a page of the household's audit log, most recent first, along with the total number of entries.
+func (m *MemoryStore) GetAuditEntries(limit int, offset int) ([]AuditEntry, int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	entries := make([]AuditEntry, 0)
+	for _, i := range pageNewestFirst(len(m.audit), limit, offset) {
+		entries = append(entries, m.audit[i])
+	}
+	return entries, len(m.audit), nil
+}
@@ -0,0 +1,837 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// A Migration is a versioned change to the database schema. Migrations are applied in order of their version.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(db execer, cfg Config) error
+	Down        func(db execer) error
+}
+
+// A MigrationState details whether a migration has been applied to the database.
+type MigrationState struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// migrations are all the changes to the database schema, in order. New migrations must be added to the end.
+var migrations = []Migration{
+	{1, "Create types, tea, owner, teaOwners and user tables", createTables, dropTables(`"user"`, "teaOwners", "owner", "tea", "types")},
+	{2, "Record selection history", createSelectionTables, dropTables("selectionOwners", "selections")},
+	{3, "Add tea ratings", createRatingsTable, dropTables("ratings")},
+	{4, "Add brewing guides and notes to teas and tea types", addBrewingGuideColumns, removeBrewingGuideColumns},
+	{5, "Track each owner's stock of a tea", addStockColumns, removeStockColumns},
+	{6, "Record webhook deliveries", createWebhookDeliveriesTable, dropTables("webhookDeliveries")},
+	{7, "Give each user a role", addUserRoles, removeUserRoles},
+	{8, "Link users to owners", addUserOwners, removeUserOwners},
+	{9, "Add households, which teas, owners and tea types belong to", addHouseholds, removeHouseholds},
+	{10, "Add refresh tokens and revoked access tokens", createTokenTables, dropTables("revokedTokens", "refreshTokens")},
+	{11, "Add an audit log to each household", createAuditLogTable, dropTables("auditLog")},
+	{12, "Add API keys", createAPIKeysTable, dropTables("apiKeys")},
+	{13, "Link users to identity providers", createIdentitiesTable, dropTables("identities")},
+	{14, "Record each user's previous passwords", createPasswordHistoryTable, dropTables("passwordHistory")},
+}
+
+// An execer runs statements against the database, either directly or within a transaction.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// execAll runs each of the statements in order, stopping at the first error.
+func execAll(db execer, statements ...string) error {
+	for _, statement := range statements {
+		if _, err := db.Exec(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func createTables(db execer, cfg Config) error {
+	if err := createTeaTypeTable(db, cfg.Database.TeaTypes); err != nil {
+		return err
+	}
+	if err := createTeaTable(db); err != nil {
+		return err
+	}
+	if err := createOwnerTable(db, cfg.Database.Owners); err != nil {
+		return err
+	}
+	if err := createTeaOwnersTable(db); err != nil {
+		return err
+	}
+	return createUserTable(db)
+}
+
+func createTeaTypeTable(db execer, types []string) error {
+	creationString := `CREATE TABLE types (
+							id INTEGER PRIMARY KEY AUTOINCREMENT,
+							name TEXT NOT NULL UNIQUE
+					   );`
+	if _, err := db.Exec(creationString); err != nil {
+		return err
+	}
+	return insertNames(db, "types", types)
+}
+
+func createTeaTable(db execer) error {
+	creationString := `CREATE TABLE tea (
+							id INTEGER PRIMARY KEY AUTOINCREMENT,
+							name TEXT NOT NULL UNIQUE,
+							teaType INTEGER,
+							FOREIGN KEY (teaType) REFERENCES types (id)
+								ON UPDATE CASCADE
+								ON DELETE RESTRICT
+					   );`
+	_, err := db.Exec(creationString)
+	return err
+}
+
+func createOwnerTable(db execer, owners []string) error {
+	creationString := `CREATE TABLE owner (
+							id INTEGER PRIMARY KEY AUTOINCREMENT,
+							name TEXT NOT NULL UNIQUE
+					   );`
+	if _, err := db.Exec(creationString); err != nil {
+		return err
+	}
+	return insertNames(db, "owner", owners)
+}
+
+// insertNames adds a row for each of the names to a table with a name column.
+func insertNames(db execer, table string, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+
+	var insertString strings.Builder
+	args := make([]interface{}, 0, len(names))
+	insertString.WriteString("INSERT INTO " + table + " (name) VALUES ")
+	for i, name := range names {
+		if i != 0 {
+			insertString.WriteString(", ")
+		}
+		insertString.WriteString("(" + placeholder(&args, name) + ")")
+	}
+	insertString.WriteString(";")
+
+	_, err := db.Exec(insertString.String(), args...)
+	return err
+}
+
+func createTeaOwnersTable(db execer) error {
+	creationString := `CREATE TABLE teaOwners (
+							teaID INTEGER,
+							ownerID INTEGER,
+							PRIMARY KEY(teaID, ownerID),
+							FOREIGN KEY (teaID) REFERENCES tea (id)
+								ON UPDATE CASCADE
+								ON DELETE RESTRICT,
+							FOREIGN KEY (ownerID) REFERENCES owner (id)
+								ON UPDATE CASCADE
+								ON DELETE RESTRICT
+					   );`
+	_, err := db.Exec(creationString)
+	return err
+}
+
+func createUserTable(db execer) error {
+	creationString := `CREATE TABLE "user" (
+							username TEXT NOT NULL UNIQUE PRIMARY KEY,
+							password TEXT NOT NULL
+						);`
+	_, err := db.Exec(creationString)
+	return err
+}
+
+// dropTables gives a migration step that drops the tables, in the order given.
+func dropTables(tables ...string) func(db execer) error {
+	return func(db execer) error {
+		for _, table := range tables {
+			if _, err := db.Exec("DROP TABLE " + table + ";"); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func createSelectionTables(db execer, cfg Config) error {
+	return execAll(db,
+		`CREATE TABLE selections (
+							id INTEGER PRIMARY KEY AUTOINCREMENT,
+							teaID INTEGER NOT NULL,
+							selectedAt INTEGER NOT NULL,
+							FOREIGN KEY (teaID) REFERENCES tea (id)
+								ON UPDATE CASCADE
+								ON DELETE CASCADE
+					   );`,
+		`CREATE TABLE selectionOwners (
+							selectionID INTEGER,
+							ownerID INTEGER,
+							PRIMARY KEY(selectionID, ownerID),
+							FOREIGN KEY (selectionID) REFERENCES selections (id)
+								ON UPDATE CASCADE
+								ON DELETE CASCADE,
+							FOREIGN KEY (ownerID) REFERENCES owner (id)
+								ON UPDATE CASCADE
+								ON DELETE CASCADE
+					   );`)
+}
+
+func createRatingsTable(db execer, cfg Config) error {
+	creationString := `CREATE TABLE ratings (
+							teaID INTEGER,
+							ownerID INTEGER,
+							rating INTEGER CHECK (rating BETWEEN 1 AND 5),
+							neverPick BOOLEAN NOT NULL DEFAULT 0,
+							PRIMARY KEY(teaID, ownerID),
+							FOREIGN KEY (teaID) REFERENCES tea (id)
+								ON UPDATE CASCADE
+								ON DELETE CASCADE,
+							FOREIGN KEY (ownerID) REFERENCES owner (id)
+								ON UPDATE CASCADE
+								ON DELETE CASCADE
+					   );`
+	_, err := db.Exec(creationString)
+	return err
+}
+
+// defaultBrewingGuides gives brewing guides for common types of tea, which are added to the types with these names.
+var defaultBrewingGuides = map[string]BrewingGuide{
+	"Black Tea":   {BrewTemperature: 100, SteepSeconds: 240, LeafGrams: 2.5, Caffeine: CaffeineHigh},
+	"Chai Tea":    {BrewTemperature: 100, SteepSeconds: 300, LeafGrams: 2.5, Caffeine: CaffeineHigh},
+	"Chinese Tea": {BrewTemperature: 90, SteepSeconds: 180, LeafGrams: 2.5, Caffeine: CaffeineMedium},
+	"Fruit Tea":   {BrewTemperature: 100, SteepSeconds: 300, LeafGrams: 3, Caffeine: CaffeineNone},
+	"Green Tea":   {BrewTemperature: 80, SteepSeconds: 120, LeafGrams: 2.5, Caffeine: CaffeineMedium},
+	"Herbal Tea":  {BrewTemperature: 100, SteepSeconds: 300, LeafGrams: 2.5, Caffeine: CaffeineNone},
+	"Matcha":      {BrewTemperature: 80, LeafGrams: 2, Caffeine: CaffeineHigh},
+	"Mint Tea":    {BrewTemperature: 100, SteepSeconds: 300, LeafGrams: 2, Caffeine: CaffeineNone},
+	"Oolong Tea":  {BrewTemperature: 90, SteepSeconds: 180, LeafGrams: 2.5, Caffeine: CaffeineMedium},
+	"Rooibos":     {BrewTemperature: 100, SteepSeconds: 300, LeafGrams: 2.5, Caffeine: CaffeineNone},
+	"White Tea":   {BrewTemperature: 85, SteepSeconds: 180, LeafGrams: 2.5, Caffeine: CaffeineLow},
+}
+
+func addBrewingGuideColumns(db execer, cfg Config) error {
+	err := execAll(db,
+		"ALTER TABLE types ADD COLUMN brewTemperature INTEGER;",
+		"ALTER TABLE types ADD COLUMN steepSeconds INTEGER;",
+		"ALTER TABLE types ADD COLUMN leafGrams REAL;",
+		"ALTER TABLE types ADD COLUMN caffeine TEXT;",
+		"ALTER TABLE tea ADD COLUMN brewTemperature INTEGER;",
+		"ALTER TABLE tea ADD COLUMN steepSeconds INTEGER;",
+		"ALTER TABLE tea ADD COLUMN leafGrams REAL;",
+		"ALTER TABLE tea ADD COLUMN caffeine TEXT;",
+		"ALTER TABLE tea ADD COLUMN notes TEXT;")
+	if err != nil {
+		return err
+	}
+
+	for name, guide := range defaultBrewingGuides {
+		args := append(guide.nullable(), name)
+		_, err := db.Exec("UPDATE types SET brewTemperature = $1, steepSeconds = $2, leafGrams = $3, caffeine = $4 WHERE name = $5;", args...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeBrewingGuideColumns removes the brewing guide columns. SQLite can't drop columns, so the types and tea tables
+// are rebuilt without them.
+func removeBrewingGuideColumns(db execer) error {
+	if _, ok := dialectOf(db).(postgresDialect); ok {
+		return execAll(db,
+			"ALTER TABLE types DROP COLUMN brewTemperature, DROP COLUMN steepSeconds, DROP COLUMN leafGrams, DROP COLUMN caffeine;",
+			"ALTER TABLE tea DROP COLUMN brewTemperature, DROP COLUMN steepSeconds, DROP COLUMN leafGrams, DROP COLUMN caffeine, DROP COLUMN notes;")
+	}
+
+	return execAll(db,
+		`CREATE TABLE newTypes (
+							id INTEGER PRIMARY KEY AUTOINCREMENT,
+							name TEXT NOT NULL UNIQUE
+					   );`,
+		"INSERT INTO newTypes (id, name) SELECT id, name FROM types;",
+		"DROP TABLE types;",
+		"ALTER TABLE newTypes RENAME TO types;",
+		`CREATE TABLE newTea (
+							id INTEGER PRIMARY KEY AUTOINCREMENT,
+							name TEXT NOT NULL UNIQUE,
+							teaType INTEGER,
+							FOREIGN KEY (teaType) REFERENCES types (id)
+								ON UPDATE CASCADE
+								ON DELETE RESTRICT
+					   );`,
+		"INSERT INTO newTea (id, name, teaType) SELECT id, name, teaType FROM tea;",
+		"DROP TABLE tea;",
+		"ALTER TABLE newTea RENAME TO tea;")
+}
+
+func addStockColumns(db execer, cfg Config) error {
+	return execAll(db,
+		"ALTER TABLE teaOwners ADD COLUMN quantity REAL CHECK (quantity >= 0);",
+		"ALTER TABLE teaOwners ADD COLUMN unit TEXT;")
+}
+
+// removeStockColumns removes the stock columns. SQLite can't drop columns, so the teaOwners table is rebuilt without them.
+func removeStockColumns(db execer) error {
+	if _, ok := dialectOf(db).(postgresDialect); ok {
+		return execAll(db, "ALTER TABLE teaOwners DROP COLUMN quantity, DROP COLUMN unit;")
+	}
+
+	return execAll(db,
+		`CREATE TABLE newTeaOwners (
+							teaID INTEGER,
+							ownerID INTEGER,
+							PRIMARY KEY(teaID, ownerID),
+							FOREIGN KEY (teaID) REFERENCES tea (id)
+								ON UPDATE CASCADE
+								ON DELETE RESTRICT,
+							FOREIGN KEY (ownerID) REFERENCES owner (id)
+								ON UPDATE CASCADE
+								ON DELETE RESTRICT
+					   );`,
+		"INSERT INTO newTeaOwners (teaID, ownerID) SELECT teaID, ownerID FROM teaOwners;",
+		"DROP TABLE teaOwners;",
+		"ALTER TABLE newTeaOwners RENAME TO teaOwners;")
+}
+
+func createWebhookDeliveriesTable(db execer, cfg Config) error {
+	creationString := `CREATE TABLE webhookDeliveries (
+							id INTEGER PRIMARY KEY AUTOINCREMENT,
+							event TEXT NOT NULL,
+							url TEXT NOT NULL,
+							payload TEXT NOT NULL,
+							attempts INTEGER NOT NULL DEFAULT 0,
+							statusCode INTEGER,
+							delivered BOOLEAN NOT NULL DEFAULT 0,
+							error TEXT,
+							createdAt INTEGER NOT NULL
+					   );`
+	_, err := db.Exec(creationString)
+	return err
+}
+
+// addUserRoles gives new users the member role. Existing users become admins, so they keep being able to do everything.
+func addUserRoles(db execer, cfg Config) error {
+	return execAll(db,
+		`ALTER TABLE "user" ADD COLUMN role TEXT NOT NULL DEFAULT 'member';`,
+		`UPDATE "user" SET role = 'admin';`)
+}
+
+// removeUserRoles removes the role column. SQLite can't drop columns, so the user table is rebuilt without it.
+func removeUserRoles(db execer) error {
+	if _, ok := dialectOf(db).(postgresDialect); ok {
+		return execAll(db, `ALTER TABLE "user" DROP COLUMN role;`)
+	}
+
+	return execAll(db,
+		`CREATE TABLE newUser (
+							username TEXT NOT NULL UNIQUE PRIMARY KEY,
+							password TEXT NOT NULL
+						);`,
+		`INSERT INTO newUser (username, password) SELECT username, password FROM "user";`,
+		`DROP TABLE "user";`,
+		`ALTER TABLE newUser RENAME TO "user";`)
+}
+
+// addUserOwners lets each user be linked to one owner. Each owner can only be linked to one user.
+func addUserOwners(db execer, cfg Config) error {
+	return execAll(db,
+		`ALTER TABLE "user" ADD COLUMN ownerID INTEGER REFERENCES owner (id) ON UPDATE CASCADE ON DELETE SET NULL;`,
+		`CREATE UNIQUE INDEX userOwner ON "user" (ownerID);`)
+}
+
+// removeUserOwners removes the link between users and owners. SQLite can't drop columns, so the user table is rebuilt
+// without it.
+func removeUserOwners(db execer) error {
+	if _, ok := dialectOf(db).(postgresDialect); ok {
+		return execAll(db, `ALTER TABLE "user" DROP COLUMN ownerID;`)
+	}
+
+	return execAll(db,
+		`CREATE TABLE newUser (
+							username TEXT NOT NULL UNIQUE PRIMARY KEY,
+							password TEXT NOT NULL,
+							role TEXT NOT NULL DEFAULT 'member'
+						);`,
+		`INSERT INTO newUser (username, password, role) SELECT username, password, role FROM "user";`,
+		`DROP TABLE "user";`,
+		`ALTER TABLE newUser RENAME TO "user";`)
+}
+
+// householdColumn is the column recording which household a row belongs to. Existing rows belong to the default household.
+const householdColumn = "householdID INTEGER NOT NULL DEFAULT 1 REFERENCES households (id)"
+
+// addHouseholds creates the default household, which all the existing data and users are moved into, and scopes
+// names to a household, so that different households can have teas, owners and tea types with the same names.
+// SQLite can't change constraints, so the types, tea and owner tables are rebuilt.
+func addHouseholds(db execer, cfg Config) error {
+	err := execAll(db,
+		`CREATE TABLE households (
+							id INTEGER PRIMARY KEY AUTOINCREMENT,
+							name TEXT NOT NULL
+					   );`,
+		"INSERT INTO households (name) VALUES ('Home');",
+		`CREATE TABLE invites (
+							code TEXT PRIMARY KEY,
+							householdID INTEGER NOT NULL,
+							expiresAt INTEGER NOT NULL,
+							FOREIGN KEY (householdID) REFERENCES households (id)
+								ON UPDATE CASCADE
+								ON DELETE CASCADE
+					   );`,
+		"ALTER TABLE teaOwners ADD COLUMN "+householdColumn+";",
+		`ALTER TABLE "user" ADD COLUMN `+householdColumn+";",
+		"ALTER TABLE webhookDeliveries ADD COLUMN "+householdColumn+";")
+	if err != nil {
+		return err
+	}
+
+	if _, ok := dialectOf(db).(postgresDialect); ok {
+		return execAll(db,
+			"ALTER TABLE types ADD COLUMN "+householdColumn+", DROP CONSTRAINT types_name_key, ADD UNIQUE (householdID, name);",
+			"ALTER TABLE tea ADD COLUMN "+householdColumn+", DROP CONSTRAINT tea_name_key, ADD UNIQUE (householdID, name);",
+			"ALTER TABLE owner ADD COLUMN "+householdColumn+", DROP CONSTRAINT owner_name_key, ADD UNIQUE (householdID, name);")
+	}
+
+	err = rebuildTable(db, "types", `
+							id INTEGER PRIMARY KEY AUTOINCREMENT,
+							name TEXT NOT NULL,
+							brewTemperature INTEGER,
+							steepSeconds INTEGER,
+							leafGrams REAL,
+							caffeine TEXT,
+							`+householdColumn+`,
+							UNIQUE (householdID, name)`,
+		"id, name, brewTemperature, steepSeconds, leafGrams, caffeine")
+	if err != nil {
+		return err
+	}
+	err = rebuildTable(db, "tea", `
+							id INTEGER PRIMARY KEY AUTOINCREMENT,
+							name TEXT NOT NULL,
+							teaType INTEGER,
+							brewTemperature INTEGER,
+							steepSeconds INTEGER,
+							leafGrams REAL,
+							caffeine TEXT,
+							notes TEXT,
+							`+householdColumn+`,
+							UNIQUE (householdID, name),
+							FOREIGN KEY (teaType) REFERENCES types (id)
+								ON UPDATE CASCADE
+								ON DELETE RESTRICT`,
+		"id, name, teaType, brewTemperature, steepSeconds, leafGrams, caffeine, notes")
+	if err != nil {
+		return err
+	}
+	return rebuildTable(db, "owner", `
+							id INTEGER PRIMARY KEY AUTOINCREMENT,
+							name TEXT NOT NULL,
+							`+householdColumn+`,
+							UNIQUE (householdID, name)`,
+		"id, name")
+}
+
+// removeHouseholds puts every household's data back together. It fails if two households have teas, owners or tea
+// types with the same name, as names must be unique again. SQLite can't drop columns, so the tables are rebuilt.
+func removeHouseholds(db execer) error {
+	if _, ok := dialectOf(db).(postgresDialect); ok {
+		return execAll(db,
+			"ALTER TABLE types DROP COLUMN householdID, ADD CONSTRAINT types_name_key UNIQUE (name);",
+			"ALTER TABLE tea DROP COLUMN householdID, ADD CONSTRAINT tea_name_key UNIQUE (name);",
+			"ALTER TABLE owner DROP COLUMN householdID, ADD CONSTRAINT owner_name_key UNIQUE (name);",
+			"ALTER TABLE teaOwners DROP COLUMN householdID;",
+			`ALTER TABLE "user" DROP COLUMN householdID;`,
+			"ALTER TABLE webhookDeliveries DROP COLUMN householdID;",
+			"DROP TABLE invites;",
+			"DROP TABLE households;")
+	}
+
+	err := rebuildTable(db, "types", `
+							id INTEGER PRIMARY KEY AUTOINCREMENT,
+							name TEXT NOT NULL UNIQUE,
+							brewTemperature INTEGER,
+							steepSeconds INTEGER,
+							leafGrams REAL,
+							caffeine TEXT`,
+		"id, name, brewTemperature, steepSeconds, leafGrams, caffeine")
+	if err != nil {
+		return err
+	}
+	err = rebuildTable(db, "tea", `
+							id INTEGER PRIMARY KEY AUTOINCREMENT,
+							name TEXT NOT NULL UNIQUE,
+							teaType INTEGER,
+							brewTemperature INTEGER,
+							steepSeconds INTEGER,
+							leafGrams REAL,
+							caffeine TEXT,
+							notes TEXT,
+							FOREIGN KEY (teaType) REFERENCES types (id)
+								ON UPDATE CASCADE
+								ON DELETE RESTRICT`,
+		"id, name, teaType, brewTemperature, steepSeconds, leafGrams, caffeine, notes")
+	if err != nil {
+		return err
+	}
+	err = rebuildTable(db, "owner", `
+							id INTEGER PRIMARY KEY AUTOINCREMENT,
+							name TEXT NOT NULL UNIQUE`,
+		"id, name")
+	if err != nil {
+		return err
+	}
+	err = rebuildTable(db, "teaOwners", `
+							teaID INTEGER,
+							ownerID INTEGER,
+							quantity REAL CHECK (quantity >= 0),
+							unit TEXT,
+							PRIMARY KEY(teaID, ownerID),
+							FOREIGN KEY (teaID) REFERENCES tea (id)
+								ON UPDATE CASCADE
+								ON DELETE RESTRICT,
+							FOREIGN KEY (ownerID) REFERENCES owner (id)
+								ON UPDATE CASCADE
+								ON DELETE RESTRICT`,
+		"teaID, ownerID, quantity, unit")
+	if err != nil {
+		return err
+	}
+	err = rebuildTable(db, "user", `
+							username TEXT NOT NULL UNIQUE PRIMARY KEY,
+							password TEXT NOT NULL,
+							role TEXT NOT NULL DEFAULT 'member',
+							ownerID INTEGER REFERENCES owner (id) ON UPDATE CASCADE ON DELETE SET NULL`,
+		"username, password, role, ownerID")
+	if err != nil {
+		return err
+	}
+	err = rebuildTable(db, "webhookDeliveries", `
+							id INTEGER PRIMARY KEY AUTOINCREMENT,
+							event TEXT NOT NULL,
+							url TEXT NOT NULL,
+							payload TEXT NOT NULL,
+							attempts INTEGER NOT NULL DEFAULT 0,
+							statusCode INTEGER,
+							delivered BOOLEAN NOT NULL DEFAULT 0,
+							error TEXT,
+							createdAt INTEGER NOT NULL`,
+		"id, event, url, payload, attempts, statusCode, delivered, error, createdAt")
+	if err != nil {
+		return err
+	}
+	return execAll(db,
+		`CREATE UNIQUE INDEX userOwner ON "user" (ownerID);`,
+		"DROP TABLE invites;",
+		"DROP TABLE households;")
+}
+
+// createTokenTables stores the refresh tokens of logged in users, which are deleted along with the user, and the
+// access tokens that have been revoked before they expire.
+func createTokenTables(db execer, cfg Config) error {
+	return execAll(db,
+		`CREATE TABLE refreshTokens (
+							hash TEXT PRIMARY KEY,
+							username TEXT NOT NULL,
+							accessTokenID TEXT NOT NULL,
+							accessExpiresAt INTEGER NOT NULL,
+							expiresAt INTEGER NOT NULL,
+							FOREIGN KEY (username) REFERENCES "user" (username)
+								ON UPDATE CASCADE
+								ON DELETE CASCADE
+					   );`,
+		`CREATE INDEX refreshTokensUser ON refreshTokens (username);`,
+		`CREATE TABLE revokedTokens (
+							id TEXT PRIMARY KEY,
+							expiresAt INTEGER NOT NULL
+					   );`)
+}
+
+func createAuditLogTable(db execer, cfg Config) error {
+	creationString := `CREATE TABLE auditLog (
+							id INTEGER PRIMARY KEY AUTOINCREMENT,
+							event TEXT NOT NULL,
+							username TEXT NOT NULL,
+							address TEXT NOT NULL,
+							message TEXT NOT NULL,
+							createdAt INTEGER NOT NULL,
+							householdID INTEGER NOT NULL,
+							FOREIGN KEY (householdID) REFERENCES households (id)
+								ON UPDATE CASCADE
+								ON DELETE CASCADE
+					   );`
+	_, err := db.Exec(creationString)
+	return err
+}
+
+// createAPIKeysTable stores the API keys of each user, which are deleted along with the user.
+func createAPIKeysTable(db execer, cfg Config) error {
+	creationString := `CREATE TABLE apiKeys (
+							id INTEGER PRIMARY KEY AUTOINCREMENT,
+							username TEXT NOT NULL,
+							name TEXT NOT NULL,
+							prefix TEXT NOT NULL,
+							hash TEXT NOT NULL UNIQUE,
+							permissions TEXT NOT NULL,
+							createdAt INTEGER NOT NULL,
+							lastUsedAt INTEGER,
+							FOREIGN KEY (username) REFERENCES "user" (username)
+								ON UPDATE CASCADE
+								ON DELETE CASCADE
+					   );`
+	_, err := db.Exec(creationString)
+	return err
+}
+
+// createIdentitiesTable stores the subject each identity provider knows a user by, which is deleted along with the user.
+func createIdentitiesTable(db execer, cfg Config) error {
+	creationString := `CREATE TABLE identities (
+							issuer TEXT NOT NULL,
+							subject TEXT NOT NULL,
+							username TEXT NOT NULL,
+							PRIMARY KEY (issuer, subject),
+							FOREIGN KEY (username) REFERENCES "user" (username)
+								ON UPDATE CASCADE
+								ON DELETE CASCADE
+					   );`
+	_, err := db.Exec(creationString)
+	return err
+}
+
+// createPasswordHistoryTable stores the hashes of each user's previous passwords, which are deleted along with the user.
+func createPasswordHistoryTable(db execer, cfg Config) error {
+	creationString := `CREATE TABLE passwordHistory (
+							id INTEGER PRIMARY KEY AUTOINCREMENT,
+							username TEXT NOT NULL,
+							password TEXT NOT NULL,
+							createdAt INTEGER NOT NULL,
+							FOREIGN KEY (username) REFERENCES "user" (username)
+								ON UPDATE CASCADE
+								ON DELETE CASCADE
+					   );`
+	_, err := db.Exec(creationString)
+	return err
+}
+
+// rebuildTable replaces a SQLite table with a new one, defined by the given columns and constraints, copying across
+// the values of the given columns.
+func rebuildTable(db execer, table string, definition string, columns string) error {
+	newTable := `"new` + table + `"`
+	return execAll(db,
+		"CREATE TABLE "+newTable+" ("+definition+");",
+		"INSERT INTO "+newTable+" ("+columns+") SELECT "+columns+` FROM "`+table+`";`,
+		`DROP TABLE "`+table+`";`,
+		"ALTER TABLE "+newTable+` RENAME TO "`+table+`";`)
+}
+
+// createMigrationsTable creates the table recording which migrations have been applied, if it doesn't exist.
+// Databases created before migrations were added already have the tables from the first migration, so it is marked as applied.
+func (s *SQLStore) createMigrationsTable() error {
+	if exists, err := s.dialect.tableExists(s.db, "schema_migrations"); err != nil || exists {
+		return err
+	}
+
+	creationString := `CREATE TABLE schema_migrations (
+							version INTEGER PRIMARY KEY,
+							description TEXT NOT NULL,
+							appliedAt INTEGER NOT NULL
+					   );`
+	if _, err := s.db.Exec(s.dialect.translate(creationString)); err != nil {
+		return err
+	}
+
+	if legacy, err := s.dialect.tableExists(s.db, "tea"); err != nil || !legacy {
+		return err
+	}
+	log.Println("Found a database created before migrations. Marking the first migration as applied.")
+	_, err := s.db.Exec("INSERT INTO schema_migrations (version, description, appliedAt) VALUES ($1, $2, $3);", migrations[0].Version, migrations[0].Description, time.Now().Unix())
+	return err
+}
+
+// GetMigrationStatus gives every migration, and whether it has been applied to the database.
+func (s *SQLStore) GetMigrationStatus() ([]MigrationState, error) {
+	if err := s.createMigrationsTable(); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query("SELECT version, appliedAt FROM schema_migrations;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt int64
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = time.Unix(appliedAt, 0).UTC()
+	}
+
+	states := make([]MigrationState, 0, len(migrations))
+	for _, migration := range migrations {
+		appliedAt, ok := applied[migration.Version]
+		states = append(states, MigrationState{Migration: migration, Applied: ok, AppliedAt: appliedAt})
+		delete(applied, migration.Version)
+	}
+	for version := range applied {
+		return states, fmt.Errorf("Database has migration %d applied, which this version of the API doesn't know about", version)
+	}
+	return states, nil
+}
+
+// MigrateUp applies up to the given number of pending migrations, in order. If steps is 0, all pending migrations are applied.
+// It gives the number of migrations that were applied. Instances of the API starting at the same time take turns, so
+// only the first applies the migrations.
+func (s *SQLStore) MigrateUp(cfg Config, steps int) (int, error) {
+	unlock, err := s.dialect.lockMigrations(s.db)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	states, err := s.GetMigrationStatus()
+	if err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	for _, state := range states {
+		if state.Applied {
+			continue
+		}
+		if steps > 0 && applied == steps {
+			break
+		}
+
+		log.Printf("Applying migration %d: %s\n", state.Version, state.Description)
+		err := s.runMigration(func(tx execer) error {
+			if err := state.Up(tx, cfg); err != nil {
+				return err
+			}
+			_, err := tx.Exec("INSERT INTO schema_migrations (version, description, appliedAt) VALUES ($1, $2, $3);", state.Version, state.Description, time.Now().Unix())
+			return err
+		})
+		if err != nil {
+			return applied, fmt.Errorf("applying migration %d: %v", state.Version, err)
+		}
+		applied++
+	}
+	return applied, nil
+}
+
+// MigrateDown reverts the given number of the most recently applied migrations, in reverse order.
+// It gives the number of migrations that were reverted.
+func (s *SQLStore) MigrateDown(steps int) (int, error) {
+	unlock, err := s.dialect.lockMigrations(s.db)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	states, err := s.GetMigrationStatus()
+	if err != nil {
+		return 0, err
+	}
+
+	reverted := 0
+	for i := len(states) - 1; i >= 0 && reverted < steps; i-- {
+		state := states[i]
+		if !state.Applied {
+			continue
+		}
+
+		log.Printf("Reverting migration %d: %s\n", state.Version, state.Description)
+		err := s.runMigration(func(tx execer) error {
+			if err := state.Down(tx); err != nil {
+				return err
+			}
+			_, err := tx.Exec("DELETE FROM schema_migrations WHERE version = $1;", state.Version)
+			return err
+		})
+		if err != nil {
+			return reverted, fmt.Errorf("reverting migration %d: %v", state.Version, err)
+		}
+		reverted++
+	}
+	return reverted, nil
+}
+
+// runMigration runs a migration step in a transaction, with its statements translated for the database's dialect.
+// The triggers keeping the search index up to date are dropped first, as they would stop tables being rebuilt. They
+// are created again when the database is initialised.
+func (s *SQLStore) runMigration(step func(tx execer) error) error {
+	if _, ok := s.dialect.(sqliteDialect); ok {
+		if err := dropSearchTriggers(s.db); err != nil {
+			return err
+		}
+	}
+	return s.dialect.runMigration(s.db, func(tx *sql.Tx) error {
+		return step(migrationTx{tx: tx, dialect: s.dialect})
+	})
+}
+
+const migrateUsage = "Usage: api migrate up [steps] | down [steps] | status"
+
+// runMigrateCommand handles the migrate subcommand, used to manage the database schema by hand.
+func runMigrateCommand(cfg Config, args []string) {
+	store := openDatabase(cfg)
+	defer store.Close()
+
+	if len(args) == 0 {
+		log.Fatal(migrateUsage)
+	}
+	steps := 0
+	if len(args) > 1 {
+		var err error
+		if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+			log.Fatalf("Invalid number of steps: %q\n%s", args[1], migrateUsage)
+		}
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := store.MigrateUp(cfg, steps)
+		checkError("migrating database", err)
+		log.Printf("Applied %d migrations.\n", applied)
+	case "down":
+		if steps == 0 {
+			steps = 1
+		}
+		reverted, err := store.MigrateDown(steps)
+		checkError("migrating database", err)
+		log.Printf("Reverted %d migrations.\n", reverted)
+	case "status":
+		states, err := store.GetMigrationStatus()
+		checkError("getting migration status", err)
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tAPPLIED AT\tDESCRIPTION")
+		for _, state := range states {
+			appliedAt := "pending"
+			if state.Applied {
+				appliedAt = state.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", state.Version, appliedAt, state.Description)
+		}
+		w.Flush()
+	default:
+		log.Fatal(migrateUsage)
+	}
+}
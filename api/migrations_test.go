@@ -0,0 +1,209 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// openTestDatabase opens an empty SQLite database in a temporary directory, giving a function to close and remove it.
+func openTestDatabase(t *testing.T) (*SQLStore, func()) {
+	dir, err := ioutil.TempDir("", "tea-selector")
+	if err != nil {
+		t.Fatalf("Error creating temporary directory: %v\n", err)
+	}
+
+	var cfg Config
+	cfg.Database.Location = filepath.Join(dir, "tea-store.db")
+	store := openDatabase(cfg)
+
+	return store, func() {
+		store.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// tableExists checks whether a table is in the test database.
+func tableExists(t *testing.T, store *SQLStore, table string) bool {
+	var count int
+	if err := store.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = $1;", table).Scan(&count); err != nil {
+		t.Fatalf("Error checking if table %s exists: %v\n", table, err)
+	}
+	return count > 0
+}
+
+func TestMigrationVersions(t *testing.T) {
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			t.Errorf("Migration %q has version %d, wanted %d\n", migration.Description, migration.Version, i+1)
+		}
+		if migration.Up == nil || migration.Down == nil {
+			t.Errorf("Migration %d is missing an up or down step\n", migration.Version)
+		}
+	}
+}
+
+func TestMigrateUpNewDatabase(t *testing.T) {
+	store, closeDatabase := openTestDatabase(t)
+	defer closeDatabase()
+
+	var cfg Config
+	cfg.Database.TeaTypes = []string{"Black Tea", "Earl Grey"}
+	cfg.Database.Owners = []string{"John", "Jane"}
+
+	applied, err := store.MigrateUp(cfg, 0)
+	if err != nil {
+		t.Fatalf("Unexpected error migrating database: %v\n", err)
+	}
+	if applied != len(migrations) {
+		t.Errorf("Unexpected number of migrations applied:\n got: %d\n wanted: %d\n", applied, len(migrations))
+	}
+
+	teaTypes, err := store.GetAllTeaTypes(ListOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error getting tea types: %v\n", err)
+	}
+	expectedTypes := []TeaType{
+		{ID: 1, Name: "Black Tea", BrewingGuide: defaultBrewingGuides["Black Tea"]},
+		{ID: 2, Name: "Earl Grey"},
+	}
+	if len(teaTypes) != 2 || teaTypes[0] != expectedTypes[0] || teaTypes[1] != expectedTypes[1] {
+		t.Errorf("Unexpected tea types after migrating:\n got: %v\n wanted: %v\n", teaTypes, expectedTypes)
+	}
+
+	owners, err := store.GetAllOwners(ListOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error getting owners: %v\n", err)
+	}
+	if len(owners) != 2 || owners[0].Name != "John" || owners[1].Name != "Jane" {
+		t.Errorf("Unexpected owners after migrating: %v\n", owners)
+	}
+
+	if applied, err := store.MigrateUp(cfg, 0); err != nil || applied != 0 {
+		t.Errorf("Migrating an up to date database applied %d migrations, with error: %v\n", applied, err)
+	}
+}
+
+func TestMigrateUpLegacyDatabase(t *testing.T) {
+	store, closeDatabase := openTestDatabase(t)
+	defer closeDatabase()
+
+	script, err := ioutil.ReadFile("tea-store.sql")
+	if err != nil {
+		t.Fatalf("Error reading example database: %v\n", err)
+	}
+	if _, err := store.db.Exec(string(script)); err != nil {
+		t.Fatalf("Error creating example database: %v\n", err)
+	}
+	if _, err := store.db.Exec(`INSERT INTO "user" (username, password) VALUES ('john', 'hash');`); err != nil {
+		t.Fatalf("Error adding user to example database: %v\n", err)
+	}
+
+	applied, err := store.MigrateUp(Config{}, 0)
+	if err != nil {
+		t.Fatalf("Unexpected error migrating database: %v\n", err)
+	}
+	if applied != len(migrations)-1 {
+		t.Errorf("Unexpected number of migrations applied:\n got: %d\n wanted: %d\n", applied, len(migrations)-1)
+	}
+
+	teasWithOwners, err := store.GetAllTeaOwners(ListOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error getting teas: %v\n", err)
+	}
+	if len(teasWithOwners) != 4 || teasWithOwners[1].Tea.Name != "Nearly Nirvana" || len(teasWithOwners[1].Owners) != 2 {
+		t.Errorf("Unexpected teas after migrating: %v\n", teasWithOwners)
+	}
+
+	teaType := TeaType{ID: 1}
+	if err := store.GetTeaType(&teaType); err != nil {
+		t.Fatalf("Unexpected error getting tea type: %v\n", err)
+	}
+	if teaType.BrewingGuide != defaultBrewingGuides["Black Tea"] {
+		t.Errorf("Unexpected brewing guide after migrating:\n got: %v\n wanted: %v\n", teaType.BrewingGuide, defaultBrewingGuides["Black Tea"])
+	}
+
+	if user, err := store.GetUser("john"); err != nil || user.Role != RoleAdmin {
+		t.Errorf("Existing user wasn't made an admin after migrating: %v, error: %v\n", user, err)
+	}
+}
+
+func TestMigrateDown(t *testing.T) {
+	store, closeDatabase := openTestDatabase(t)
+	defer closeDatabase()
+
+	var cfg Config
+	cfg.Database.TeaTypes = []string{"Black Tea"}
+	cfg.Database.Owners = []string{"John"}
+	if _, err := store.MigrateUp(cfg, 0); err != nil {
+		t.Fatalf("Unexpected error migrating database: %v\n", err)
+	}
+
+	quantity := 10.0
+	tea := Tea{Name: "Snowball", TeaType: TeaType{ID: 1}, BrewingGuide: BrewingGuide{SteepSeconds: 200}}
+	if err := store.CreateTea(&tea); err != nil {
+		t.Fatalf("Unexpected error creating tea: %v\n", err)
+	}
+	if _, err := store.CreateTeaOwner(tea.ID, &Owner{ID: 1}, Stock{Quantity: &quantity, Unit: UnitBags}); err != nil {
+		t.Fatalf("Unexpected error creating tea owner: %v\n", err)
+	}
+
+	reverted, err := store.MigrateDown(len(migrations) - 1)
+	if err != nil {
+		t.Fatalf("Unexpected error reverting migrations: %v\n", err)
+	}
+	if reverted != len(migrations)-1 {
+		t.Errorf("Unexpected number of migrations reverted:\n got: %d\n wanted: %d\n", reverted, len(migrations)-1)
+	}
+
+	for _, table := range []string{"selections", "selectionOwners", "ratings", "webhookDeliveries", "households", "invites", "refreshTokens", "revokedTokens", "auditLog", "apiKeys", "identities", "passwordHistory"} {
+		if tableExists(t, store, table) {
+			t.Errorf("Table %s still exists after reverting migrations\n", table)
+		}
+	}
+	if _, err := store.db.Exec("SELECT steepSeconds FROM tea;"); err == nil {
+		t.Errorf("Tea table still has brewing guide after reverting migrations\n")
+	}
+
+	var name string
+	var ownerID int
+	row := store.db.QueryRow("SELECT tea.name, teaOwners.ownerID FROM tea INNER JOIN teaOwners ON teaOwners.teaID = tea.id INNER JOIN types ON types.id = tea.teaType;")
+	if err := row.Scan(&name, &ownerID); err != nil || name != "Snowball" || ownerID != 1 {
+		t.Errorf("Unexpected tea after reverting migrations: %q, %d, error: %v\n", name, ownerID, err)
+	}
+
+	states, err := store.GetMigrationStatus()
+	if err != nil {
+		t.Fatalf("Unexpected error getting migration status: %v\n", err)
+	}
+	for _, state := range states {
+		if state.Applied != (state.Version == 1) {
+			t.Errorf("Migration %d has unexpected status after reverting: applied %v\n", state.Version, state.Applied)
+		}
+	}
+
+	if applied, err := store.MigrateUp(cfg, 2); err != nil || applied != 2 {
+		t.Errorf("Migrating up two steps applied %d migrations, with error: %v\n", applied, err)
+	}
+	if !tableExists(t, store, "ratings") || tableExists(t, store, "webhookDeliveries") {
+		t.Errorf("Unexpected tables after migrating up two steps\n")
+	}
+}
+
+func TestMigrateUnknownVersion(t *testing.T) {
+	store, closeDatabase := openTestDatabase(t)
+	defer closeDatabase()
+
+	if _, err := store.MigrateUp(Config{}, 0); err != nil {
+		t.Fatalf("Unexpected error migrating database: %v\n", err)
+	}
+	if _, err := store.db.Exec("INSERT INTO schema_migrations (version, description, appliedAt) VALUES (999, 'From the future', 0);"); err != nil {
+		t.Fatalf("Error adding unknown migration: %v\n", err)
+	}
+
+	expected := "Database has migration 999 applied, which this version of the API doesn't know about"
+	if _, err := store.MigrateUp(Config{}, 0); err == nil || err.Error() != expected {
+		t.Errorf("Unexpected error migrating database:\n got: %v\n wanted: %v\n", err, expected)
+	}
+}
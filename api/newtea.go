@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// A NewTea is a tea being created along with its owners, so that it is never left without them. The tea's type can be
+// given by name instead of ID, and can be created if it doesn't exist yet.
+type NewTea struct {
+	Tea
+	Owners []NewTeaOwner `json:"owners,omitempty"`
+}
+
+// A NewTeaOwner is an owner of a new tea. It can be given by ID, by name, or as an object with either, which can also
+// have the owner's stock of the tea.
+type NewTeaOwner struct {
+	TeaOwner
+}
+
+// UnmarshalJSON reads an owner of a new tea from a number, which is their ID, a string, which is their name, or an
+// object.
+func (owner *NewTeaOwner) UnmarshalJSON(data []byte) error {
+	var id int
+	if err := json.Unmarshal(data, &id); err == nil {
+		owner.ID = id
+		return nil
+	}
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		owner.Name = name
+		return nil
+	}
+	return json.Unmarshal(data, &owner.TeaOwner)
+}
+
+// createTeaWithOwners creates a tea in a store, then adds its owners. A type given by name is matched with the existing
+// type of that name, and created if createType is set and there isn't one. Owners without an ID are matched by name.
+// Everything is checked before anything is created, but the store should still be in a transaction that can be rolled
+// back, in case it changes part way through.
+func createTeaWithOwners(store Store, tea *Tea, owners []TeaOwner, createType bool) (TeaWithOwners, error) {
+	if tea.TeaType.ID == 0 && tea.TeaType.Name != "" {
+		if err := findTeaType(store, &tea.TeaType, createType); err != nil {
+			return TeaWithOwners{}, err
+		}
+	}
+	if err := findOwners(store, owners); err != nil {
+		return TeaWithOwners{}, err
+	}
+
+	if err := store.CreateTea(tea); err != nil {
+		return TeaWithOwners{}, err
+	}
+	for _, owner := range owners {
+		if _, err := store.CreateTeaOwner(tea.ID, &owner.Owner, owner.Stock); err != nil {
+			return TeaWithOwners{}, err
+		}
+	}
+
+	teaOwners, err := store.GetTeaOwners(tea)
+	if err != nil {
+		return TeaWithOwners{}, err
+	}
+	return TeaWithOwners{Tea: *tea, Owners: teaOwners}, nil
+}
+
+// findTeaType fills in the ID of a tea type by its name, creating it if it doesn't exist and create is set.
+func findTeaType(store Store, teaType *TeaType, create bool) error {
+	types, err := store.GetAllTeaTypes(ListOptions{})
+	if err != nil {
+		return err
+	}
+	for _, existing := range types {
+		if existing.Name == teaType.Name {
+			*teaType = existing
+			return nil
+		}
+	}
+
+	if !create {
+		return newError(ErrValidation, "Tea type %q does not exist", teaType.Name)
+	}
+	return store.CreateTeaType(teaType)
+}
+
+// findOwners checks that each owner of a new tea exists and is only given once, filling in the IDs of those given by
+// name and the names of those given by ID.
+func findOwners(store Store, owners []TeaOwner) error {
+	if len(owners) == 0 {
+		return nil
+	}
+	existing, err := store.GetAllOwners(ListOptions{})
+	if err != nil {
+		return err
+	}
+	byID := make(map[int]Owner, len(existing))
+	byName := make(map[string]Owner, len(existing))
+	for _, owner := range existing {
+		byID[owner.ID] = owner
+		byName[owner.Name] = owner
+	}
+
+	seen := make(map[int]bool, len(owners))
+	for i := range owners {
+		owner, ok := byID[owners[i].ID]
+		name := strconv.Itoa(owners[i].ID)
+		if owners[i].ID == 0 {
+			owner, ok = byName[owners[i].Name]
+			name = strconv.Quote(owners[i].Name)
+		}
+		if !ok {
+			return newError(ErrValidation, "Owner %s does not exist", name)
+		}
+		if seen[owner.ID] {
+			return newError(ErrValidation, "Owner %s is given more than once", name)
+		}
+		seen[owner.ID] = true
+		owners[i].Owner = owner
+	}
+	return nil
+}
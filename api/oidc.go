@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// An OIDCConfig details the OpenID Connect identity provider users can log in with, instead of a password. Logging in
+// with a provider is disabled if there is no issuer.
+type OIDCConfig struct {
+	Issuer       string `yaml:"issuer"`
+	ClientID     string `yaml:"clientID"`
+	ClientSecret string `yaml:"clientSecret"`
+	RedirectURL  string `yaml:"redirectURL"`
+}
+
+// OIDCStateCookie holds the state of a login with the identity provider, so that the callback can check it was
+// started by the same browser.
+const OIDCStateCookie = "oidc_state"
+
+// OIDCLoginLifetime is how long a user has to log in with the identity provider, once they have been sent to it.
+const OIDCLoginLifetime = 10 * time.Minute
+
+// An Identity links a user to the subject that an identity provider knows them by.
+type Identity struct {
+	Issuer   string
+	Subject  string
+	Username string
+}
+
+// An oidcProvider logs users in with an identity provider, using the authorization code flow. The provider's endpoints
+// and signing keys are fetched the first time they are needed.
+type oidcProvider struct {
+	cfg    OIDCConfig
+	client *http.Client
+	now    func() time.Time
+
+	mutex     sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]*rsa.PublicKey // By key ID
+	logins    map[string]oidcLogin      // By state
+}
+
+// An oidcDiscovery is the part of the provider's discovery document needed to log in.
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// An oidcLogin is a login that has been started, but not finished. The nonce must be in the ID token given for it.
+type oidcLogin struct {
+	nonce     string
+	expiresAt time.Time
+}
+
+// oidcClaims are the claims of an ID token that are used to find, or create, the user.
+type oidcClaims struct {
+	Subject           string
+	PreferredUsername string
+	Email             string
+}
+
+// newOIDCProvider creates a provider for the config.
+func newOIDCProvider(cfg OIDCConfig) *oidcProvider {
+	return &oidcProvider{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+		now:    time.Now,
+		keys:   make(map[string]*rsa.PublicKey),
+		logins: make(map[string]oidcLogin),
+	}
+}
+
+// loginURL starts a login, giving the URL of the provider to send the user to, and the state that must be sent back
+// to the callback.
+func (p *oidcProvider) loginURL(ctx context.Context) (string, string, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	state, err := randomToken(16)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomToken(16)
+	if err != nil {
+		return "", "", err
+	}
+
+	p.mutex.Lock()
+	now := p.now()
+	for s, login := range p.logins {
+		if now.After(login.expiresAt) {
+			delete(p.logins, s)
+		}
+	}
+	p.logins[state] = oidcLogin{nonce: nonce, expiresAt: now.Add(OIDCLoginLifetime)}
+	p.mutex.Unlock()
+
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {p.cfg.ClientID},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"scope":         {"openid profile email"},
+		"state":         {state},
+		"nonce":         {nonce},
+	}
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + query.Encode(), state, nil
+}
+
+// finishLogin swaps the code the provider gave the callback for an ID token, giving its claims once it has been
+// checked. Each login can only be finished once.
+func (p *oidcProvider) finishLogin(ctx context.Context, state string, code string) (oidcClaims, error) {
+	p.mutex.Lock()
+	login, ok := p.logins[state]
+	delete(p.logins, state)
+	p.mutex.Unlock()
+	if !ok || p.now().After(login.expiresAt) {
+		return oidcClaims{}, newError(ErrUnauthorized, "Login has expired, please try again")
+	}
+
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return oidcClaims{}, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"client_id":     {p.cfg.ClientID},
+		"client_secret": {p.cfg.ClientSecret},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return oidcClaims{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := p.fetchJSON(req, &tokens); err != nil {
+		return oidcClaims{}, newError(ErrUnauthorized, "Identity provider refused the login: %v", err)
+	}
+	return p.verifyIDToken(ctx, tokens.IDToken, login.nonce)
+}
+
+// verifyIDToken checks an ID token was signed by the provider, for this client, and for the login with the nonce,
+// giving its claims.
+func (p *oidcProvider) verifyIDToken(ctx context.Context, idToken string, nonce string) (oidcClaims, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return oidcClaims{}, err
+	}
+	token, err := jwt.Parse(idToken, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		keyID, _ := token.Header["kid"].(string)
+		return p.signingKey(ctx, keyID)
+	})
+	if err != nil {
+		return oidcClaims{}, newError(ErrUnauthorized, "Invalid ID token: %v", err)
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return oidcClaims{}, newError(ErrUnauthorized, "Invalid ID token")
+	}
+
+	if !claims.VerifyIssuer(discovery.Issuer, true) {
+		return oidcClaims{}, newError(ErrUnauthorized, "ID token was issued by another provider")
+	}
+	if !hasAudience(claims["aud"], p.cfg.ClientID) {
+		return oidcClaims{}, newError(ErrUnauthorized, "ID token was issued to another client")
+	}
+	if claimNonce, _ := claims["nonce"].(string); claimNonce != nonce {
+		return oidcClaims{}, newError(ErrUnauthorized, "ID token is for another login")
+	}
+
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return oidcClaims{}, newError(ErrUnauthorized, "ID token has no subject")
+	}
+	preferredUsername, _ := claims["preferred_username"].(string)
+	email, _ := claims["email"].(string)
+	return oidcClaims{Subject: subject, PreferredUsername: preferredUsername, Email: email}, nil
+}
+
+// hasAudience checks the audience of a token, which is either a single string or a list, includes the client.
+func hasAudience(audience interface{}, clientID string) bool {
+	switch audience := audience.(type) {
+	case string:
+		return audience == clientID
+	case []interface{}:
+		for _, a := range audience {
+			if a == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// discover gets the provider's endpoints from its discovery document, fetching it the first time.
+func (p *oidcProvider) discover(ctx context.Context) (oidcDiscovery, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.discovery != nil {
+		return *p.discovery, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(p.cfg.Issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return oidcDiscovery{}, err
+	}
+	var discovery oidcDiscovery
+	if err := p.fetchJSON(req, &discovery); err != nil {
+		return oidcDiscovery{}, fmt.Errorf("fetching discovery document: %w", err)
+	}
+	if discovery.Issuer != p.cfg.Issuer {
+		return oidcDiscovery{}, fmt.Errorf("discovery document is for issuer %q, not %q", discovery.Issuer, p.cfg.Issuer)
+	}
+	p.discovery = &discovery
+	return discovery, nil
+}
+
+// signingKey gives the provider's key with the ID. The keys are fetched again if the key isn't known, as the provider
+// may have rotated them.
+func (p *oidcProvider) signingKey(ctx context.Context, keyID string) (*rsa.PublicKey, error) {
+	p.mutex.Lock()
+	key, ok := p.keys[keyID]
+	p.mutex.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, discovery.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var jwks struct {
+		Keys []struct {
+			KeyType string `json:"kty"`
+			KeyID   string `json:"kid"`
+			N       string `json:"n"`
+			E       string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := p.fetchJSON(req, &jwks); err != nil {
+		return nil, fmt.Errorf("fetching signing keys: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, jwk := range jwks.Keys {
+		if jwk.KeyType != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, fmt.Errorf("decoding modulus of key %q: %w", jwk.KeyID, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, fmt.Errorf("decoding exponent of key %q: %w", jwk.KeyID, err)
+		}
+		keys[jwk.KeyID] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+
+	p.mutex.Lock()
+	p.keys = keys
+	p.mutex.Unlock()
+	if key, ok := keys[keyID]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", keyID)
+}
+
+// fetchJSON sends a request to the provider, decoding the JSON response into v.
+func (p *oidcProvider) fetchJSON(req *http.Request, v interface{}) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s responded with status %d", req.Method, req.URL, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("invalid response from %s: %w", req.URL, err)
+	}
+	return nil
+}
+
+// username gives the username to create a user with, from the username the provider knows them by, or their email
+// address if it doesn't give one.
+func (claims oidcClaims) username() string {
+	username := claims.PreferredUsername
+	if username == "" {
+		username = strings.SplitN(claims.Email, "@", 2)[0]
+	}
+	return strings.ToLower(username)
+}
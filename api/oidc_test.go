@@ -0,0 +1,293 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// A mockOIDCProvider is an identity provider for tests. Each code it gives out logs in as the subject it was given for.
+type mockOIDCProvider struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mutex sync.Mutex
+	codes map[string]jwt.MapClaims // The claims of the ID token for each code
+}
+
+// newMockOIDCProvider starts a provider with a new signing key.
+func newMockOIDCProvider(t *testing.T) *mockOIDCProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error generating signing key: %v\n", err)
+	}
+	provider := &mockOIDCProvider{key: key, codes: make(map[string]jwt.MapClaims)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		respondWithJSON(w, http.StatusOK, map[string]string{
+			"issuer":                 provider.URL,
+			"authorization_endpoint": provider.URL + "/authorize",
+			"token_endpoint":         provider.URL + "/token",
+			"jwks_uri":               provider.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		respondWithJSON(w, http.StatusOK, map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("client_id") != "tea" || r.PostFormValue("client_secret") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		provider.mutex.Lock()
+		claims, ok := provider.codes[r.PostFormValue("code")]
+		delete(provider.codes, r.PostFormValue("code"))
+		provider.mutex.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, map[string]string{"id_token": provider.sign(t, claims)})
+	})
+	provider.Server = httptest.NewServer(mux)
+	return provider
+}
+
+// sign gives an ID token with the claims, signed by the provider. It is called by the provider's handlers, so can't
+// stop the test.
+func (p *mockOIDCProvider) sign(t *testing.T, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "test"
+	signed, err := token.SignedString(p.key)
+	if err != nil {
+		t.Errorf("Error signing ID token: %v\n", err)
+	}
+	return signed
+}
+
+// authorize logs in to the provider as the subject, in place of the user, giving the callback URL the provider would
+// send them back to. The claims are added to the ID token, or replace the ones the provider would give.
+func (p *mockOIDCProvider) authorize(t *testing.T, loginURL string, subject string, claims jwt.MapClaims) string {
+	login, err := url.Parse(loginURL)
+	if err != nil {
+		t.Fatalf("Error parsing login URL %q: %v\n", loginURL, err)
+	}
+	query := login.Query()
+	idClaims := jwt.MapClaims{
+		"iss":   p.URL,
+		"sub":   subject,
+		"aud":   query.Get("client_id"),
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"nonce": query.Get("nonce"),
+	}
+	for name, value := range claims {
+		idClaims[name] = value
+	}
+	code, err := randomToken(8)
+	if err != nil {
+		t.Fatalf("Error generating code: %v\n", err)
+	}
+	p.mutex.Lock()
+	p.codes[code] = idClaims
+	p.mutex.Unlock()
+	return query.Get("redirect_uri") + "?" + url.Values{"code": {code}, "state": {query.Get("state")}}.Encode()
+}
+
+// oidcTestServer starts a test server that logs in with the provider.
+func oidcTestServer(provider *mockOIDCProvider, registerEnabled bool) *httptest.Server {
+	return oidcStoreTestServer(provider, NewMemoryStore(storeTestConfig()), registerEnabled)
+}
+
+// oidcStoreTestServer starts a server using a store, which logs in with a provider.
+func oidcStoreTestServer(provider *mockOIDCProvider, store Store, registerEnabled bool) *httptest.Server {
+	server := NewServer(store, "signingKey")
+	testServer := httptest.NewUnstartedServer(nil)
+	server.SetOIDCConfig(OIDCConfig{Issuer: provider.URL, ClientID: "tea", ClientSecret: "secret", RedirectURL: "http://" + testServer.Listener.Addr().String() + "/auth/oidc/callback"})
+	testServer.Config.Handler = server.Router(registerEnabled)
+	testServer.Start()
+	return testServer
+}
+
+// startOIDCLogin starts a login with a test server, using a client that keeps cookies and doesn't follow redirects,
+// giving the client and the URL of the provider it was sent to.
+func startOIDCLogin(t *testing.T, server *httptest.Server) (*http.Client, string) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("Error creating cookie jar: %v\n", err)
+	}
+	client := &http.Client{Jar: jar, CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+	resp, err := client.Get(server.URL + "/auth/oidc/login")
+	if err != nil {
+		t.Fatalf("Error starting login: %v\n", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("Unexpected status starting login: %d\n", resp.StatusCode)
+	}
+	return client, resp.Header.Get("Location")
+}
+
+// oidcCallback sends the callback of a login to the test server, giving the session and status of the response.
+func oidcCallback(t *testing.T, client *http.Client, callbackURL string) (Session, int) {
+	resp, err := client.Get(callbackURL)
+	if err != nil {
+		t.Fatalf("Error sending callback: %v\n", err)
+	}
+	defer resp.Body.Close()
+
+	var session Session
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&session); err != nil {
+			t.Fatalf("Error decoding session: %v\n", err)
+		}
+	}
+	return session, resp.StatusCode
+}
+
+func TestOIDCLogin(t *testing.T) {
+	provider := newMockOIDCProvider(t)
+	defer provider.Close()
+	server := oidcTestServer(provider, true)
+	defer server.Close()
+
+	// The first login creates the user
+	client, loginURL := startOIDCLogin(t, server)
+	session, status := oidcCallback(t, client, provider.authorize(t, loginURL, "1234", jwt.MapClaims{"preferred_username": "John"}))
+	if status != http.StatusOK || session.Token == "" || session.RefreshToken == "" {
+		t.Fatalf("Unexpected session: %+v, status: %d\n", session, status)
+	}
+	var profile Profile
+	if status := serverRequest(t, server, http.MethodGet, "/me", session.Token, nil, &profile); status != http.StatusOK || profile.Username != "john" || profile.Role != RoleAdmin {
+		t.Errorf("Unexpected profile: %+v, status: %d\n", profile, status)
+	}
+
+	// Later logins find the same user, even if their username at the provider has changed
+	client, loginURL = startOIDCLogin(t, server)
+	session, status = oidcCallback(t, client, provider.authorize(t, loginURL, "1234", jwt.MapClaims{"preferred_username": "johnny"}))
+	if status != http.StatusOK {
+		t.Fatalf("Unexpected status logging in again: %d\n", status)
+	}
+	if status := serverRequest(t, server, http.MethodGet, "/me", session.Token, nil, &profile); status != http.StatusOK || profile.Username != "john" {
+		t.Errorf("Unexpected profile after logging in again: %+v, status: %d\n", profile, status)
+	}
+
+	// Users without a username at the provider are named after their email address
+	client, loginURL = startOIDCLogin(t, server)
+	session, status = oidcCallback(t, client, provider.authorize(t, loginURL, "5678", jwt.MapClaims{"email": "Jane@example.com"}))
+	if status != http.StatusOK {
+		t.Fatalf("Unexpected status logging in with email: %d\n", status)
+	}
+	if status := serverRequest(t, server, http.MethodGet, "/me", session.Token, nil, &profile); status != http.StatusOK || profile.Username != "jane" || profile.Role != RoleAdmin {
+		t.Errorf("Unexpected profile of user named after email: %+v, status: %d\n", profile, status)
+	}
+
+	// A username that is already taken isn't linked to the login
+	client, loginURL = startOIDCLogin(t, server)
+	if _, status := oidcCallback(t, client, provider.authorize(t, loginURL, "9012", jwt.MapClaims{"preferred_username": "john"})); status != http.StatusConflict {
+		t.Errorf("Unexpected status logging in with a taken username: %d\n", status)
+	}
+}
+
+func TestOIDCLoginRefused(t *testing.T) {
+	provider := newMockOIDCProvider(t)
+	defer provider.Close()
+	server := oidcTestServer(provider, true)
+	defer server.Close()
+
+	tests := map[string]jwt.MapClaims{
+		"wrong issuer":   {"iss": "https://other.example.com"},
+		"wrong audience": {"aud": "other"},
+		"wrong nonce":    {"nonce": "other"},
+		"expired":        {"exp": time.Now().Add(-time.Minute).Unix()},
+		"no subject":     {"sub": ""},
+	}
+	for name, claims := range tests {
+		client, loginURL := startOIDCLogin(t, server)
+		claims["preferred_username"] = "john"
+		if _, status := oidcCallback(t, client, provider.authorize(t, loginURL, "1234", claims)); status != http.StatusUnauthorized {
+			t.Errorf("Unexpected status logging in with %s: %d\n", name, status)
+		}
+	}
+
+	// The callback must be sent by the browser that started the login, and only once
+	client, loginURL := startOIDCLogin(t, server)
+	callbackURL := provider.authorize(t, loginURL, "1234", jwt.MapClaims{"preferred_username": "john"})
+	if _, status := oidcCallback(t, server.Client(), callbackURL); status != http.StatusUnauthorized {
+		t.Errorf("Unexpected status sending callback without the state cookie: %d\n", status)
+	}
+	if _, status := oidcCallback(t, client, callbackURL); status != http.StatusOK {
+		t.Errorf("Unexpected status sending callback: %d\n", status)
+	}
+	if _, status := oidcCallback(t, client, callbackURL); status != http.StatusUnauthorized {
+		t.Errorf("Unexpected status sending callback twice: %d\n", status)
+	}
+}
+
+func TestOIDCLoginRegisterDisabled(t *testing.T) {
+	provider := newMockOIDCProvider(t)
+	defer provider.Close()
+	server := oidcTestServer(provider, false)
+	defer server.Close()
+
+	client, loginURL := startOIDCLogin(t, server)
+	if _, status := oidcCallback(t, client, provider.authorize(t, loginURL, "1234", jwt.MapClaims{"preferred_username": "john"})); status != http.StatusForbidden {
+		t.Errorf("Unexpected status logging in as a new user with registration disabled: %d\n", status)
+	}
+}
+
+func TestOIDCLoginIdentityFails(t *testing.T) {
+	provider := newMockOIDCProvider(t)
+	defer provider.Close()
+	store, closeDatabase := openTestDatabase(t)
+	defer closeDatabase()
+	if _, err := store.MigrateUp(storeTestConfig(), 0); err != nil {
+		t.Fatalf("Unexpected error migrating database: %v\n", err)
+	}
+	server := oidcStoreTestServer(provider, store, true)
+	defer server.Close()
+
+	// If the login can't be linked to the new user, the user isn't created either
+	if _, err := store.db.Exec("CREATE TRIGGER failIdentity BEFORE INSERT ON identities BEGIN SELECT RAISE(ABORT, 'Failed to link identity'); END;"); err != nil {
+		t.Fatalf("Unexpected error creating trigger: %v\n", err)
+	}
+	client, loginURL := startOIDCLogin(t, server)
+	if _, status := oidcCallback(t, client, provider.authorize(t, loginURL, "1234", jwt.MapClaims{"preferred_username": "john"})); status != http.StatusInternalServerError {
+		t.Errorf("Unexpected status logging in when the identity can't be linked: %d\n", status)
+	}
+	if _, err := store.GetUser("john"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("User was left without their identity:\n got: %v\n wanted: %v\n", err, ErrNotFound)
+	}
+
+	// So logging in again works, and they are still the first user
+	if _, err := store.db.Exec("DROP TRIGGER failIdentity;"); err != nil {
+		t.Fatalf("Unexpected error dropping trigger: %v\n", err)
+	}
+	client, loginURL = startOIDCLogin(t, server)
+	session, status := oidcCallback(t, client, provider.authorize(t, loginURL, "1234", jwt.MapClaims{"preferred_username": "john"}))
+	if status != http.StatusOK {
+		t.Fatalf("Unexpected status logging in again: %d\n", status)
+	}
+	var household Household
+	if status := serverRequest(t, server, http.MethodGet, "/household", session.Token, nil, &household); status != http.StatusOK || household.ID != DefaultHousehold {
+		t.Errorf("Unexpected household of first user: %+v, status: %d\n", household, status)
+	}
+}
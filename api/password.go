@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// A PasswordConfig details how passwords are hashed, and which passwords are allowed.
+type PasswordConfig struct {
+	Cost         int    `yaml:"cost"`
+	MinLength    int    `yaml:"minLength"`
+	BreachedList string `yaml:"breachedList"`
+	History      int    `yaml:"history"`
+}
+
+// Password settings, used if the config doesn't say.
+const (
+	DefaultPasswordCost      = bcrypt.DefaultCost
+	DefaultPasswordMinLength = 8
+)
+
+// A passwordPolicy hashes passwords, and checks new passwords are long enough and aren't in the list of breached
+// passwords.
+type passwordPolicy struct {
+	cost      int
+	minLength int
+	history   int             // How many of a user's most recent passwords can't be used again, including the current one
+	breached  map[string]bool // SHA-1 hashes of the breached passwords, in hex
+
+	dummyOnce sync.Once
+	dummy     string
+}
+
+// newPasswordPolicy creates a policy with the config's settings, using the defaults for any that aren't set, and reading
+// the list of breached passwords if there is one.
+func newPasswordPolicy(cfg PasswordConfig) (*passwordPolicy, error) {
+	policy := &passwordPolicy{cost: cfg.Cost, minLength: cfg.MinLength, history: cfg.History, breached: make(map[string]bool)}
+	if policy.cost == 0 {
+		policy.cost = DefaultPasswordCost
+	}
+	if policy.cost < bcrypt.MinCost || policy.cost > bcrypt.MaxCost {
+		return nil, fmt.Errorf("password cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	if policy.minLength <= 0 {
+		policy.minLength = DefaultPasswordMinLength
+	}
+	if policy.history < 0 {
+		policy.history = 0
+	}
+	if cfg.BreachedList != "" {
+		if err := policy.readBreachedList(cfg.BreachedList); err != nil {
+			return nil, err
+		}
+	}
+	return policy, nil
+}
+
+// readBreachedList reads a file of breached passwords, one per line. Each line is either a password, or the SHA-1 hash
+// of one in hex, optionally followed by a colon and the number of times it was seen, as in the Pwned Passwords lists.
+func (p *passwordPolicy) readBreachedList(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening breached password list: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if hash := strings.SplitN(line, ":", 2)[0]; isSHA1Hex(hash) {
+			p.breached[strings.ToLower(hash)] = true
+		} else {
+			p.breached[sha1Hex(line)] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading breached password list: %w", err)
+	}
+	return nil
+}
+
+// check checks a new password is long enough, and isn't in the list of breached passwords.
+func (p *passwordPolicy) check(password string) error {
+	if utf8.RuneCountInString(password) < p.minLength {
+		return newError(ErrValidation, "Password must be at least %d characters", p.minLength)
+	}
+	if p.breached[sha1Hex(password)] {
+		return newError(ErrValidation, "This password has appeared in a data breach, please choose another")
+	}
+	return nil
+}
+
+// hash hashes a password with the policy's cost.
+func (p *passwordPolicy) hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), p.cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// needsRehash checks whether a hash has a lower cost than the policy's, so should be replaced once the password is known.
+func (p *passwordPolicy) needsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err == nil && cost < p.cost
+}
+
+// dummyHash gives a hash with the policy's cost, which is compared against the password given when logging in as a user
+// that doesn't exist, so that it takes as long as a wrong password.
+func (p *passwordPolicy) dummyHash() string {
+	p.dummyOnce.Do(func() {
+		hash, err := p.hash("not a real password")
+		if err != nil {
+			panic(err)
+		}
+		p.dummy = hash
+	})
+	return p.dummy
+}
+
+// isSHA1Hex checks whether a string is a SHA-1 hash in hex.
+func isSHA1Hex(s string) bool {
+	if len(s) != 2*sha1.Size {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// sha1Hex gives the SHA-1 hash of a password in hex, as the breached passwords are stored.
+func sha1Hex(password string) string {
+	hash := sha1.Sum([]byte(password))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestNewPasswordPolicyDefaults(t *testing.T) {
+	policy, err := newPasswordPolicy(PasswordConfig{History: -1})
+	if err != nil {
+		t.Fatalf("Unexpected error creating policy: %v\n", err)
+	}
+	if policy.cost != DefaultPasswordCost || policy.minLength != DefaultPasswordMinLength || policy.history != 0 {
+		t.Errorf("Unexpected defaults: cost %d, min length %d, history %d\n", policy.cost, policy.minLength, policy.history)
+	}
+
+	for _, cost := range []int{bcrypt.MinCost - 1, bcrypt.MaxCost + 1} {
+		if _, err := newPasswordPolicy(PasswordConfig{Cost: cost}); err == nil {
+			t.Errorf("Expected error creating policy with cost %d\n", cost)
+		}
+	}
+	if _, err := newPasswordPolicy(PasswordConfig{BreachedList: filepath.Join(os.TempDir(), "missing-breached-list.txt")}); err == nil {
+		t.Error("Expected error creating policy with a missing breached password list")
+	}
+}
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "passwords")
+	if err != nil {
+		t.Fatalf("Error creating temporary directory: %v\n", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// "password1" is listed as it is, and "letmein123" by its SHA-1 hash, as in the Pwned Passwords lists
+	list := filepath.Join(dir, "breached.txt")
+	if err := ioutil.WriteFile(list, []byte("password1\n\n"+sha1Hex("letmein123")+":42\n"), 0600); err != nil {
+		t.Fatalf("Error writing breached password list: %v\n", err)
+	}
+	policy, err := newPasswordPolicy(PasswordConfig{MinLength: 6, BreachedList: list})
+	if err != nil {
+		t.Fatalf("Unexpected error creating policy: %v\n", err)
+	}
+
+	tests := map[string]error{
+		"":           ErrValidation,
+		"short":      ErrValidation,
+		"pässwö":     nil,
+		"password1":  ErrValidation,
+		"letmein123": ErrValidation,
+		"letmein124": nil,
+	}
+	for password, expected := range tests {
+		if err := policy.check(password); !errors.Is(err, expected) {
+			t.Errorf("Unexpected error checking password %q:\n got: %v\n wanted: %v\n", password, err, expected)
+		}
+	}
+}
+
+func TestPasswordPolicyRehash(t *testing.T) {
+	policy, err := newPasswordPolicy(PasswordConfig{Cost: bcrypt.MinCost + 1})
+	if err != nil {
+		t.Fatalf("Unexpected error creating policy: %v\n", err)
+	}
+	weak, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if !policy.needsRehash(string(weak)) {
+		t.Error("Expected hash with a lower cost to need rehashing")
+	}
+	hash, err := policy.hash("password")
+	if err != nil || policy.needsRehash(hash) {
+		t.Errorf("Unexpected hash %q needing rehashing, error: %v\n", hash, err)
+	}
+	if policy.needsRehash("") {
+		t.Error("Unexpected rehash of a user without a password")
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+// Roles a user can have, which decide what they are allowed to do.
+const (
+	RoleAdmin  = "admin"  // Can do anything, including managing other users' roles
+	RoleMember = "member" // Can add and change teas, but not delete them
+	RoleViewer = "viewer" // Can only look at teas and select one
+)
+
+// A Permission allows a user to use some of the API's endpoints. Each route requires a permission.
+type Permission string
+
+// Permissions required by the API's routes.
+const (
+	PermissionRead        Permission = "read"
+	PermissionWrite       Permission = "write"
+	PermissionDelete      Permission = "delete"
+	PermissionManageUsers Permission = "manage_users"
+)
+
+// rolePermissions gives the permissions each role has.
+var rolePermissions = map[string][]Permission{
+	RoleAdmin:  {PermissionRead, PermissionWrite, PermissionDelete, PermissionManageUsers},
+	RoleMember: {PermissionRead, PermissionWrite},
+	RoleViewer: {PermissionRead},
+}
+
+// A User is someone who can log in to the API, along with their role, their household, and the owner they are linked
+// to, if any.
+type User struct {
+	Username  string `json:"username"`
+	Role      string `json:"role"`
+	Household int    `json:"household,omitempty"`
+	OwnerID   int    `json:"ownerID,omitempty"`
+}
+
+// A RoleRequest changes the role of a user.
+type RoleRequest struct {
+	Role string `json:"role"`
+}
+
+// A LinkOwnerRequest links a user to an owner. An owner ID of zero unlinks them.
+type LinkOwnerRequest struct {
+	OwnerID int `json:"ownerID"`
+}
+
+// hasPermissions checks whether a role has all of the given permissions. Unknown roles have no permissions.
+func hasPermissions(role string, required []Permission) bool {
+	for _, permission := range required {
+		if !hasPermission(role, permission) {
+			return false
+		}
+	}
+	return true
+}
+
+func hasPermission(role string, permission Permission) bool {
+	return containsPermission(rolePermissions[role], permission)
+}
+
+func containsPermission(permissions []Permission, permission Permission) bool {
+	for _, p := range permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// validateRole checks a role is one of admin, member or viewer.
+func validateRole(role string) error {
+	if _, ok := rolePermissions[role]; !ok {
+		return newError(ErrValidation, "Role must be one of admin, member or viewer")
+	}
+	return nil
+}
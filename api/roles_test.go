@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestHasPermissions(t *testing.T) {
+	tests := []struct {
+		role        string
+		permissions []Permission
+		expected    bool
+	}{
+		{RoleAdmin, []Permission{PermissionDelete, PermissionManageUsers}, true},
+		{RoleMember, []Permission{PermissionRead, PermissionWrite}, true},
+		{RoleMember, []Permission{PermissionDelete}, false},
+		{RoleViewer, []Permission{PermissionRead}, true},
+		{RoleViewer, []Permission{PermissionWrite}, false},
+		{RoleViewer, nil, true},
+		{"", []Permission{PermissionRead}, false},
+	}
+
+	for _, test := range tests {
+		if actual := hasPermissions(test.role, test.permissions); actual != test.expected {
+			t.Errorf("Unexpected permissions for role %q with %v:\n got: %v\n wanted: %v\n", test.role, test.permissions, actual, test.expected)
+		}
+	}
+}
+
+func TestIsLastAdmin(t *testing.T) {
+	users := []User{{Username: "jane", Role: RoleMember}, {Username: "john", Role: RoleAdmin}}
+	if !isLastAdmin(users, "john") {
+		t.Errorf("Expected john to be the last admin\n")
+	}
+	if isLastAdmin(users, "jane") {
+		t.Errorf("Expected jane not to be the last admin, as they aren't an admin\n")
+	}
+
+	users = append(users, User{Username: "alice", Role: RoleAdmin})
+	if isLastAdmin(users, "john") {
+		t.Errorf("Expected john not to be the last admin, as alice is also an admin\n")
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Limits on searches for teas.
+const (
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 100
+	MaxSearchTerms     = 10
+)
+
+// How much a term matching each field of a tea adds to its relevance. A tea's name counts for the most, then the name
+// of its type, then its notes.
+const (
+	searchNameWeight  = 10
+	searchTypeWeight  = 5
+	searchNotesWeight = 1
+)
+
+// searchWords splits text into the lowercase words it is made of, ignoring punctuation.
+func searchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// parseSearchTerms splits a search into the lowercase words it is made of, ignoring punctuation and repeated words.
+// Only the first MaxSearchTerms words are used.
+func parseSearchTerms(query string) []string {
+	words := searchWords(query)
+
+	terms := make([]string, 0, len(words))
+	seen := make(map[string]bool)
+	for _, word := range words {
+		if seen[word] {
+			continue
+		}
+		seen[word] = true
+		terms = append(terms, word)
+		if len(terms) == MaxSearchTerms {
+			break
+		}
+	}
+	return terms
+}
+
+// fullTextQuery gives the FTS5 query matching teas with every term, each of which can be the start of a word. Terms
+// only have letters and digits in them, so can be quoted as they are.
+func fullTextQuery(terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + term + `"*`
+	}
+	return strings.Join(quoted, " ")
+}
+
+// searchScore gives how relevant a tea is to a search, by which of its fields contain each term. Teas that don't
+// contain every term have a score of zero. It is used when full-text search isn't available.
+func searchScore(tea Tea, terms []string) int {
+	name, typeName, notes := strings.ToLower(tea.Name), strings.ToLower(tea.TeaType.Name), strings.ToLower(tea.Notes)
+
+	score := 0
+	for _, term := range terms {
+		termScore := 0
+		if strings.Contains(name, term) {
+			termScore += searchNameWeight
+		}
+		if strings.Contains(typeName, term) {
+			termScore += searchTypeWeight
+		}
+		if strings.Contains(notes, term) {
+			termScore += searchNotesWeight
+		}
+		if termScore == 0 {
+			return 0
+		}
+		score += termScore
+	}
+	return score
+}
+
+// maxTypos gives how many letters of a term can be wrong for it to still match a word. Short terms have to match
+// exactly, as almost any short word would be close to them.
+func maxTypos(term string) int {
+	switch length := len([]rune(term)); {
+	case length >= 8:
+		return 2
+	case length >= 4:
+		return 1
+	}
+	return 0
+}
+
+// editDistance gives the number of letters that have to be added, removed or changed to turn one word into another.
+func editDistance(from string, to string) int {
+	a, b := []rune(from), []rune(to)
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j-1] + cost
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+// closeToAny checks whether a term is at most maxTypos letters away from any of the words.
+func closeToAny(term string, words []string) bool {
+	for _, word := range words {
+		if editDistance(term, word) <= maxTypos(term) {
+			return true
+		}
+	}
+	return false
+}
+
+// fuzzyScore gives how relevant a tea is to a search that nothing matched, allowing for typos in the names of teas and
+// their types. Each term must be close to a word in the tea's name or its type's name, otherwise the tea has a score of
+// zero.
+func fuzzyScore(tea Tea, terms []string) int {
+	name, typeName := searchWords(tea.Name), searchWords(tea.TeaType.Name)
+
+	score := 0
+	for _, term := range terms {
+		switch {
+		case closeToAny(term, name):
+			score += searchNameWeight
+		case closeToAny(term, typeName):
+			score += searchTypeWeight
+		default:
+			return 0
+		}
+	}
+	return score
+}
+
+// rankTeas gives the teas that match a search, most relevant first, using a score such as searchScore. Teas that are
+// as relevant as each other are in order of their IDs. There is no limit to how many teas are given if the limit is
+// zero.
+func rankTeas(teas []Tea, terms []string, limit int, scoreFunc func(Tea, []string) int) []Tea {
+	scores := make(map[int]int)
+	matches := make([]Tea, 0)
+	for _, tea := range teas {
+		if score := scoreFunc(tea, terms); score > 0 {
+			scores[tea.ID] = score
+			matches = append(matches, tea)
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if scores[matches[i].ID] != scores[matches[j].ID] {
+			return scores[matches[i].ID] > scores[matches[j].ID]
+		}
+		return matches[i].ID < matches[j].ID
+	})
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+)
+
+// A SelectionStrategy decides how likely each candidate tea is to be selected.
+type SelectionStrategy interface {
+	// Weights gives a weight for each of the candidate teas, in the same order, using the store for anything else it
+	// needs to know. A tea with double the weight of another is twice as likely to be picked.
+	Weights(store Store, candidates []Tea, options SelectionOptions) ([]float64, error)
+}
+
+// DefaultSelectionStrategy is the name of the strategy used when none is requested.
+const DefaultSelectionStrategy = "uniform"
+
+// SelectionStrategies holds the available strategies, by the name used to request them.
+var SelectionStrategies = map[string]SelectionStrategy{
+	"uniform":      UniformStrategy{},
+	"least-recent": LeastRecentStrategy{},
+	"rating":       RatingStrategy{},
+	"stock":        StockStrategy{},
+}
+
+// RandomFloatFunc points to a function to pick a random number in [0.0,1.0). Useful for mocking.
+var RandomFloatFunc = rand.Float64
+
+// UniformStrategy gives every tea an equal chance of being selected.
+type UniformStrategy struct{}
+
+// Weights gives every candidate a weight of 1.
+func (UniformStrategy) Weights(store Store, candidates []Tea, options SelectionOptions) ([]float64, error) {
+	weights := make([]float64, len(candidates))
+	for i := range weights {
+		weights[i] = 1
+	}
+	return weights, nil
+}
+
+// LeastRecentStrategy favours the teas that haven't been drunk for the longest time.
+type LeastRecentStrategy struct{}
+
+// Weights ranks the candidates by when they were last selected. Teas that have never been
+// selected get the highest weight, and the most recently selected tea gets a weight of 1.
+func (LeastRecentStrategy) Weights(store Store, candidates []Tea, options SelectionOptions) ([]float64, error) {
+	lastSelected, err := store.GetLastSelected()
+	if err != nil {
+		return nil, err
+	}
+
+	order := make([]int, len(candidates))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		timeA, selectedA := lastSelected[candidates[order[a]].ID]
+		timeB, selectedB := lastSelected[candidates[order[b]].ID]
+		if !selectedA || !selectedB {
+			return !selectedA && selectedB
+		}
+		return timeA.Before(timeB)
+	})
+
+	weights := make([]float64, len(candidates))
+	for rank, i := range order {
+		weights[i] = float64(len(candidates) - rank)
+	}
+	for i, tea := range candidates {
+		if _, selected := lastSelected[tea.ID]; !selected {
+			weights[i] = float64(len(candidates))
+		}
+	}
+	return weights, nil
+}
+
+// pickWeighted randomly picks an index, using the weights to decide how likely each index is.
+func pickWeighted(weights []float64) (int, error) {
+	var total float64
+	for _, weight := range weights {
+		if weight < 0 {
+			return 0, errors.New("Selection weights must not be negative")
+		}
+		total += weight
+	}
+	if total <= 0 {
+		return 0, errNoChanceOfSelection
+	}
+
+	target := RandomFloatFunc() * total
+	for i, weight := range weights {
+		if target < weight {
+			return i, nil
+		}
+		target -= weight
+	}
+
+	// Rounding errors can leave a small amount of the target, so pick the last tea with any weight.
+	for i := len(weights) - 1; i >= 0; i-- {
+		if weights[i] > 0 {
+			return i, nil
+		}
+	}
+	return 0, errNoChanceOfSelection
+}
+
+// errNoChanceOfSelection is returned when every tea has been weighted out of being picked.
+var errNoChanceOfSelection = newError(ErrNotFound, "No teas have a chance of being selected")
+
+// UnratedWeight is the weight given to a tea that hasn't been rated, the middle of the 1 to 5 star range.
+const UnratedWeight = 3
+
+// RatingStrategy favours the teas with the best average rating from the owners the tea is being selected for.
+type RatingStrategy struct{}
+
+// Weights uses the average rating of each candidate as its weight.
+func (RatingStrategy) Weights(store Store, candidates []Tea, options SelectionOptions) ([]float64, error) {
+	averages, err := store.GetAverageRatings(options.OwnerIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	weights := make([]float64, len(candidates))
+	for i, tea := range candidates {
+		average, rated := averages[tea.ID]
+		if !rated {
+			average = UnratedWeight
+		}
+		weights[i] = average
+	}
+	return weights, nil
+}
+
+// DefaultLeafGrams is the amount of loose leaf tea assumed to make a single cup, when the tea doesn't say.
+const DefaultLeafGrams = 2.5
+
+// StockStrategy favours the teas that the owners the tea is being selected for have the most of.
+type StockStrategy struct{}
+
+// Weights uses the number of cups each candidate has left as its weight. Teas that have stock measured in grams
+// are converted to cups using the tea's brewing guide. If any owner of a tea isn't tracking their stock, the tea
+// is given the average weight of the teas that are tracked.
+func (StockStrategy) Weights(store Store, candidates []Tea, options SelectionOptions) ([]float64, error) {
+	stock, err := store.GetStock(options.OwnerIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	weights := make([]float64, len(candidates))
+	untracked := make([]int, 0)
+	var trackedTotal float64
+	for i, tea := range candidates {
+		tracked := len(stock[tea.ID]) > 0
+		for _, ownerStock := range stock[tea.ID] {
+			if ownerStock.Quantity == nil {
+				tracked = false
+				break
+			}
+			weights[i] += cups(tea, ownerStock)
+		}
+
+		if tracked {
+			trackedTotal += weights[i]
+		} else {
+			untracked = append(untracked, i)
+		}
+	}
+
+	untrackedWeight := 1.0
+	if tracked := len(candidates) - len(untracked); tracked > 0 && trackedTotal > 0 {
+		untrackedWeight = trackedTotal / float64(tracked)
+	}
+	for _, i := range untracked {
+		weights[i] = untrackedWeight
+	}
+	return weights, nil
+}
+
+// cups gives the number of cups of tea that can be made from some stock of the tea.
+func cups(tea Tea, stock Stock) float64 {
+	if stock.Quantity == nil {
+		return 0
+	}
+	if stock.Unit != UnitGrams {
+		return *stock.Quantity
+	}
+
+	leafGrams := tea.Brewing().LeafGrams
+	if leafGrams <= 0 {
+		leafGrams = DefaultLeafGrams
+	}
+	return *stock.Quantity / leafGrams
+}
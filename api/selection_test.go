@@ -0,0 +1,160 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestUniformStrategy(t *testing.T) {
+	teas, _ := allTeasResponseMock(ListOptions{})
+
+	weights, err := UniformStrategy{}.Weights(new(mockStore), teas, SelectionOptions{})
+	if err != nil {
+		t.Errorf("Strategy returned unexpected error: %v\n", err)
+	}
+
+	expected := []float64{1, 1}
+	if !reflect.DeepEqual(weights, expected) {
+		t.Errorf("Strategy returned unexpected weights:\n got: %v\n wanted: %v\n", weights, expected)
+	}
+}
+
+func TestLeastRecentStrategy(t *testing.T) {
+	teas := []Tea{{ID: 1}, {ID: 2}, {ID: 3}, {ID: 4}}
+
+	store := new(mockStore)
+	store.getLastSelected = func() (map[int]time.Time, error) {
+		now := time.Now()
+		return map[int]time.Time{
+			1: now,
+			3: now.Add(-time.Hour),
+		}, nil
+	}
+
+	weights, err := LeastRecentStrategy{}.Weights(store, teas, SelectionOptions{})
+	if err != nil {
+		t.Errorf("Strategy returned unexpected error: %v\n", err)
+	}
+
+	expected := []float64{1, 4, 2, 4}
+	if !reflect.DeepEqual(weights, expected) {
+		t.Errorf("Strategy returned unexpected weights:\n got: %v\n wanted: %v\n", weights, expected)
+	}
+}
+
+func TestPickWeighted(t *testing.T) {
+	oldFunc := RandomFloatFunc
+	defer func() { RandomFloatFunc = oldFunc }()
+
+	weights := []float64{1, 0, 3}
+	tests := []struct {
+		random   float64
+		expected int
+	}{
+		{0, 0},
+		{0.24, 0},
+		{0.25, 2},
+		{0.99, 2},
+	}
+
+	for _, test := range tests {
+		RandomFloatFunc = func() float64 { return test.random }
+
+		index, err := pickWeighted(weights)
+		if err != nil {
+			t.Errorf("pickWeighted returned unexpected error: %v\n", err)
+		}
+		if index != test.expected {
+			t.Errorf("pickWeighted picked wrong index for %v:\n got: %d\n wanted: %d\n", test.random, index, test.expected)
+		}
+	}
+}
+
+func TestPickWeightedNoWeight(t *testing.T) {
+	if _, err := pickWeighted([]float64{0, 0}); err == nil {
+		t.Errorf("pickWeighted didn't return an error when no tea could be picked")
+	}
+	if _, err := pickWeighted([]float64{1, -1}); err == nil {
+		t.Errorf("pickWeighted didn't return an error for a negative weight")
+	}
+}
+
+func TestRatingStrategy(t *testing.T) {
+	teas := []Tea{{ID: 1}, {ID: 2}}
+
+	store := new(mockStore)
+	var ownerIDs []int
+	store.getAverageRatings = func(ids []int) (map[int]float64, error) {
+		ownerIDs = ids
+		return map[int]float64{1: 4.5}, nil
+	}
+
+	weights, err := RatingStrategy{}.Weights(store, teas, SelectionOptions{OwnerIDs: []int{3}})
+	if err != nil {
+		t.Errorf("Strategy returned unexpected error: %v\n", err)
+	}
+
+	if !reflect.DeepEqual(ownerIDs, []int{3}) {
+		t.Errorf("Strategy used ratings of wrong owners:\n got: %v\n wanted: %v\n", ownerIDs, []int{3})
+	}
+	expected := []float64{4.5, UnratedWeight}
+	if !reflect.DeepEqual(weights, expected) {
+		t.Errorf("Strategy returned unexpected weights:\n got: %v\n wanted: %v\n", weights, expected)
+	}
+}
+
+func TestStockStrategy(t *testing.T) {
+	teas := []Tea{
+		{ID: 1},
+		{ID: 2, BrewingGuide: BrewingGuide{LeafGrams: 4}},
+		{ID: 3},
+		{ID: 4},
+	}
+
+	store := new(mockStore)
+	var ownerIDs []int
+	store.getStock = func(ids []int) (map[int][]Stock, error) {
+		ownerIDs = ids
+		bags, grams := 6.0, 20.0
+		return map[int][]Stock{
+			1: {{Quantity: &bags, Unit: UnitBags}, {Quantity: &bags, Unit: UnitBags}},
+			2: {{Quantity: &grams, Unit: UnitGrams}},
+			3: {{Quantity: &bags, Unit: UnitBags}, {}},
+		}, nil
+	}
+
+	weights, err := StockStrategy{}.Weights(store, teas, SelectionOptions{OwnerIDs: []int{1, 2}})
+	if err != nil {
+		t.Errorf("Strategy returned unexpected error: %v\n", err)
+	}
+
+	if !reflect.DeepEqual(ownerIDs, []int{1, 2}) {
+		t.Errorf("Strategy used stock of wrong owners:\n got: %v\n wanted: %v\n", ownerIDs, []int{1, 2})
+	}
+	// Untracked teas get the average of 12 and 20 / 4 cups.
+	expected := []float64{12, 5, 8.5, 8.5}
+	if !reflect.DeepEqual(weights, expected) {
+		t.Errorf("Strategy returned unexpected weights:\n got: %v\n wanted: %v\n", weights, expected)
+	}
+}
+
+func TestCups(t *testing.T) {
+	quantity := 10.0
+	tests := []struct {
+		tea      Tea
+		stock    Stock
+		expected float64
+	}{
+		{Tea{}, Stock{Quantity: &quantity, Unit: UnitBags}, 10},
+		{Tea{}, Stock{Quantity: &quantity, Unit: UnitGrams}, 4},
+		{Tea{TeaType: TeaType{BrewingGuide: BrewingGuide{LeafGrams: 5}}}, Stock{Quantity: &quantity, Unit: UnitGrams}, 2},
+		{Tea{}, Stock{}, 0},
+	}
+
+	for _, test := range tests {
+		if actual := cups(test.tea, test.stock); actual != test.expected {
+			t.Errorf("Unexpected number of cups for %+v:\n got: %v\n wanted: %v\n", test.stock, actual, test.expected)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// A Server handles requests to the API, keeping all of its data in a store.
+// Each server is independent, so several can run in the same process.
+type Server struct {
+	store      Store
+	signingKey []byte
+	logins     *loginLimiter
+	passwords  *passwordPolicy
+	oidc       *oidcProvider
+}
+
+// NewServer creates a server using the given store, which signs tokens with the given key. Failed logins are limited,
+// and passwords are checked, using the defaults, until SetLoginConfig and SetPasswordConfig are called.
+func NewServer(store Store, signingKey string) *Server {
+	passwords, _ := newPasswordPolicy(PasswordConfig{}) // The defaults are always valid
+	return &Server{store: store, signingKey: []byte(signingKey), logins: newLoginLimiter(LoginConfig{}), passwords: passwords}
+}
+
+// SetLoginConfig sets how many failed logins are allowed before a username or address is locked out, forgetting any
+// logins that have already failed.
+func (s *Server) SetLoginConfig(cfg LoginConfig) {
+	s.logins = newLoginLimiter(cfg)
+}
+
+// SetPasswordConfig sets how passwords are hashed, and which passwords are allowed. It fails if the cost is out of
+// range, or the list of breached passwords can't be read.
+func (s *Server) SetPasswordConfig(cfg PasswordConfig) error {
+	passwords, err := newPasswordPolicy(cfg)
+	if err != nil {
+		return err
+	}
+	s.passwords = passwords
+	return nil
+}
+
+// SetOIDCConfig lets users log in with the identity provider in the config, if it has one.
+func (s *Server) SetOIDCConfig(cfg OIDCConfig) {
+	s.oidc = nil
+	if cfg.Issuer != "" {
+		s.oidc = newOIDCProvider(cfg)
+	}
+}
+
+// Router gives a router for all of the server's endpoints. POST /register is only included if registration is enabled,
+// and the OIDC endpoints if there is an identity provider. Users logging in with the provider for the first time are
+// only created if registration is enabled.
+// Each route is given the permission a user's role must have to use it. Routes that manage the user's account can't be
+// used with an API key.
+func (s *Server) Router(registerEnabled bool) *mux.Router {
+	router := mux.NewRouter().StrictSlash(true)
+
+	// Account functions
+	router.HandleFunc("/login", s.loginHandler).Methods(http.MethodPost)
+	router.Handle("/changepassword", s.isAuthorized(sessionOnly(s.changePasswordHandler), PermissionRead)).Methods(http.MethodPost)
+	router.HandleFunc("/token/refresh", s.refreshTokenHandler).Methods(http.MethodPost)
+	router.Handle("/logout", s.isAuthorized(sessionOnly(s.logoutHandler), PermissionRead)).Methods(http.MethodPost)
+	if registerEnabled {
+		router.HandleFunc("/register", s.registerHandler).Methods(http.MethodPost)
+	}
+	if s.oidc != nil {
+		router.HandleFunc("/auth/oidc/login", s.oidcLoginHandler).Methods(http.MethodGet)
+		router.HandleFunc("/auth/oidc/callback", s.oidcCallbackHandler(registerEnabled)).Methods(http.MethodGet)
+	}
+	router.Handle("/me/keys", s.isAuthorized(sessionOnly(s.getAPIKeysHandler), PermissionRead)).Methods(http.MethodGet)
+	router.Handle("/me/keys", s.isAuthorized(sessionOnly(s.createAPIKeyHandler), PermissionRead)).Methods(http.MethodPost)
+	router.Handle("/me/key/{id:[0-9]+}", s.isAuthorized(sessionOnly(s.deleteAPIKeyHandler), PermissionRead)).Methods(http.MethodDelete)
+
+	// Users
+	router.Handle("/users", s.isAuthorized(s.getAllUsersHandler, PermissionManageUsers)).Methods(http.MethodGet)
+	router.Handle("/user/{username}/role", s.isAuthorized(s.updateUserRoleHandler, PermissionManageUsers)).Methods(http.MethodPut)
+	router.Handle("/user/{username}/owner", s.isAuthorized(s.updateUserOwnerHandler, PermissionManageUsers)).Methods(http.MethodPut)
+	router.Handle("/audit", s.isAuthorized(s.getAuditLogHandler, PermissionManageUsers)).Methods(http.MethodGet)
+
+	// Households
+	router.Handle("/household", s.isAuthorized(s.getHouseholdHandler, PermissionRead)).Methods(http.MethodGet)
+	router.Handle("/household/join", s.isAuthorized(sessionOnly(s.joinHouseholdHandler), PermissionRead)).Methods(http.MethodPost)
+	router.Handle("/household/invites", s.isAuthorized(s.getInvitesHandler, PermissionManageUsers)).Methods(http.MethodGet)
+	router.Handle("/household/invite", s.isAuthorized(s.createInviteHandler, PermissionManageUsers)).Methods(http.MethodPost)
+	router.Handle("/household/invite/{code:[0-9a-f]+}", s.isAuthorized(s.deleteInviteHandler, PermissionManageUsers)).Methods(http.MethodDelete)
+
+	// The logged in user's own collection
+	router.Handle("/me", s.isAuthorized(s.getProfileHandler, PermissionRead)).Methods(http.MethodGet)
+	router.Handle("/me/tea/{id:[0-9]+}", s.isAuthorized(s.addMyTeaHandler, PermissionWrite)).Methods(http.MethodPost)
+	router.Handle("/me/tea/{id:[0-9]+}", s.isAuthorized(s.removeMyTeaHandler, PermissionWrite)).Methods(http.MethodDelete)
+	router.Handle("/me/tea/{teaID:[0-9]+}/consume", s.isAuthorized(s.consumeMyTeaHandler, PermissionWrite)).Methods(http.MethodPost)
+	router.Handle("/me/tea/{teaID:[0-9]+}/restock", s.isAuthorized(s.restockMyTeaHandler, PermissionWrite)).Methods(http.MethodPost)
+
+	// Tea Types
+	router.Handle("/types", s.isAuthorized(s.getAllTeaTypesHandler, PermissionRead)).Methods(http.MethodGet)
+	router.Handle("/types/teas", s.isAuthorized(s.getAllTeasTypesHandler, PermissionRead)).Methods(http.MethodGet)
+	router.Handle("/type/{id:[0-9]+}", s.isAuthorized(s.getTeaTypeHandler, PermissionRead)).Methods(http.MethodGet)
+	router.Handle("/type", s.isAuthorized(s.createTeaTypeHandler, PermissionWrite)).Methods(http.MethodPost)
+	router.Handle("/type/{id:[0-9]+}", s.isAuthorized(s.updateTeaTypeHandler, PermissionWrite)).Methods(http.MethodPut)
+	router.Handle("/type/{id:[0-9]+}", s.isAuthorized(s.deleteTeaTypeHandler, PermissionDelete)).Methods(http.MethodDelete)
+
+	// Tea Owners
+	router.Handle("/owners", s.isAuthorized(s.getAllOwnersHandler, PermissionRead)).Methods(http.MethodGet)
+	router.Handle("/owners/teas", s.isAuthorized(s.getAllOwnersTeasHandler, PermissionRead)).Methods(http.MethodGet)
+	router.Handle("/owner/{id:[0-9]+}", s.isAuthorized(s.getOwnerHandler, PermissionRead)).Methods(http.MethodGet)
+	router.Handle("/owner", s.isAuthorized(s.createOwnerHandler, PermissionWrite)).Methods(http.MethodPost)
+	router.Handle("/owner/{id:[0-9]+}", s.isAuthorized(s.updateOwnerHandler, PermissionWrite)).Methods(http.MethodPut)
+	router.Handle("/owner/{id:[0-9]+}", s.isAuthorized(s.deleteOwnerHandler, PermissionDelete)).Methods(http.MethodDelete)
+
+	// Tea
+	router.Handle("/teas", s.isAuthorized(s.getAllTeasHandler, PermissionRead)).Methods(http.MethodGet)
+	router.Handle("/teas/owners", s.isAuthorized(s.getAllTeaOwnersHandler, PermissionRead)).Methods(http.MethodGet)
+	router.Handle("/search", s.isAuthorized(s.searchTeasHandler, PermissionRead)).Methods(http.MethodGet)
+	router.Handle("/tea/{id:[0-9]+}", s.isAuthorized(s.getTeaHandler, PermissionRead)).Methods(http.MethodGet)
+	router.Handle("/tea", s.isAuthorized(s.createTeaHandler, PermissionWrite)).Methods(http.MethodPost)
+	router.Handle("/tea/{id:[0-9]+}", s.isAuthorized(s.updateTeaHandler, PermissionWrite)).Methods(http.MethodPut, http.MethodPatch)
+	router.Handle("/tea/{id:[0-9]+}", s.isAuthorized(s.deleteTeaHandler, PermissionDelete)).Methods(http.MethodDelete)
+	router.Handle("/tea/{id:[0-9]+}/owners", s.isAuthorized(s.getTeaOwnersHandler, PermissionRead)).Methods(http.MethodGet)
+	router.Handle("/tea/{id:[0-9]+}/owner", s.isAuthorized(s.createTeaOwnerHandler, PermissionWrite)).Methods(http.MethodPost)
+	router.Handle("/tea/{teaID:[0-9]+}/owner/{ownerID:[0-9]+}", s.isAuthorized(s.deleteTeaOwnerHandler, PermissionWrite)).Methods(http.MethodDelete)
+	router.Handle("/tea/{teaID:[0-9]+}/owner/{ownerID:[0-9]+}/consume", s.isAuthorized(s.consumeTeaStockHandler, PermissionWrite)).Methods(http.MethodPost)
+	router.Handle("/tea/{teaID:[0-9]+}/owner/{ownerID:[0-9]+}/restock", s.isAuthorized(s.restockTeaHandler, PermissionWrite)).Methods(http.MethodPost)
+
+	// Import and export
+	router.Handle("/export", s.isAuthorized(s.exportHandler, PermissionRead)).Methods(http.MethodGet)
+	router.Handle("/import", s.isAuthorized(s.importHandler, PermissionWrite)).Methods(http.MethodPost)
+
+	// Tea Ratings
+	router.Handle("/tea/{id:[0-9]+}/ratings", s.isAuthorized(s.getTeaRatingsHandler, PermissionRead)).Methods(http.MethodGet)
+	router.Handle("/tea/{id:[0-9]+}/ratings", s.isAuthorized(s.createTeaRatingHandler, PermissionWrite)).Methods(http.MethodPost)
+	router.Handle("/tea/{teaID:[0-9]+}/ratings/{ownerID:[0-9]+}", s.isAuthorized(s.updateTeaRatingHandler, PermissionWrite)).Methods(http.MethodPut)
+	router.Handle("/tea/{teaID:[0-9]+}/ratings/{ownerID:[0-9]+}", s.isAuthorized(s.deleteTeaRatingHandler, PermissionWrite)).Methods(http.MethodDelete)
+
+	// Selection
+	router.Handle("/select", s.isAuthorized(s.selectTeaHandler, PermissionRead)).Methods(http.MethodGet)
+	router.Handle("/select", s.isAuthorized(s.selectTeaHandler, PermissionWrite)).Methods(http.MethodPost)
+	router.Handle("/selections", s.isAuthorized(s.getSelectionsHandler, PermissionRead)).Methods(http.MethodGet)
+
+	// Webhooks
+	router.Handle("/webhooks/deliveries", s.isAuthorized(s.getWebhookDeliveriesHandler, PermissionRead)).Methods(http.MethodGet)
+
+	return router
+}
@@ -0,0 +1,843 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newTestServer creates a server for the handler tests, using the given store.
+func newTestServer(store Store) *Server {
+	return NewServer(store, "testSigningKey")
+}
+
+// serverRequest sends a request to a test server, decoding the JSON response into result if it isn't nil.
+func serverRequest(t *testing.T, server *httptest.Server, method string, path string, token string, body interface{}, result interface{}) int {
+	var requestBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&requestBody).Encode(body); err != nil {
+			t.Fatalf("Error encoding request body: %v\n", err)
+		}
+	}
+
+	req, err := http.NewRequest(method, server.URL+path, &requestBody)
+	if err != nil {
+		t.Fatalf("Error creating request: %v\n", err)
+	}
+	if token != "" {
+		req.Header.Set("Token", token)
+	}
+
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Error sending request %s %s: %v\n", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	if result != nil {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			t.Fatalf("Error decoding response to %s %s: %v\n", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// registerTestUser registers a user with a test server, giving the token for them.
+func registerTestUser(t *testing.T, server *httptest.Server, username string) string {
+	var response map[string]string
+	if status := serverRequest(t, server, http.MethodPost, "/register", "", UserLogin{Username: username, Password: "password"}, &response); status != http.StatusOK {
+		t.Fatalf("Unexpected status registering user: %d\n", status)
+	}
+	return response["token"]
+}
+
+// inviteTestUser registers a user with a test server using an invite made by an admin, so they join the admin's
+// household, giving the token for them.
+func inviteTestUser(t *testing.T, server *httptest.Server, adminToken string, username string) string {
+	var invite Invite
+	if status := serverRequest(t, server, http.MethodPost, "/household/invite", adminToken, nil, &invite); status != http.StatusCreated {
+		t.Fatalf("Unexpected status creating invite: %d\n", status)
+	}
+	var response map[string]string
+	request := RegisterRequest{UserLogin: UserLogin{Username: username, Password: "password"}, Invite: invite.Code}
+	if status := serverRequest(t, server, http.MethodPost, "/register", "", request, &response); status != http.StatusOK {
+		t.Fatalf("Unexpected status registering user with invite: %d\n", status)
+	}
+	return response["token"]
+}
+
+// loginTestUser logs in to a test server as a user registered by registerTestUser, giving a new token for them.
+func loginTestUser(t *testing.T, server *httptest.Server, username string) string {
+	var response map[string]string
+	if status := serverRequest(t, server, http.MethodPost, "/login", "", UserLogin{Username: username, Password: "password"}, &response); status != http.StatusOK {
+		t.Fatalf("Unexpected status logging in: %d\n", status)
+	}
+	return response["token"]
+}
+
+func TestServerWithMemoryStore(t *testing.T) {
+	server := httptest.NewServer(NewServer(NewMemoryStore(storeTestConfig()), "signingKey").Router(true))
+	defer server.Close()
+
+	token := registerTestUser(t, server, "john")
+
+	var tea Tea
+	if status := serverRequest(t, server, http.MethodPost, "/tea", token, Tea{Name: "Snowball", TeaType: TeaType{ID: 1}}, &tea); status != http.StatusCreated || tea.ID != 1 {
+		t.Fatalf("Unexpected tea created: %+v, status: %d\n", tea, status)
+	}
+	if status := serverRequest(t, server, http.MethodPost, "/tea/1/owner", token, Owner{ID: 2}, nil); status != http.StatusCreated {
+		t.Fatalf("Unexpected status adding owner to tea: %d\n", status)
+	}
+
+	var selected Selection
+	if status := serverRequest(t, server, http.MethodGet, "/select?owners=2", token, nil, &selected); status != http.StatusOK || selected.Tea.Name != "Snowball" || selected.Brewing.BrewTemperature != 100 {
+		t.Errorf("Unexpected tea selected: %+v, status: %d\n", selected, status)
+	}
+	var history SelectionHistory
+	if status := serverRequest(t, server, http.MethodGet, "/selections", token, nil, &history); status != http.StatusOK || history.Total != 0 {
+		t.Errorf("Unexpected selection history after GET /select: %+v, status: %d\n", history, status)
+	}
+	if status := serverRequest(t, server, http.MethodPost, "/select?owners=2", token, nil, &selected); status != http.StatusCreated || selected.Tea.Name != "Snowball" {
+		t.Errorf("Unexpected tea selected and recorded: %+v, status: %d\n", selected, status)
+	}
+	if status := serverRequest(t, server, http.MethodGet, "/selections", token, nil, &history); status != http.StatusOK || history.Total != 1 || history.Selections[0].Tea.Name != "Snowball" {
+		t.Errorf("Unexpected selection history after POST /select: %+v, status: %d\n", history, status)
+	}
+
+	var response map[string]string
+	if status := serverRequest(t, server, http.MethodGet, "/tea/2", token, nil, &response); status != http.StatusNotFound || response["error"] != "Tea does not exist" || response["code"] != "not_found" {
+		t.Errorf("Unexpected response getting missing tea: %v, status: %d\n", response, status)
+	}
+}
+
+func TestServersAreIndependent(t *testing.T) {
+	first := httptest.NewServer(NewServer(NewMemoryStore(storeTestConfig()), "firstKey").Router(true))
+	defer first.Close()
+	second := httptest.NewServer(NewServer(NewMemoryStore(storeTestConfig()), "secondKey").Router(true))
+	defer second.Close()
+
+	firstToken := registerTestUser(t, first, "john")
+	secondToken := registerTestUser(t, second, "john")
+
+	if status := serverRequest(t, first, http.MethodPost, "/owner", firstToken, Owner{Name: "Bob"}, nil); status != http.StatusCreated {
+		t.Fatalf("Unexpected status creating owner: %d\n", status)
+	}
+
+	var owners []Owner
+	if status := serverRequest(t, second, http.MethodGet, "/owners", secondToken, nil, &owners); status != http.StatusOK || len(owners) != 2 {
+		t.Errorf("Owner created on one server appeared on the other: %v, status: %d\n", owners, status)
+	}
+	if status := serverRequest(t, second, http.MethodGet, "/owners", firstToken, nil, nil); status != http.StatusUnauthorized {
+		t.Errorf("Token signed by one server was accepted by the other, status: %d\n", status)
+	}
+}
+
+func TestServerRoles(t *testing.T) {
+	server := httptest.NewServer(NewServer(NewMemoryStore(storeTestConfig()), "signingKey").Router(true))
+	defer server.Close()
+
+	adminToken := registerTestUser(t, server, "john")
+	memberToken := inviteTestUser(t, server, adminToken, "jane")
+
+	var users []User
+	expectedUsers := []User{{Username: "jane", Role: RoleMember, Household: DefaultHousehold}, {Username: "john", Role: RoleAdmin, Household: DefaultHousehold}}
+	if status := serverRequest(t, server, http.MethodGet, "/users", adminToken, nil, &users); status != http.StatusOK || !reflect.DeepEqual(users, expectedUsers) {
+		t.Errorf("Unexpected users: %v, status: %d\n wanted: %v\n", users, status, expectedUsers)
+	}
+	if status := serverRequest(t, server, http.MethodGet, "/users", memberToken, nil, nil); status != http.StatusForbidden {
+		t.Errorf("Unexpected status listing users as a member: %d\n", status)
+	}
+
+	if status := serverRequest(t, server, http.MethodPost, "/tea", memberToken, Tea{Name: "Snowball", TeaType: TeaType{ID: 1}}, nil); status != http.StatusCreated {
+		t.Errorf("Unexpected status creating tea as a member: %d\n", status)
+	}
+	var response map[string]string
+	if status := serverRequest(t, server, http.MethodDelete, "/tea/1", memberToken, nil, &response); status != http.StatusForbidden || response["code"] != "forbidden" {
+		t.Errorf("Unexpected response deleting tea as a member: %v, status: %d\n", response, status)
+	}
+
+	if status := serverRequest(t, server, http.MethodPut, "/user/jane/role", adminToken, RoleRequest{Role: RoleViewer}, nil); status != http.StatusOK {
+		t.Errorf("Unexpected status changing role: %d\n", status)
+	}
+	if status := serverRequest(t, server, http.MethodPut, "/user/jane/role", adminToken, RoleRequest{Role: "owner"}, nil); status != http.StatusUnprocessableEntity {
+		t.Errorf("Unexpected status changing to an unknown role: %d\n", status)
+	}
+	if status := serverRequest(t, server, http.MethodPut, "/user/john/role", adminToken, RoleRequest{Role: RoleMember}, nil); status != http.StatusConflict {
+		t.Errorf("Unexpected status removing the last admin: %d\n", status)
+	}
+
+	viewerToken := loginTestUser(t, server, "jane")
+	if status := serverRequest(t, server, http.MethodGet, "/teas", viewerToken, nil, nil); status != http.StatusOK {
+		t.Errorf("Unexpected status getting teas as a viewer: %d\n", status)
+	}
+	if status := serverRequest(t, server, http.MethodPost, "/owner", viewerToken, Owner{Name: "Bob"}, nil); status != http.StatusForbidden {
+		t.Errorf("Unexpected status creating owner as a viewer: %d\n", status)
+	}
+	if status := serverRequest(t, server, http.MethodDelete, "/tea/1", adminToken, nil, nil); status != http.StatusOK {
+		t.Errorf("Unexpected status deleting tea as an admin: %d\n", status)
+	}
+}
+
+func TestServerProfile(t *testing.T) {
+	server := httptest.NewServer(NewServer(NewMemoryStore(storeTestConfig()), "signingKey").Router(true))
+	defer server.Close()
+
+	adminToken := registerTestUser(t, server, "john")
+	token := inviteTestUser(t, server, adminToken, "jane")
+
+	var profile Profile
+	if status := serverRequest(t, server, http.MethodGet, "/me", token, nil, &profile); status != http.StatusOK || profile.Username != "jane" || profile.Owner != nil || len(profile.Teas) != 0 {
+		t.Errorf("Unexpected profile of unlinked user: %+v, status: %d\n", profile, status)
+	}
+	if status := serverRequest(t, server, http.MethodPost, "/tea", adminToken, Tea{Name: "Snowball", TeaType: TeaType{ID: 1}}, nil); status != http.StatusCreated {
+		t.Fatalf("Unexpected status creating tea: %d\n", status)
+	}
+	if status := serverRequest(t, server, http.MethodPost, "/me/tea/1", token, nil, nil); status != http.StatusConflict {
+		t.Errorf("Unexpected status adding tea for unlinked user: %d\n", status)
+	}
+	if status := serverRequest(t, server, http.MethodPost, "/tea/1/owner", token, Owner{ID: 1}, nil); status != http.StatusConflict {
+		t.Errorf("Unexpected status adding an owner of tea as an unlinked member: %d\n", status)
+	}
+
+	var user User
+	if status := serverRequest(t, server, http.MethodPut, "/user/jane/owner", adminToken, LinkOwnerRequest{OwnerID: 2}, &user); status != http.StatusOK || user.OwnerID != 2 {
+		t.Fatalf("Unexpected user after linking to owner: %+v, status: %d\n", user, status)
+	}
+	if status := serverRequest(t, server, http.MethodPut, "/user/john/owner", adminToken, LinkOwnerRequest{OwnerID: 2}, nil); status != http.StatusConflict {
+		t.Errorf("Unexpected status linking a second user to an owner: %d\n", status)
+	}
+
+	if status := serverRequest(t, server, http.MethodPost, "/me/tea/1", token, nil, nil); status != http.StatusCreated {
+		t.Errorf("Unexpected status adding tea to collection: %d\n", status)
+	}
+	var stock Stock
+	quantity := 5.0
+	if status := serverRequest(t, server, http.MethodPost, "/me/tea/1/restock", token, Stock{Quantity: &quantity}, &stock); status != http.StatusOK || stock.Quantity == nil || *stock.Quantity != 5 {
+		t.Errorf("Unexpected stock after restocking: %+v, status: %d\n", stock, status)
+	}
+
+	profile = Profile{}
+	if status := serverRequest(t, server, http.MethodGet, "/me", token, nil, &profile); status != http.StatusOK || profile.Owner == nil || profile.Owner.Name != "Jane" || len(profile.Teas) != 1 || profile.Teas[0].Name != "Snowball" {
+		t.Errorf("Unexpected profile of linked user: %+v, status: %d\n", profile, status)
+	}
+
+	var teaOwners []TeaOwner
+	if status := serverRequest(t, server, http.MethodGet, "/tea/1/owners", token, nil, &teaOwners); status != http.StatusOK || len(teaOwners) != 1 || teaOwners[0].ID != 2 {
+		t.Errorf("Tea wasn't added to the linked owner's collection: %+v, status: %d\n", teaOwners, status)
+	}
+
+	// A linked member can only change the teas of their own owner
+	if status := serverRequest(t, server, http.MethodPost, "/tea/1/owner", adminToken, TeaOwner{Owner: Owner{ID: 1}, Stock: Stock{Quantity: &quantity}}, nil); status != http.StatusCreated {
+		t.Fatalf("Unexpected status adding another owner of tea: %d\n", status)
+	}
+	otherOwnerRequests := []struct {
+		method string
+		path   string
+		body   interface{}
+	}{
+		{http.MethodPost, "/tea/1/owner", Owner{ID: 1}},
+		{http.MethodPost, "/tea/1/owner/1/consume", Stock{Quantity: &quantity}},
+		{http.MethodPost, "/tea/1/owner/1/restock", Stock{Quantity: &quantity}},
+		{http.MethodDelete, "/tea/1/owner/1", nil},
+		{http.MethodPost, "/tea/1/ratings", Rating{Owner: Owner{ID: 1}, Rating: 1}},
+		{http.MethodPut, "/tea/1/ratings/1", Rating{Rating: 1}},
+		{http.MethodDelete, "/tea/1/ratings/1", nil},
+	}
+	for _, request := range otherOwnerRequests {
+		if status := serverRequest(t, server, request.method, request.path, token, request.body, nil); status != http.StatusForbidden {
+			t.Errorf("Unexpected status for %s %s on another owner: %d\n", request.method, request.path, status)
+		}
+	}
+	if status := serverRequest(t, server, http.MethodPost, "/tea/1/owner/2/consume", token, Stock{Quantity: &quantity}, nil); status != http.StatusOK {
+		t.Errorf("Unexpected status consuming own tea: %d\n", status)
+	}
+	if status := serverRequest(t, server, http.MethodPost, "/tea/1/ratings", token, Rating{Owner: Owner{ID: 2}, Rating: 4}, nil); status != http.StatusCreated {
+		t.Errorf("Unexpected status rating own tea: %d\n", status)
+	}
+	if status := serverRequest(t, server, http.MethodPut, "/tea/1/ratings/1", adminToken, Rating{Rating: 2}, nil); status == http.StatusForbidden {
+		t.Errorf("Admin wasn't allowed to change another owner's rating\n")
+	}
+
+	if status := serverRequest(t, server, http.MethodDelete, "/me/tea/1", token, nil, nil); status != http.StatusOK {
+		t.Errorf("Unexpected status removing tea from collection: %d\n", status)
+	}
+	if status := serverRequest(t, server, http.MethodDelete, "/me/tea/1", token, nil, nil); status != http.StatusNotFound {
+		t.Errorf("Unexpected status removing tea that isn't in the collection: %d\n", status)
+	}
+}
+
+func TestServerHouseholds(t *testing.T) {
+	server := httptest.NewServer(NewServer(NewMemoryStore(storeTestConfig()), "signingKey").Router(true))
+	defer server.Close()
+
+	homeToken := registerTestUser(t, server, "john")
+	flatToken := registerTestUser(t, server, "jane")
+
+	var household Household
+	if status := serverRequest(t, server, http.MethodGet, "/household", flatToken, nil, &household); status != http.StatusOK || household.ID == DefaultHousehold || household.Name != "jane's household" {
+		t.Errorf("Unexpected household of second user: %+v, status: %d\n", household, status)
+	}
+	if status := serverRequest(t, server, http.MethodPost, "/owner", flatToken, Owner{Name: "Jane"}, nil); status != http.StatusCreated {
+		t.Errorf("Unexpected status creating owner with a name used by another household: %d\n", status)
+	}
+	var tea Tea
+	if status := serverRequest(t, server, http.MethodPost, "/tea", homeToken, Tea{Name: "Snowball", TeaType: TeaType{ID: 1}}, &tea); status != http.StatusCreated {
+		t.Fatalf("Unexpected status creating tea: %d\n", status)
+	}
+	if status := serverRequest(t, server, http.MethodGet, fmt.Sprintf("/tea/%d", tea.ID), flatToken, nil, nil); status != http.StatusNotFound {
+		t.Errorf("Unexpected status getting a tea from another household: %d\n", status)
+	}
+
+	var invite Invite
+	if status := serverRequest(t, server, http.MethodPost, "/household/invite", homeToken, nil, &invite); status != http.StatusCreated || invite.Household != DefaultHousehold {
+		t.Fatalf("Unexpected invite: %+v, status: %d\n", invite, status)
+	}
+	if status := serverRequest(t, server, http.MethodPost, "/household/join", flatToken, JoinRequest{Code: "0000"}, nil); status != http.StatusNotFound {
+		t.Errorf("Unexpected status joining with an unknown invite: %d\n", status)
+	}
+	if status := serverRequest(t, server, http.MethodPost, "/household/join", homeToken, JoinRequest{Code: invite.Code}, nil); status != http.StatusConflict {
+		t.Errorf("Unexpected status joining your own household: %d\n", status)
+	}
+	var response map[string]string
+	if status := serverRequest(t, server, http.MethodPost, "/household/join", flatToken, JoinRequest{Code: invite.Code}, &response); status != http.StatusOK || response["token"] == "" {
+		t.Fatalf("Unexpected response joining household: %v, status: %d\n", response, status)
+	}
+	if status := serverRequest(t, server, http.MethodGet, fmt.Sprintf("/tea/%d", tea.ID), response["token"], nil, nil); status != http.StatusOK {
+		t.Errorf("Unexpected status getting a tea after joining its household: %d\n", status)
+	}
+
+	var users []User
+	expectedUsers := []User{{Username: "jane", Role: RoleMember, Household: DefaultHousehold}, {Username: "john", Role: RoleAdmin, Household: DefaultHousehold}}
+	if status := serverRequest(t, server, http.MethodGet, "/users", homeToken, nil, &users); status != http.StatusOK || !reflect.DeepEqual(users, expectedUsers) {
+		t.Errorf("Unexpected users after joining: %v, status: %d\n wanted: %v\n", users, status, expectedUsers)
+	}
+
+	var invites []Invite
+	if status := serverRequest(t, server, http.MethodGet, "/household/invites", homeToken, nil, &invites); status != http.StatusOK || len(invites) != 1 || invites[0].Code != invite.Code {
+		t.Errorf("Unexpected invites: %+v, status: %d\n", invites, status)
+	}
+	if status := serverRequest(t, server, http.MethodDelete, "/household/invite/"+invite.Code, homeToken, nil, nil); status != http.StatusOK {
+		t.Errorf("Unexpected status deleting invite: %d\n", status)
+	}
+	request := RegisterRequest{UserLogin: UserLogin{Username: "bob", Password: "password"}, Invite: invite.Code}
+	if status := serverRequest(t, server, http.MethodPost, "/register", "", request, nil); status != http.StatusNotFound {
+		t.Errorf("Unexpected status registering with a deleted invite: %d\n", status)
+	}
+}
+
+func TestServerFirstUser(t *testing.T) {
+	server := httptest.NewServer(NewServer(NewMemoryStore(storeTestConfig()), "signingKey").Router(true))
+	defer server.Close()
+
+	homeToken := registerTestUser(t, server, "john")
+	flatToken := registerTestUser(t, server, "jane")
+
+	// Once the default household is empty, a new user still gets a household of their own
+	var invite Invite
+	if status := serverRequest(t, server, http.MethodPost, "/household/invite", flatToken, nil, &invite); status != http.StatusCreated {
+		t.Fatalf("Unexpected status creating invite: %d\n", status)
+	}
+	if status := serverRequest(t, server, http.MethodPost, "/household/join", homeToken, JoinRequest{Code: invite.Code}, nil); status != http.StatusOK {
+		t.Fatalf("Unexpected status leaving the default household: %d\n", status)
+	}
+
+	token := registerTestUser(t, server, "kate")
+	var household Household
+	if status := serverRequest(t, server, http.MethodGet, "/household", token, nil, &household); status != http.StatusOK || household.ID == DefaultHousehold || household.Name != "kate's household" {
+		t.Errorf("Unexpected household of user registering after the default household was emptied: %+v, status: %d\n", household, status)
+	}
+	var owners []Owner
+	if status := serverRequest(t, server, http.MethodGet, "/owners", token, nil, &owners); status != http.StatusOK || len(owners) != 0 {
+		t.Errorf("User can see the owners of the default household: %+v, status: %d\n", owners, status)
+	}
+}
+
+func TestServerSessions(t *testing.T) {
+	server := httptest.NewServer(NewServer(NewMemoryStore(storeTestConfig()), "signingKey").Router(true))
+	defer server.Close()
+
+	registerTestUser(t, server, "john")
+	var first, second Session
+	if status := serverRequest(t, server, http.MethodPost, "/login", "", UserLogin{Username: "john", Password: "password"}, &first); status != http.StatusOK || first.Token == "" || first.RefreshToken == "" {
+		t.Fatalf("Unexpected session after logging in: %+v, status: %d\n", first, status)
+	}
+	if status := serverRequest(t, server, http.MethodPost, "/login", "", UserLogin{Username: "john", Password: "password"}, &second); status != http.StatusOK {
+		t.Fatalf("Unexpected status logging in again: %d\n", status)
+	}
+
+	var refreshed Session
+	if status := serverRequest(t, server, http.MethodPost, "/token/refresh", "", RefreshRequest{RefreshToken: first.RefreshToken}, &refreshed); status != http.StatusOK || refreshed.Token == "" || refreshed.RefreshToken == first.RefreshToken {
+		t.Fatalf("Unexpected session after refreshing: %+v, status: %d\n", refreshed, status)
+	}
+	if status := serverRequest(t, server, http.MethodPost, "/token/refresh", "", RefreshRequest{RefreshToken: first.RefreshToken}, nil); status != http.StatusUnauthorized {
+		t.Errorf("Unexpected status using a refresh token twice: %d\n", status)
+	}
+	if status := serverRequest(t, server, http.MethodGet, "/teas", refreshed.Token, nil, nil); status != http.StatusOK {
+		t.Errorf("Unexpected status using refreshed token: %d\n", status)
+	}
+
+	if status := serverRequest(t, server, http.MethodPost, "/logout", refreshed.Token, nil, nil); status != http.StatusOK {
+		t.Errorf("Unexpected status logging out: %d\n", status)
+	}
+	if status := serverRequest(t, server, http.MethodGet, "/teas", refreshed.Token, nil, nil); status != http.StatusUnauthorized {
+		t.Errorf("Unexpected status using token after logging out: %d\n", status)
+	}
+	if status := serverRequest(t, server, http.MethodPost, "/token/refresh", "", RefreshRequest{RefreshToken: refreshed.RefreshToken}, nil); status != http.StatusUnauthorized {
+		t.Errorf("Unexpected status refreshing after logging out: %d\n", status)
+	}
+	if status := serverRequest(t, server, http.MethodGet, "/teas", second.Token, nil, nil); status != http.StatusOK {
+		t.Errorf("Logging out revoked another session, status: %d\n", status)
+	}
+
+	third := loginTestUser(t, server, "john")
+	var changed Session
+	if status := serverRequest(t, server, http.MethodPost, "/changepassword", third, NewPasswordRequest{OldPassword: "password", NewPassword: "newPassword"}, &changed); status != http.StatusOK {
+		t.Fatalf("Unexpected status changing password: %d\n", status)
+	}
+	if status := serverRequest(t, server, http.MethodGet, "/teas", changed.Token, nil, nil); status != http.StatusOK {
+		t.Errorf("Unexpected status using the session from changing password: %d\n", status)
+	}
+	for _, token := range []string{second.Token, third} {
+		if status := serverRequest(t, server, http.MethodGet, "/teas", token, nil, nil); status != http.StatusUnauthorized {
+			t.Errorf("Unexpected status using token after changing password: %d\n", status)
+		}
+	}
+	if status := serverRequest(t, server, http.MethodPost, "/token/refresh", "", RefreshRequest{RefreshToken: second.RefreshToken}, nil); status != http.StatusUnauthorized {
+		t.Errorf("Unexpected status refreshing after changing password: %d\n", status)
+	}
+}
+
+func TestServerPasswordPolicy(t *testing.T) {
+	store := NewMemoryStore(storeTestConfig())
+	api := NewServer(store, "signingKey")
+	if err := api.SetPasswordConfig(PasswordConfig{Cost: bcrypt.MinCost + 1, MinLength: 8, History: 2}); err != nil {
+		t.Fatalf("Unexpected error setting password config: %v\n", err)
+	}
+	server := httptest.NewServer(api.Router(true))
+	defer server.Close()
+
+	if status := serverRequest(t, server, http.MethodPost, "/register", "", UserLogin{Username: "john", Password: "short"}, nil); status != http.StatusUnprocessableEntity {
+		t.Errorf("Unexpected status registering with a short password: %d\n", status)
+	}
+	token := registerTestUser(t, server, "john")
+
+	// Neither the current password, nor the one before it, can be used again
+	changes := []struct {
+		old, new string
+		status   int
+	}{
+		{"password", "password", http.StatusUnprocessableEntity},
+		{"password", "tiny", http.StatusUnprocessableEntity},
+		{"password", "newPassword", http.StatusOK},
+		{"newPassword", "password", http.StatusUnprocessableEntity},
+		{"newPassword", "thirdPassword", http.StatusOK},
+		{"thirdPassword", "password", http.StatusOK},
+	}
+	for _, change := range changes {
+		if status := serverRequest(t, server, http.MethodPost, "/changepassword", token, NewPasswordRequest{OldPassword: change.old, NewPassword: change.new}, nil); status != change.status {
+			t.Errorf("Unexpected status changing password from %q to %q:\n got: %d\n wanted: %d\n", change.old, change.new, status, change.status)
+		}
+		if change.status == http.StatusOK {
+			// Changing the password logs the user out
+			var session Session
+			if status := serverRequest(t, server, http.MethodPost, "/login", "", UserLogin{Username: "john", Password: change.new}, &session); status != http.StatusOK {
+				t.Fatalf("Unexpected status logging in with new password: %d\n", status)
+			}
+			token = session.Token
+		}
+	}
+
+	// Passwords hashed with a lower cost are hashed again when the user logs in
+	weak, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err := store.ChangePassword("john", string(weak)); err != nil {
+		t.Fatalf("Unexpected error changing password: %v\n", err)
+	}
+	loginTestUser(t, server, "john")
+	if hash, err := store.GetPassword("john"); err != nil {
+		t.Errorf("Unexpected error getting password: %v\n", err)
+	} else if cost, _ := bcrypt.Cost([]byte(hash)); cost != bcrypt.MinCost+1 {
+		t.Errorf("Unexpected cost of password after logging in:\n got: %d\n wanted: %d\n", cost, bcrypt.MinCost+1)
+	}
+}
+
+func TestServerLoginLockout(t *testing.T) {
+	api := NewServer(NewMemoryStore(storeTestConfig()), "signingKey")
+	api.SetLoginConfig(LoginConfig{MaxAttempts: 2, MaxAttemptsPerAddress: 10})
+	server := httptest.NewServer(api.Router(true))
+	defer server.Close()
+
+	token := registerTestUser(t, server, "john")
+
+	var missing, wrong map[string]string
+	if status := serverRequest(t, server, http.MethodPost, "/login", "", UserLogin{Username: "jane", Password: "password"}, &missing); status != http.StatusUnauthorized {
+		t.Errorf("Unexpected status logging in as a missing user: %d\n", status)
+	}
+	if status := serverRequest(t, server, http.MethodPost, "/login", "", UserLogin{Username: "john", Password: "wrong"}, &wrong); status != http.StatusUnauthorized {
+		t.Errorf("Unexpected status logging in with the wrong password: %d\n", status)
+	}
+	if !reflect.DeepEqual(missing, wrong) {
+		t.Errorf("Failed logins for a missing user and a wrong password differ:\n %v\n %v\n", missing, wrong)
+	}
+
+	var response map[string]string
+	if status := serverRequest(t, server, http.MethodPost, "/login", "", UserLogin{Username: "john", Password: "wrong"}, &response); status != http.StatusUnauthorized {
+		t.Errorf("Unexpected status of the failed login that locks out the user: %d\n", status)
+	}
+	if status := serverRequest(t, server, http.MethodPost, "/login", "", UserLogin{Username: "john", Password: "password"}, &response); status != http.StatusTooManyRequests || response["code"] != "too_many_requests" {
+		t.Errorf("Unexpected response logging in while locked out: %v, status: %d\n", response, status)
+	}
+	if status := serverRequest(t, server, http.MethodPost, "/login", "", UserLogin{Username: "jane", Password: "password"}, nil); status != http.StatusUnauthorized {
+		t.Errorf("Unexpected status logging in as another user: %d\n", status)
+	}
+
+	var audit AuditLog
+	if status := serverRequest(t, server, http.MethodGet, "/audit", token, nil, &audit); status != http.StatusOK || audit.Total != 1 || audit.Entries[0].Event != AuditUserLocked || audit.Entries[0].Username != "john" {
+		t.Errorf("Unexpected audit log: %+v, status: %d\n", audit, status)
+	}
+}
+
+func TestServerAPIKeys(t *testing.T) {
+	server := httptest.NewServer(NewServer(NewMemoryStore(storeTestConfig()), "signingKey").Router(true))
+	defer server.Close()
+
+	token := registerTestUser(t, server, "john")
+
+	var key NewAPIKey
+	if status := serverRequest(t, server, http.MethodPost, "/me/keys", token, APIKeyRequest{Name: "Kitchen display"}, &key); status != http.StatusCreated || !strings.HasPrefix(key.Key, APIKeyPrefix) || !reflect.DeepEqual(key.Permissions, []Permission{PermissionRead}) {
+		t.Fatalf("Unexpected API key created: %+v, status: %d\n", key, status)
+	}
+	if status := serverRequest(t, server, http.MethodPost, "/me/keys", token, APIKeyRequest{Name: "Script", Permissions: []Permission{"everything"}}, nil); status != http.StatusUnprocessableEntity {
+		t.Errorf("Unexpected status creating API key with an unknown permission: %d\n", status)
+	}
+
+	for _, header := range []string{APIKeyHeader, AuthorizationHeader} {
+		value := key.Key
+		if header == AuthorizationHeader {
+			value = "Bearer " + key.Key
+		}
+		if status := apiKeyRequest(t, server, http.MethodGet, "/teas", header, value); status != http.StatusOK {
+			t.Errorf("Unexpected status getting teas with API key in %s header: %d\n", header, status)
+		}
+	}
+	if status := apiKeyRequest(t, server, http.MethodPost, "/owner", APIKeyHeader, key.Key); status != http.StatusForbidden {
+		t.Errorf("Unexpected status using API key without permission: %d\n", status)
+	}
+	if status := apiKeyRequest(t, server, http.MethodGet, "/me/keys", APIKeyHeader, key.Key); status != http.StatusForbidden {
+		t.Errorf("Unexpected status managing API keys with an API key: %d\n", status)
+	}
+	if status := apiKeyRequest(t, server, http.MethodGet, "/teas", APIKeyHeader, APIKeyPrefix+"wrong"); status != http.StatusUnauthorized {
+		t.Errorf("Unexpected status using an unknown API key: %d\n", status)
+	}
+
+	var keys []APIKey
+	if status := serverRequest(t, server, http.MethodGet, "/me/keys", token, nil, &keys); status != http.StatusOK || len(keys) != 1 || keys[0].Prefix != key.Prefix || keys[0].LastUsedAt == nil {
+		t.Errorf("Unexpected API keys: %+v, status: %d\n", keys, status)
+	}
+	if status := serverRequest(t, server, http.MethodDelete, fmt.Sprintf("/me/key/%d", key.ID), token, nil, nil); status != http.StatusOK {
+		t.Errorf("Unexpected status deleting API key: %d\n", status)
+	}
+	if status := apiKeyRequest(t, server, http.MethodGet, "/teas", APIKeyHeader, key.Key); status != http.StatusUnauthorized {
+		t.Errorf("Unexpected status using a deleted API key: %d\n", status)
+	}
+}
+
+// apiKeyRequest sends a request to a test server with an API key in the given header, giving the status of the response.
+func apiKeyRequest(t *testing.T, server *httptest.Server, method string, path string, header string, value string) int {
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader("{}"))
+	if err != nil {
+		t.Fatalf("Error creating request: %v\n", err)
+	}
+	req.Header.Set(header, value)
+
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Error sending request %s %s: %v\n", method, path, err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestServerListPages(t *testing.T) {
+	server := httptest.NewServer(NewServer(NewMemoryStore(storeTestConfig()), "signingKey").Router(true))
+	defer server.Close()
+
+	token := registerTestUser(t, server, "john")
+	for _, name := range []string{"Snowball", "Assam", "Sencha", "Earl Grey", "Darjeeling"} {
+		if status := serverRequest(t, server, http.MethodPost, "/tea", token, Tea{Name: name, TeaType: TeaType{ID: 1}}, nil); status != http.StatusCreated {
+			t.Fatalf("Unexpected status creating tea %q: %d\n", name, status)
+		}
+	}
+
+	// Following the next links gives every tea once
+	names := make([]string, 0)
+	for path, pages := "/teas?sort=name&limit=2", 0; path != ""; pages++ {
+		if pages > 3 {
+			t.Fatalf("Too many pages: %q\n", names)
+		}
+		var teas []Tea
+		header := listRequest(t, server, path, token, &teas)
+		for _, tea := range teas {
+			names = append(names, tea.Name)
+		}
+
+		path = ""
+		if link := header.Get("Link"); link != "" {
+			path = strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`)
+			if !strings.Contains(path, "cursor="+header.Get(NextCursorHeader)) {
+				t.Errorf("Next link %q doesn't have the next cursor %q\n", link, header.Get(NextCursorHeader))
+			}
+		}
+	}
+	expected := []string{"Assam", "Darjeeling", "Earl Grey", "Sencha", "Snowball"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Unexpected teas:\n got: %q\n wanted: %q\n", names, expected)
+	}
+
+	// Without a limit, a page has the default number of items
+	var teas []Tea
+	if header := listRequest(t, server, "/teas?name=s", token, &teas); len(teas) != 2 || header.Get("Link") != "" {
+		t.Errorf("Unexpected teas without a limit: %+v, link: %q\n", teas, header.Get("Link"))
+	}
+	for i := 0; i < DefaultListLimit; i++ {
+		if status := serverRequest(t, server, http.MethodPost, "/tea", token, Tea{Name: fmt.Sprintf("Tea %d", i), TeaType: TeaType{ID: 1}}, nil); status != http.StatusCreated {
+			t.Fatalf("Unexpected status creating tea %d: %d\n", i, status)
+		}
+	}
+	teas = nil
+	header := listRequest(t, server, "/teas", token, &teas)
+	if len(teas) != DefaultListLimit || header.Get(NextCursorHeader) == "" {
+		t.Fatalf("Unexpected first page without a limit: %d teas, next cursor: %q\n", len(teas), header.Get(NextCursorHeader))
+	}
+	teas = nil
+	if header := listRequest(t, server, "/teas?cursor="+header.Get(NextCursorHeader), token, &teas); len(teas) != 5 || header.Get("Link") != "" {
+		t.Errorf("Unexpected last page without a limit: %d teas, link: %q\n", len(teas), header.Get("Link"))
+	}
+
+	cursor := encodeListCursor(ListCursor{Sort: SortName, ID: 1, Name: "Assam"})
+	for _, path := range []string{"/teas?sort=price", "/teas?limit=0", "/teas?limit=101", "/teas?cursor=notACursor", "/teas?cursor=" + cursor, "/owners?sort=-id&cursor=" + cursor, "/teas/owners?owner=abc"} {
+		if status := serverRequest(t, server, http.MethodGet, path, token, nil, nil); status != http.StatusUnprocessableEntity {
+			t.Errorf("Unexpected status getting %s: %d\n", path, status)
+		}
+	}
+}
+
+func TestServerSearch(t *testing.T) {
+	server := httptest.NewServer(NewServer(NewMemoryStore(storeTestConfig()), "signingKey").Router(true))
+	defer server.Close()
+
+	token := registerTestUser(t, server, "john")
+	for _, tea := range []Tea{{Name: "Earl Grey", TeaType: TeaType{ID: 1}}, {Name: "Sencha", TeaType: TeaType{ID: 2}, Notes: "Grassy"}, {Name: "Gunpowder", TeaType: TeaType{ID: 2}}} {
+		if status := serverRequest(t, server, http.MethodPost, "/tea", token, tea, nil); status != http.StatusCreated {
+			t.Fatalf("Unexpected status creating tea %q: %d\n", tea.Name, status)
+		}
+	}
+
+	var teas []Tea
+	if status := serverRequest(t, server, http.MethodGet, "/search?q=green+grass", token, nil, &teas); status != http.StatusOK || len(teas) != 1 || teas[0].Name != "Sencha" || teas[0].TeaType.Name != "Green Tea" {
+		t.Errorf("Unexpected teas found: %+v, status: %d\n", teas, status)
+	}
+	if status := serverRequest(t, server, http.MethodGet, "/search?q=green&limit=1", token, nil, &teas); status != http.StatusOK || len(teas) != 1 {
+		t.Errorf("Unexpected teas found with a limit: %+v, status: %d\n", teas, status)
+	}
+	if status := serverRequest(t, server, http.MethodGet, "/search?q=senca", token, nil, &teas); status != http.StatusOK || len(teas) != 1 || teas[0].Name != "Sencha" {
+		t.Errorf("Unexpected teas found with a typo: %+v, status: %d\n", teas, status)
+	}
+
+	for _, path := range []string{"/search", "/search?q=%21%3F", "/search?q=green&limit=0", "/search?q=green&limit=101"} {
+		if status := serverRequest(t, server, http.MethodGet, path, token, nil, nil); status != http.StatusUnprocessableEntity {
+			t.Errorf("Unexpected status getting %s: %d\n", path, status)
+		}
+	}
+	if status := serverRequest(t, server, http.MethodGet, "/search?q=green", "", nil, nil); status != http.StatusUnauthorized {
+		t.Errorf("Unexpected status searching without a token: %d\n", status)
+	}
+}
+
+func TestServerCreateTeaWithOwners(t *testing.T) {
+	server := httptest.NewServer(NewServer(NewMemoryStore(storeTestConfig()), "signingKey").Router(true))
+	defer server.Close()
+	token := registerTestUser(t, server, "john")
+
+	// Owners can be given by ID, by name or with their stock, and the type is created by name
+	body := `{"name": "Tie Guan Yin", "type": {"name": "Oolong", "steepSeconds": 60}, "owners": [1, "Jane"]}`
+	var created TeaWithOwners
+	status, _, response := rawRequest(t, server, http.MethodPost, "/tea?createType=true", token, "application/json", body)
+	if err := json.Unmarshal([]byte(response), &created); err != nil || status != http.StatusCreated {
+		t.Fatalf("Unexpected response creating tea with owners: %s, status: %d\n", response, status)
+	}
+	if created.Tea.ID == 0 || created.Tea.TeaType.Name != "Oolong" || len(created.Owners) != 2 || created.Owners[1].Name != "Jane" {
+		t.Errorf("Unexpected tea created with owners: %+v\n", created)
+	}
+	body = `{"name": "Assam", "type": {"id": 1}, "owners": [{"name": "John", "quantity": 20}]}`
+	status, _, response = rawRequest(t, server, http.MethodPost, "/tea", token, "application/json", body)
+	if err := json.Unmarshal([]byte(response), &created); err != nil || status != http.StatusCreated || created.Owners[0].Unit != UnitBags || *created.Owners[0].Quantity != 20 {
+		t.Errorf("Unexpected response creating tea with stock: %s, status: %d\n", response, status)
+	}
+
+	// Without owners, the tea is given back on its own
+	var tea Tea
+	if status := serverRequest(t, server, http.MethodPost, "/tea", token, map[string]interface{}{"name": "Ceylon", "type": map[string]string{"name": "Black Tea"}}, &tea); status != http.StatusCreated || tea.ID == 0 || tea.TeaType.ID != 1 {
+		t.Errorf("Unexpected tea created with a type by name: %+v, status: %d\n", tea, status)
+	}
+
+	invalid := []struct {
+		path string
+		body string
+	}{
+		{"/tea", `{"name": "Sencha", "type": {"name": "Green"}, "owners": []}`},
+		{"/tea?createType=maybe", `{"name": "Sencha", "type": {"id": 2}, "owners": []}`},
+		{"/tea", `{"name": "Sencha", "type": {"id": 2}, "owners": ["Nobody"]}`},
+		{"/tea", `{"name": "Sencha", "type": {"id": 2}, "owners": [1, "John"]}`},
+		{"/tea", `{"name": "Sencha", "type": {"id": 2}, "owners": [{"id": 1, "quantity": -1}]}`},
+	}
+	for _, request := range invalid {
+		if status, _, response := rawRequest(t, server, http.MethodPost, request.path, token, "application/json", request.body); status != http.StatusUnprocessableEntity {
+			t.Errorf("Unexpected status creating tea with %s: %d, body: %s\n", request.body, status, response)
+		}
+	}
+	if status, _, _ := rawRequest(t, server, http.MethodPost, "/tea", token, "application/json", `{"name": "Sencha", "owners": [true]}`); status != http.StatusBadRequest {
+		t.Errorf("Unexpected status creating tea with an invalid owner: %d\n", status)
+	}
+
+	// A member linked to an owner can only create teas for that owner
+	memberToken := inviteTestUser(t, server, token, "jane")
+	if status := serverRequest(t, server, http.MethodPut, "/user/jane/owner", token, LinkOwnerRequest{OwnerID: 2}, nil); status != http.StatusOK {
+		t.Fatalf("Unexpected status linking user to owner: %d\n", status)
+	}
+	if status, _, response := rawRequest(t, server, http.MethodPost, "/tea", memberToken, "application/json", `{"name": "Sencha", "type": {"id": 2}, "owners": ["Jane", "John"]}`); status != http.StatusForbidden {
+		t.Errorf("Unexpected status creating tea for another owner: %d, body: %s\n", status, response)
+	}
+	if status, _, response := rawRequest(t, server, http.MethodPost, "/tea", memberToken, "application/json", `{"name": "Gunpowder", "type": {"id": 2}, "owners": ["Jane"]}`); status != http.StatusCreated {
+		t.Errorf("Unexpected status creating tea for own owner: %d, body: %s\n", status, response)
+	}
+
+	var teas []Tea
+	if status := serverRequest(t, server, http.MethodGet, "/teas?name=Sencha", token, nil, &teas); status != http.StatusOK || len(teas) != 0 {
+		t.Errorf("Unexpected teas after failing to create them: %+v, status: %d\n", teas, status)
+	}
+}
+
+func TestServerImportExport(t *testing.T) {
+	server := httptest.NewServer(NewServer(NewMemoryStore(storeTestConfig()), "signingKey").Router(true))
+	defer server.Close()
+
+	john := registerTestUser(t, server, "john")
+	tea := Tea{Name: "Earl Grey", TeaType: TeaType{ID: 1}, BrewingGuide: BrewingGuide{SteepSeconds: 200}, Notes: "Bergamot, and cornflowers"}
+	if status := serverRequest(t, server, http.MethodPost, "/tea", john, tea, &tea); status != http.StatusCreated {
+		t.Fatalf("Unexpected status creating tea: %d\n", status)
+	}
+	quantity := 40.0
+	if status := serverRequest(t, server, http.MethodPost, fmt.Sprintf("/tea/%d/owner", tea.ID), john, map[string]interface{}{"id": 2, "quantity": quantity, "unit": UnitBags}, nil); status != http.StatusCreated {
+		t.Fatalf("Unexpected status adding owner to tea: %d\n", status)
+	}
+	status, header, exported := rawRequest(t, server, http.MethodGet, "/export?format=csv", john, "", "")
+	if status != http.StatusOK || header.Get("Content-Type") != "text/csv" || !strings.Contains(exported, "ownership,Earl Grey,,,,,,,Jane,40,bags") {
+		t.Fatalf("Unexpected CSV export: %q, status: %d\n", exported, status)
+	}
+
+	// A dry run of importing the export into another household doesn't change it
+	jane := registerTestUser(t, server, "jane")
+	var report ImportReport
+	if status := serverRequest(t, server, http.MethodPost, "/import?format=csv&dryRun=true", jane, nil, nil); status != http.StatusBadRequest {
+		t.Errorf("Unexpected status importing empty CSV: %d\n", status)
+	}
+	status, _, body := rawRequest(t, server, http.MethodPost, "/import?dryRun=true", jane, "text/csv", exported)
+	if err := json.Unmarshal([]byte(body), &report); err != nil || status != http.StatusOK || !report.DryRun || report.Created != 6 {
+		t.Fatalf("Unexpected report of dry run: %s, status: %d\n", body, status)
+	}
+	if _, _, empty := rawRequest(t, server, http.MethodGet, "/export?format=csv", jane, "", ""); strings.Count(empty, "\n") != 1 {
+		t.Errorf("Unexpected export after dry run: %q\n", empty)
+	}
+
+	// Importing it gives the same collection, in CSV or JSON
+	if status, _, body := rawRequest(t, server, http.MethodPost, "/import?format=csv", jane, "", exported); status != http.StatusOK {
+		t.Fatalf("Unexpected status importing CSV: %d, body: %s\n", status, body)
+	}
+	if _, _, imported := rawRequest(t, server, http.MethodGet, "/export?format=csv", jane, "", ""); imported != exported {
+		t.Errorf("Unexpected export after importing CSV:\n got: %q\n wanted: %q\n", imported, exported)
+	}
+	var collection Collection
+	if status := serverRequest(t, server, http.MethodGet, "/export", john, nil, &collection); status != http.StatusOK || len(collection.Teas) != 1 || collection.Teas[0].Tea.Notes != tea.Notes {
+		t.Fatalf("Unexpected JSON export: %+v, status: %d\n", collection, status)
+	}
+	kate := registerTestUser(t, server, "kate")
+	if status := serverRequest(t, server, http.MethodPost, "/import", kate, collection, &report); status != http.StatusOK || report.Created != 6 || report.Failed != 0 {
+		t.Fatalf("Unexpected report of importing JSON: %+v, status: %d\n", report, status)
+	}
+	if _, _, imported := rawRequest(t, server, http.MethodGet, "/export?format=csv", kate, "", ""); imported != exported {
+		t.Errorf("Unexpected export after importing JSON:\n got: %q\n wanted: %q\n", imported, exported)
+	}
+
+	// A member linked to an owner can only import teas for that owner
+	member := inviteTestUser(t, server, john, "liz")
+	if status := serverRequest(t, server, http.MethodPut, "/user/liz/owner", john, LinkOwnerRequest{OwnerID: 2}, nil); status != http.StatusOK {
+		t.Fatalf("Unexpected status linking user to owner: %d\n", status)
+	}
+	assam := Collection{Teas: []TeaWithOwners{{Tea: Tea{Name: "Assam", TeaType: TeaType{Name: "Black Tea"}}, Owners: []TeaOwner{{Owner: Owner{Name: "John"}}, {Owner: Owner{Name: "Jane"}}}}}}
+	if status := serverRequest(t, server, http.MethodPost, "/import", member, assam, &report); status != http.StatusOK || report.Created != 2 || report.Failed != 1 || report.Rows[1].Owner != "John" || report.Rows[1].Error != errOtherOwner.Error() {
+		t.Errorf("Unexpected report of importing for another owner: %+v, status: %d\n", report, status)
+	}
+
+	for _, path := range []string{"/export?format=xml", "/import?format=xml", "/import?dryRun=maybe"} {
+		method := http.MethodGet
+		if strings.HasPrefix(path, "/import") {
+			method = http.MethodPost
+		}
+		if status := serverRequest(t, server, method, path, john, Collection{}, nil); status != http.StatusUnprocessableEntity {
+			t.Errorf("Unexpected status sending %s %s: %d\n", method, path, status)
+		}
+	}
+}
+
+// rawRequest sends a request with a body that isn't JSON to a test server, giving the status, headers and body of the
+// response.
+func rawRequest(t *testing.T, server *httptest.Server, method string, path string, token string, contentType string, body string) (int, http.Header, string) {
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Error creating request: %v\n", err)
+	}
+	req.Header.Set("Token", token)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Error sending request %s %s: %v\n", method, path, err)
+	}
+	defer resp.Body.Close()
+	var response bytes.Buffer
+	if _, err := response.ReadFrom(resp.Body); err != nil {
+		t.Fatalf("Error reading response to %s %s: %v\n", method, path, err)
+	}
+	return resp.StatusCode, resp.Header, response.String()
+}
+
+// listRequest gets a page of a list from a test server, giving the headers of the response.
+func listRequest(t *testing.T, server *httptest.Server, path string, token string, result interface{}) http.Header {
+	req, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+	if err != nil {
+		t.Fatalf("Error creating request: %v\n", err)
+	}
+	req.Header.Set("Token", token)
+
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Error sending request GET %s: %v\n", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Unexpected status getting %s: %d\n", path, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		t.Fatalf("Error decoding response to GET %s: %v\n", path, err)
+	}
+	return resp.Header
+}
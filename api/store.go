@@ -0,0 +1,235 @@
+package main
+
+import "time"
+
+// A Store holds all of the data used by the API. Whichever store is used, a record that doesn't exist is reported
+// with an ErrNotFound error, and a record that clashes with another with an ErrConflict error, so handlers can treat
+// every store the same.
+//
+// Each store only holds the data of one household, which for a newly opened store is the default household. Teas,
+// owners, tea types and the rest of a household's data can't be seen from any other household. Users belong to a
+// household too, but usernames are unique across every household, so users can be found when they log in.
+type Store interface {
+	HouseholdStore
+	UserStore
+	TokenStore
+	APIKeyStore
+	IdentityStore
+	TeaTypeStore
+	OwnerStore
+	TeaStore
+	OwnershipStore
+	ImportStore
+	SelectionStore
+	RatingStore
+	WebhookStore
+	AuditStore
+}
+
+// Errors shared by every store.
+var (
+	errHouseholdMissing  = newError(ErrNotFound, "Household does not exist")
+	errInviteMissing     = newError(ErrNotFound, "Invite code does not exist or has expired")
+	errUserMissing       = newError(ErrNotFound, "User doesn't exist")
+	errRefreshMissing    = newError(ErrNotFound, "Refresh token does not exist or has expired")
+	errAPIKeyMissing     = newError(ErrNotFound, "API key does not exist")
+	errIdentityMissing   = newError(ErrNotFound, "No user is linked to this login")
+	errIdentityLinked    = newError(ErrConflict, "This login is already linked to a user")
+	errUsernameTaken     = newError(ErrConflict, "Username is already taken")
+	errTeaTypeNameTaken  = newError(ErrConflict, "A tea type with this name already exists")
+	errTeaTypeInUse      = newError(ErrConflict, "This tea type still has teas")
+	errTeaTypeMissingID  = newError(ErrNotFound, "Tea type does not exist")
+	errTeaTypeMissing    = newError(ErrValidation, "Tea type does not exist or is missing")
+	errOwnerNameTaken    = newError(ErrConflict, "An owner with this name already exists")
+	errOwnerMissing      = newError(ErrNotFound, "Owner does not exist")
+	errOwnerInUse        = newError(ErrConflict, "This owner still owns teas")
+	errOwnerLinked       = newError(ErrConflict, "This owner is already linked to another user")
+	errTeaNameTaken      = newError(ErrConflict, "A tea with this name already exists")
+	errTeaMissing        = newError(ErrNotFound, "Tea does not exist")
+	errTeaInUse          = newError(ErrConflict, "This tea still has owners")
+	errTeaOwnerExists    = newError(ErrConflict, "This relationship already exists")
+	errTeaOwnerMissing   = newError(ErrNotFound, "This owner doesn't own this tea")
+	errTeaOrOwnerMissing = newError(ErrNotFound, "Either the tea or owner ID do not exist in the database")
+	errRatingExists      = newError(ErrConflict, "This owner has already rated this tea")
+	errRatingMissing     = newError(ErrNotFound, "This owner hasn't rated this tea")
+)
+
+// DefaultHousehold is the ID of the household created along with the database, which has the tea types and owners
+// from the config, and all of the data from before households were added.
+const DefaultHousehold = 1
+
+// A HouseholdStore holds the households sharing the API, and the invites used to join them.
+type HouseholdStore interface {
+	// ForHousehold gives a store holding the data of the given household, which must exist.
+	ForHousehold(household int) Store
+	GetHousehold() (Household, error)
+	// CreateHousehold adds a new household, giving it an ID, along with its first user, who is an admin.
+	CreateHousehold(household *Household, admin UserLogin) error
+	// JoinHousehold moves a user into the store's household, giving them a role. They are unlinked from their owner,
+	// as it belongs to their old household.
+	JoinHousehold(username string, role string) error
+	// GetInvite gets an invite that hasn't expired, from any household.
+	GetInvite(code string) (Invite, error)
+	GetInvites() ([]Invite, error)
+	CreateInvite(invite *Invite) error
+	DeleteInvite(code string) error
+}
+
+// A UserStore holds the users who can log in, along with their hashed passwords and roles.
+// Users are found in any household by GetPassword, GetUser, ChangePassword and the password history methods, so that
+// they can log in, while the other methods only see the users in the store's household.
+type UserStore interface {
+	GetPassword(username string) (string, error)
+	GetUser(username string) (User, error)
+	GetAllUsers() ([]User, error)
+	// CreateUser adds a user to the store's household.
+	CreateUser(user UserLogin, role string) error
+	// CreateFirstUser adds a user to the default household as its admin, but only if there are no users in any
+	// household yet, giving whether they were added. Only one user can ever be added this way.
+	CreateFirstUser(user UserLogin) (bool, error)
+	ChangePassword(username string, password string) error
+	// GetPasswordHistory gets the hashes of a user's previous passwords, most recent first.
+	GetPasswordHistory(username string) ([]string, error)
+	// AddPasswordHistory records the hash of a user's previous password, only keeping the given number of the most
+	// recent.
+	AddPasswordHistory(username string, password string, keep int) error
+	// ReplacePassword changes a user's password, records their previous one as AddPasswordHistory does, and revokes
+	// all of their sessions, all at once.
+	ReplacePassword(username string, password string, previous string, keep int) error
+	SetUserRole(username string, role string) error
+	// SetUserOwner links a user to an owner, so they can manage the owner's teas as their own. An owner ID of zero
+	// unlinks them. Each owner can only be linked to one user.
+	SetUserOwner(username string, ownerID int) error
+}
+
+// A TokenStore holds the refresh tokens that keep users logged in, and the access tokens that were revoked before they
+// expired. Tokens belong to a user in any household.
+type TokenStore interface {
+	CreateRefreshToken(token RefreshToken) error
+	// UseRefreshToken gets a refresh token that hasn't expired by its hash, and deletes it so it can only be used once.
+	UseRefreshToken(hash string) (RefreshToken, error)
+	// RevokeSession revokes an access token until it expires, along with the refresh token issued with it.
+	RevokeSession(accessTokenID string, expiresAt time.Time) error
+	// RevokeUserSessions revokes all of a user's refresh tokens, and the access tokens issued with them.
+	RevokeUserSessions(username string) error
+	IsTokenRevoked(accessTokenID string) (bool, error)
+}
+
+// An APIKeyStore holds the API keys each user has created. Keys belong to a user in any household.
+type APIKeyStore interface {
+	// CreateAPIKey stores a new API key, giving it an ID.
+	CreateAPIKey(key *APIKey) error
+	// UseAPIKey gets an API key by its hash, recording that it was used.
+	UseAPIKey(hash string) (APIKey, error)
+	GetAPIKeys(username string) ([]APIKey, error)
+	DeleteAPIKey(username string, id int) error
+}
+
+// An IdentityStore links users to the subjects that identity providers know them by, so they can log in with a provider
+// instead of a password. Identities belong to a user in any household.
+type IdentityStore interface {
+	// GetIdentityUser gets the user linked to a subject of an identity provider.
+	GetIdentityUser(issuer string, subject string) (User, error)
+	CreateIdentity(identity Identity) error
+	// CreateIdentityUser adds a user without a password, linked to a subject of an identity provider, all at once. As
+	// when registering, the very first user joins the default household, and anyone else is the admin of a new
+	// household, which is given an ID.
+	CreateIdentityUser(identity Identity, household *Household) (User, error)
+}
+
+// A TeaTypeStore holds the types of tea.
+type TeaTypeStore interface {
+	// GetAllTeaTypes gets the tea types in a list, which can be filtered by name.
+	GetAllTeaTypes(options ListOptions) ([]TeaType, error)
+	GetTeaType(teaType *TeaType) error
+	CreateTeaType(teaType *TeaType) error
+	UpdateTeaType(teaType *TeaType) error
+	DeleteTeaType(teaType *TeaType) error
+	GetAllTypesTeas() ([]TypeWithTeas, error)
+}
+
+// An OwnerStore holds the people who own teas.
+type OwnerStore interface {
+	// GetAllOwners gets the owners in a list, which can be filtered by name.
+	GetAllOwners(options ListOptions) ([]Owner, error)
+	GetOwner(owner *Owner) error
+	CreateOwner(owner *Owner) error
+	UpdateOwner(owner *Owner) error
+	DeleteOwner(owner *Owner) error
+	GetAllOwnersTeas() ([]OwnerWithTeas, error)
+}
+
+// A TeaStore holds the teas.
+type TeaStore interface {
+	// GetAllTeas gets the teas in a list, which can be filtered by name, type and owner.
+	GetAllTeas(options ListOptions) ([]Tea, error)
+	// SearchTeas gets the teas whose name, type or notes match every term, most relevant first. If none do, teas are
+	// found by names of teas and types that are close to every term, so that typos are allowed. There is no limit to
+	// how many teas are given if the limit is zero.
+	SearchTeas(terms []string, limit int) ([]Tea, error)
+	GetTea(tea *Tea) error
+	CreateTea(tea *Tea) error
+	UpdateTea(tea *Tea) error
+	DeleteTea(tea *Tea) error
+}
+
+// An OwnershipStore holds which owners have each tea, and how much of it they have left.
+type OwnershipStore interface {
+	GetTeaOwners(tea *Tea) ([]TeaOwner, error)
+	// GetAllTeaOwners gets the teas in a list, which can be filtered by name, type and owner, along with their owners.
+	GetAllTeaOwners(options ListOptions) ([]TeaWithOwners, error)
+	CreateTeaOwner(teaID int, owner *Owner, stock Stock) (Tea, error)
+	// CreateTeaWithOwners creates a tea along with its owners, all at once. A type given by name is created if
+	// createType is set and it doesn't exist, and owners can be given by name.
+	CreateTeaWithOwners(tea *Tea, owners []TeaOwner, createType bool) (TeaWithOwners, error)
+	DeleteTeaOwner(tea *Tea, owner *Owner) error
+	ConsumeTeaStock(teaID int, ownerID int, quantity float64) (Stock, error)
+	RestockTea(teaID int, ownerID int, change Stock) (Stock, error)
+	GetStock(ownerIDs []int) (map[int][]Stock, error)
+	// SetStockObserver sets who is told about every change to an owner's stock of a tea.
+	SetStockObserver(observer StockObserver)
+}
+
+// An ImportStore adds whole collections of tea types, owners and teas at once.
+type ImportStore interface {
+	// ImportCollection imports a collection, all at once, reporting what happened to each item. Nothing is changed by a
+	// dry run. Teas are only added to owners that checkOwner allows, and other ownerships are reported as failed.
+	ImportCollection(collection Collection, dryRun bool, checkOwner func(ownerID int) error) (ImportReport, error)
+}
+
+// A SelectionStore holds the teas that can be selected, and the history of selections.
+type SelectionStore interface {
+	GetSelectionCandidates(options SelectionOptions) ([]Tea, error)
+	CreateSelection(selection *SelectionRecord) error
+	GetSelections(limit int, offset int) ([]SelectionRecord, int, error)
+	GetLastSelected() (map[int]time.Time, error)
+}
+
+// A RatingStore holds each owner's ratings of teas.
+type RatingStore interface {
+	GetTeaRatings(tea *Tea) ([]Rating, error)
+	CreateTeaRating(teaID int, rating *Rating) error
+	UpdateTeaRating(teaID int, rating *Rating) error
+	DeleteTeaRating(teaID int, owner *Owner) error
+	GetAverageRatings(ownerIDs []int) (map[int]float64, error)
+}
+
+// A WebhookStore records the deliveries made to webhooks.
+type WebhookStore interface {
+	CreateWebhookDelivery(delivery *WebhookDelivery) error
+	UpdateWebhookDelivery(delivery *WebhookDelivery) error
+	GetWebhookDeliveries(limit int, offset int) ([]WebhookDelivery, int, error)
+}
+
+// An AuditStore holds the household's audit log.
+type AuditStore interface {
+	CreateAuditEntry(entry *AuditEntry) error
+	// GetAuditEntries gets a page of the audit log, most recent first, along with the total number of entries.
+	GetAuditEntries(limit int, offset int) ([]AuditEntry, int, error)
+}
+
+// A StockObserver is told whenever an owner's stock of a tea is changed by consuming or restocking it, along with
+// the household the tea belongs to.
+type StockObserver interface {
+	StockChanged(household int, teaID int, ownerID int, before Stock, after Stock)
+}
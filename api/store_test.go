@@ -0,0 +1,1321 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// mockStore is a Store that calls the function given for each method, so tests can choose what the store returns.
+// Calling a method that hasn't been given a function panics.
+type mockStore struct {
+	getHousehold           func() (Household, error)
+	createHousehold        func(*Household, UserLogin) error
+	joinHousehold          func(string, string) error
+	getInvite              func(string) (Invite, error)
+	getInvites             func() ([]Invite, error)
+	createInvite           func(*Invite) error
+	deleteInvite           func(string) error
+	getPassword            func(string) (string, error)
+	getUser                func(string) (User, error)
+	getAllUsers            func() ([]User, error)
+	createUser             func(UserLogin, string) error
+	createFirstUser        func(UserLogin) (bool, error)
+	changePassword         func(string, string) error
+	setUserRole            func(string, string) error
+	setUserOwner           func(string, int) error
+	createRefreshToken     func(RefreshToken) error
+	useRefreshToken        func(string) (RefreshToken, error)
+	revokeSession          func(string, time.Time) error
+	revokeUserSessions     func(string) error
+	isTokenRevoked         func(string) (bool, error)
+	createAPIKey           func(*APIKey) error
+	useAPIKey              func(string) (APIKey, error)
+	getAPIKeys             func(string) ([]APIKey, error)
+	deleteAPIKey           func(string, int) error
+	getIdentityUser        func(string, string) (User, error)
+	getPasswordHistory     func(string) ([]string, error)
+	addPasswordHistory     func(string, string, int) error
+	createIdentity         func(Identity) error
+	createIdentityUser     func(Identity, *Household) (User, error)
+	replacePassword        func(string, string, string, int) error
+	getAllTeaTypes         func(ListOptions) ([]TeaType, error)
+	getTeaType             func(*TeaType) error
+	createTeaType          func(*TeaType) error
+	updateTeaType          func(*TeaType) error
+	deleteTeaType          func(*TeaType) error
+	getAllTypesTeas        func() ([]TypeWithTeas, error)
+	getAllOwners           func(ListOptions) ([]Owner, error)
+	getOwner               func(*Owner) error
+	createOwner            func(*Owner) error
+	updateOwner            func(*Owner) error
+	deleteOwner            func(*Owner) error
+	getAllOwnersTeas       func() ([]OwnerWithTeas, error)
+	getAllTeas             func(ListOptions) ([]Tea, error)
+	searchTeas             func([]string, int) ([]Tea, error)
+	importCollection       func(Collection, bool, func(int) error) (ImportReport, error)
+	getTea                 func(*Tea) error
+	createTea              func(*Tea) error
+	updateTea              func(*Tea) error
+	deleteTea              func(*Tea) error
+	getTeaOwners           func(*Tea) ([]TeaOwner, error)
+	getAllTeaOwners        func(ListOptions) ([]TeaWithOwners, error)
+	createTeaOwner         func(int, *Owner, Stock) (Tea, error)
+	createTeaWithOwners    func(*Tea, []TeaOwner, bool) (TeaWithOwners, error)
+	deleteTeaOwner         func(*Tea, *Owner) error
+	consumeTeaStock        func(int, int, float64) (Stock, error)
+	restockTea             func(int, int, Stock) (Stock, error)
+	getStock               func([]int) (map[int][]Stock, error)
+	getSelectionCandidates func(SelectionOptions) ([]Tea, error)
+	createSelection        func(*SelectionRecord) error
+	getSelections          func(int, int) ([]SelectionRecord, int, error)
+	getLastSelected        func() (map[int]time.Time, error)
+	getTeaRatings          func(*Tea) ([]Rating, error)
+	createTeaRating        func(int, *Rating) error
+	updateTeaRating        func(int, *Rating) error
+	deleteTeaRating        func(int, *Owner) error
+	getAverageRatings      func([]int) (map[int]float64, error)
+	createWebhookDelivery  func(*WebhookDelivery) error
+	updateWebhookDelivery  func(*WebhookDelivery) error
+	getWebhookDeliveries   func(int, int) ([]WebhookDelivery, int, error)
+	createAuditEntry       func(*AuditEntry) error
+	getAuditEntries        func(int, int) ([]AuditEntry, int, error)
+}
+
+func (m *mockStore) SetStockObserver(observer StockObserver) {}
+
+// ForHousehold gives the same mock store, so tests choose what every household's store returns.
+func (m *mockStore) ForHousehold(household int) Store {
+	return m
+}
+
+func (m *mockStore) GetHousehold() (Household, error) {
+	return m.getHousehold()
+}
+
+func (m *mockStore) CreateHousehold(household *Household, admin UserLogin) error {
+	return m.createHousehold(household, admin)
+}
+
+func (m *mockStore) JoinHousehold(username string, role string) error {
+	return m.joinHousehold(username, role)
+}
+
+func (m *mockStore) GetInvite(code string) (Invite, error) {
+	return m.getInvite(code)
+}
+
+func (m *mockStore) GetInvites() ([]Invite, error) {
+	return m.getInvites()
+}
+
+func (m *mockStore) CreateInvite(invite *Invite) error {
+	return m.createInvite(invite)
+}
+
+func (m *mockStore) DeleteInvite(code string) error {
+	return m.deleteInvite(code)
+}
+
+func (m *mockStore) GetPassword(username string) (string, error) {
+	return m.getPassword(username)
+}
+
+func (m *mockStore) GetUser(username string) (User, error) {
+	return m.getUser(username)
+}
+
+func (m *mockStore) GetAllUsers() ([]User, error) {
+	return m.getAllUsers()
+}
+
+func (m *mockStore) CreateUser(user UserLogin, role string) error {
+	return m.createUser(user, role)
+}
+
+func (m *mockStore) CreateFirstUser(user UserLogin) (bool, error) {
+	return m.createFirstUser(user)
+}
+
+func (m *mockStore) ChangePassword(username string, password string) error {
+	return m.changePassword(username, password)
+}
+
+func (m *mockStore) SetUserRole(username string, role string) error {
+	return m.setUserRole(username, role)
+}
+
+func (m *mockStore) SetUserOwner(username string, ownerID int) error {
+	return m.setUserOwner(username, ownerID)
+}
+
+func (m *mockStore) CreateRefreshToken(token RefreshToken) error {
+	return m.createRefreshToken(token)
+}
+
+func (m *mockStore) UseRefreshToken(hash string) (RefreshToken, error) {
+	return m.useRefreshToken(hash)
+}
+
+func (m *mockStore) RevokeSession(accessTokenID string, expiresAt time.Time) error {
+	return m.revokeSession(accessTokenID, expiresAt)
+}
+
+func (m *mockStore) RevokeUserSessions(username string) error {
+	return m.revokeUserSessions(username)
+}
+
+func (m *mockStore) IsTokenRevoked(accessTokenID string) (bool, error) {
+	return m.isTokenRevoked(accessTokenID)
+}
+
+func (m *mockStore) CreateAPIKey(key *APIKey) error {
+	return m.createAPIKey(key)
+}
+
+func (m *mockStore) UseAPIKey(hash string) (APIKey, error) {
+	return m.useAPIKey(hash)
+}
+
+func (m *mockStore) GetAPIKeys(username string) ([]APIKey, error) {
+	return m.getAPIKeys(username)
+}
+
+func (m *mockStore) DeleteAPIKey(username string, id int) error {
+	return m.deleteAPIKey(username, id)
+}
+
+func (m *mockStore) GetPasswordHistory(username string) ([]string, error) {
+	return m.getPasswordHistory(username)
+}
+
+func (m *mockStore) AddPasswordHistory(username string, password string, keep int) error {
+	return m.addPasswordHistory(username, password, keep)
+}
+
+func (m *mockStore) GetIdentityUser(issuer string, subject string) (User, error) {
+	return m.getIdentityUser(issuer, subject)
+}
+
+func (m *mockStore) CreateIdentity(identity Identity) error {
+	return m.createIdentity(identity)
+}
+
+func (m *mockStore) ReplacePassword(username string, password string, previous string, keep int) error {
+	return m.replacePassword(username, password, previous, keep)
+}
+
+func (m *mockStore) CreateIdentityUser(identity Identity, household *Household) (User, error) {
+	return m.createIdentityUser(identity, household)
+}
+
+func (m *mockStore) GetAllTeaTypes(options ListOptions) ([]TeaType, error) {
+	return m.getAllTeaTypes(options)
+}
+
+func (m *mockStore) GetTeaType(teaType *TeaType) error {
+	return m.getTeaType(teaType)
+}
+
+func (m *mockStore) CreateTeaType(teaType *TeaType) error {
+	return m.createTeaType(teaType)
+}
+
+func (m *mockStore) UpdateTeaType(teaType *TeaType) error {
+	return m.updateTeaType(teaType)
+}
+
+func (m *mockStore) DeleteTeaType(teaType *TeaType) error {
+	return m.deleteTeaType(teaType)
+}
+
+func (m *mockStore) GetAllTypesTeas() ([]TypeWithTeas, error) {
+	return m.getAllTypesTeas()
+}
+
+func (m *mockStore) GetAllOwners(options ListOptions) ([]Owner, error) {
+	return m.getAllOwners(options)
+}
+
+func (m *mockStore) GetOwner(owner *Owner) error {
+	return m.getOwner(owner)
+}
+
+func (m *mockStore) CreateOwner(owner *Owner) error {
+	return m.createOwner(owner)
+}
+
+func (m *mockStore) UpdateOwner(owner *Owner) error {
+	return m.updateOwner(owner)
+}
+
+func (m *mockStore) DeleteOwner(owner *Owner) error {
+	return m.deleteOwner(owner)
+}
+
+func (m *mockStore) GetAllOwnersTeas() ([]OwnerWithTeas, error) {
+	return m.getAllOwnersTeas()
+}
+
+func (m *mockStore) GetAllTeas(options ListOptions) ([]Tea, error) {
+	return m.getAllTeas(options)
+}
+
+func (m *mockStore) SearchTeas(terms []string, limit int) ([]Tea, error) {
+	return m.searchTeas(terms, limit)
+}
+
+func (m *mockStore) ImportCollection(collection Collection, dryRun bool, checkOwner func(ownerID int) error) (ImportReport, error) {
+	return m.importCollection(collection, dryRun, checkOwner)
+}
+
+func (m *mockStore) GetTea(tea *Tea) error {
+	return m.getTea(tea)
+}
+
+func (m *mockStore) CreateTea(tea *Tea) error {
+	return m.createTea(tea)
+}
+
+func (m *mockStore) UpdateTea(tea *Tea) error {
+	return m.updateTea(tea)
+}
+
+func (m *mockStore) DeleteTea(tea *Tea) error {
+	return m.deleteTea(tea)
+}
+
+func (m *mockStore) GetTeaOwners(tea *Tea) ([]TeaOwner, error) {
+	return m.getTeaOwners(tea)
+}
+
+func (m *mockStore) GetAllTeaOwners(options ListOptions) ([]TeaWithOwners, error) {
+	return m.getAllTeaOwners(options)
+}
+
+func (m *mockStore) CreateTeaOwner(teaID int, owner *Owner, stock Stock) (Tea, error) {
+	return m.createTeaOwner(teaID, owner, stock)
+}
+
+func (m *mockStore) CreateTeaWithOwners(tea *Tea, owners []TeaOwner, createType bool) (TeaWithOwners, error) {
+	return m.createTeaWithOwners(tea, owners, createType)
+}
+
+func (m *mockStore) DeleteTeaOwner(tea *Tea, owner *Owner) error {
+	return m.deleteTeaOwner(tea, owner)
+}
+
+func (m *mockStore) ConsumeTeaStock(teaID int, ownerID int, quantity float64) (Stock, error) {
+	return m.consumeTeaStock(teaID, ownerID, quantity)
+}
+
+func (m *mockStore) RestockTea(teaID int, ownerID int, change Stock) (Stock, error) {
+	return m.restockTea(teaID, ownerID, change)
+}
+
+func (m *mockStore) GetStock(ownerIDs []int) (map[int][]Stock, error) {
+	return m.getStock(ownerIDs)
+}
+
+func (m *mockStore) GetSelectionCandidates(options SelectionOptions) ([]Tea, error) {
+	return m.getSelectionCandidates(options)
+}
+
+func (m *mockStore) CreateSelection(selection *SelectionRecord) error {
+	return m.createSelection(selection)
+}
+
+func (m *mockStore) GetSelections(limit int, offset int) ([]SelectionRecord, int, error) {
+	return m.getSelections(limit, offset)
+}
+
+func (m *mockStore) GetLastSelected() (map[int]time.Time, error) {
+	return m.getLastSelected()
+}
+
+func (m *mockStore) GetTeaRatings(tea *Tea) ([]Rating, error) {
+	return m.getTeaRatings(tea)
+}
+
+func (m *mockStore) CreateTeaRating(teaID int, rating *Rating) error {
+	return m.createTeaRating(teaID, rating)
+}
+
+func (m *mockStore) UpdateTeaRating(teaID int, rating *Rating) error {
+	return m.updateTeaRating(teaID, rating)
+}
+
+func (m *mockStore) DeleteTeaRating(teaID int, owner *Owner) error {
+	return m.deleteTeaRating(teaID, owner)
+}
+
+func (m *mockStore) GetAverageRatings(ownerIDs []int) (map[int]float64, error) {
+	return m.getAverageRatings(ownerIDs)
+}
+
+func (m *mockStore) CreateWebhookDelivery(delivery *WebhookDelivery) error {
+	return m.createWebhookDelivery(delivery)
+}
+
+func (m *mockStore) UpdateWebhookDelivery(delivery *WebhookDelivery) error {
+	return m.updateWebhookDelivery(delivery)
+}
+
+func (m *mockStore) GetWebhookDeliveries(limit int, offset int) ([]WebhookDelivery, int, error) {
+	return m.getWebhookDeliveries(limit, offset)
+}
+
+func (m *mockStore) CreateAuditEntry(entry *AuditEntry) error {
+	return m.createAuditEntry(entry)
+}
+
+func (m *mockStore) GetAuditEntries(limit int, offset int) ([]AuditEntry, int, error) {
+	return m.getAuditEntries(limit, offset)
+}
+
+// storeTestConfig gives the tea types and owners the stores are created with.
+func storeTestConfig() Config {
+	var cfg Config
+	cfg.Database.TeaTypes = []string{"Black Tea", "Green Tea"}
+	cfg.Database.Owners = []string{"John", "Jane"}
+	return cfg
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore(storeTestConfig()))
+}
+
+func TestSQLStore(t *testing.T) {
+	store, closeDatabase := openTestDatabase(t)
+	defer closeDatabase()
+	if _, err := store.MigrateUp(storeTestConfig(), 0); err != nil {
+		t.Fatalf("Unexpected error migrating database: %v\n", err)
+	}
+	if err := store.setupSearch(); err != nil {
+		t.Fatalf("Unexpected error setting up search: %v\n", err)
+	}
+	testStore(t, store)
+}
+
+func TestSQLStoreTransaction(t *testing.T) {
+	store, closeDatabase := openTestDatabase(t)
+	defer closeDatabase()
+	if _, err := store.MigrateUp(storeTestConfig(), 0); err != nil {
+		t.Fatalf("Unexpected error migrating database: %v\n", err)
+	}
+
+	failure := errors.New("failed")
+	err := store.inTransaction(func(tx *SQLStore) error {
+		if err := tx.CreateTeaType(&TeaType{Name: "Oolong"}); err != nil {
+			return err
+		}
+		// Steps in the same transaction run in it, rather than starting another
+		return tx.inTransaction(func(nested *SQLStore) error {
+			if err := nested.CreateOwner(&Owner{Name: "Ann"}); err != nil {
+				return err
+			}
+			return failure
+		})
+	})
+	if err != failure {
+		t.Errorf("Unexpected error from failed transaction: %v\n", err)
+	}
+	if types, err := store.GetAllTeaTypes(ListOptions{}); err != nil || len(types) != 2 {
+		t.Errorf("Unexpected tea types after rolling back: %+v, error: %v\n", types, err)
+	}
+	if owners, err := store.GetAllOwners(ListOptions{}); err != nil || len(owners) != 2 {
+		t.Errorf("Unexpected owners after rolling back: %+v, error: %v\n", owners, err)
+	}
+
+	if err := store.inTransaction(func(tx *SQLStore) error { return tx.CreateTeaType(&TeaType{Name: "Oolong"}) }); err != nil {
+		t.Fatalf("Unexpected error from transaction: %v\n", err)
+	}
+	if types, err := store.GetAllTeaTypes(ListOptions{}); err != nil || len(types) != 3 {
+		t.Errorf("Unexpected tea types after committing: %+v, error: %v\n", types, err)
+	}
+
+	// A tea created with its type is rolled back along with the type
+	if err := store.CreateTea(&Tea{Name: "Assam", TeaType: TeaType{ID: 1}}); err != nil {
+		t.Fatalf("Unexpected error creating tea: %v\n", err)
+	}
+	if _, err := store.CreateTeaWithOwners(&Tea{Name: "Assam", TeaType: TeaType{Name: "Herbal"}}, nil, true); !errors.Is(err, ErrConflict) {
+		t.Errorf("Unexpected error creating tea with a taken name:\n got: %v\n wanted: %v\n", err, ErrConflict)
+	}
+	if types, err := store.GetAllTeaTypes(ListOptions{}); err != nil || len(types) != 3 {
+		t.Errorf("Unexpected tea types after failing to create tea: %+v, error: %v\n", types, err)
+	}
+}
+
+// testStore checks that a store created with storeTestConfig behaves the way the handlers expect.
+// Every store should pass, so they can be used interchangeably.
+func testStore(t *testing.T, store Store) {
+	observer := new(recordingObserver)
+	store.SetStockObserver(observer)
+
+	// Users
+	if created, err := store.CreateFirstUser(UserLogin{Username: "john", Password: "hash"}); err != nil || !created {
+		t.Fatalf("Unexpected result creating first user: %v, error: %v\n", created, err)
+	}
+	if err := store.CreateUser(UserLogin{Username: "alice", Password: "hash"}, RoleMember); err != nil {
+		t.Fatalf("Unexpected error creating user: %v\n", err)
+	}
+	if created, err := store.CreateFirstUser(UserLogin{Username: "bob", Password: "hash"}); err != nil || created {
+		t.Errorf("Unexpected result creating first user when there are already users: %v, error: %v\n", created, err)
+	}
+	if _, err := store.GetUser("bob"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Unexpected error getting user who wasn't first:\n got: %v\n wanted: %v\n", err, ErrNotFound)
+	}
+	if err := store.CreateUser(UserLogin{Username: "john", Password: "other"}, RoleMember); !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected an error creating a user that already exists\n")
+	}
+	if err := store.ChangePassword("john", "newHash"); err != nil {
+		t.Errorf("Unexpected error changing password: %v\n", err)
+	}
+	if password, err := store.GetPassword("john"); err != nil || password != "newHash" {
+		t.Errorf("Unexpected password after changing it: %q, error: %v\n", password, err)
+	}
+	if _, err := store.GetPassword("jane"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Unexpected error getting password of missing user:\n got: %v\n wanted: %v\n", err, ErrNotFound)
+	}
+	if err := store.SetUserRole("alice", RoleViewer); err != nil {
+		t.Errorf("Unexpected error changing role: %v\n", err)
+	}
+	if user, err := store.GetUser("alice"); err != nil || user != (User{Username: "alice", Role: RoleViewer, Household: DefaultHousehold}) {
+		t.Errorf("Unexpected user after changing role: %v, error: %v\n", user, err)
+	}
+	users, err := store.GetAllUsers()
+	expectedUsers := []User{{Username: "alice", Role: RoleViewer, Household: DefaultHousehold}, {Username: "john", Role: RoleAdmin, Household: DefaultHousehold}}
+	if err != nil || !reflect.DeepEqual(users, expectedUsers) {
+		t.Errorf("Unexpected users: %v, error: %v\n wanted: %v\n", users, err, expectedUsers)
+	}
+	if err := store.SetUserRole("jane", RoleAdmin); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Unexpected error changing role of missing user:\n got: %v\n wanted: %v\n", err, ErrNotFound)
+	}
+
+	// Tea types and owners
+	teaTypes, err := store.GetAllTeaTypes(ListOptions{})
+	expectedTypes := []TeaType{{ID: 1, Name: "Black Tea", BrewingGuide: defaultBrewingGuides["Black Tea"]}, {ID: 2, Name: "Green Tea", BrewingGuide: defaultBrewingGuides["Green Tea"]}}
+	if err != nil || !reflect.DeepEqual(teaTypes, expectedTypes) {
+		t.Errorf("Unexpected tea types: %v, error: %v\n wanted: %v\n", teaTypes, err, expectedTypes)
+	}
+	herbal := TeaType{Name: "Herbal Tea", BrewingGuide: BrewingGuide{BrewTemperature: 100}}
+	if err := store.CreateTeaType(&herbal); err != nil || herbal.ID != 3 {
+		t.Errorf("Unexpected tea type created: %+v, error: %v\n", herbal, err)
+	}
+	if err := store.CreateTeaType(&TeaType{Name: "Herbal Tea"}); !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected an error creating a tea type with a name that is taken\n")
+	}
+	if err := store.UpdateTeaType(&TeaType{ID: 99, Name: "Missing"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Unexpected error updating missing tea type:\n got: %v\n wanted: %v\n", err, ErrNotFound)
+	}
+	if err := store.DeleteTeaType(&TeaType{ID: 3}); err != nil {
+		t.Errorf("Unexpected error deleting tea type: %v\n", err)
+	}
+	if err := store.GetTeaType(&TeaType{ID: 3}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Unexpected error getting deleted tea type:\n got: %v\n wanted: %v\n", err, ErrNotFound)
+	}
+
+	owner := Owner{ID: 2}
+	if err := store.GetOwner(&owner); err != nil || owner.Name != "Jane" {
+		t.Errorf("Unexpected owner: %+v, error: %v\n", owner, err)
+	}
+	bob := Owner{Name: "Bob"}
+	if err := store.CreateOwner(&bob); err != nil || bob.ID != 3 {
+		t.Errorf("Unexpected owner created: %+v, error: %v\n", bob, err)
+	}
+	if err := store.SetUserOwner("alice", bob.ID); err != nil {
+		t.Errorf("Unexpected error linking user to owner: %v\n", err)
+	}
+	if user, err := store.GetUser("alice"); err != nil || user.OwnerID != bob.ID {
+		t.Errorf("Unexpected user after linking to owner: %+v, error: %v\n", user, err)
+	}
+	if err := store.SetUserOwner("john", bob.ID); !errors.Is(err, ErrConflict) {
+		t.Errorf("Unexpected error linking a second user to an owner:\n got: %v\n wanted: %v\n", err, ErrConflict)
+	}
+	if err := store.SetUserOwner("john", 99); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Unexpected error linking a user to a missing owner:\n got: %v\n wanted: %v\n", err, ErrNotFound)
+	}
+
+	// Teas
+	snowball := Tea{Name: "Snowball", TeaType: TeaType{ID: 1}, BrewingGuide: BrewingGuide{SteepSeconds: 200}}
+	sencha := Tea{Name: "Sencha", TeaType: TeaType{ID: 2}, Notes: "Don't use boiling water"}
+	for _, tea := range []*Tea{&snowball, &sencha} {
+		if err := store.CreateTea(tea); err != nil {
+			t.Fatalf("Unexpected error creating tea %q: %v\n", tea.Name, err)
+		}
+	}
+	if err := store.CreateTea(&Tea{Name: "Snowball", TeaType: TeaType{ID: 1}}); !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected an error creating a tea with a name that is taken\n")
+	}
+	if err := store.CreateTea(&Tea{Name: "Mystery", TeaType: TeaType{ID: 99}}); !errors.Is(err, ErrValidation) {
+		t.Errorf("Expected an error creating a tea with a missing type\n")
+	}
+
+	tea := Tea{ID: snowball.ID}
+	if err := store.GetTea(&tea); err != nil || tea.Name != "Snowball" || tea.TeaType.Name != "Black Tea" || tea.Brewing().BrewTemperature != 100 || tea.SteepSeconds != 200 {
+		t.Errorf("Unexpected tea: %+v, error: %v\n", tea, err)
+	}
+	sencha.Name = "Gyokuro Sencha"
+	if err := store.UpdateTea(&sencha); err != nil || sencha.TeaType.Name != "Green Tea" || sencha.Notes != "Don't use boiling water" {
+		t.Errorf("Unexpected tea after updating: %+v, error: %v\n", sencha, err)
+	}
+	typesWithTeas, err := store.GetAllTypesTeas()
+	if err != nil || len(typesWithTeas) != 2 || len(typesWithTeas[0].Teas) != 1 || typesWithTeas[1].Teas[0].Name != "Gyokuro Sencha" {
+		t.Errorf("Unexpected teas by type: %+v, error: %v\n", typesWithTeas, err)
+	}
+
+	// Owners and stock
+	five := 5.0
+	if _, err := store.CreateTeaOwner(snowball.ID, &Owner{ID: 1}, Stock{Quantity: &five, Unit: UnitBags}); err != nil {
+		t.Fatalf("Unexpected error adding owner to tea: %v\n", err)
+	}
+	if created, err := store.CreateTeaOwner(snowball.ID, &Owner{ID: 2}, Stock{}); err != nil || created.Name != "Snowball" || created.TeaType.Name != "Black Tea" {
+		t.Errorf("Unexpected tea after adding owner: %+v, error: %v\n", created, err)
+	}
+	if _, err := store.CreateTeaOwner(sencha.ID, &Owner{ID: 1}, Stock{}); err != nil {
+		t.Fatalf("Unexpected error adding owner to tea: %v\n", err)
+	}
+	if _, err := store.CreateTeaOwner(snowball.ID, &Owner{ID: 1}, Stock{}); !errors.Is(err, ErrConflict) || err.Error() != "This relationship already exists" {
+		t.Errorf("Unexpected error adding an owner to a tea twice: %v\n", err)
+	}
+	if _, err := store.CreateTeaOwner(99, &Owner{ID: 1}, Stock{}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected an error adding an owner to a missing tea\n")
+	}
+
+	owners, err := store.GetTeaOwners(&Tea{ID: snowball.ID})
+	if err != nil || len(owners) != 2 || owners[0].Name != "John" || *owners[0].Quantity != 5 || owners[1].Quantity != nil {
+		t.Errorf("Unexpected owners of tea: %+v, error: %v\n", owners, err)
+	}
+	if err := store.DeleteTea(&Tea{ID: snowball.ID}); !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected an error deleting a tea that still has owners\n")
+	}
+	if err := store.DeleteOwner(&Owner{ID: 1}); !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected an error deleting an owner who still owns teas\n")
+	}
+
+	if stock, err := store.ConsumeTeaStock(snowball.ID, 1, 8); err != nil || *stock.Quantity != 0 || stock.Unit != UnitBags {
+		t.Errorf("Unexpected stock after consuming: %+v, error: %v\n", stock, err)
+	}
+	if _, err := store.ConsumeTeaStock(snowball.ID, 2, 1); err != ErrStockNotTracked {
+		t.Errorf("Unexpected error consuming untracked stock:\n got: %v\n wanted: %v\n", err, ErrStockNotTracked)
+	}
+	if _, err := store.RestockTea(snowball.ID, 1, Stock{Quantity: &five, Unit: UnitGrams}); err != ErrStockUnitMismatch {
+		t.Errorf("Unexpected error restocking in a different unit:\n got: %v\n wanted: %v\n", err, ErrStockUnitMismatch)
+	}
+	if _, err := store.RestockTea(99, 1, Stock{Quantity: &five}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Unexpected error restocking a tea the owner doesn't have:\n got: %v\n wanted: %v\n", err, ErrNotFound)
+	}
+	if len(observer.changes) != 1 || *observer.changes[0].before.Quantity != 5 || *observer.changes[0].after.Quantity != 0 {
+		t.Errorf("Unexpected stock changes told to the observer: %+v\n", observer.changes)
+	}
+
+	stock, err := store.GetStock([]int{1})
+	if err != nil || len(stock) != 2 || *stock[snowball.ID][0].Quantity != 0 || stock[sencha.ID][0].Quantity != nil {
+		t.Errorf("Unexpected stock of owner: %+v, error: %v\n", stock, err)
+	}
+
+	// Ratings
+	if err := store.CreateTeaRating(snowball.ID, &Rating{Owner: Owner{ID: 1}, Rating: 4}); err != nil {
+		t.Errorf("Unexpected error rating tea: %v\n", err)
+	}
+	rating := Rating{Owner: Owner{ID: 2}, Rating: 2}
+	if err := store.CreateTeaRating(snowball.ID, &rating); err != nil || rating.Owner.Name != "Jane" {
+		t.Errorf("Unexpected rating: %+v, error: %v\n", rating, err)
+	}
+	if err := store.CreateTeaRating(sencha.ID, &Rating{Owner: Owner{ID: 2}, NeverPick: true}); err != nil {
+		t.Errorf("Unexpected error rating tea: %v\n", err)
+	}
+	if err := store.CreateTeaRating(snowball.ID, &Rating{Owner: Owner{ID: 1}, Rating: 1}); !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected an error rating a tea twice\n")
+	}
+	if err := store.UpdateTeaRating(sencha.ID, &Rating{Owner: Owner{ID: 1}, Rating: 1}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Unexpected error updating a missing rating:\n got: %v\n wanted: %v\n", err, ErrNotFound)
+	}
+	if averages, err := store.GetAverageRatings(nil); err != nil || !reflect.DeepEqual(averages, map[int]float64{snowball.ID: 3}) {
+		t.Errorf("Unexpected average ratings: %v, error: %v\n", averages, err)
+	}
+	if err := store.GetTea(&tea); err != nil || tea.AverageRating != 3 {
+		t.Errorf("Unexpected average rating of tea: %v, error: %v\n", tea.AverageRating, err)
+	}
+
+	// Selections
+	candidates, err := store.GetSelectionCandidates(SelectionOptions{})
+	if err != nil || len(candidates) != 2 {
+		t.Errorf("Unexpected candidates, expected a tea to be included while an owner has it in stock: %+v, error: %v\n", candidates, err)
+	}
+	candidates, err = store.GetSelectionCandidates(SelectionOptions{OwnerIDs: []int{1}})
+	if err != nil || len(candidates) != 1 || candidates[0].ID != sencha.ID {
+		t.Errorf("Unexpected candidates, expected the out of stock tea to be excluded: %+v, error: %v\n", candidates, err)
+	}
+	if _, err := store.RestockTea(snowball.ID, 1, Stock{Quantity: &five}); err != nil {
+		t.Errorf("Unexpected error restocking tea: %v\n", err)
+	}
+	candidates, err = store.GetSelectionCandidates(SelectionOptions{OwnerIDs: []int{1, 2}})
+	if err != nil || len(candidates) != 1 || candidates[0].ID != snowball.ID || candidates[0].TeaType.BrewTemperature != 100 {
+		t.Errorf("Unexpected candidates for both owners: %+v, error: %v\n", candidates, err)
+	}
+	candidates, err = store.GetSelectionCandidates(SelectionOptions{Caffeine: []string{CaffeineMedium}})
+	if err != nil || len(candidates) != 1 || candidates[0].ID != sencha.ID {
+		t.Errorf("Unexpected candidates with medium caffeine: %+v, error: %v\n", candidates, err)
+	}
+
+	selectedAt := time.Date(2020, 7, 1, 12, 0, 0, 0, time.UTC)
+	selection := SelectionRecord{Tea: Tea{ID: snowball.ID}, Owners: []Owner{{ID: 1}, {ID: 2}}, SelectedAt: selectedAt}
+	if err := store.CreateSelection(&selection); err != nil || selection.ID != 1 {
+		t.Errorf("Unexpected selection recorded: %+v, error: %v\n", selection, err)
+	}
+	candidates, err = store.GetSelectionCandidates(SelectionOptions{ExcludePicks: 1})
+	if err != nil || len(candidates) != 1 || candidates[0].ID != sencha.ID {
+		t.Errorf("Unexpected candidates excluding the last pick: %+v, error: %v\n", candidates, err)
+	}
+	selections, total, err := store.GetSelections(10, 0)
+	if err != nil || total != 1 || len(selections) != 1 || selections[0].Tea.Name != "Snowball" || len(selections[0].Owners) != 2 || selections[0].Owners[1].Name != "Jane" || !selections[0].SelectedAt.Equal(selectedAt) {
+		t.Errorf("Unexpected selection history: %+v, total: %d, error: %v\n", selections, total, err)
+	}
+	if lastSelected, err := store.GetLastSelected(); err != nil || !lastSelected[snowball.ID].Equal(selectedAt) {
+		t.Errorf("Unexpected last selected times: %v, error: %v\n", lastSelected, err)
+	}
+
+	// Deleting
+	if err := store.DeleteTeaOwner(&Tea{ID: snowball.ID}, &Owner{ID: 3}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Unexpected error deleting a missing tea owner:\n got: %v\n wanted: %v\n", err, ErrNotFound)
+	}
+	for _, ownerID := range []int{1, 2} {
+		if err := store.DeleteTeaOwner(&Tea{ID: snowball.ID}, &Owner{ID: ownerID}); err != nil {
+			t.Errorf("Unexpected error deleting tea owner: %v\n", err)
+		}
+	}
+	deleted := Tea{ID: snowball.ID}
+	if err := store.DeleteTea(&deleted); err != nil || deleted.Name != "Snowball" {
+		t.Errorf("Unexpected tea deleted: %+v, error: %v\n", deleted, err)
+	}
+	if err := store.DeleteTea(&Tea{ID: snowball.ID}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Unexpected error deleting a missing tea:\n got: %v\n wanted: %v\n", err, ErrNotFound)
+	}
+	if _, total, err := store.GetSelections(10, 0); err != nil || total != 0 {
+		t.Errorf("Selections of a deleted tea weren't removed. Total: %d, error: %v\n", total, err)
+	}
+	if ratings, err := store.GetTeaRatings(&Tea{ID: snowball.ID}); err != nil || len(ratings) != 0 {
+		t.Errorf("Ratings of a deleted tea weren't removed: %v, error: %v\n", ratings, err)
+	}
+	if err := store.DeleteOwner(&Owner{ID: bob.ID}); err != nil {
+		t.Errorf("Unexpected error deleting owner linked to a user: %v\n", err)
+	}
+	if user, err := store.GetUser("alice"); err != nil || user.OwnerID != 0 {
+		t.Errorf("User is still linked to a deleted owner: %+v, error: %v\n", user, err)
+	}
+
+	// Webhook deliveries
+	for _, url := range []string{"http://localhost/first", "http://localhost/second"} {
+		delivery := WebhookDelivery{Event: WebhookLowStock, URL: url, Payload: "{}", CreatedAt: selectedAt}
+		if err := store.CreateWebhookDelivery(&delivery); err != nil {
+			t.Fatalf("Unexpected error recording webhook delivery: %v\n", err)
+		}
+		delivery.Attempts, delivery.Delivered, delivery.StatusCode = 1, true, 200
+		if err := store.UpdateWebhookDelivery(&delivery); err != nil {
+			t.Errorf("Unexpected error updating webhook delivery: %v\n", err)
+		}
+	}
+	deliveries, total, err := store.GetWebhookDeliveries(1, 0)
+	expectedDelivery := WebhookDelivery{ID: 2, Event: WebhookLowStock, URL: "http://localhost/second", Payload: "{}", Attempts: 1, StatusCode: 200, Delivered: true, CreatedAt: selectedAt}
+	if err != nil || total != 2 || len(deliveries) != 1 || deliveries[0] != expectedDelivery {
+		t.Errorf("Unexpected webhook deliveries: %+v, total: %d, error: %v\n wanted: %+v\n", deliveries, total, err, expectedDelivery)
+	}
+	// Households
+	if household, err := store.GetHousehold(); err != nil || household != (Household{ID: DefaultHousehold, Name: "Home"}) {
+		t.Errorf("Unexpected default household: %+v, error: %v\n", household, err)
+	}
+	flat := Household{Name: "Flat"}
+	if err := store.CreateHousehold(&flat, UserLogin{Username: "bob", Password: "hash"}); err != nil || flat.ID == DefaultHousehold {
+		t.Fatalf("Unexpected household created: %+v, error: %v\n", flat, err)
+	}
+	if err := store.CreateHousehold(&Household{Name: "Other"}, UserLogin{Username: "john", Password: "hash"}); !errors.Is(err, ErrConflict) {
+		t.Errorf("Unexpected error creating a household for a username that's taken:\n got: %v\n wanted: %v\n", err, ErrConflict)
+	}
+	flatStore := store.ForHousehold(flat.ID)
+	if household, err := flatStore.GetHousehold(); err != nil || household != flat {
+		t.Errorf("Unexpected household: %+v, error: %v\n wanted: %+v\n", household, err, flat)
+	}
+	if _, err := store.ForHousehold(flat.ID + 1).GetHousehold(); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Unexpected error getting missing household:\n got: %v\n wanted: %v\n", err, ErrNotFound)
+	}
+	users, err = flatStore.GetAllUsers()
+	expectedUsers = []User{{Username: "bob", Role: RoleAdmin, Household: flat.ID}}
+	if err != nil || !reflect.DeepEqual(users, expectedUsers) {
+		t.Errorf("Unexpected users in new household: %v, error: %v\n wanted: %v\n", users, err, expectedUsers)
+	}
+	if teas, err := flatStore.GetAllTeas(ListOptions{}); err != nil || len(teas) != 0 {
+		t.Errorf("New household can see teas of another household: %v, error: %v\n", teas, err)
+	}
+	flatType := TeaType{Name: "Black Tea"}
+	if err := flatStore.CreateTeaType(&flatType); err != nil {
+		t.Errorf("Unexpected error creating a tea type with a name used by another household: %v\n", err)
+	}
+	if err := store.GetTeaType(&TeaType{ID: flatType.ID}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Unexpected error getting a tea type from another household:\n got: %v\n wanted: %v\n", err, ErrNotFound)
+	}
+	flatTea := Tea{Name: "Snowball", TeaType: flatType}
+	if err := flatStore.CreateTea(&flatTea); err != nil {
+		t.Errorf("Unexpected error creating tea in new household: %v\n", err)
+	}
+	if _, err := store.CreateTeaOwner(flatTea.ID, &Owner{ID: 1}, Stock{}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Unexpected error adding an owner to a tea from another household:\n got: %v\n wanted: %v\n", err, ErrNotFound)
+	}
+	if err := store.SetUserRole("bob", RoleMember); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Unexpected error changing role of a user in another household:\n got: %v\n wanted: %v\n", err, ErrNotFound)
+	}
+
+	// Invites
+	now := time.Unix(time.Now().Unix(), 0).UTC()
+	invite := Invite{Code: "0123456789abcdef", ExpiresAt: now.Add(InviteLifetime)}
+	if err := flatStore.CreateInvite(&invite); err != nil || invite.Household != flat.ID {
+		t.Fatalf("Unexpected invite created: %+v, error: %v\n", invite, err)
+	}
+	expired := Invite{Code: "fedcba9876543210", ExpiresAt: now.Add(-time.Hour)}
+	if err := flatStore.CreateInvite(&expired); err != nil {
+		t.Fatalf("Unexpected error creating invite: %v\n", err)
+	}
+	if found, err := store.GetInvite(invite.Code); err != nil || found != invite {
+		t.Errorf("Unexpected invite: %+v, error: %v\n wanted: %+v\n", found, err, invite)
+	}
+	if _, err := store.GetInvite(expired.Code); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Unexpected error getting expired invite:\n got: %v\n wanted: %v\n", err, ErrNotFound)
+	}
+	if invites, err := flatStore.GetInvites(); err != nil || !reflect.DeepEqual(invites, []Invite{invite}) {
+		t.Errorf("Unexpected invites: %+v, error: %v\n", invites, err)
+	}
+	if invites, err := store.GetInvites(); err != nil || len(invites) != 0 {
+		t.Errorf("Unexpected invites in another household: %+v, error: %v\n", invites, err)
+	}
+	if err := store.DeleteInvite(invite.Code); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Unexpected error deleting an invite from another household:\n got: %v\n wanted: %v\n", err, ErrNotFound)
+	}
+
+	// Joining a household
+	if err := store.SetUserOwner("alice", 1); err != nil {
+		t.Errorf("Unexpected error linking user to owner: %v\n", err)
+	}
+	if err := flatStore.JoinHousehold("alice", RoleMember); err != nil {
+		t.Errorf("Unexpected error joining household: %v\n", err)
+	}
+	if user, err := store.GetUser("alice"); err != nil || user != (User{Username: "alice", Role: RoleMember, Household: flat.ID}) {
+		t.Errorf("Unexpected user after joining household: %+v, error: %v\n", user, err)
+	}
+	if users, err := store.GetAllUsers(); err != nil || len(users) != 1 || users[0].Username != "john" {
+		t.Errorf("User is still in their old household: %v, error: %v\n", users, err)
+	}
+	if err := flatStore.JoinHousehold("jane", RoleMember); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Unexpected error joining household as a missing user:\n got: %v\n wanted: %v\n", err, ErrNotFound)
+	}
+	if err := flatStore.DeleteInvite(invite.Code); err != nil {
+		t.Errorf("Unexpected error deleting invite: %v\n", err)
+	}
+	if _, err := store.GetInvite(invite.Code); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Unexpected error getting deleted invite:\n got: %v\n wanted: %v\n", err, ErrNotFound)
+	}
+	// Tokens
+	first := RefreshToken{Hash: "first", Username: "john", AccessTokenID: "firstAccess", AccessExpiresAt: now.Add(AccessTokenLifetime), ExpiresAt: now.Add(RefreshTokenLifetime)}
+	second := RefreshToken{Hash: "second", Username: "john", AccessTokenID: "secondAccess", AccessExpiresAt: now.Add(AccessTokenLifetime), ExpiresAt: now.Add(RefreshTokenLifetime)}
+	other := RefreshToken{Hash: "other", Username: "bob", AccessTokenID: "otherAccess", AccessExpiresAt: now.Add(AccessTokenLifetime), ExpiresAt: now.Add(RefreshTokenLifetime)}
+	for _, token := range []RefreshToken{first, second, other} {
+		if err := store.CreateRefreshToken(token); err != nil {
+			t.Fatalf("Unexpected error creating refresh token: %v\n", err)
+		}
+	}
+	if err := store.CreateRefreshToken(RefreshToken{Hash: "missing", Username: "jane", AccessTokenID: "missingAccess", AccessExpiresAt: now, ExpiresAt: now}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Unexpected error creating refresh token for missing user:\n got: %v\n wanted: %v\n", err, ErrNotFound)
+	}
+	if token, err := store.UseRefreshToken(first.Hash); err != nil || token != first {
+		t.Errorf("Unexpected refresh token: %+v, error: %v\n wanted: %+v\n", token, err, first)
+	}
+	if _, err := store.UseRefreshToken(first.Hash); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Unexpected error using a refresh token twice:\n got: %v\n wanted: %v\n", err, ErrNotFound)
+	}
+	if revoked, err := store.IsTokenRevoked(first.AccessTokenID); err != nil || revoked {
+		t.Errorf("Unexpected revocation of access token: %v, error: %v\n", revoked, err)
+	}
+	if err := store.RevokeSession(first.AccessTokenID, first.AccessExpiresAt); err != nil {
+		t.Errorf("Unexpected error revoking session: %v\n", err)
+	}
+	if revoked, err := store.IsTokenRevoked(first.AccessTokenID); err != nil || !revoked {
+		t.Errorf("Access token wasn't revoked: %v, error: %v\n", revoked, err)
+	}
+	if err := store.RevokeUserSessions("john"); err != nil {
+		t.Errorf("Unexpected error revoking user's sessions: %v\n", err)
+	}
+	if revoked, err := store.IsTokenRevoked(second.AccessTokenID); err != nil || !revoked {
+		t.Errorf("Access token of revoked session wasn't revoked: %v, error: %v\n", revoked, err)
+	}
+	if _, err := store.UseRefreshToken(second.Hash); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Unexpected error using a revoked refresh token:\n got: %v\n wanted: %v\n", err, ErrNotFound)
+	}
+	if revoked, err := store.IsTokenRevoked(other.AccessTokenID); err != nil || revoked {
+		t.Errorf("Another user's access token was revoked: %v, error: %v\n", revoked, err)
+	}
+	if token, err := store.UseRefreshToken(other.Hash); err != nil || token != other {
+		t.Errorf("Unexpected refresh token of another user: %+v, error: %v\n wanted: %+v\n", token, err, other)
+	}
+	// Audit log
+	for _, username := range []string{"john", "alice"} {
+		entry := AuditEntry{Event: AuditUserLocked, Username: username, Address: "127.0.0.1", Message: "Locked out", CreatedAt: selectedAt}
+		if err := store.CreateAuditEntry(&entry); err != nil {
+			t.Fatalf("Unexpected error adding to audit log: %v\n", err)
+		}
+	}
+	entries, total, err := store.GetAuditEntries(1, 0)
+	expectedEntry := AuditEntry{ID: 2, Event: AuditUserLocked, Username: "alice", Address: "127.0.0.1", Message: "Locked out", CreatedAt: selectedAt}
+	if err != nil || total != 2 || len(entries) != 1 || entries[0] != expectedEntry {
+		t.Errorf("Unexpected audit log: %+v, total: %d, error: %v\n wanted: %+v\n", entries, total, err, expectedEntry)
+	}
+	if entries, total, err := flatStore.GetAuditEntries(20, 0); err != nil || total != 0 || len(entries) != 0 {
+		t.Errorf("Unexpected audit log of another household: %+v, total: %d, error: %v\n", entries, total, err)
+	}
+	// API keys
+	key := APIKey{Name: "Kitchen", Prefix: "tea_0123", Permissions: []Permission{PermissionRead, PermissionWrite}, CreatedAt: selectedAt, Username: "john", Hash: "keyHash"}
+	if err := store.CreateAPIKey(&key); err != nil || key.ID == 0 {
+		t.Fatalf("Unexpected API key created: %+v, error: %v\n", key, err)
+	}
+	if err := store.CreateAPIKey(&APIKey{Name: "Missing", Permissions: []Permission{PermissionRead}, CreatedAt: selectedAt, Username: "jane", Hash: "otherHash"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Unexpected error creating API key for missing user:\n got: %v\n wanted: %v\n", err, ErrNotFound)
+	}
+	used, err := store.UseAPIKey(key.Hash)
+	if err != nil || used.ID != key.ID || used.Username != "john" || !reflect.DeepEqual(used.Permissions, key.Permissions) || used.LastUsedAt == nil {
+		t.Errorf("Unexpected API key used: %+v, error: %v\n", used, err)
+	}
+	if _, err := store.UseAPIKey("wrongHash"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Unexpected error using missing API key:\n got: %v\n wanted: %v\n", err, ErrNotFound)
+	}
+	if keys, err := store.GetAPIKeys("john"); err != nil || len(keys) != 1 || keys[0].Name != "Kitchen" || keys[0].CreatedAt != selectedAt || keys[0].LastUsedAt == nil {
+		t.Errorf("Unexpected API keys: %+v, error: %v\n", keys, err)
+	}
+	if err := store.DeleteAPIKey("bob", key.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Unexpected error deleting another user's API key:\n got: %v\n wanted: %v\n", err, ErrNotFound)
+	}
+	if err := store.DeleteAPIKey("john", key.ID); err != nil {
+		t.Errorf("Unexpected error deleting API key: %v\n", err)
+	}
+	if keys, err := store.GetAPIKeys("john"); err != nil || len(keys) != 0 {
+		t.Errorf("Unexpected API keys after deleting: %+v, error: %v\n", keys, err)
+	}
+
+	// Password history
+	for _, password := range []string{"first", "second", "third"} {
+		if err := store.AddPasswordHistory("john", password, 2); err != nil {
+			t.Fatalf("Unexpected error adding password history: %v\n", err)
+		}
+	}
+	if history, err := store.GetPasswordHistory("john"); err != nil || !reflect.DeepEqual(history, []string{"third", "second"}) {
+		t.Errorf("Unexpected password history: %q, error: %v\n", history, err)
+	}
+	if err := store.AddPasswordHistory("john", "fourth", 0); err != nil {
+		t.Errorf("Unexpected error clearing password history: %v\n", err)
+	}
+	if history, err := store.GetPasswordHistory("john"); err != nil || len(history) != 0 {
+		t.Errorf("Unexpected password history after clearing: %q, error: %v\n", history, err)
+	}
+	if err := store.AddPasswordHistory("jane", "first", 2); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Unexpected error adding password history of missing user:\n got: %v\n wanted: %v\n", err, ErrNotFound)
+	}
+	replaced := RefreshToken{Hash: "replaced", Username: "john", AccessTokenID: "replacedAccess", AccessExpiresAt: now.Add(AccessTokenLifetime), ExpiresAt: now.Add(RefreshTokenLifetime)}
+	if err := store.CreateRefreshToken(replaced); err != nil {
+		t.Fatalf("Unexpected error creating refresh token: %v\n", err)
+	}
+	if err := store.ReplacePassword("john", "replacedHash", "previousHash", 2); err != nil {
+		t.Errorf("Unexpected error replacing password: %v\n", err)
+	}
+	if password, err := store.GetPassword("john"); err != nil || password != "replacedHash" {
+		t.Errorf("Unexpected password after replacing: %q, error: %v\n", password, err)
+	}
+	if history, err := store.GetPasswordHistory("john"); err != nil || !reflect.DeepEqual(history, []string{"previousHash"}) {
+		t.Errorf("Unexpected password history after replacing: %q, error: %v\n", history, err)
+	}
+	if revoked, err := store.IsTokenRevoked(replaced.AccessTokenID); err != nil || !revoked {
+		t.Errorf("Access token wasn't revoked by replacing password: %v, error: %v\n", revoked, err)
+	}
+	if _, err := store.UseRefreshToken(replaced.Hash); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Unexpected error using a refresh token after replacing password:\n got: %v\n wanted: %v\n", err, ErrNotFound)
+	}
+	if err := store.ReplacePassword("jane", "replacedHash", "previousHash", 2); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Unexpected error replacing password of missing user:\n got: %v\n wanted: %v\n", err, ErrNotFound)
+	}
+
+	// Identities
+	identity := Identity{Issuer: "https://id.example.com", Subject: "1234", Username: "john"}
+	if _, err := store.GetIdentityUser(identity.Issuer, identity.Subject); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Unexpected error getting unlinked identity:\n got: %v\n wanted: %v\n", err, ErrNotFound)
+	}
+	if err := store.CreateIdentity(identity); err != nil {
+		t.Errorf("Unexpected error linking identity: %v\n", err)
+	}
+	if err := store.CreateIdentity(Identity{Issuer: identity.Issuer, Subject: identity.Subject, Username: "bob"}); !errors.Is(err, ErrConflict) {
+		t.Errorf("Unexpected error linking identity twice:\n got: %v\n wanted: %v\n", err, ErrConflict)
+	}
+	if err := store.CreateIdentity(Identity{Issuer: identity.Issuer, Subject: "5678", Username: "jane"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Unexpected error linking identity to missing user:\n got: %v\n wanted: %v\n", err, ErrNotFound)
+	}
+	if user, err := store.GetIdentityUser(identity.Issuer, identity.Subject); err != nil || user.Username != "john" {
+		t.Errorf("Unexpected user linked to identity: %+v, error: %v\n", user, err)
+	}
+	if _, err := store.GetIdentityUser("https://other.example.com", identity.Subject); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Unexpected error getting identity of another issuer:\n got: %v\n wanted: %v\n", err, ErrNotFound)
+	}
+	kimsHousehold := Household{Name: "kim's household"}
+	if user, err := store.CreateIdentityUser(Identity{Issuer: identity.Issuer, Subject: "5678", Username: "kim"}, &kimsHousehold); err != nil || user.Household == DefaultHousehold || user.Household != kimsHousehold.ID || user.Role != RoleAdmin {
+		t.Errorf("Unexpected user created with identity: %+v, error: %v\n", user, err)
+	}
+	if user, err := store.GetIdentityUser(identity.Issuer, "5678"); err != nil || user.Username != "kim" {
+		t.Errorf("Unexpected user linked to new identity: %+v, error: %v\n", user, err)
+	}
+	if _, err := store.CreateIdentityUser(Identity{Issuer: identity.Issuer, Subject: identity.Subject, Username: "lee"}, &Household{Name: "lee's household"}); !errors.Is(err, ErrConflict) {
+		t.Errorf("Unexpected error creating user with a linked identity:\n got: %v\n wanted: %v\n", err, ErrConflict)
+	}
+	if _, err := store.GetUser("lee"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("User was left without their identity:\n got: %v\n wanted: %v\n", err, ErrNotFound)
+	}
+
+	testStoreLists(t, store)
+	testStoreSearch(t, store)
+	testStoreImport(t, store)
+	testStoreCreateTeaWithOwners(t, store)
+}
+
+// testStoreLists checks that lists of teas, owners and tea types are filtered, sorted and paged in the same way by
+// every store, using a household of their own.
+func testStoreLists(t *testing.T, store Store) {
+	household := Household{Name: "Lists"}
+	if err := store.CreateHousehold(&household, UserLogin{Username: "lister", Password: "hash"}); err != nil {
+		t.Fatalf("Unexpected error creating household: %v\n", err)
+	}
+	store = store.ForHousehold(household.ID)
+
+	black, green := TeaType{Name: "Black Tea"}, TeaType{Name: "Green Tea"}
+	for _, teaType := range []*TeaType{&black, &green} {
+		if err := store.CreateTeaType(teaType); err != nil {
+			t.Fatalf("Unexpected error creating tea type: %v\n", err)
+		}
+	}
+	ann, bo := Owner{Name: "Ann"}, Owner{Name: "Bo"}
+	for _, owner := range []*Owner{&ann, &bo} {
+		if err := store.CreateOwner(owner); err != nil {
+			t.Fatalf("Unexpected error creating owner: %v\n", err)
+		}
+	}
+	teas := map[string]TeaType{"Earl Grey": black, "Sencha": green, "Assam": black, "100% Sencha": green, "Darjeeling": black}
+	ids := make(map[string]int)
+	for _, name := range []string{"Earl Grey", "Sencha", "Assam", "100% Sencha", "Darjeeling"} {
+		tea := Tea{Name: name, TeaType: teas[name]}
+		if err := store.CreateTea(&tea); err != nil {
+			t.Fatalf("Unexpected error creating tea: %v\n", err)
+		}
+		ids[name] = tea.ID
+	}
+	for _, name := range []string{"Earl Grey", "Sencha"} {
+		if _, err := store.CreateTeaOwner(ids[name], &ann, Stock{}); err != nil {
+			t.Fatalf("Unexpected error adding owner to tea: %v\n", err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		options  ListOptions
+		expected []string
+	}{
+		{"everything", ListOptions{}, []string{"Earl Grey", "Sencha", "Assam", "100% Sencha", "Darjeeling"}},
+		{"by name", ListOptions{Sort: SortName}, []string{"100% Sencha", "Assam", "Darjeeling", "Earl Grey", "Sencha"}},
+		{"by ID descending", ListOptions{Sort: "-id", Limit: 2}, []string{"Darjeeling", "100% Sencha"}},
+		{"first page", ListOptions{Sort: SortName, Limit: 2}, []string{"100% Sencha", "Assam"}},
+		{"next page", ListOptions{Sort: SortName, Limit: 2, After: &ListCursor{Sort: SortName, ID: ids["Assam"], Name: "Assam"}}, []string{"Darjeeling", "Earl Grey"}},
+		{"next page descending", ListOptions{Sort: "-name", After: &ListCursor{Sort: "-name", ID: ids["Earl Grey"], Name: "Earl Grey"}}, []string{"Darjeeling", "Assam", "100% Sencha"}},
+		{"after ID", ListOptions{After: &ListCursor{Sort: SortID, ID: ids["Assam"]}}, []string{"100% Sencha", "Darjeeling"}},
+		{"name prefix", ListOptions{NamePrefix: "earl"}, []string{"Earl Grey"}},
+		{"name prefix with wildcards", ListOptions{NamePrefix: "100%"}, []string{"100% Sencha"}},
+		{"name prefix of a wildcard", ListOptions{NamePrefix: "_"}, []string{}},
+		{"type", ListOptions{TypeID: green.ID}, []string{"Sencha", "100% Sencha"}},
+		{"owner", ListOptions{OwnerID: ann.ID, Sort: SortName}, []string{"Earl Grey", "Sencha"}},
+		{"owner without teas", ListOptions{OwnerID: bo.ID}, []string{}},
+	}
+	for _, test := range tests {
+		teas, err := store.GetAllTeas(test.options)
+		names := make([]string, 0)
+		for _, tea := range teas {
+			names = append(names, tea.Name)
+		}
+		if err != nil || !reflect.DeepEqual(names, test.expected) {
+			t.Errorf("Unexpected teas listed %s: %q, error: %v\n wanted: %q\n", test.name, names, err, test.expected)
+		}
+
+		teasWithOwners, err := store.GetAllTeaOwners(test.options)
+		names = make([]string, 0)
+		for _, teaWithOwners := range teasWithOwners {
+			names = append(names, teaWithOwners.Tea.Name)
+		}
+		if err != nil || !reflect.DeepEqual(names, test.expected) {
+			t.Errorf("Unexpected teas with owners listed %s: %q, error: %v\n wanted: %q\n", test.name, names, err, test.expected)
+		}
+	}
+
+	if owners, err := store.GetAllOwners(ListOptions{Sort: "-name", Limit: 1}); err != nil || !reflect.DeepEqual(owners, []Owner{bo}) {
+		t.Errorf("Unexpected owners: %+v, error: %v\n", owners, err)
+	}
+	if types, err := store.GetAllTeaTypes(ListOptions{NamePrefix: "GREEN"}); err != nil || !reflect.DeepEqual(types, []TeaType{green}) {
+		t.Errorf("Unexpected tea types: %+v, error: %v\n", types, err)
+	}
+}
+
+// testStoreSearch checks that teas are searched and ranked in the same way by every store, whether or not it has a
+// full-text index, and that the results follow changes to teas and tea types.
+func testStoreSearch(t *testing.T, defaultStore Store) {
+	household := Household{Name: "Search"}
+	if err := defaultStore.CreateHousehold(&household, UserLogin{Username: "searcher", Password: "hash"}); err != nil {
+		t.Fatalf("Unexpected error creating household: %v\n", err)
+	}
+	store := defaultStore.ForHousehold(household.ID)
+
+	black, oolong := TeaType{Name: "Black Tea"}, TeaType{Name: "Oolong"}
+	for _, teaType := range []*TeaType{&black, &oolong} {
+		if err := store.CreateTeaType(teaType); err != nil {
+			t.Fatalf("Unexpected error creating tea type: %v\n", err)
+		}
+	}
+	teas := []Tea{
+		{Name: "Earl Grey", TeaType: black, Notes: "Bergamot and cornflowers"},
+		{Name: "Lady Grey", TeaType: black, Notes: "Orange and lemon"},
+		{Name: "Milk Oolong", TeaType: oolong, Notes: "Creamy"},
+		{Name: "Iron Goddess", TeaType: oolong, Notes: "Roasted, with a grey-green leaf"},
+		{Name: "Assam", TeaType: black, Notes: "Malty"},
+	}
+	for i := range teas {
+		if err := store.CreateTea(&teas[i]); err != nil {
+			t.Fatalf("Unexpected error creating tea: %v\n", err)
+		}
+	}
+
+	search := func(query string, limit int, expected ...string) {
+		t.Helper()
+		found, err := store.SearchTeas(parseSearchTerms(query), limit)
+		names := make([]string, 0)
+		for _, tea := range found {
+			names = append(names, tea.Name)
+		}
+		if err != nil || !reflect.DeepEqual(names, append([]string{}, expected...)) {
+			t.Errorf("Unexpected teas found searching %q: %q, error: %v\n wanted: %q\n", query, names, err, expected)
+		}
+	}
+	search("grey", 0, "Earl Grey", "Lady Grey", "Iron Goddess")
+	search("grey", 1, "Earl Grey")
+	search("oolong", 0, "Milk Oolong", "Iron Goddess")
+	search("GREY, earl", 0, "Earl Grey")
+	search("berg", 0, "Earl Grey")
+	search("malty assam", 0, "Assam")
+	search("coffee", 0)
+	search("", 0)
+
+	// If nothing matches, names of teas and types with a typo in them are still found
+	search("earl gray", 0, "Earl Grey")
+	search("blak", 0, "Earl Grey", "Lady Grey", "Assam")
+	search("goddes asam", 0)
+	search("tee", 0)
+
+	if found, err := defaultStore.SearchTeas([]string{"goddess"}, 0); err != nil || len(found) != 0 {
+		t.Errorf("Unexpected teas found searching another household: %+v, error: %v\n", found, err)
+	}
+
+	// Results follow changes to teas and their types
+	oolong.Name = "Wulong"
+	if err := store.UpdateTeaType(&oolong); err != nil {
+		t.Fatalf("Unexpected error updating tea type: %v\n", err)
+	}
+	search("wulong", 0, "Milk Oolong", "Iron Goddess")
+	teas[4].Notes = "Smoky"
+	if err := store.UpdateTea(&teas[4]); err != nil {
+		t.Fatalf("Unexpected error updating tea: %v\n", err)
+	}
+	search("malty", 0)
+	search("smoky", 0, "Assam")
+	if err := store.DeleteTea(&teas[1]); err != nil {
+		t.Fatalf("Unexpected error deleting tea: %v\n", err)
+	}
+	search("grey", 0, "Earl Grey", "Iron Goddess")
+}
+
+// anyOwner lets teas be imported for any owner.
+func anyOwner(ownerID int) error {
+	return nil
+}
+
+// testStoreImport checks that collections are imported in the same way by every store, using a household of their own.
+func testStoreImport(t *testing.T, defaultStore Store) {
+	household := Household{Name: "Import"}
+	if err := defaultStore.CreateHousehold(&household, UserLogin{Username: "importer", Password: "hash"}); err != nil {
+		t.Fatalf("Unexpected error creating household: %v\n", err)
+	}
+	store := defaultStore.ForHousehold(household.ID)
+	if err := store.CreateTeaType(&TeaType{Name: "Black Tea"}); err != nil {
+		t.Fatalf("Unexpected error creating tea type: %v\n", err)
+	}
+
+	quantity := 20.0
+	collection := Collection{
+		Types:  []TeaType{{Name: "Black Tea"}, {Name: "Green Tea", BrewingGuide: BrewingGuide{BrewTemperature: 80}}, {Name: "Hot", BrewingGuide: BrewingGuide{BrewTemperature: 120}}},
+		Owners: []Owner{{Name: "Ann"}, {Name: "Bo"}, {Name: "Ann"}},
+		Teas: []TeaWithOwners{
+			{Tea: Tea{Name: "Assam", TeaType: TeaType{Name: "Black Tea"}}, Owners: []TeaOwner{{Owner: Owner{Name: "Ann"}, Stock: Stock{Quantity: &quantity, Unit: UnitBags}}, {Owner: Owner{Name: "Cy"}}}},
+			{Tea: Tea{Name: "Sencha", TeaType: TeaType{Name: "Green Tea"}, Notes: "Grassy"}, Owners: []TeaOwner{{Owner: Owner{Name: "Bo"}}}},
+			{Tea: Tea{Name: "Rooibos", TeaType: TeaType{Name: "Herbal"}}, Owners: []TeaOwner{{Owner: Owner{Name: "Ann"}}}},
+		},
+	}
+	expected := []ImportRow{
+		{Kind: KindType, Name: "Black Tea", Status: ImportSkipped},
+		{Kind: KindType, Name: "Green Tea", Status: ImportCreated},
+		{Kind: KindType, Name: "Hot", Status: ImportFailed, Error: "Brew temperature must be between 0 and 100"},
+		{Kind: KindOwner, Name: "Ann", Status: ImportCreated},
+		{Kind: KindOwner, Name: "Bo", Status: ImportCreated},
+		{Kind: KindOwner, Name: "Ann", Status: ImportFailed, Error: "Appears more than once"},
+		{Kind: KindTea, Name: "Assam", Status: ImportCreated},
+		{Kind: KindOwnership, Name: "Assam", Owner: "Ann", Status: ImportCreated},
+		{Kind: KindOwnership, Name: "Assam", Owner: "Cy", Status: ImportFailed, Error: "Unknown owner: Cy"},
+		{Kind: KindTea, Name: "Sencha", Status: ImportCreated},
+		{Kind: KindOwnership, Name: "Sencha", Owner: "Bo", Status: ImportCreated},
+		{Kind: KindTea, Name: "Rooibos", Status: ImportFailed, Error: "Unknown tea type: Herbal"},
+		{Kind: KindOwnership, Name: "Rooibos", Owner: "Ann", Status: ImportFailed, Error: "Tea wasn't imported"},
+	}
+
+	// A dry run reports what would happen, without changing anything
+	report, err := store.ImportCollection(collection, true, anyOwner)
+	if err != nil || !report.DryRun || report.Created != 7 || report.Skipped != 1 || report.Failed != 5 || !reflect.DeepEqual(report.Rows, expected) {
+		t.Errorf("Unexpected report of dry run: %+v, error: %v\n", report, err)
+	}
+	if exported, err := exportCollection(store); err != nil || len(exported.Types) != 1 || len(exported.Owners) != 0 || len(exported.Teas) != 0 {
+		t.Errorf("Unexpected collection after dry run: %+v, error: %v\n", exported, err)
+	}
+
+	report, err = store.ImportCollection(collection, false, anyOwner)
+	if err != nil || report.DryRun || !reflect.DeepEqual(report.Rows, expected) {
+		t.Errorf("Unexpected report of import: %+v, error: %v\n", report, err)
+	}
+	exported, err := exportCollection(store)
+	if err != nil {
+		t.Fatalf("Unexpected error exporting collection: %v\n", err)
+	}
+	teas := make(map[string]TeaWithOwners)
+	for _, tea := range exported.Teas {
+		teas[tea.Tea.Name] = tea
+	}
+	if len(exported.Types) != 2 || exported.Types[1].BrewTemperature != 80 || len(exported.Owners) != 2 || len(teas) != 2 {
+		t.Fatalf("Unexpected collection after import: %+v\n", exported)
+	}
+	if assam := teas["Assam"]; assam.Tea.TeaType.Name != "Black Tea" || len(assam.Owners) != 1 || assam.Owners[0].Name != "Ann" || assam.Owners[0].Quantity == nil || *assam.Owners[0].Quantity != 20 {
+		t.Errorf("Unexpected imported tea: %+v\n", assam)
+	}
+	if sencha := teas["Sencha"]; sencha.Tea.TeaType.Name != "Green Tea" || sencha.Tea.Notes != "Grassy" || len(sencha.Owners) != 1 || sencha.Owners[0].Name != "Bo" {
+		t.Errorf("Unexpected imported tea: %+v\n", sencha)
+	}
+
+	// Importing an export again changes nothing, and changed items are updated
+	if report, err := store.ImportCollection(exported, false, anyOwner); err != nil || report.Skipped != 8 || report.Created+report.Updated+report.Failed != 0 {
+		t.Errorf("Unexpected report of importing an export: %+v, error: %v\n", report, err)
+	}
+	changes := Collection{
+		Types: []TeaType{{Name: "Green Tea", BrewingGuide: BrewingGuide{BrewTemperature: 75}}},
+		Teas: []TeaWithOwners{
+			{Tea: Tea{Name: "Sencha", TeaType: TeaType{ID: exported.Types[1].ID}, Notes: "Fresh"}},
+			{Tea: Tea{Name: "Assam"}, Owners: []TeaOwner{{Owner: Owner{ID: teas["Sencha"].Owners[0].ID}}}},
+		},
+	}
+	expected = []ImportRow{
+		{Kind: KindType, Name: "Green Tea", Status: ImportUpdated},
+		{Kind: KindTea, Name: "Sencha", Status: ImportUpdated},
+		{Kind: KindOwnership, Name: "Assam", Owner: "Bo", Status: ImportCreated},
+	}
+	if report, err := store.ImportCollection(changes, false, anyOwner); err != nil || !reflect.DeepEqual(report.Rows, expected) {
+		t.Errorf("Unexpected report of importing changes: %+v, error: %v\n", report, err)
+	}
+	sencha := Tea{ID: teas["Sencha"].Tea.ID}
+	if err := store.GetTea(&sencha); err != nil || sencha.Notes != "Fresh" || sencha.TeaType.BrewTemperature != 75 {
+		t.Errorf("Unexpected tea after importing changes: %+v, error: %v\n", sencha, err)
+	}
+	if owners, err := store.GetTeaOwners(&Tea{ID: teas["Assam"].Tea.ID}); err != nil || len(owners) != 2 {
+		t.Errorf("Unexpected owners of tea after importing changes: %+v, error: %v\n", owners, err)
+	}
+
+	// Teas are only added to the owners that are allowed
+	bo := teas["Sencha"].Owners[0].ID
+	onlyBo := func(ownerID int) error {
+		if ownerID != bo {
+			return errOtherOwner
+		}
+		return nil
+	}
+	restricted := Collection{Teas: []TeaWithOwners{{Tea: Tea{Name: "Darjeeling", TeaType: TeaType{Name: "Black Tea"}}, Owners: []TeaOwner{{Owner: Owner{Name: "Ann"}}, {Owner: Owner{Name: "Bo"}}}}}}
+	expected = []ImportRow{
+		{Kind: KindTea, Name: "Darjeeling", Status: ImportCreated},
+		{Kind: KindOwnership, Name: "Darjeeling", Owner: "Ann", Status: ImportFailed, Error: errOtherOwner.Error()},
+		{Kind: KindOwnership, Name: "Darjeeling", Owner: "Bo", Status: ImportCreated},
+	}
+	if report, err := store.ImportCollection(restricted, false, onlyBo); err != nil || !reflect.DeepEqual(report.Rows, expected) {
+		t.Errorf("Unexpected report of importing for an owner that isn't allowed: %+v, error: %v\n", report, err)
+	}
+}
+
+// testStoreCreateTeaWithOwners checks that teas are created along with their owners in the same way by every store,
+// using a household of their own.
+func testStoreCreateTeaWithOwners(t *testing.T, defaultStore Store) {
+	household := Household{Name: "New Teas"}
+	if err := defaultStore.CreateHousehold(&household, UserLogin{Username: "newTeas", Password: "hash"}); err != nil {
+		t.Fatalf("Unexpected error creating household: %v\n", err)
+	}
+	store := defaultStore.ForHousehold(household.ID)
+	blackTea := TeaType{Name: "Black Tea"}
+	ann, bo := Owner{Name: "Ann"}, Owner{Name: "Bo"}
+	if err := store.CreateTeaType(&blackTea); err != nil {
+		t.Fatalf("Unexpected error creating tea type: %v\n", err)
+	}
+	for _, owner := range []*Owner{&ann, &bo} {
+		if err := store.CreateOwner(owner); err != nil {
+			t.Fatalf("Unexpected error creating owner: %v\n", err)
+		}
+	}
+
+	// Types and owners can be given by ID or name
+	quantity := 20.0
+	assam := Tea{Name: "Assam", TeaType: TeaType{Name: "Black Tea"}}
+	created, err := store.CreateTeaWithOwners(&assam, []TeaOwner{{Owner: Owner{ID: ann.ID}, Stock: Stock{Quantity: &quantity, Unit: UnitBags}}, {Owner: Owner{Name: "Bo"}}}, false)
+	expected := []TeaOwner{{Owner: ann, Stock: Stock{Quantity: &quantity, Unit: UnitBags}}, {Owner: bo}}
+	if err != nil || created.Tea.ID == 0 || created.Tea.TeaType.ID != blackTea.ID || !reflect.DeepEqual(created.Owners, expected) {
+		t.Errorf("Unexpected tea created with owners: %+v, error: %v\n", created, err)
+	}
+	if owners, err := store.GetTeaOwners(&created.Tea); err != nil || !reflect.DeepEqual(owners, expected) {
+		t.Errorf("Unexpected owners of created tea: %+v, error: %v\n", owners, err)
+	}
+
+	// A type that doesn't exist is only created when asked
+	tieGuanYin := Tea{Name: "Tie Guan Yin", TeaType: TeaType{Name: "Oolong", BrewingGuide: BrewingGuide{SteepSeconds: 60}}}
+	if _, err := store.CreateTeaWithOwners(&tieGuanYin, nil, false); !errors.Is(err, ErrValidation) {
+		t.Errorf("Unexpected error creating tea of unknown type:\n got: %v\n wanted: %v\n", err, ErrValidation)
+	}
+	created, err = store.CreateTeaWithOwners(&tieGuanYin, nil, true)
+	if err != nil || created.Tea.TeaType.ID == 0 || len(created.Owners) != 0 {
+		t.Errorf("Unexpected tea created with its type: %+v, error: %v\n", created, err)
+	}
+	oolong := TeaType{ID: created.Tea.TeaType.ID}
+	if err := store.GetTeaType(&oolong); err != nil || oolong.Name != "Oolong" || oolong.SteepSeconds != 60 {
+		t.Errorf("Unexpected created tea type: %+v, error: %v\n", oolong, err)
+	}
+
+	// Nothing is created if any owner is unknown, given twice or in another household
+	invalid := [][]TeaOwner{
+		{{Owner: Owner{Name: "Ann"}}, {Owner: Owner{Name: "Cy"}}},
+		{{Owner: Owner{ID: ann.ID}}, {Owner: Owner{Name: "Ann"}}},
+		{{Owner: Owner{ID: 1}}},
+	}
+	for _, owners := range invalid {
+		darjeeling := Tea{Name: "Darjeeling", TeaType: TeaType{ID: blackTea.ID}}
+		if _, err := store.CreateTeaWithOwners(&darjeeling, owners, false); !errors.Is(err, ErrValidation) {
+			t.Errorf("Unexpected error creating tea with owners %+v:\n got: %v\n wanted: %v\n", owners, err, ErrValidation)
+		}
+	}
+	if teas, err := store.GetAllTeas(ListOptions{NamePrefix: "Darjeeling"}); err != nil || len(teas) != 0 {
+		t.Errorf("Unexpected teas after failing to create them: %+v, error: %v\n", teas, err)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// How long the tokens given to a user when they log in last for. Access tokens are short lived, so that revoking a
+// session only needs the few access tokens that haven't expired yet to be remembered.
+const (
+	AccessTokenLifetime  = 15 * time.Minute
+	RefreshTokenLifetime = 30 * 24 * time.Hour
+)
+
+// A Session is the pair of tokens given to a user when they log in. The access token is sent with each request, and the
+// refresh token is used to get a new pair of tokens before the access token expires.
+type Session struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
+}
+
+// A RefreshRequest swaps a refresh token for a new session.
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// A RefreshToken is a stored refresh token, along with the ID of the access token it was issued with, so that both
+// can be revoked together. Only a hash of the token is stored, so the tokens can't be read from the store.
+type RefreshToken struct {
+	Hash            string
+	Username        string
+	AccessTokenID   string
+	AccessExpiresAt time.Time
+	ExpiresAt       time.Time
+}
+
+// randomToken gives a random hex string from the given number of bytes, which is hard to guess.
+func randomToken(bytes int) (string, error) {
+	token := make([]byte, bytes)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}
+
+// hashToken gives the hash of a refresh token that is stored in place of the token.
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
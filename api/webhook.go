@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Events that can be sent to webhooks.
+const (
+	WebhookLowStock = "stock.low"
+)
+
+// Headers sent with every webhook request.
+const (
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// DefaultWebhookAttempts is how many times a webhook is sent before giving up, if the config doesn't say.
+const DefaultWebhookAttempts = 5
+
+// A WebhookConfig details where webhooks are sent and how they are signed.
+type WebhookConfig struct {
+	URLs        []string `yaml:"urls"`
+	Secret      string   `yaml:"secret"`
+	MaxAttempts int      `yaml:"maxAttempts"`
+	LowStock    struct {
+		Bags  float64 `yaml:"bags"`
+		Grams float64 `yaml:"grams"`
+	} `yaml:"lowStock"`
+}
+
+// A WebhookPayload is the body sent to a webhook.
+type WebhookPayload struct {
+	Event  string      `json:"event"`
+	SentAt time.Time   `json:"sentAt"`
+	Data   interface{} `json:"data"`
+}
+
+// A LowStockEvent is sent when an owner's stock of a tea falls below the configured threshold.
+type LowStockEvent struct {
+	Tea       Tea     `json:"tea"`
+	Owner     Owner   `json:"owner"`
+	Stock     Stock   `json:"stock"`
+	Threshold float64 `json:"threshold"`
+}
+
+// A WebhookDelivery records the attempts to send an event to a webhook.
+type WebhookDelivery struct {
+	ID         int       `json:"id"`
+	Event      string    `json:"event"`
+	URL        string    `json:"url"`
+	Payload    string    `json:"payload"`
+	Attempts   int       `json:"attempts"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	Delivered  bool      `json:"delivered"`
+	Error      string    `json:"error,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// A WebhookDeliveryHistory is a page of the webhook delivery history.
+type WebhookDeliveryHistory struct {
+	Deliveries []WebhookDelivery `json:"deliveries"`
+	Total      int               `json:"total"`
+	Limit      int               `json:"limit"`
+	Offset     int               `json:"offset"`
+}
+
+// DefaultWebhookBackoff is how long to wait before the first retry of a webhook. The wait doubles after each failed attempt.
+const DefaultWebhookBackoff = 2 * time.Second
+
+// Webhooks sends events to the configured webhooks, recording every delivery in the store of the household the event
+// happened in. It watches the store's stock, sending a low stock event when an owner is running out of a tea.
+type Webhooks struct {
+	config  WebhookConfig
+	store   Store
+	client  *http.Client
+	backoff time.Duration
+	pending sync.WaitGroup // Tracks the webhooks that are still being delivered
+}
+
+// NewWebhooks creates a sender for the webhooks in the config, which records deliveries in the store.
+func NewWebhooks(cfg WebhookConfig, store Store) *Webhooks {
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = DefaultWebhookAttempts
+	}
+	return &Webhooks{
+		config:  cfg,
+		store:   store,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		backoff: DefaultWebhookBackoff,
+	}
+}
+
+// Wait blocks until every webhook that is being sent has either been delivered or given up on.
+func (w *Webhooks) Wait() {
+	w.pending.Wait()
+}
+
+// Send sends an event that happened in a household to every configured webhook. Deliveries are recorded in the
+// household, then made in the background.
+func (w *Webhooks) Send(household int, event string, data interface{}) {
+	if len(w.config.URLs) == 0 {
+		return
+	}
+
+	payload, err := json.Marshal(WebhookPayload{Event: event, SentAt: time.Now().UTC(), Data: data})
+	if err != nil {
+		log.Printf("Failed to create payload for webhook event %q. Error: %v\n", event, err)
+		return
+	}
+
+	store := w.store.ForHousehold(household)
+	for _, url := range w.config.URLs {
+		delivery := WebhookDelivery{Event: event, URL: url, Payload: string(payload), CreatedAt: time.Now()}
+		if err := store.CreateWebhookDelivery(&delivery); err != nil {
+			log.Printf("Failed to record webhook delivery to %s. Error: %v\n", url, err)
+			continue
+		}
+
+		w.pending.Add(1)
+		go func() {
+			defer w.pending.Done()
+			w.deliver(store, &delivery)
+		}()
+	}
+}
+
+// deliver sends a delivery's payload, retrying with an increasing delay until it succeeds or runs out of attempts.
+// The delivery is updated in the store after every attempt.
+func (w *Webhooks) deliver(store Store, delivery *WebhookDelivery) {
+	backoff := w.backoff
+	for delivery.Attempts < w.config.MaxAttempts {
+		if delivery.Attempts > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		delivery.Attempts++
+		delivery.StatusCode, delivery.Error = 0, ""
+		if statusCode, err := w.post(delivery); err != nil {
+			delivery.Error = err.Error()
+		} else {
+			delivery.StatusCode = statusCode
+			delivery.Delivered = statusCode >= 200 && statusCode < 300
+			if !delivery.Delivered {
+				delivery.Error = fmt.Sprintf("Received status code %d", statusCode)
+			}
+		}
+
+		if err := store.UpdateWebhookDelivery(delivery); err != nil {
+			log.Printf("Failed to update webhook delivery with ID: %d\n Error: %v\n", delivery.ID, err)
+		}
+		if delivery.Delivered {
+			log.Printf("Delivered webhook. ID: %d \t URL: %s\n", delivery.ID, delivery.URL)
+			return
+		}
+		log.Printf("Failed to deliver webhook. ID: %d \t Attempt: %d\n Error: %s\n", delivery.ID, delivery.Attempts, delivery.Error)
+	}
+}
+
+// post makes a single attempt at sending a delivery's payload, giving the status code of the response.
+func (w *Webhooks) post(delivery *WebhookDelivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, delivery.URL, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, delivery.Event)
+	req.Header.Set(WebhookDeliveryHeader, strconv.Itoa(delivery.ID))
+	req.Header.Set(WebhookSignatureHeader, SignWebhookPayload(w.config.Secret, []byte(delivery.Payload)))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// StockChanged sends a low stock event if an owner's stock of a tea has fallen below the threshold.
+func (w *Webhooks) StockChanged(household int, teaID int, ownerID int, before Stock, after Stock) {
+	threshold := w.lowStockThreshold(after.Unit)
+	if !fallsBelow(before, after, threshold) {
+		return
+	}
+
+	event := LowStockEvent{Tea: Tea{ID: teaID}, Owner: Owner{ID: ownerID}, Stock: after, Threshold: threshold}
+	store := w.store.ForHousehold(household)
+	if err := store.GetTea(&event.Tea); err != nil {
+		log.Printf("Failed to get tea for low stock event. teaID: %d\n Error: %v\n", teaID, err)
+		return
+	}
+	if err := store.GetOwner(&event.Owner); err != nil {
+		log.Printf("Failed to get owner for low stock event. ownerID: %d\n Error: %v\n", ownerID, err)
+		return
+	}
+	w.Send(household, WebhookLowStock, event)
+}
+
+// SignWebhookPayload gives the signature of a payload, sent in the signature header.
+// Receivers can check a webhook came from this API by calculating the same HMAC-SHA256 of the body with the shared secret.
+func SignWebhookPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// lowStockThreshold gives the quantity that stock in the given unit must fall below to send a low stock event.
+// A threshold of 0 means no events are sent for that unit.
+func (w *Webhooks) lowStockThreshold(unit string) float64 {
+	if unit == UnitGrams {
+		return w.config.LowStock.Grams
+	}
+	return w.config.LowStock.Bags
+}
+
+// fallsBelow checks whether a change in stock has taken the quantity below the threshold.
+func fallsBelow(before Stock, after Stock, threshold float64) bool {
+	if after.Quantity == nil || threshold <= 0 || *after.Quantity >= threshold {
+		return false
+	}
+	return before.Quantity != nil && *before.Quantity >= threshold
+}
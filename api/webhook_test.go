@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSignWebhookPayload(t *testing.T) {
+	expected := "sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"
+	if actual := SignWebhookPayload("key", []byte("The quick brown fox jumps over the lazy dog")); actual != expected {
+		t.Errorf("Unexpected webhook signature:\n got: %v\n wanted: %v\n", actual, expected)
+	}
+}
+
+func TestFallsBelow(t *testing.T) {
+	ten, five, two := 10.0, 5.0, 2.0
+	tests := []struct {
+		before    Stock
+		after     Stock
+		threshold float64
+		expected  bool
+	}{
+		{Stock{Quantity: &ten}, Stock{Quantity: &two}, 5, true},
+		{Stock{Quantity: &five}, Stock{Quantity: &two}, 5, true},
+		{Stock{Quantity: &ten}, Stock{Quantity: &five}, 5, false},
+		{Stock{Quantity: &five}, Stock{Quantity: &two}, 2, false},
+		{Stock{Quantity: &two}, Stock{Quantity: &two}, 5, false},
+		{Stock{}, Stock{Quantity: &two}, 5, false},
+		{Stock{Quantity: &ten}, Stock{Quantity: &two}, 0, false},
+	}
+
+	for _, test := range tests {
+		if actual := fallsBelow(test.before, test.after, test.threshold); actual != test.expected {
+			t.Errorf("Unexpected result for %v to %v below %v:\n got: %v\n wanted: %v\n", test.before.Quantity, test.after.Quantity, test.threshold, actual, test.expected)
+		}
+	}
+}
+
+// mockWebhookDeliveries gives a store for webhook deliveries, recording every update made.
+func mockWebhookDeliveries() (*mockStore, *[]WebhookDelivery) {
+	var mutex sync.Mutex
+	updates := make([]WebhookDelivery, 0)
+
+	store := new(mockStore)
+	store.createWebhookDelivery = func(delivery *WebhookDelivery) error {
+		delivery.ID = 7
+		return nil
+	}
+	store.updateWebhookDelivery = func(delivery *WebhookDelivery) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		updates = append(updates, *delivery)
+		return nil
+	}
+	return store, &updates
+}
+
+// newTestWebhooks sends webhooks with the given config, without waiting long between attempts.
+func newTestWebhooks(cfg WebhookConfig, store Store) *Webhooks {
+	webhooks := NewWebhooks(cfg, store)
+	webhooks.backoff = time.Millisecond
+	return webhooks
+}
+
+func TestSendWebhook(t *testing.T) {
+	store, updates := mockWebhookDeliveries()
+
+	requests := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		body, _ := ioutil.ReadAll(r.Body)
+		if signature := r.Header.Get(WebhookSignatureHeader); signature != SignWebhookPayload("secret", body) {
+			t.Errorf("Webhook sent with unexpected signature: %v\n", signature)
+		}
+		if event := r.Header.Get(WebhookEventHeader); event != WebhookLowStock {
+			t.Errorf("Webhook sent with unexpected event: %v\n", event)
+		}
+		if id := r.Header.Get(WebhookDeliveryHeader); id != strconv.Itoa(7) {
+			t.Errorf("Webhook sent with unexpected delivery ID: %v\n", id)
+		}
+
+		var payload struct {
+			Event string        `json:"event"`
+			Data  LowStockEvent `json:"data"`
+		}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Errorf("Webhook sent with invalid body: %v\n", err)
+		}
+		if payload.Event != WebhookLowStock || payload.Data.Tea.ID != 1 || payload.Data.Owner.ID != 2 {
+			t.Errorf("Webhook sent with unexpected body: %s\n", body)
+		}
+
+		// Fail the first attempt, to check it is retried
+		if requests == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer receiver.Close()
+
+	webhooks := newTestWebhooks(WebhookConfig{URLs: []string{receiver.URL}, Secret: "secret"}, store)
+	webhooks.Send(DefaultHousehold, WebhookLowStock, LowStockEvent{Tea: Tea{ID: 1}, Owner: Owner{ID: 2}})
+	webhooks.Wait()
+
+	if requests != 2 {
+		t.Errorf("Webhook sent unexpected number of times:\n got: %v\n wanted: %v\n", requests, 2)
+	}
+	if len(*updates) != 2 {
+		t.Fatalf("Webhook delivery updated unexpected number of times:\n got: %v\n wanted: %v\n", len(*updates), 2)
+	}
+	if first := (*updates)[0]; first.Attempts != 1 || first.Delivered || first.StatusCode != http.StatusInternalServerError {
+		t.Errorf("Unexpected delivery after first attempt: %+v\n", first)
+	}
+	if second := (*updates)[1]; second.Attempts != 2 || !second.Delivered || second.StatusCode != http.StatusOK || second.Error != "" {
+		t.Errorf("Unexpected delivery after second attempt: %+v\n", second)
+	}
+}
+
+func TestDeliverWebhookGivesUp(t *testing.T) {
+	store, updates := mockWebhookDeliveries()
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+
+	webhooks := newTestWebhooks(WebhookConfig{URLs: []string{receiver.URL}, Secret: "secret", MaxAttempts: 3}, store)
+	delivery := WebhookDelivery{ID: 1, Event: WebhookLowStock, URL: receiver.URL, Payload: "{}"}
+	webhooks.deliver(store, &delivery)
+
+	if len(*updates) != 3 {
+		t.Errorf("Webhook delivery updated unexpected number of times:\n got: %v\n wanted: %v\n", len(*updates), 3)
+	}
+	if delivery.Attempts != 3 || delivery.Delivered || delivery.Error != "Received status code 503" {
+		t.Errorf("Unexpected delivery after giving up: %+v\n", delivery)
+	}
+}
+
+func TestSendWebhookDisabled(t *testing.T) {
+	store := new(mockStore)
+	store.createWebhookDelivery = func(delivery *WebhookDelivery) error {
+		t.Errorf("Webhook delivery recorded when no webhooks are configured")
+		return nil
+	}
+
+	webhooks := newTestWebhooks(WebhookConfig{}, store)
+	webhooks.Send(DefaultHousehold, WebhookLowStock, LowStockEvent{})
+	webhooks.Wait()
+}
+
+func TestStockChangedSendsLowStock(t *testing.T) {
+	var payloads []LowStockEvent
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Data LowStockEvent `json:"data"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("Webhook sent with invalid body: %v\n", err)
+		}
+		payloads = append(payloads, payload.Data)
+	}))
+	defer receiver.Close()
+
+	store := NewMemoryStore(storeTestConfig())
+	var cfg WebhookConfig
+	cfg.URLs = []string{receiver.URL}
+	cfg.LowStock.Bags = 3
+	webhooks := newTestWebhooks(cfg, store)
+	store.SetStockObserver(webhooks)
+
+	quantity := 5.0
+	tea := Tea{Name: "Snowball", TeaType: TeaType{ID: 1}}
+	if err := store.CreateTea(&tea); err != nil {
+		t.Fatalf("Unexpected error creating tea: %v\n", err)
+	}
+	if _, err := store.CreateTeaOwner(tea.ID, &Owner{ID: 2}, Stock{Quantity: &quantity, Unit: UnitBags}); err != nil {
+		t.Fatalf("Unexpected error creating tea owner: %v\n", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := store.ConsumeTeaStock(tea.ID, 2, 1); err != nil {
+			t.Fatalf("Unexpected error consuming stock: %v\n", err)
+		}
+		webhooks.Wait()
+	}
+
+	if len(payloads) != 1 {
+		t.Fatalf("Low stock webhook sent unexpected number of times:\n got: %v\n wanted: %v\n", len(payloads), 1)
+	}
+	if event := payloads[0]; event.Tea.Name != "Snowball" || event.Tea.TeaType.Name != "Black Tea" || event.Owner.Name != "Jane" || *event.Stock.Quantity != 2 || event.Threshold != 3 {
+		t.Errorf("Unexpected low stock event: %+v\n", event)
+	}
+
+	deliveries, total, err := store.GetWebhookDeliveries(10, 0)
+	if err != nil || total != 1 || !deliveries[0].Delivered {
+		t.Errorf("Unexpected webhook deliveries recorded: %+v, error: %v\n", deliveries, err)
+	}
+}